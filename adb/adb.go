@@ -0,0 +1,401 @@
+// Package adb implements the Android Debug Bridge wire protocol over USB,
+// talking to adbd directly without the adb server.
+package adb
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/op0xA5/gousb"
+)
+
+// ADB interface class codes
+const (
+	SubClassADB = uint8(0x42)
+	ProtocolADB = uint8(0x01)
+)
+
+// commands
+const (
+	CmdSYNC = uint32(0x434e5953)
+	CmdCNXN = uint32(0x4e584e43)
+	CmdAUTH = uint32(0x48545541)
+	CmdOPEN = uint32(0x4e45504f)
+	CmdOKAY = uint32(0x59414b4f)
+	CmdCLSE = uint32(0x45534c43)
+	CmdWRTE = uint32(0x45545257)
+)
+
+// AUTH types
+const (
+	AuthToken        = uint32(1)
+	AuthSignature    = uint32(2)
+	AuthRSAPublicKey = uint32(3)
+)
+
+const (
+	// Version is the protocol version announced in CNXN; devices on this
+	// version skip the payload checksum.
+	Version = uint32(0x01000001)
+	// MaxPayload is the largest payload announced in CNXN.
+	MaxPayload = uint32(256 * 1024)
+
+	headerLength  = 24
+	legacyPayload = uint32(4096)
+	readTimeout   = 500
+)
+
+// Message is an ADB packet.
+type Message struct {
+	Command uint32
+	Arg0    uint32
+	Arg1    uint32
+	Data    []byte
+}
+
+func checksum(b []byte) uint32 {
+	var sum uint32
+	for _, c := range b {
+		sum += uint32(c)
+	}
+	return sum
+}
+
+func (msg *Message) header() []byte {
+	b := make([]byte, headerLength)
+	binary.LittleEndian.PutUint32(b[0:], msg.Command)
+	binary.LittleEndian.PutUint32(b[4:], msg.Arg0)
+	binary.LittleEndian.PutUint32(b[8:], msg.Arg1)
+	binary.LittleEndian.PutUint32(b[12:], uint32(len(msg.Data)))
+	binary.LittleEndian.PutUint32(b[16:], checksum(msg.Data))
+	binary.LittleEndian.PutUint32(b[20:], msg.Command^0xffffffff)
+	return b
+}
+
+func (msg *Message) String() string {
+	var cmd [4]byte
+	binary.LittleEndian.PutUint32(cmd[:], msg.Command)
+	return fmt.Sprintf("%s(%08x, %08x, %d bytes)", cmd[:], msg.Arg0, msg.Arg1, len(msg.Data))
+}
+
+// IsADBInterface reports whether iface is an ADB interface.
+func IsADBInterface(iface *gousb.Interface) bool {
+	return iface.InterfaceClass == gousb.ClassVendorSpecific &&
+		iface.InterfaceSubClass == SubClassADB &&
+		iface.InterfaceProtocol == ProtocolADB
+}
+
+// Conn is an ADB connection over a claimed USB interface. After Connect
+// a background goroutine dispatches incoming packets to streams.
+type Conn struct {
+	h     *gousb.Handle
+	iface *gousb.Interface
+
+	in        *gousb.BulkTransfer
+	data      *gousb.BulkTransfer
+	out       *gousb.BulkTransfer
+	maxPacket int
+	claimed   bool
+
+	// Banner is the connection string sent by the device in CNXN, like
+	// "device::ro.product.name=...;".
+	Banner  string
+	version uint32
+	maxData uint32
+
+	wmu       sync.Mutex
+	mu        sync.Mutex
+	streams   map[uint32]*Stream
+	nextID    uint32
+	err       error
+	connected bool
+	closing   bool
+	done      chan struct{}
+}
+
+// Open finds the ADB interface of the active configuration and claims it.
+func Open(h *gousb.Handle) (*Conn, error) {
+	cfg, err := h.GetActiveConfigDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range cfg.Interfaces {
+		if IsADBInterface(iface) {
+			return New(h, iface)
+		}
+	}
+	return nil, gousb.ErrNotFound
+}
+
+func New(h *gousb.Handle, iface *gousb.Interface) (*Conn, error) {
+	in := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk)
+	out := iface.FindEndpoint(gousb.EndpointOut, gousb.TransferTypeBulk)
+	if in == nil || out == nil {
+		return nil, errors.New("adb: bulk endpoints not found")
+	}
+	if err := h.ClaimInterface(int(iface.InterfaceNumber)); err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		h:         h,
+		iface:     iface,
+		in:        h.GetBulkTransfer(in.EndpointAddress, 0),
+		data:      h.GetBulkTransfer(in.EndpointAddress, 0),
+		out:       h.GetBulkTransfer(0, out.EndpointAddress),
+		maxPacket: int(out.MaxPacketSize & 0x7ff),
+		claimed:   true,
+		maxData:   legacyPayload,
+		streams:   make(map[uint32]*Stream),
+		done:      make(chan struct{}),
+	}
+	if c.maxPacket == 0 {
+		c.maxPacket = 512
+	}
+	c.in.SetTimeout(0)
+	c.data.SetTimeout(0)
+	c.out.SetTimeout(0)
+	return c, nil
+}
+
+func (c *Conn) send(msg *Message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if _, err := c.out.Write(msg.header()); err != nil {
+		return err
+	}
+	if len(msg.Data) == 0 {
+		return nil
+	}
+	if _, err := c.out.Write(msg.Data); err != nil {
+		return err
+	}
+	if len(msg.Data)%c.maxPacket == 0 {
+		_, err := c.out.Write(nil)
+		return err
+	}
+	return nil
+}
+
+func (c *Conn) recv() (*Message, error) {
+	hdr := make([]byte, c.maxPacket)
+	var n int
+	for {
+		var err error
+		n, err = c.in.Read(hdr)
+		if err == gousb.ErrTimeout {
+			c.mu.Lock()
+			closing := c.closing
+			c.mu.Unlock()
+			if closing {
+				return nil, errors.New("adb: connection closed")
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			break
+		}
+	}
+	if n != headerLength {
+		return nil, errors.New("adb: bad message header")
+	}
+	msg := &Message{
+		Command: binary.LittleEndian.Uint32(hdr[0:]),
+		Arg0:    binary.LittleEndian.Uint32(hdr[4:]),
+		Arg1:    binary.LittleEndian.Uint32(hdr[8:]),
+	}
+	length := binary.LittleEndian.Uint32(hdr[12:])
+	sum := binary.LittleEndian.Uint32(hdr[16:])
+	if binary.LittleEndian.Uint32(hdr[20:]) != msg.Command^0xffffffff {
+		return nil, errors.New("adb: bad message magic")
+	}
+	if length > MaxPayload {
+		return nil, errors.New("adb: payload too large")
+	}
+	if length > 0 {
+		msg.Data = make([]byte, length)
+		for got := 0; got < int(length); {
+			n, err := c.data.Read(msg.Data[got:])
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				return nil, errors.New("adb: short payload")
+			}
+			got += n
+		}
+		if c.version < Version && checksum(msg.Data) != sum {
+			return nil, errors.New("adb: bad payload checksum")
+		}
+	}
+	return msg, nil
+}
+
+// Connect runs the CNXN/AUTH handshake. key signs the device's AUTH token;
+// when the device does not know the key its public part is offered, which
+// makes the device ask the user to confirm. key may be nil for devices
+// with authentication disabled.
+func (c *Conn) Connect(key *rsa.PrivateKey) error {
+	err := c.send(&Message{Command: CmdCNXN, Arg0: Version, Arg1: MaxPayload, Data: []byte("host::\x00")})
+	if err != nil {
+		return err
+	}
+	sentSignature := false
+	for {
+		msg, err := c.recv()
+		if err != nil {
+			return err
+		}
+		switch msg.Command {
+		case CmdCNXN:
+			c.version = msg.Arg0
+			c.maxData = msg.Arg1
+			if c.maxData > MaxPayload {
+				c.maxData = MaxPayload
+			}
+			c.Banner = strings.TrimRight(string(msg.Data), "\x00")
+			c.in.SetTimeout(readTimeout)
+			c.mu.Lock()
+			c.connected = true
+			c.mu.Unlock()
+			go c.dispatch()
+			return nil
+		case CmdAUTH:
+			if msg.Arg0 != AuthToken {
+				continue
+			}
+			if key == nil {
+				return errors.New("adb: device requires authentication")
+			}
+			if !sentSignature {
+				sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA1, msg.Data)
+				if err != nil {
+					return err
+				}
+				sentSignature = true
+				err = c.send(&Message{Command: CmdAUTH, Arg0: AuthSignature, Data: sig})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			pub, err := EncodePublicKey(&key.PublicKey, "gousb@host")
+			if err != nil {
+				return err
+			}
+			// the device now waits for the user to accept the key
+			c.in.SetTimeout(0)
+			err = c.send(&Message{Command: CmdAUTH, Arg0: AuthRSAPublicKey, Data: append(pub, 0)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *Conn) dispatch() {
+	var err error
+	for {
+		var msg *Message
+		msg, err = c.recv()
+		if err != nil {
+			break
+		}
+		c.mu.Lock()
+		s := c.streams[msg.Arg1]
+		c.mu.Unlock()
+		if s == nil {
+			if msg.Command == CmdWRTE || msg.Command == CmdOKAY {
+				c.send(&Message{Command: CmdCLSE, Arg0: msg.Arg1, Arg1: msg.Arg0})
+			}
+			continue
+		}
+		s.handle(msg)
+	}
+
+	c.mu.Lock()
+	c.err = err
+	streams := c.streams
+	c.streams = make(map[uint32]*Stream)
+	c.mu.Unlock()
+	for _, s := range streams {
+		s.closeLocal()
+	}
+	close(c.done)
+}
+
+// MaxData is the payload size agreed with the device.
+func (c *Conn) MaxData() int {
+	return int(c.maxData)
+}
+
+// OpenStream opens a service like "shell:ls" or "sync:".
+func (c *Conn) OpenStream(service string) (*Stream, error) {
+	c.mu.Lock()
+	if !c.connected || c.closing || c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		if err == nil {
+			err = errors.New("adb: not connected")
+		}
+		return nil, err
+	}
+	c.nextID++
+	s := newStream(c, c.nextID)
+	c.streams[s.local] = s
+	c.mu.Unlock()
+
+	err := c.send(&Message{Command: CmdOPEN, Arg0: s.local, Data: append([]byte(service), 0)})
+	if err == nil {
+		err = <-s.ready
+	}
+	if err != nil {
+		c.removeStream(s.local)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *Conn) removeStream(id uint32) {
+	c.mu.Lock()
+	delete(c.streams, id)
+	c.mu.Unlock()
+}
+
+// Shell runs cmd in the device shell; an empty cmd starts an interactive
+// shell.
+func (c *Conn) Shell(cmd string) (*Stream, error) {
+	return c.OpenStream("shell:" + cmd)
+}
+
+// Close closes all streams, stops the dispatcher and releases the
+// interface.
+func (c *Conn) Close() error {
+	if !c.claimed {
+		return nil
+	}
+	c.mu.Lock()
+	connected := c.connected
+	c.closing = true
+	streams := make([]*Stream, 0, len(c.streams))
+	for _, s := range c.streams {
+		streams = append(streams, s)
+	}
+	c.mu.Unlock()
+	for _, s := range streams {
+		s.Close()
+	}
+	if connected {
+		<-c.done
+	}
+	c.claimed = false
+	return c.h.ReleaseInterface(int(c.iface.InterfaceNumber))
+}
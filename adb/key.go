@@ -0,0 +1,54 @@
+package adb
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+const keyWords = 2048 / 32
+
+// EncodePublicKey encodes a 2048 bit RSA key in the format adbd keeps in
+// adb_keys: the base64 of the mincrypt RSAPublicKey structure followed
+// by a space and comment.
+func EncodePublicKey(pub *rsa.PublicKey, comment string) ([]byte, error) {
+	if pub.N.BitLen() != 2048 {
+		return nil, errors.New("adb: key must be 2048 bit")
+	}
+	b := make([]byte, 4+4+keyWords*4+keyWords*4+4)
+	binary.LittleEndian.PutUint32(b[0:], keyWords)
+
+	// n0inv = -1 / n[0] mod 2^32
+	r32 := new(big.Int).Lsh(big.NewInt(1), 32)
+	n0 := new(big.Int).Mod(pub.N, r32)
+	n0inv := new(big.Int).ModInverse(n0, r32)
+	n0inv.Sub(r32, n0inv)
+	binary.LittleEndian.PutUint32(b[4:], uint32(n0inv.Uint64()))
+
+	putWords(b[8:], pub.N)
+
+	// rr = (2^2048)^2 mod n
+	rr := new(big.Int).Lsh(big.NewInt(1), 2*2048)
+	rr.Mod(rr, pub.N)
+	putWords(b[8+keyWords*4:], rr)
+
+	binary.LittleEndian.PutUint32(b[8+2*keyWords*4:], uint32(pub.E))
+
+	enc := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+	base64.StdEncoding.Encode(enc, b)
+	if comment != "" {
+		enc = append(append(enc, ' '), comment...)
+	}
+	return enc, nil
+}
+
+// putWords stores x as little endian 32 bit words, least significant
+// word first.
+func putWords(b []byte, x *big.Int) {
+	be := x.FillBytes(make([]byte, keyWords*4))
+	for i := range be {
+		b[i] = be[len(be)-1-i]
+	}
+}
@@ -0,0 +1,132 @@
+package adb
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Stream is one ADB stream multiplexed on a Conn.
+type Stream struct {
+	c      *Conn
+	local  uint32
+	remote uint32
+
+	ready  chan error
+	okay   chan struct{}
+	data   chan []byte
+	closed chan struct{}
+	buf    []byte
+
+	once sync.Once
+	wmu  sync.Mutex
+}
+
+func newStream(c *Conn, local uint32) *Stream {
+	return &Stream{
+		c:      c,
+		local:  local,
+		ready:  make(chan error, 1),
+		okay:   make(chan struct{}, 1),
+		data:   make(chan []byte, 1),
+		closed: make(chan struct{}),
+	}
+}
+
+// handle is called by the dispatcher for every message addressed to s.
+// The device sends the next WRTE only after our OKAY, so data never
+// holds more than one payload and handle does not block.
+func (s *Stream) handle(msg *Message) {
+	switch msg.Command {
+	case CmdOKAY:
+		if s.remote == 0 {
+			s.remote = msg.Arg0
+			s.ready <- nil
+			return
+		}
+		select {
+		case s.okay <- struct{}{}:
+		default:
+		}
+	case CmdWRTE:
+		s.data <- msg.Data
+	case CmdCLSE:
+		if s.remote == 0 {
+			s.ready <- errors.New("adb: service refused")
+		}
+		s.c.removeStream(s.local)
+		s.closeLocal()
+	}
+}
+
+func (s *Stream) closeLocal() {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+	select {
+	case s.ready <- errors.New("adb: connection closed"):
+	default:
+	}
+}
+
+func (s *Stream) Read(p []byte) (int, error) {
+	if len(s.buf) == 0 {
+		select {
+		case b := <-s.data:
+			s.buf = b
+			if err := s.c.send(&Message{Command: CmdOKAY, Arg0: s.local, Arg1: s.remote}); err != nil {
+				return 0, err
+			}
+		case <-s.closed:
+			// deliver a payload that raced with CLSE
+			select {
+			case b := <-s.data:
+				s.buf = b
+			default:
+				return 0, io.EOF
+			}
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// Write sends p in payloads of at most Conn.MaxData bytes, waiting for
+// the device to acknowledge each one.
+func (s *Stream) Write(p []byte) (n int, err error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	for n < len(p) {
+		chunk := p[n:]
+		if len(chunk) > s.c.MaxData() {
+			chunk = chunk[:s.c.MaxData()]
+		}
+		select {
+		case <-s.closed:
+			return n, io.ErrClosedPipe
+		default:
+		}
+		if err := s.c.send(&Message{Command: CmdWRTE, Arg0: s.local, Arg1: s.remote, Data: chunk}); err != nil {
+			return n, err
+		}
+		select {
+		case <-s.okay:
+		case <-s.closed:
+			return n, io.ErrClosedPipe
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+func (s *Stream) Close() error {
+	select {
+	case <-s.closed:
+		return nil
+	default:
+	}
+	s.c.removeStream(s.local)
+	s.closeLocal()
+	return s.c.send(&Message{Command: CmdCLSE, Arg0: s.local, Arg1: s.remote})
+}
@@ -0,0 +1,203 @@
+package adb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const syncDataMax = 64 * 1024
+
+// SyncClient speaks the file transfer protocol of the "sync:" service.
+type SyncClient struct {
+	s *Stream
+}
+
+// Sync opens a "sync:" stream.
+func (c *Conn) Sync() (*SyncClient, error) {
+	s, err := c.OpenStream("sync:")
+	if err != nil {
+		return nil, err
+	}
+	return &SyncClient{s: s}, nil
+}
+
+func (sc *SyncClient) Close() error {
+	sc.request("QUIT", nil)
+	return sc.s.Close()
+}
+
+func (sc *SyncClient) request(id string, data []byte) error {
+	b := make([]byte, 8+len(data))
+	copy(b, id)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(data)))
+	copy(b[8:], data)
+	_, err := sc.s.Write(b)
+	return err
+}
+
+func (sc *SyncClient) readHeader() (string, uint32, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(sc.s, b[:]); err != nil {
+		return "", 0, err
+	}
+	return string(b[:4]), binary.LittleEndian.Uint32(b[4:]), nil
+}
+
+func (sc *SyncClient) readFail(length uint32) error {
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(sc.s, msg); err != nil {
+		return err
+	}
+	return fmt.Errorf("adb: sync: %s", msg)
+}
+
+// FileInfo is the result of Stat and List.
+type FileInfo struct {
+	Name    string
+	Mode    os.FileMode
+	Size    uint32
+	ModTime time.Time
+}
+
+// unix mode to os.FileMode
+func fileMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode & 0777)
+	switch mode & 0170000 {
+	case 0040000:
+		m |= os.ModeDir
+	case 0120000:
+		m |= os.ModeSymlink
+	case 0010000:
+		m |= os.ModeNamedPipe
+	case 0140000:
+		m |= os.ModeSocket
+	case 0020000:
+		m |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		m |= os.ModeDevice
+	}
+	return m
+}
+
+// Stat returns information about path. A missing file has a zero Mode.
+func (sc *SyncClient) Stat(path string) (*FileInfo, error) {
+	if err := sc.request("STAT", []byte(path)); err != nil {
+		return nil, err
+	}
+	var b [16]byte
+	if _, err := io.ReadFull(sc.s, b[:]); err != nil {
+		return nil, err
+	}
+	if string(b[:4]) != "STAT" {
+		return nil, errors.New("adb: sync: unexpected response")
+	}
+	return &FileInfo{
+		Name:    path,
+		Mode:    fileMode(binary.LittleEndian.Uint32(b[4:])),
+		Size:    binary.LittleEndian.Uint32(b[8:]),
+		ModTime: time.Unix(int64(binary.LittleEndian.Uint32(b[12:])), 0),
+	}, nil
+}
+
+// List returns the entries of directory path.
+func (sc *SyncClient) List(path string) ([]*FileInfo, error) {
+	if err := sc.request("LIST", []byte(path)); err != nil {
+		return nil, err
+	}
+	var list []*FileInfo
+	for {
+		var b [20]byte
+		if _, err := io.ReadFull(sc.s, b[:]); err != nil {
+			return nil, err
+		}
+		switch string(b[:4]) {
+		case "DONE":
+			return list, nil
+		case "DENT":
+		default:
+			return nil, errors.New("adb: sync: unexpected response")
+		}
+		name := make([]byte, binary.LittleEndian.Uint32(b[16:]))
+		if _, err := io.ReadFull(sc.s, name); err != nil {
+			return nil, err
+		}
+		list = append(list, &FileInfo{
+			Name:    string(name),
+			Mode:    fileMode(binary.LittleEndian.Uint32(b[4:])),
+			Size:    binary.LittleEndian.Uint32(b[8:]),
+			ModTime: time.Unix(int64(binary.LittleEndian.Uint32(b[12:])), 0),
+		})
+	}
+}
+
+// Pull copies the device file at path to w.
+func (sc *SyncClient) Pull(path string, w io.Writer) error {
+	if err := sc.request("RECV", []byte(path)); err != nil {
+		return err
+	}
+	for {
+		id, length, err := sc.readHeader()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case "DATA":
+			if length > syncDataMax {
+				return errors.New("adb: sync: chunk too large")
+			}
+			if _, err := io.CopyN(w, sc.s, int64(length)); err != nil {
+				return err
+			}
+		case "DONE":
+			return nil
+		case "FAIL":
+			return sc.readFail(length)
+		default:
+			return errors.New("adb: sync: unexpected response")
+		}
+	}
+}
+
+// Push copies r to the device file at path, created with mode and
+// modification time mtime.
+func (sc *SyncClient) Push(r io.Reader, path string, mode os.FileMode, mtime time.Time) error {
+	if err := sc.request("SEND", []byte(fmt.Sprintf("%s,%d", path, uint32(mode.Perm())|0100000))); err != nil {
+		return err
+	}
+	buf := make([]byte, syncDataMax)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := sc.request("DATA", buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	done := make([]byte, 8)
+	copy(done, "DONE")
+	binary.LittleEndian.PutUint32(done[4:], uint32(mtime.Unix()))
+	if _, err := sc.s.Write(done); err != nil {
+		return err
+	}
+	id, length, err := sc.readHeader()
+	if err != nil {
+		return err
+	}
+	switch id {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		return sc.readFail(length)
+	}
+	return errors.New("adb: sync: unexpected response")
+}
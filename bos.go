@@ -0,0 +1,437 @@
+package gousb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// device capability types
+const (
+	CapabilityWirelessUSB    = uint8(0x01)
+	CapabilityUSB2Extension  = uint8(0x02)
+	CapabilitySuperSpeed     = uint8(0x03)
+	CapabilityContainerID    = uint8(0x04)
+	CapabilityPlatform       = uint8(0x05)
+	CapabilityPowerDelivery  = uint8(0x06)
+	CapabilityBatteryInfo    = uint8(0x07)
+	CapabilityPDConsumerPort = uint8(0x08)
+	CapabilityPDProviderPort = uint8(0x09)
+	CapabilitySuperSpeedPlus = uint8(0x0A)
+	CapabilityPrecisionTime  = uint8(0x0B)
+	CapabilityWirelessUSBExt = uint8(0x0C)
+	CapabilityBillboard      = uint8(0x0D)
+	CapabilityAuthentication = uint8(0x0E)
+	CapabilityBillboardEx    = uint8(0x0F)
+	CapabilityConfigSummary  = uint8(0x10)
+)
+
+// platform capability UUIDs in descriptor byte order
+var (
+	// {3408B638-09A9-47A0-8BFD-A0768815B665}
+	PlatformUUIDWebUSB = [16]byte{
+		0x38, 0xB6, 0x08, 0x34, 0xA9, 0x09, 0xA0, 0x47,
+		0x8B, 0xFD, 0xA0, 0x76, 0x88, 0x15, 0xB6, 0x65,
+	}
+	// {D8DD60DF-4589-4CC7-9CD2-659D9E648A9F}
+	PlatformUUIDMSOS20 = [16]byte{
+		0xDF, 0x60, 0xDD, 0xD8, 0x89, 0x45, 0xC7, 0x4C,
+		0x9C, 0xD2, 0x65, 0x9D, 0x9E, 0x64, 0x8A, 0x9F,
+	}
+)
+
+// FormatUUID formats a UUID stored in descriptor (little endian GUID)
+// byte order.
+func FormatUUID(u [16]byte) string {
+	return fmt.Sprintf("{%02x%02x%02x%02x-%02x%02x-%02x%02x-%02x%02x-%02x%02x%02x%02x%02x%02x}",
+		u[3], u[2], u[1], u[0], u[5], u[4], u[7], u[6],
+		u[8], u[9], u[10], u[11], u[12], u[13], u[14], u[15])
+}
+
+// DeviceCapability is one device capability descriptor of the BOS
+// descriptor; Data holds the bytes following bDevCapabilityType.
+type DeviceCapability struct {
+	Length         uint8
+	DescriptorType uint8
+	CapabilityType uint8
+	Data           []byte
+}
+
+func (desc *DeviceCapability) Len() int {
+	return int(desc.Length)
+}
+func (desc *DeviceCapability) Type() uint8 {
+	return desc.DescriptorType
+}
+
+// BOSDescriptor is the binary device object store with its capabilities.
+type BOSDescriptor struct {
+	Length         uint8
+	DescriptorType uint8
+	TotalLength    uint16
+	NumDeviceCaps  uint8
+	Capabilities   []*DeviceCapability
+}
+
+func (desc *BOSDescriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *BOSDescriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+const bosDescriptorLength = 5
+
+// ParseBOSDescriptor parses a full BOS descriptor including its device
+// capabilities.
+func ParseBOSDescriptor(b []byte) (*BOSDescriptor, error) {
+	if len(b) < bosDescriptorLength {
+		return nil, errors.New("too less bytes")
+	}
+	if b[1] != DescriptorTypeBOS {
+		return nil, errors.New("not a BOS descriptor")
+	}
+	bos := &BOSDescriptor{
+		Length:         b[0],
+		DescriptorType: b[1],
+		TotalLength:    usbEncoding.Uint16(b[2:]),
+		NumDeviceCaps:  b[4],
+	}
+	if int(bos.TotalLength) < len(b) {
+		b = b[:bos.TotalLength]
+	}
+	for off := int(bos.Length); off < len(b); {
+		length := int(b[off])
+		if length < 3 || off+length > len(b) {
+			return nil, errors.New("malformed descriptor")
+		}
+		d := b[off : off+length]
+		if d[1] == DescriptorTypeDevCap {
+			bos.Capabilities = append(bos.Capabilities, &DeviceCapability{
+				Length:         d[0],
+				DescriptorType: d[1],
+				CapabilityType: d[2],
+				Data:           append([]byte(nil), d[3:]...),
+			})
+		}
+		off += length
+	}
+	return bos, nil
+}
+
+// BOSDescriptor reads the BOS descriptor. Devices before USB 2.1 have
+// none and usually stall.
+func (h *Handle) BOSDescriptor() (*BOSDescriptor, error) {
+	buf, err := h.GetDescriptorBuffer(DescriptorTypeBOS, 0, make([]byte, bosDescriptorLength))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < bosDescriptorLength {
+		return nil, errors.New("too less bytes")
+	}
+	total := usbEncoding.Uint16(buf[2:])
+	if total < bosDescriptorLength {
+		return nil, errors.New("descriptor length mismatch")
+	}
+	buf, err = h.GetDescriptorBuffer(DescriptorTypeBOS, 0, make([]byte, total))
+	if err != nil {
+		return nil, err
+	}
+	return ParseBOSDescriptor(buf)
+}
+
+// Capability returns the first capability of type typ.
+func (bos *BOSDescriptor) Capability(typ uint8) *DeviceCapability {
+	for _, c := range bos.Capabilities {
+		if c.CapabilityType == typ {
+			return c
+		}
+	}
+	return nil
+}
+
+func (bos *BOSDescriptor) USB2Extension() *USB2Extension {
+	c := bos.Capability(CapabilityUSB2Extension)
+	if c == nil {
+		return nil
+	}
+	ext, _ := ParseUSB2Extension(c)
+	return ext
+}
+func (bos *BOSDescriptor) SuperSpeed() *SuperSpeedCapability {
+	c := bos.Capability(CapabilitySuperSpeed)
+	if c == nil {
+		return nil
+	}
+	ss, _ := ParseSuperSpeedCapability(c)
+	return ss
+}
+func (bos *BOSDescriptor) SuperSpeedPlus() *SuperSpeedPlusCapability {
+	c := bos.Capability(CapabilitySuperSpeedPlus)
+	if c == nil {
+		return nil
+	}
+	ssp, _ := ParseSuperSpeedPlusCapability(c)
+	return ssp
+}
+func (bos *BOSDescriptor) ContainerID() *ContainerID {
+	c := bos.Capability(CapabilityContainerID)
+	if c == nil {
+		return nil
+	}
+	id, _ := ParseContainerID(c)
+	return id
+}
+func (bos *BOSDescriptor) Billboard() *BillboardCapability {
+	c := bos.Capability(CapabilityBillboard)
+	if c == nil {
+		return nil
+	}
+	bb, _ := ParseBillboardCapability(c)
+	return bb
+}
+
+// Platform returns the platform capability with the given UUID.
+func (bos *BOSDescriptor) Platform(uuid [16]byte) *PlatformCapability {
+	for _, c := range bos.Capabilities {
+		if c.CapabilityType != CapabilityPlatform {
+			continue
+		}
+		if p, err := ParsePlatformCapability(c); err == nil && p.UUID == uuid {
+			return p
+		}
+	}
+	return nil
+}
+
+var errCapabilityLength = errors.New("capability length mismatch")
+
+// USB 2.0 extension attribute bits
+const (
+	USB2ExtLPM           = uint32(0x00000002)
+	USB2ExtBESL          = uint32(0x00000004)
+	USB2ExtBaselineValid = uint32(0x00000008)
+	USB2ExtDeepValid     = uint32(0x00000010)
+)
+
+// USB2Extension is the USB 2.0 extension capability.
+type USB2Extension struct {
+	Attributes uint32
+}
+
+func ParseUSB2Extension(c *DeviceCapability) (*USB2Extension, error) {
+	if c.CapabilityType != CapabilityUSB2Extension || len(c.Data) < 4 {
+		return nil, errCapabilityLength
+	}
+	return &USB2Extension{Attributes: usbEncoding.Uint32(c.Data)}, nil
+}
+
+// LPM reports support of link power management.
+func (ext *USB2Extension) LPM() bool {
+	return ext.Attributes&USB2ExtLPM != 0
+}
+func (ext *USB2Extension) BESL() bool {
+	return ext.Attributes&USB2ExtBESL != 0
+}
+
+// BaselineBESL and DeepBESL return the recommended BESL values and
+// whether they are valid.
+func (ext *USB2Extension) BaselineBESL() (uint8, bool) {
+	return uint8(ext.Attributes >> 8 & 0xf), ext.Attributes&USB2ExtBaselineValid != 0
+}
+func (ext *USB2Extension) DeepBESL() (uint8, bool) {
+	return uint8(ext.Attributes >> 12 & 0xf), ext.Attributes&USB2ExtDeepValid != 0
+}
+
+// wSpeedsSupported bits
+const (
+	SpeedSupportLow   = uint16(0x0001)
+	SpeedSupportFull  = uint16(0x0002)
+	SpeedSupportHigh  = uint16(0x0004)
+	SpeedSupportSuper = uint16(0x0008)
+)
+
+// SuperSpeedCapability is the SuperSpeed USB device capability.
+type SuperSpeedCapability struct {
+	Attributes           uint8
+	SpeedsSupported      uint16
+	FunctionalitySupport uint8
+	U1DevExitLat         uint8
+	U2DevExitLat         uint16
+}
+
+func ParseSuperSpeedCapability(c *DeviceCapability) (*SuperSpeedCapability, error) {
+	if c.CapabilityType != CapabilitySuperSpeed || len(c.Data) < 7 {
+		return nil, errCapabilityLength
+	}
+	return &SuperSpeedCapability{
+		Attributes:           c.Data[0],
+		SpeedsSupported:      usbEncoding.Uint16(c.Data[1:]),
+		FunctionalitySupport: c.Data[3],
+		U1DevExitLat:         c.Data[4],
+		U2DevExitLat:         usbEncoding.Uint16(c.Data[5:]),
+	}, nil
+}
+
+// LTM reports latency tolerance messaging support.
+func (ss *SuperSpeedCapability) LTM() bool {
+	return ss.Attributes&0x02 != 0
+}
+
+// SublinkSpeed is one sublink speed attribute of the SuperSpeedPlus
+// capability.
+type SublinkSpeed uint32
+
+func (s SublinkSpeed) ID() uint8 {
+	return uint8(s & 0xf)
+}
+
+// BitRate returns the lane speed in bits per second.
+func (s SublinkSpeed) BitRate() uint64 {
+	rate := uint64(s >> 16)
+	for exp := s >> 4 & 0x3; exp > 0; exp-- {
+		rate *= 1000
+	}
+	return rate
+}
+
+// Symmetric reports whether rx and tx run at the same speed; if not, Tx
+// tells which direction the attribute describes.
+func (s SublinkSpeed) Symmetric() bool {
+	return s&0x40 == 0
+}
+func (s SublinkSpeed) Tx() bool {
+	return s&0x80 != 0
+}
+
+// Protocol returns the link protocol: 0 SuperSpeed, 1 SuperSpeedPlus.
+func (s SublinkSpeed) Protocol() uint8 {
+	return uint8(s >> 14 & 0x3)
+}
+
+// SuperSpeedPlusCapability is the SuperSpeedPlus USB device capability.
+type SuperSpeedPlusCapability struct {
+	Attributes           uint32
+	FunctionalitySupport uint16
+	SublinkSpeeds        []SublinkSpeed
+}
+
+func ParseSuperSpeedPlusCapability(c *DeviceCapability) (*SuperSpeedPlusCapability, error) {
+	if c.CapabilityType != CapabilitySuperSpeedPlus || len(c.Data) < 9 {
+		return nil, errCapabilityLength
+	}
+	ssp := &SuperSpeedPlusCapability{
+		Attributes:           usbEncoding.Uint32(c.Data[1:]),
+		FunctionalitySupport: usbEncoding.Uint16(c.Data[5:]),
+	}
+	n := int(ssp.Attributes&0x1f) + 1
+	if len(c.Data) < 9+n*4 {
+		return nil, errCapabilityLength
+	}
+	for i := 0; i < n; i++ {
+		ssp.SublinkSpeeds = append(ssp.SublinkSpeeds, SublinkSpeed(usbEncoding.Uint32(c.Data[9+i*4:])))
+	}
+	return ssp, nil
+}
+
+// MinRxLanes and MinTxLanes return the minimum lane counts of the
+// functional speed.
+func (ssp *SuperSpeedPlusCapability) MinRxLanes() int {
+	return int(ssp.FunctionalitySupport >> 8 & 0xf)
+}
+func (ssp *SuperSpeedPlusCapability) MinTxLanes() int {
+	return int(ssp.FunctionalitySupport >> 12 & 0xf)
+}
+
+// ContainerID is the container ID capability, shared by all functions of
+// one physical device.
+type ContainerID struct {
+	UUID [16]byte
+}
+
+func ParseContainerID(c *DeviceCapability) (*ContainerID, error) {
+	if c.CapabilityType != CapabilityContainerID || len(c.Data) < 17 {
+		return nil, errCapabilityLength
+	}
+	id := new(ContainerID)
+	copy(id.UUID[:], c.Data[1:17])
+	return id, nil
+}
+func (id *ContainerID) String() string {
+	return FormatUUID(id.UUID)
+}
+
+// PlatformCapability is a platform capability; Data is interpreted by
+// the platform named by UUID.
+type PlatformCapability struct {
+	UUID [16]byte
+	Data []byte
+}
+
+func ParsePlatformCapability(c *DeviceCapability) (*PlatformCapability, error) {
+	if c.CapabilityType != CapabilityPlatform || len(c.Data) < 17 {
+		return nil, errCapabilityLength
+	}
+	p := &PlatformCapability{Data: c.Data[17:]}
+	copy(p.UUID[:], c.Data[1:17])
+	return p, nil
+}
+
+// AlternateMode is an alternate or USB4 mode listed in the Billboard
+// capability.
+type AlternateMode struct {
+	SVID      uint16
+	Mode      uint8
+	IdxString uint8
+}
+
+// BillboardCapability is the Billboard capability of a USB Type-C
+// device that failed to enter an alternate mode.
+type BillboardCapability struct {
+	IdxAdditionalInfoURL  uint8
+	NumberOfModes         uint8
+	PreferredMode         uint8
+	VconnPower            uint16
+	Configured            [32]byte
+	BcdVersion            uint16
+	AdditionalFailureInfo uint8
+	Modes                 []AlternateMode
+}
+
+// ParseBillboardCapability parses the Billboard capability.
+func ParseBillboardCapability(c *DeviceCapability) (*BillboardCapability, error) {
+	if c.CapabilityType != CapabilityBillboard || len(c.Data) < 41 {
+		return nil, errCapabilityLength
+	}
+	d := c.Data
+	bb := &BillboardCapability{
+		IdxAdditionalInfoURL:  d[0],
+		NumberOfModes:         d[1],
+		PreferredMode:         d[2],
+		VconnPower:            usbEncoding.Uint16(d[3:]),
+		BcdVersion:            usbEncoding.Uint16(d[37:]),
+		AdditionalFailureInfo: d[39],
+	}
+	copy(bb.Configured[:], d[5:37])
+	n := int(bb.NumberOfModes)
+	if len(d) < 41+n*4 {
+		return nil, errCapabilityLength
+	}
+	for i := 0; i < n; i++ {
+		m := d[41+i*4:]
+		bb.Modes = append(bb.Modes, AlternateMode{
+			SVID:      usbEncoding.Uint16(m),
+			Mode:      m[2],
+			IdxString: m[3],
+		})
+	}
+	return bb, nil
+}
+
+// ModeState returns the bmConfigured state of mode i: 0 unspecified
+// error, 1 not attempted, 2 unsuccessful, 3 successful.
+func (bb *BillboardCapability) ModeState(i int) uint8 {
+	if i < 0 || i >= 128 {
+		return 0
+	}
+	return bb.Configured[i/4] >> uint(i%4*2) & 0x3
+}
@@ -0,0 +1,198 @@
+package gousb
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testBOS is the BOS descriptor of a SuperSpeedPlus device with WebUSB
+// and MS OS 2.0 platform capabilities.
+var testBOS = []byte{
+	0x05, 0x0f, 0x72, 0x00, 0x06,
+	0x07, 0x10, 0x02, 0x1e, 0xf4, 0x00, 0x00, // USB 2.0 extension, LPM and BESL
+	0x0a, 0x10, 0x03, 0x02, 0x0e, 0x00, 0x01, 0x0a, 0xff, 0x07, // SuperSpeed
+	0x14, 0x10, 0x0a, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x11, 0x00, 0x00, // SuperSpeedPlus
+	0x30, 0x40, 0x0a, 0x00, // sublink 0: Gb/s, symmetric, SuperSpeedPlus, 10
+	0x31, 0x40, 0x05, 0x00, // sublink 1: Gb/s, symmetric, SuperSpeedPlus, 5
+	0x14, 0x10, 0x04, 0x00, // container ID
+	0x10, 0x32, 0x54, 0x76, 0x98, 0xba, 0xdc, 0xfe, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef,
+	0x18, 0x10, 0x05, 0x00, // WebUSB
+	0x38, 0xb6, 0x08, 0x34, 0xa9, 0x09, 0xa0, 0x47, 0x8b, 0xfd, 0xa0, 0x76, 0x88, 0x15, 0xb6, 0x65,
+	0x00, 0x01, 0x01, 0x01,
+	0x1c, 0x10, 0x05, 0x00, // MS OS 2.0
+	0xdf, 0x60, 0xdd, 0xd8, 0x89, 0x45, 0xc7, 0x4c, 0x9c, 0xd2, 0x65, 0x9d, 0x9e, 0x64, 0x8a, 0x9f,
+	0x00, 0x00, 0x03, 0x06, 0xb2, 0x00, 0x01, 0x00,
+}
+
+func TestParseBOSDescriptor(t *testing.T) {
+	desc, err := ParseBOSDescriptor(testBOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.TotalLength != 114 || desc.NumDeviceCaps != 6 || len(desc.Capabilities) != 6 {
+		t.Fatalf("got %+v", desc)
+	}
+	var types []uint8
+	for _, c := range desc.Capabilities {
+		if c.Len() != len(c.Data)+3 || c.Type() != DescriptorTypeDevCap {
+			t.Errorf("capability %+v", c)
+		}
+		types = append(types, c.CapabilityType)
+	}
+	want := []uint8{
+		CapabilityUSB2Extension, CapabilitySuperSpeed, CapabilitySuperSpeedPlus,
+		CapabilityContainerID, CapabilityPlatform, CapabilityPlatform,
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("capability types %v, want %v", types, want)
+	}
+
+	// a class descriptor in between is skipped, and so is anything past
+	// wTotalLength
+	desc, err = ParseBOSDescriptor([]byte{
+		0x05, 0x0f, 0x10, 0x00, 0x02,
+		0x04, 0x24, 0x01, 0x00,
+		0x07, 0x10, 0x02, 0x02, 0x00, 0x00, 0x00,
+		0x03, 0x10, 0x05,
+	})
+	if err != nil || len(desc.Capabilities) != 1 || desc.USB2Extension() == nil {
+		t.Errorf("got %+v, %v", desc, err)
+	}
+}
+
+func TestParseBOSDescriptorErrors(t *testing.T) {
+	for in, want := range map[string]string{
+		"\x05\x0f\x05\x00":                     "too less bytes",
+		"\x05\x02\x05\x00\x00":                 "not a BOS descriptor",
+		"\x05\x0f\x07\x00\x01\x02\x10":         "malformed descriptor",
+		"\x05\x0f\x09\x00\x01\x08\x10\x02\x00": "malformed descriptor",
+	} {
+		if _, err := ParseBOSDescriptor([]byte(in)); err == nil || err.Error() != want {
+			t.Errorf("% x: got error %v, want %q", in, err, want)
+		}
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	desc, err := ParseBOSDescriptor(testBOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ext := desc.USB2Extension()
+	if !reflect.DeepEqual(ext, &USB2Extension{Attributes: 0xf41e}) || !ext.LPM() || !ext.BESL() {
+		t.Errorf("usb2 extension %+v", ext)
+	}
+	if besl, ok := ext.BaselineBESL(); besl != 4 || !ok {
+		t.Errorf("baseline BESL %d, %v", besl, ok)
+	}
+	if besl, ok := ext.DeepBESL(); besl != 15 || !ok {
+		t.Errorf("deep BESL %d, %v", besl, ok)
+	}
+
+	ss := desc.SuperSpeed()
+	if want := (&SuperSpeedCapability{
+		Attributes:           0x02,
+		SpeedsSupported:      SpeedSupportFull | SpeedSupportHigh | SpeedSupportSuper,
+		FunctionalitySupport: 1,
+		U1DevExitLat:         10,
+		U2DevExitLat:         0x07ff,
+	}); !reflect.DeepEqual(ss, want) || !ss.LTM() {
+		t.Errorf("superspeed %+v, want %+v", ss, want)
+	}
+
+	ssp := desc.SuperSpeedPlus()
+	if want := (&SuperSpeedPlusCapability{
+		Attributes:           1,
+		FunctionalitySupport: 0x1100,
+		SublinkSpeeds:        []SublinkSpeed{0x000a4030, 0x00054031},
+	}); !reflect.DeepEqual(ssp, want) {
+		t.Errorf("superspeed plus %+v, want %+v", ssp, want)
+	}
+	if ssp.MinRxLanes() != 1 || ssp.MinTxLanes() != 1 {
+		t.Errorf("lanes %d/%d", ssp.MinRxLanes(), ssp.MinTxLanes())
+	}
+	link := ssp.SublinkSpeeds[0]
+	if link.ID() != 0 || ssp.SublinkSpeeds[1].ID() != 1 || link.BitRate() != 10000000000 || !link.Symmetric() || link.Protocol() != 1 {
+		t.Errorf("sublink %#x: id %d, %d b/s, symmetric %v, protocol %d", uint32(link), link.ID(), link.BitRate(), link.Symmetric(), link.Protocol())
+	}
+
+	if id := desc.ContainerID().String(); id != "{76543210-ba98-fedc-0123-456789abcdef}" {
+		t.Errorf("container id %s", id)
+	}
+
+	if p := desc.Platform(PlatformUUIDWebUSB); p == nil || string(p.Data) != "\x00\x01\x01\x01" {
+		t.Errorf("webusb platform %+v", p)
+	}
+	if p := desc.Platform(PlatformUUIDMSOS20); p == nil || string(p.Data) != "\x00\x00\x03\x06\xb2\x00\x01\x00" {
+		t.Errorf("msos platform %+v", p)
+	}
+	if p := desc.Platform([16]byte{}); p != nil {
+		t.Errorf("unknown platform %+v", p)
+	}
+	if c := desc.Capability(CapabilityPrecisionTime); c != nil {
+		t.Errorf("precision time %+v", c)
+	}
+}
+
+func TestBillboard(t *testing.T) {
+	desc, err := ParseBOSDescriptor([]byte{
+		0x05, 0x0f, 0x39, 0x00, 0x01,
+		0x34, 0x10, 0x0d,
+		0x01, 0x02, 0x00, 0x01, 0x00, // iAdditionalInfoURL, two modes, VCONN power
+		0x0e, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // mode 0 unsuccessful, mode 1 successful
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0x21, 0x01, 0x00, 0x00, // bcdVersion 1.21
+		0x01, 0xff, 0x00, 0x03, // DisplayPort
+		0x87, 0x80, 0x01, 0x04, // Thunderbolt
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb := desc.Billboard()
+	want := &BillboardCapability{
+		IdxAdditionalInfoURL: 1,
+		NumberOfModes:        2,
+		VconnPower:           1,
+		Configured:           [32]byte{0x0e},
+		BcdVersion:           0x0121,
+		Modes: []AlternateMode{
+			{SVID: 0xff01, Mode: 0, IdxString: 3},
+			{SVID: 0x8087, Mode: 1, IdxString: 4},
+		},
+	}
+	if !reflect.DeepEqual(bb, want) {
+		t.Errorf("got %+v, want %+v", bb, want)
+	}
+	if s0, s1, s2 := bb.ModeState(0), bb.ModeState(1), bb.ModeState(2); s0 != 2 || s1 != 3 || s2 != 0 {
+		t.Errorf("mode states %d %d %d", s0, s1, s2)
+	}
+}
+
+func TestCapabilityLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func(*DeviceCapability) error
+		c     *DeviceCapability
+	}{
+		{"usb2 extension", func(c *DeviceCapability) error { _, err := ParseUSB2Extension(c); return err },
+			&DeviceCapability{CapabilityType: CapabilityUSB2Extension, Data: []byte{0, 0, 0}}},
+		{"usb2 extension type", func(c *DeviceCapability) error { _, err := ParseUSB2Extension(c); return err },
+			&DeviceCapability{CapabilityType: CapabilitySuperSpeed, Data: []byte{0, 0, 0, 0}}},
+		{"superspeed", func(c *DeviceCapability) error { _, err := ParseSuperSpeedCapability(c); return err },
+			&DeviceCapability{CapabilityType: CapabilitySuperSpeed, Data: make([]byte, 6)}},
+		{"superspeed plus sublinks", func(c *DeviceCapability) error { _, err := ParseSuperSpeedPlusCapability(c); return err },
+			&DeviceCapability{CapabilityType: CapabilitySuperSpeedPlus, Data: []byte{0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}},
+		{"container id", func(c *DeviceCapability) error { _, err := ParseContainerID(c); return err },
+			&DeviceCapability{CapabilityType: CapabilityContainerID, Data: make([]byte, 16)}},
+		{"platform", func(c *DeviceCapability) error { _, err := ParsePlatformCapability(c); return err },
+			&DeviceCapability{CapabilityType: CapabilityPlatform, Data: make([]byte, 16)}},
+		{"billboard modes", func(c *DeviceCapability) error { _, err := ParseBillboardCapability(c); return err },
+			&DeviceCapability{CapabilityType: CapabilityBillboard, Data: append([]byte{0, 1}, make([]byte, 39)...)}},
+	}
+	for _, tt := range tests {
+		if err := tt.parse(tt.c); err != errCapabilityLength {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, errCapabilityLength)
+		}
+	}
+}
@@ -0,0 +1,442 @@
+// Package ccid implements the USB CCID smart card reader class
+// (ClassSmartCard).
+package ccid
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/op0xA5/gousb"
+)
+
+// PC_to_RDR message types
+const (
+	MsgSetParameters   = uint8(0x61)
+	MsgIccPowerOn      = uint8(0x62)
+	MsgIccPowerOff     = uint8(0x63)
+	MsgGetSlotStatus   = uint8(0x65)
+	MsgEscape          = uint8(0x6B)
+	MsgGetParameters   = uint8(0x6C)
+	MsgResetParameters = uint8(0x6D)
+	MsgXfrBlock        = uint8(0x6F)
+	MsgAbort           = uint8(0x72)
+)
+
+// RDR_to_PC message types
+const (
+	MsgDataBlock        = uint8(0x80)
+	MsgSlotStatus       = uint8(0x81)
+	MsgParameters       = uint8(0x82)
+	MsgEscapeResponse   = uint8(0x83)
+	MsgDataRateAndClock = uint8(0x84)
+)
+
+// interrupt notifications
+const (
+	MsgNotifySlotChange = uint8(0x50)
+	MsgHardwareError    = uint8(0x51)
+)
+
+// class-specific requests
+const (
+	RequestAbort               = uint8(0x01)
+	RequestGetClockFrequencies = uint8(0x02)
+	RequestGetDataRates        = uint8(0x03)
+)
+
+// Voltage values for IccPowerOn
+const (
+	VoltageAuto = uint8(0x00)
+	Voltage5V   = uint8(0x01)
+	Voltage3V   = uint8(0x02)
+	Voltage1V8  = uint8(0x03)
+)
+
+// ICC status, bits 0-1 of bStatus
+const (
+	IccActive     = uint8(0x00)
+	IccInactive   = uint8(0x01)
+	IccNotPresent = uint8(0x02)
+)
+
+// command status, bits 6-7 of bStatus
+const (
+	commandOK            = uint8(0x00)
+	commandFailed        = uint8(0x40)
+	commandTimeExtension = uint8(0x80)
+)
+
+const headerLength = 10
+
+// Message is a CCID bulk message. Param holds the three message specific
+// header bytes; for RDR_to_PC messages these are bStatus, bError and the
+// message specific byte.
+type Message struct {
+	Type  uint8
+	Slot  uint8
+	Seq   uint8
+	Param [3]byte
+	Data  []byte
+}
+
+func (msg *Message) Status() uint8 {
+	return msg.Param[0]
+}
+func (msg *Message) IccStatus() uint8 {
+	return msg.Param[0] & 0x03
+}
+func (msg *Message) Error() uint8 {
+	return msg.Param[1]
+}
+
+func (msg *Message) MarshalBinary() ([]byte, error) {
+	b := make([]byte, headerLength+len(msg.Data))
+	b[0] = msg.Type
+	binary.LittleEndian.PutUint32(b[1:], uint32(len(msg.Data)))
+	b[5] = msg.Slot
+	b[6] = msg.Seq
+	copy(b[7:10], msg.Param[:])
+	copy(b[headerLength:], msg.Data)
+	return b, nil
+}
+func (msg *Message) UnmarshalBinary(b []byte) error {
+	if len(b) < headerLength {
+		return errors.New("ccid: short message")
+	}
+	length := int(binary.LittleEndian.Uint32(b[1:]))
+	if len(b) < headerLength+length {
+		return errors.New("ccid: truncated message")
+	}
+	msg.Type = b[0]
+	msg.Slot = b[5]
+	msg.Seq = b[6]
+	copy(msg.Param[:], b[7:10])
+	msg.Data = append([]byte(nil), b[headerLength:headerLength+length]...)
+	return nil
+}
+
+// SlotError is returned when the reader reports a failed command.
+type SlotError struct {
+	Slot   uint8
+	Status uint8
+	Code   uint8
+}
+
+func (err *SlotError) Error() string {
+	var s string
+	switch err.Code {
+	case 0xFF:
+		s = "command aborted"
+	case 0xFE:
+		s = "icc mute"
+	case 0xFD:
+		s = "parity error"
+	case 0xFC:
+		s = "overrun"
+	case 0xFB:
+		s = "hardware error"
+	case 0xF8:
+		s = "bad atr ts"
+	case 0xF7:
+		s = "bad atr tck"
+	case 0xF6:
+		s = "icc protocol not supported"
+	case 0xF5:
+		s = "icc class not supported"
+	case 0xF4:
+		s = "procedure byte conflict"
+	case 0xF3:
+		s = "deactivated protocol"
+	case 0xF2:
+		s = "busy with auto sequence"
+	case 0xE0:
+		s = "slot busy"
+	case 0x00:
+		s = "command not supported"
+	default:
+		s = fmt.Sprintf("error 0x%02x", err.Code)
+	}
+	if err.Status&0x03 == IccNotPresent {
+		s += ", no card"
+	}
+	return fmt.Sprintf("ccid: slot %d: %s", err.Slot, s)
+}
+
+// Reader is a claimed CCID interface.
+type Reader struct {
+	h     *gousb.Handle
+	iface *gousb.Interface
+	desc  *Descriptor
+
+	bulk    *gousb.BulkTransfer
+	intr    *gousb.InterruptTransfer
+	maxMsg  int
+	claimed bool
+
+	mu  sync.Mutex
+	seq uint8
+}
+
+// Open finds the first smart card interface in the active configuration
+// and claims it.
+func Open(h *gousb.Handle) (*Reader, error) {
+	cfg, err := h.GetActiveConfigDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	list := cfg.InterfacesByClass(gousb.ClassSmartCard)
+	if len(list) == 0 {
+		return nil, gousb.ErrNotFound
+	}
+	return New(h, list[0])
+}
+
+// New claims iface and returns a Reader for it.
+func New(h *gousb.Handle, iface *gousb.Interface) (*Reader, error) {
+	desc := findDescriptor(iface.Extra)
+	for _, ep := range iface.Endpoints {
+		if desc == nil {
+			desc = findDescriptor(ep.Extra)
+		}
+	}
+	if desc == nil {
+		return nil, errors.New("ccid: class descriptor not found")
+	}
+	in := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk)
+	out := iface.FindEndpoint(gousb.EndpointOut, gousb.TransferTypeBulk)
+	if in == nil || out == nil {
+		return nil, errors.New("ccid: bulk endpoints not found")
+	}
+	if err := h.ClaimInterface(int(iface.InterfaceNumber)); err != nil {
+		return nil, err
+	}
+	r := &Reader{
+		h:       h,
+		iface:   iface,
+		desc:    desc,
+		bulk:    h.GetBulkTransfer(in.EndpointAddress, out.EndpointAddress),
+		maxMsg:  int(desc.MaxCCIDMessageLength),
+		claimed: true,
+	}
+	if r.maxMsg < headerLength+261 {
+		r.maxMsg = headerLength + 261
+	}
+	if ep := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeInterrupt); ep != nil {
+		r.intr = h.GetInterruptTransfer(ep.EndpointAddress)
+	}
+	return r, nil
+}
+
+func (r *Reader) Close() error {
+	if !r.claimed {
+		return nil
+	}
+	r.claimed = false
+	return r.h.ReleaseInterface(int(r.iface.InterfaceNumber))
+}
+
+func (r *Reader) Descriptor() *Descriptor {
+	return r.desc
+}
+func (r *Reader) NumSlots() int {
+	return int(r.desc.MaxSlotIndex) + 1
+}
+
+func (r *Reader) SetTimeout(timeout uint) {
+	r.bulk.SetTimeout(timeout)
+}
+
+// Transact sends a PC_to_RDR message and waits for the matching
+// RDR_to_PC response, skipping time extension requests.
+func (r *Reader) Transact(msg *Message) (*Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	msg.Seq = r.seq
+	r.seq++
+	b, _ := msg.MarshalBinary()
+	if _, err := r.bulk.Write(b); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, r.maxMsg)
+	for {
+		n, err := r.bulk.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		resp := new(Message)
+		if err := resp.UnmarshalBinary(buf[:n]); err != nil {
+			return nil, err
+		}
+		if resp.Seq != msg.Seq || resp.Slot != msg.Slot {
+			// stale response of an earlier, timed out command
+			continue
+		}
+		switch resp.Status() & 0xC0 {
+		case commandTimeExtension:
+			continue
+		case commandFailed:
+			return resp, &SlotError{Slot: resp.Slot, Status: resp.Status(), Code: resp.Error()}
+		}
+		return resp, nil
+	}
+}
+
+// PowerOn activates the card in slot and returns its ATR.
+func (r *Reader) PowerOn(slot uint8, voltage uint8) ([]byte, error) {
+	resp, err := r.Transact(&Message{Type: MsgIccPowerOn, Slot: slot, Param: [3]byte{voltage}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+func (r *Reader) PowerOff(slot uint8) error {
+	_, err := r.Transact(&Message{Type: MsgIccPowerOff, Slot: slot})
+	return err
+}
+
+// SlotStatus returns the ICC status (IccActive, IccInactive or
+// IccNotPresent) of slot.
+func (r *Reader) SlotStatus(slot uint8) (uint8, error) {
+	resp, err := r.Transact(&Message{Type: MsgGetSlotStatus, Slot: slot})
+	if err != nil {
+		return 0, err
+	}
+	return resp.IccStatus(), nil
+}
+
+// Parameters are the protocol data structure of SetParameters and
+// GetParameters; Data is the T=0 (5 bytes) or T=1 (7 bytes) structure.
+type Parameters struct {
+	Protocol uint8
+	Data     []byte
+}
+
+func (r *Reader) GetParameters(slot uint8) (*Parameters, error) {
+	resp, err := r.Transact(&Message{Type: MsgGetParameters, Slot: slot})
+	if err != nil {
+		return nil, err
+	}
+	return &Parameters{Protocol: resp.Param[2], Data: resp.Data}, nil
+}
+func (r *Reader) SetParameters(slot uint8, params *Parameters) (*Parameters, error) {
+	resp, err := r.Transact(&Message{Type: MsgSetParameters, Slot: slot, Param: [3]byte{params.Protocol}, Data: params.Data})
+	if err != nil {
+		return nil, err
+	}
+	return &Parameters{Protocol: resp.Param[2], Data: resp.Data}, nil
+}
+
+// XfrBlock sends one data block to the card.
+func (r *Reader) XfrBlock(slot uint8, data []byte, levelParameter uint16) (*Message, error) {
+	msg := &Message{Type: MsgXfrBlock, Slot: slot, Data: data}
+	binary.LittleEndian.PutUint16(msg.Param[1:], levelParameter)
+	return r.Transact(msg)
+}
+
+// chain parameter values of XfrBlock and DataBlock
+const (
+	chainBeginEnd     = 0x00
+	chainBegin        = 0x01
+	chainEnd          = 0x02
+	chainContinue     = 0x03
+	chainEmptyRequest = 0x10
+)
+
+// Transmit exchanges an APDU with the card in slot. It requires a reader
+// with short or extended APDU level exchange, and follows response
+// chaining for extended APDUs.
+func (r *Reader) Transmit(slot uint8, apdu []byte) ([]byte, error) {
+	switch r.desc.Features & FeatureExchangeMask {
+	case FeatureShortAPDU, FeatureExtendedAPDU:
+	default:
+		return nil, errors.New("ccid: reader does not support apdu level exchange")
+	}
+	resp, err := r.XfrBlock(slot, apdu, 0)
+	if err != nil {
+		return nil, err
+	}
+	data := resp.Data
+	for resp.Param[2] == chainBegin || resp.Param[2] == chainContinue {
+		resp, err = r.XfrBlock(slot, nil, chainEmptyRequest)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, resp.Data...)
+	}
+	return data, nil
+}
+
+// Abort aborts the current command on slot, using both the control
+// request and the bulk Abort message as required by the spec.
+func (r *Reader) Abort(slot uint8) error {
+	r.mu.Lock()
+	seq := r.seq
+	r.mu.Unlock()
+	typ := gousb.EndpointOut | gousb.RequestTypeClass | gousb.RecipientInterface
+	value := uint16(seq)<<8 | uint16(slot)
+	if _, err := r.h.ControlTransfer(typ, RequestAbort, value, uint16(r.iface.InterfaceNumber), nil); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.seq = seq
+	r.mu.Unlock()
+	_, err := r.Transact(&Message{Type: MsgAbort, Slot: slot})
+	return err
+}
+
+// SlotChange is the state of one slot reported by NotifySlotChange.
+type SlotChange struct {
+	Slot    uint8
+	Present bool
+	Changed bool
+}
+
+// Notification is a message received on the interrupt endpoint.
+type Notification struct {
+	Type  uint8
+	Slots []SlotChange
+
+	// HardwareError only
+	Slot      uint8
+	Seq       uint8
+	ErrorCode uint8
+}
+
+// ReadNotification waits for the next interrupt notification.
+func (r *Reader) ReadNotification() (*Notification, error) {
+	if r.intr == nil {
+		return nil, errors.New("ccid: no interrupt endpoint")
+	}
+	buf := make([]byte, 64)
+	n, err := r.intr.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 {
+		return nil, errors.New("ccid: empty notification")
+	}
+	nt := &Notification{Type: buf[0]}
+	switch buf[0] {
+	case MsgNotifySlotChange:
+		for slot := 0; slot < r.NumSlots() && 1+slot/4 < n; slot++ {
+			bits := buf[1+slot/4] >> uint(slot%4*2)
+			nt.Slots = append(nt.Slots, SlotChange{
+				Slot:    uint8(slot),
+				Present: bits&0x01 != 0,
+				Changed: bits&0x02 != 0,
+			})
+		}
+	case MsgHardwareError:
+		if n < 4 {
+			return nil, errors.New("ccid: short notification")
+		}
+		nt.Slot, nt.Seq, nt.ErrorCode = buf[1], buf[2], buf[3]
+	default:
+		return nil, fmt.Errorf("ccid: unknown notification 0x%02x", buf[0])
+	}
+	return nt, nil
+}
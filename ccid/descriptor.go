@@ -0,0 +1,130 @@
+package ccid
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/op0xA5/gousb"
+)
+
+// DescriptorTypeCCID is the class-specific functional descriptor type.
+const DescriptorTypeCCID = uint8(0x21)
+
+const descriptorLength = 54
+
+// Protocols bits
+const (
+	ProtocolT0 = uint32(1 << 0)
+	ProtocolT1 = uint32(1 << 1)
+)
+
+// Features bits
+const (
+	FeatureAutoParameters = uint32(0x00000002)
+	FeatureAutoActivation = uint32(0x00000004)
+	FeatureAutoVoltage    = uint32(0x00000008)
+	FeatureAutoClock      = uint32(0x00000010)
+	FeatureAutoBaud       = uint32(0x00000020)
+	FeatureAutoPPS        = uint32(0x00000080)
+	FeatureStopClock      = uint32(0x00000100)
+	FeatureAutoNAD        = uint32(0x00000200)
+	FeatureAutoIFSD       = uint32(0x00000400)
+	FeatureTPDU           = uint32(0x00010000)
+	FeatureShortAPDU      = uint32(0x00020000)
+	FeatureExtendedAPDU   = uint32(0x00040000)
+	FeatureExchangeMask   = uint32(0x00070000)
+	FeatureUSBWakeUp      = uint32(0x00100000)
+)
+
+// Descriptor is the CCID class descriptor.
+type Descriptor struct {
+	Length                uint8
+	DescriptorType        uint8
+	BcdCCID               uint16
+	MaxSlotIndex          uint8
+	VoltageSupport        uint8
+	Protocols             uint32
+	DefaultClock          uint32
+	MaximumClock          uint32
+	NumClockSupported     uint8
+	DataRate              uint32
+	MaxDataRate           uint32
+	NumDataRatesSupported uint8
+	MaxIFSD               uint32
+	SynchProtocols        uint32
+	Mechanical            uint32
+	Features              uint32
+	MaxCCIDMessageLength  uint32
+	ClassGetResponse      uint8
+	ClassEnvelope         uint8
+	LcdLayout             uint16
+	PINSupport            uint8
+	MaxCCIDBusySlots      uint8
+}
+
+func (desc *Descriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *Descriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+func (desc *Descriptor) Put(b []byte) {
+	le := binary.LittleEndian
+	desc.Length = b[0]
+	desc.DescriptorType = b[1]
+	desc.BcdCCID = le.Uint16(b[2:])
+	desc.MaxSlotIndex = b[4]
+	desc.VoltageSupport = b[5]
+	desc.Protocols = le.Uint32(b[6:])
+	desc.DefaultClock = le.Uint32(b[10:])
+	desc.MaximumClock = le.Uint32(b[14:])
+	desc.NumClockSupported = b[18]
+	desc.DataRate = le.Uint32(b[19:])
+	desc.MaxDataRate = le.Uint32(b[23:])
+	desc.NumDataRatesSupported = b[27]
+	desc.MaxIFSD = le.Uint32(b[28:])
+	desc.SynchProtocols = le.Uint32(b[32:])
+	desc.Mechanical = le.Uint32(b[36:])
+	desc.Features = le.Uint32(b[40:])
+	desc.MaxCCIDMessageLength = le.Uint32(b[44:])
+	desc.ClassGetResponse = b[48]
+	desc.ClassEnvelope = b[49]
+	desc.LcdLayout = le.Uint16(b[50:])
+	desc.PINSupport = b[52]
+	desc.MaxCCIDBusySlots = b[53]
+}
+
+// ParseDescriptor parses a CCID class descriptor.
+func ParseDescriptor(b []byte) (*Descriptor, error) {
+	if len(b) < 2 {
+		return nil, errors.New("too less bytes")
+	}
+	if b[1] != DescriptorTypeCCID {
+		return nil, errors.New("not a ccid descriptor")
+	}
+	if b[0] != descriptorLength || len(b) < descriptorLength {
+		return nil, errors.New("descriptor length mismatch")
+	}
+	desc := new(Descriptor)
+	desc.Put(b)
+	return desc, nil
+}
+
+func init() {
+	gousb.RegisterDescriptor(gousb.ClassSmartCard, DescriptorTypeCCID, func(iface *gousb.InterfaceDescriptor, b []byte) (gousb.Descriptor, error) {
+		return ParseDescriptor(b)
+	})
+}
+
+// findDescriptor looks for the CCID descriptor in a run of
+// class-specific descriptors.
+func findDescriptor(extra []byte) *Descriptor {
+	for len(extra) >= 2 && int(extra[0]) >= 2 && int(extra[0]) <= len(extra) {
+		if desc, err := ParseDescriptor(extra[:extra[0]]); err == nil {
+			return desc
+		}
+		extra = extra[extra[0]:]
+	}
+	return nil
+}
@@ -0,0 +1,457 @@
+// Package cdcnet drives CDC Ethernet (ECM) and Network Control Model (NCM)
+// interfaces, exposing whole Ethernet frames for use behind a TAP device.
+package cdcnet
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/op0xA5/gousb"
+)
+
+// communications interface subclasses
+const (
+	SubClassECM = uint8(0x06)
+	SubClassNCM = uint8(0x0D)
+)
+
+// class-specific requests
+const (
+	RequestSetEthernetMulticastFilters = uint8(0x40)
+	RequestSetEthernetPacketFilter     = uint8(0x43)
+	RequestGetEthernetStatistic        = uint8(0x44)
+	RequestGetNTBParameters            = uint8(0x80)
+	RequestGetNetAddress               = uint8(0x81)
+	RequestSetNetAddress               = uint8(0x82)
+	RequestGetNTBFormat                = uint8(0x83)
+	RequestSetNTBFormat                = uint8(0x84)
+	RequestGetNTBInputSize             = uint8(0x85)
+	RequestSetNTBInputSize             = uint8(0x86)
+	RequestGetMaxDatagramSize          = uint8(0x87)
+	RequestSetMaxDatagramSize          = uint8(0x88)
+)
+
+// packet filter bits
+const (
+	FilterPromiscuous  = uint16(0x01)
+	FilterAllMulticast = uint16(0x02)
+	FilterDirected     = uint16(0x04)
+	FilterBroadcast    = uint16(0x08)
+	FilterMulticast    = uint16(0x10)
+)
+
+// notification codes
+const (
+	NotifyNetworkConnection = uint8(0x00)
+	NotifyResponseAvailable = uint8(0x01)
+	NotifySpeedChange       = uint8(0x2A)
+)
+
+const (
+	defaultMaxSegmentSize = 1514
+	notificationLength    = 8
+)
+
+const requestIn = gousb.EndpointIn | gousb.RequestTypeClass | gousb.RecipientInterface
+const requestOut = gousb.EndpointOut | gousb.RequestTypeClass | gousb.RecipientInterface
+
+// Notification is a message received on the interrupt endpoint.
+type Notification struct {
+	Code  uint8
+	Value uint16
+	Index uint16
+	Data  []byte
+}
+
+// Connected reports the link state of a NETWORK_CONNECTION notification.
+func (nt *Notification) Connected() bool {
+	return nt.Code == NotifyNetworkConnection && nt.Value != 0
+}
+
+// Speed returns the downlink and uplink bit rates of a
+// CONNECTION_SPEED_CHANGE notification.
+func (nt *Notification) Speed() (down, up uint32) {
+	if nt.Code != NotifySpeedChange || len(nt.Data) < 8 {
+		return 0, 0
+	}
+	return binary.LittleEndian.Uint32(nt.Data), binary.LittleEndian.Uint32(nt.Data[4:])
+}
+
+// Device is a claimed ECM or NCM function: the communications interface
+// and its data interface.
+type Device struct {
+	h    *gousb.Handle
+	ctrl *gousb.Interface
+	data *gousb.Interface
+
+	ether  *EthernetDescriptor
+	ncm    *NCMDescriptor
+	params *NTBParameters
+
+	r       *gousb.BulkTransfer
+	w       *gousb.BulkTransfer
+	intr    *gousb.InterruptTransfer
+	maxOut  int
+	claimed bool
+
+	// read side
+	rbuf    []byte
+	pending [][]byte
+
+	// write side
+	wmu sync.Mutex
+	enc NTBEncoder
+
+	mu        sync.Mutex
+	connected bool
+	down, up  uint32
+}
+
+// Open finds the first ECM or NCM function in the active configuration and
+// claims it.
+func Open(h *gousb.Handle) (*Device, error) {
+	cfg, err := h.GetActiveConfigDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	for _, ctrl := range cfg.InterfacesByClass(gousb.ClassCDCControl) {
+		if ctrl.InterfaceSubClass != SubClassECM && ctrl.InterfaceSubClass != SubClassNCM {
+			continue
+		}
+		// the union names the data interface; without one fall back to
+		// the other interface of the IAD function
+		var number uint8
+		if union, err := ParseUnionDescriptor(findFunctional(ctrl.Extra, SubtypeUnion)); err == nil && len(union.SubordinateInterfaces) > 0 {
+			number = union.SubordinateInterfaces[0]
+		} else if fn := cfg.Function(ctrl.InterfaceNumber); fn != nil && fn.InterfaceCount == 2 {
+			number = fn.FirstInterface
+			if number == ctrl.InterfaceNumber {
+				number++
+			}
+		} else {
+			continue
+		}
+		// the data interface has no endpoints in alt 0, pick the one
+		// that carries the bulk pipes
+		for _, data := range cfg.Interfaces {
+			if data.InterfaceNumber == number && data.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk) != nil {
+				return New(h, ctrl, data)
+			}
+		}
+	}
+	return nil, gousb.ErrNotFound
+}
+
+// New claims the communications interface ctrl and the data interface
+// alternate setting data, and brings the data path up.
+func New(h *gousb.Handle, ctrl, data *gousb.Interface) (*Device, error) {
+	ether, err := ParseEthernetDescriptor(findFunctional(ctrl.Extra, SubtypeEthernet))
+	if err != nil {
+		return nil, errors.New("cdcnet: ethernet functional descriptor not found")
+	}
+	in := data.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk)
+	out := data.FindEndpoint(gousb.EndpointOut, gousb.TransferTypeBulk)
+	if in == nil || out == nil {
+		return nil, errors.New("cdcnet: bulk endpoints not found")
+	}
+	if err := h.ClaimInterface(int(ctrl.InterfaceNumber)); err != nil {
+		return nil, err
+	}
+	if err := h.ClaimInterface(int(data.InterfaceNumber)); err != nil {
+		h.ReleaseInterface(int(ctrl.InterfaceNumber))
+		return nil, err
+	}
+	d := &Device{
+		h:       h,
+		ctrl:    ctrl,
+		data:    data,
+		ether:   ether,
+		r:       h.GetBulkTransfer(in.EndpointAddress, 0),
+		w:       h.GetBulkTransfer(0, out.EndpointAddress),
+		maxOut:  int(out.MaxPacketSize & 0x7ff),
+		claimed: true,
+	}
+	if d.maxOut == 0 {
+		d.maxOut = 64
+	}
+	if ep := ctrl.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeInterrupt); ep != nil {
+		d.intr = h.GetInterruptTransfer(ep.EndpointAddress)
+	}
+	if err := d.start(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Device) start() error {
+	if d.IsNCM() {
+		if b := findFunctional(d.ctrl.Extra, SubtypeNCM); b != nil {
+			d.ncm, _ = ParseNCMDescriptor(b)
+		}
+		// NTB parameters are only valid while the data interface is in
+		// its no-endpoint setting
+		if err := d.h.SetInterfaceAltSetting(int(d.data.InterfaceNumber), 0); err != nil {
+			return err
+		}
+		if err := d.getNTBParameters(); err != nil {
+			return err
+		}
+		d.rbuf = make([]byte, d.params.NtbInMaxSize)
+	} else {
+		size := int(d.ether.MaxSegmentSize)
+		if size < defaultMaxSegmentSize {
+			size = defaultMaxSegmentSize
+		}
+		// round up to whole packets so a full frame never overflows
+		in := d.data.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk)
+		if max := int(in.MaxPacketSize & 0x7ff); max > 0 {
+			size = (size + max - 1) / max * max
+		}
+		d.rbuf = make([]byte, size)
+	}
+	if err := d.h.SetInterfaceAltSetting(int(d.data.InterfaceNumber), int(d.data.AlternateSetting)); err != nil {
+		return err
+	}
+	return d.SetPacketFilter(FilterDirected | FilterBroadcast | FilterAllMulticast)
+}
+
+func (d *Device) getNTBParameters() error {
+	buf := make([]byte, ntbParametersLength)
+	n, err := d.h.ControlTransfer(requestIn, RequestGetNTBParameters, 0, uint16(d.ctrl.InterfaceNumber), buf)
+	if err != nil {
+		return err
+	}
+	if n < ntbParametersLength {
+		return errors.New("cdcnet: short ntb parameters")
+	}
+	d.params = new(NTBParameters)
+	d.params.Put(buf)
+	if d.params.NtbInMaxSize < 2048 {
+		return errors.New("cdcnet: bad ntb input size")
+	}
+	d.enc = NTBEncoder{
+		Format:    NTB16,
+		Divisor:   int(d.params.NdpOutDivisor),
+		Remainder: int(d.params.NdpOutPayloadRemainder),
+		Alignment: int(d.params.NdpOutAlignment),
+		MaxSize:   int(d.params.NtbOutMaxSize),
+	}
+	return nil
+}
+
+func (d *Device) Close() error {
+	if !d.claimed {
+		return nil
+	}
+	d.claimed = false
+	d.h.SetInterfaceAltSetting(int(d.data.InterfaceNumber), 0)
+	d.h.ReleaseInterface(int(d.data.InterfaceNumber))
+	return d.h.ReleaseInterface(int(d.ctrl.InterfaceNumber))
+}
+
+func (d *Device) IsNCM() bool {
+	return d.ctrl.InterfaceSubClass == SubClassNCM
+}
+func (d *Device) EthernetDescriptor() *EthernetDescriptor {
+	return d.ether
+}
+func (d *Device) NCMDescriptor() *NCMDescriptor {
+	return d.ncm
+}
+
+// NTBParameters returns the device's NTB limits, nil on ECM.
+func (d *Device) NTBParameters() *NTBParameters {
+	return d.params
+}
+
+// MTU returns the largest IP packet that fits a frame.
+func (d *Device) MTU() int {
+	size := int(d.ether.MaxSegmentSize)
+	if size == 0 {
+		size = defaultMaxSegmentSize
+	}
+	return size - 14
+}
+
+func (d *Device) SetTimeout(timeout uint) {
+	d.r.SetTimeout(timeout)
+	d.w.SetTimeout(timeout)
+}
+
+// MACAddress reads the permanent address from the string descriptor named
+// by the Ethernet functional descriptor.
+func (d *Device) MACAddress() (net.HardwareAddr, error) {
+	if d.ether.MACAddress == 0 {
+		return nil, errors.New("cdcnet: no mac address string")
+	}
+	s, err := d.h.GetStringDescriptor(d.ether.MACAddress, 0x0409)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := hex.DecodeString(s)
+	if err != nil || len(mac) != 6 {
+		return nil, errors.New("cdcnet: bad mac address string")
+	}
+	return net.HardwareAddr(mac), nil
+}
+
+func (d *Device) SetPacketFilter(filter uint16) error {
+	_, err := d.h.ControlTransfer(requestOut, RequestSetEthernetPacketFilter, filter, uint16(d.ctrl.InterfaceNumber), nil)
+	return err
+}
+
+// SetMulticastFilters sets the exact multicast addresses to receive.
+func (d *Device) SetMulticastFilters(addrs []net.HardwareAddr) error {
+	buf := make([]byte, 0, len(addrs)*6)
+	for _, a := range addrs {
+		if len(a) != 6 {
+			return errors.New("cdcnet: bad multicast address")
+		}
+		buf = append(buf, a...)
+	}
+	_, err := d.h.ControlTransfer(requestOut, RequestSetEthernetMulticastFilters, uint16(len(addrs)), uint16(d.ctrl.InterfaceNumber), buf)
+	return err
+}
+
+// SetNTBFormat switches an NCM function between NTB16 and NTB32. The data
+// interface is reset in the process.
+func (d *Device) SetNTBFormat(format NTBFormat) error {
+	if !d.IsNCM() {
+		return gousb.ErrNotSupported
+	}
+	if !d.params.Supports(format) {
+		return gousb.ErrNotSupported
+	}
+	number := int(d.data.InterfaceNumber)
+	if err := d.h.SetInterfaceAltSetting(number, 0); err != nil {
+		return err
+	}
+	_, err := d.h.ControlTransfer(requestOut, RequestSetNTBFormat, uint16(format), uint16(d.ctrl.InterfaceNumber), nil)
+	if err == nil {
+		d.wmu.Lock()
+		d.enc.Format = format
+		d.wmu.Unlock()
+		d.pending = nil
+	}
+	if err2 := d.h.SetInterfaceAltSetting(number, int(d.data.AlternateSetting)); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// ReadPacket reads the next Ethernet frame into b. NCM blocks carrying
+// several frames are returned one frame per call.
+func (d *Device) ReadPacket(b []byte) (int, error) {
+	if !d.IsNCM() {
+		n, err := d.r.Read(d.rbuf)
+		if err != nil {
+			return 0, err
+		}
+		if n > len(b) {
+			return 0, io.ErrShortBuffer
+		}
+		return copy(b, d.rbuf[:n]), nil
+	}
+	for len(d.pending) == 0 {
+		n, err := d.r.Read(d.rbuf)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			continue
+		}
+		list, err := DecodeNTB(d.rbuf[:n])
+		if err != nil {
+			return 0, err
+		}
+		d.pending = list
+	}
+	frame := d.pending[0]
+	d.pending = d.pending[1:]
+	if len(frame) > len(b) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(b, frame), nil
+}
+
+// WritePacket sends one Ethernet frame.
+func (d *Device) WritePacket(b []byte) error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+
+	if d.IsNCM() {
+		ntb, err := d.enc.Encode([][]byte{b})
+		if err != nil {
+			return err
+		}
+		b = ntb
+	}
+	if _, err := d.w.Write(b); err != nil {
+		return err
+	}
+	// a transfer that ends on a packet boundary needs a ZLP, unless an NTB
+	// fills dwNtbOutMaxSize exactly
+	if len(b)%d.maxOut == 0 && (d.params == nil || len(b) < int(d.params.NtbOutMaxSize)) {
+		_, err := d.w.Write(nil)
+		return err
+	}
+	return nil
+}
+
+// ReadNotification waits for the next interrupt notification and updates
+// the link state.
+func (d *Device) ReadNotification() (*Notification, error) {
+	if d.intr == nil {
+		return nil, errors.New("cdcnet: no interrupt endpoint")
+	}
+	buf := make([]byte, 16)
+	n, err := d.intr.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < notificationLength {
+		return nil, errors.New("cdcnet: short notification")
+	}
+	le := binary.LittleEndian
+	nt := &Notification{
+		Code:  buf[1],
+		Value: le.Uint16(buf[2:]),
+		Index: le.Uint16(buf[4:]),
+	}
+	length := int(le.Uint16(buf[6:]))
+	if notificationLength+length > n {
+		length = n - notificationLength
+	}
+	nt.Data = buf[notificationLength : notificationLength+length]
+
+	d.mu.Lock()
+	switch nt.Code {
+	case NotifyNetworkConnection:
+		d.connected = nt.Connected()
+	case NotifySpeedChange:
+		d.down, d.up = nt.Speed()
+	}
+	d.mu.Unlock()
+	return nt, nil
+}
+
+// Connected returns the link state from the last NETWORK_CONNECTION
+// notification.
+func (d *Device) Connected() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.connected
+}
+
+// Speed returns the bit rates from the last CONNECTION_SPEED_CHANGE
+// notification.
+func (d *Device) Speed() (down, up uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.down, d.up
+}
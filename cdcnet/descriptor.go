@@ -0,0 +1,200 @@
+package cdcnet
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/op0xA5/gousb"
+)
+
+// DescriptorTypeCSInterface is the class-specific interface descriptor
+// type carrying the CDC functional descriptors.
+const DescriptorTypeCSInterface = uint8(0x24)
+
+// functional descriptor subtypes
+const (
+	SubtypeHeader   = uint8(0x00)
+	SubtypeUnion    = uint8(0x06)
+	SubtypeEthernet = uint8(0x0F)
+	SubtypeNCM      = uint8(0x1A)
+)
+
+const (
+	ethernetDescriptorLength = 13
+	ncmDescriptorLength      = 6
+)
+
+// EthernetDescriptor is the Ethernet Networking functional descriptor.
+type EthernetDescriptor struct {
+	Length             uint8
+	DescriptorType     uint8
+	DescriptorSubtype  uint8
+	MACAddress         uint8
+	EthernetStatistics uint32
+	MaxSegmentSize     uint16
+	NumberMCFilters    uint16
+	NumberPowerFilters uint8
+}
+
+func (desc *EthernetDescriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *EthernetDescriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+func (desc *EthernetDescriptor) Put(b []byte) {
+	le := binary.LittleEndian
+	desc.Length = b[0]
+	desc.DescriptorType = b[1]
+	desc.DescriptorSubtype = b[2]
+	desc.MACAddress = b[3]
+	desc.EthernetStatistics = le.Uint32(b[4:])
+	desc.MaxSegmentSize = le.Uint16(b[8:])
+	desc.NumberMCFilters = le.Uint16(b[10:])
+	desc.NumberPowerFilters = b[12]
+}
+
+// PerfectMCFiltering reports whether the device filters multicast
+// addresses exactly rather than by hash.
+func (desc *EthernetDescriptor) PerfectMCFiltering() bool {
+	return desc.NumberMCFilters&0x8000 == 0
+}
+
+// ParseEthernetDescriptor parses an Ethernet Networking functional
+// descriptor.
+func ParseEthernetDescriptor(b []byte) (*EthernetDescriptor, error) {
+	if len(b) < 3 {
+		return nil, errors.New("cdcnet: short functional descriptor")
+	}
+	if b[1] != DescriptorTypeCSInterface || b[2] != SubtypeEthernet {
+		return nil, errors.New("cdcnet: not an ethernet functional descriptor")
+	}
+	if b[0] < ethernetDescriptorLength || len(b) < ethernetDescriptorLength {
+		return nil, errors.New("cdcnet: descriptor length mismatch")
+	}
+	desc := new(EthernetDescriptor)
+	desc.Put(b)
+	return desc, nil
+}
+
+// NCM network capabilities bits
+const (
+	CapSetEthernetPacketFilter = uint8(0x01)
+	CapNetAddress              = uint8(0x02)
+	CapEncapsulatedCommand     = uint8(0x04)
+	CapMaxDatagramSize         = uint8(0x08)
+	CapCRCMode                 = uint8(0x10)
+	CapNTBInputSize8Byte       = uint8(0x20)
+)
+
+// NCMDescriptor is the NCM functional descriptor.
+type NCMDescriptor struct {
+	Length              uint8
+	DescriptorType      uint8
+	DescriptorSubtype   uint8
+	BcdNcmVersion       uint16
+	NetworkCapabilities uint8
+}
+
+func (desc *NCMDescriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *NCMDescriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+func (desc *NCMDescriptor) Put(b []byte) {
+	desc.Length = b[0]
+	desc.DescriptorType = b[1]
+	desc.DescriptorSubtype = b[2]
+	desc.BcdNcmVersion = binary.LittleEndian.Uint16(b[3:])
+	desc.NetworkCapabilities = b[5]
+}
+
+// ParseNCMDescriptor parses an NCM functional descriptor.
+func ParseNCMDescriptor(b []byte) (*NCMDescriptor, error) {
+	if len(b) < 3 {
+		return nil, errors.New("cdcnet: short functional descriptor")
+	}
+	if b[1] != DescriptorTypeCSInterface || b[2] != SubtypeNCM {
+		return nil, errors.New("cdcnet: not an ncm functional descriptor")
+	}
+	if b[0] < ncmDescriptorLength || len(b) < ncmDescriptorLength {
+		return nil, errors.New("cdcnet: descriptor length mismatch")
+	}
+	desc := new(NCMDescriptor)
+	desc.Put(b)
+	return desc, nil
+}
+
+// UnionDescriptor is the Union functional descriptor, naming the control
+// interface and the interfaces it manages.
+type UnionDescriptor struct {
+	Length                uint8
+	DescriptorType        uint8
+	DescriptorSubtype     uint8
+	ControlInterface      uint8
+	SubordinateInterfaces []uint8
+}
+
+func (desc *UnionDescriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *UnionDescriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+// ParseUnionDescriptor parses a Union functional descriptor.
+func ParseUnionDescriptor(b []byte) (*UnionDescriptor, error) {
+	if len(b) < 3 {
+		return nil, errors.New("cdcnet: short functional descriptor")
+	}
+	if b[1] != DescriptorTypeCSInterface || b[2] != SubtypeUnion {
+		return nil, errors.New("cdcnet: not a union functional descriptor")
+	}
+	if b[0] < 5 || len(b) < int(b[0]) {
+		return nil, errors.New("cdcnet: descriptor length mismatch")
+	}
+	desc := &UnionDescriptor{
+		Length:            b[0],
+		DescriptorType:    b[1],
+		DescriptorSubtype: b[2],
+		ControlInterface:  b[3],
+	}
+	desc.SubordinateInterfaces = append(desc.SubordinateInterfaces, b[4:b[0]]...)
+	return desc, nil
+}
+
+// parseFunctional decodes the functional descriptors this package knows
+// and leaves others, and ones too short to decode, to the registry.
+func parseFunctional(iface *gousb.InterfaceDescriptor, b []byte) (gousb.Descriptor, error) {
+	if len(b) < 3 {
+		return nil, nil
+	}
+	switch {
+	case b[2] == SubtypeUnion && len(b) >= 5:
+		return ParseUnionDescriptor(b)
+	case b[2] == SubtypeEthernet && len(b) >= ethernetDescriptorLength:
+		return ParseEthernetDescriptor(b)
+	case b[2] == SubtypeNCM && len(b) >= ncmDescriptorLength:
+		return ParseNCMDescriptor(b)
+	}
+	return nil, nil
+}
+
+func init() {
+	gousb.RegisterDescriptor(gousb.ClassCDCControl, DescriptorTypeCSInterface, parseFunctional)
+}
+
+// findFunctional returns the first functional descriptor of subtype in a
+// run of class-specific descriptors.
+func findFunctional(extra []byte, subtype uint8) []byte {
+	for len(extra) >= 3 && int(extra[0]) >= 3 && int(extra[0]) <= len(extra) {
+		if extra[1] == DescriptorTypeCSInterface && extra[2] == subtype {
+			return extra[:extra[0]]
+		}
+		extra = extra[extra[0]:]
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package cdcnet
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// NTBFormat type
+type NTBFormat int
+
+// NTBFormat values, as used by SET_NTB_FORMAT
+const (
+	NTB16 = NTBFormat(0)
+	NTB32 = NTBFormat(1)
+)
+
+// NTB header and datagram pointer signatures
+const (
+	signatureNTH16 = uint32(0x484D434E) // "NCMH"
+	signatureNTH32 = uint32(0x686D636E) // "ncmh"
+	signatureNDP16 = uint32(0x304D434E) // "NCM0"
+	signatureNDP32 = uint32(0x306D636E) // "ncm0"
+)
+
+const (
+	nth16Length = 12
+	nth32Length = 16
+	ndp16Length = 8
+	ndp32Length = 16
+)
+
+// NTBParameters is the response to GET_NTB_PARAMETERS.
+type NTBParameters struct {
+	Length                 uint16
+	NtbFormatsSupported    uint16
+	NtbInMaxSize           uint32
+	NdpInDivisor           uint16
+	NdpInPayloadRemainder  uint16
+	NdpInAlignment         uint16
+	NtbOutMaxSize          uint32
+	NdpOutDivisor          uint16
+	NdpOutPayloadRemainder uint16
+	NdpOutAlignment        uint16
+	NtbOutMaxDatagrams     uint16
+}
+
+const ntbParametersLength = 28
+
+func (p *NTBParameters) Put(b []byte) {
+	le := binary.LittleEndian
+	p.Length = le.Uint16(b[0:])
+	p.NtbFormatsSupported = le.Uint16(b[2:])
+	p.NtbInMaxSize = le.Uint32(b[4:])
+	p.NdpInDivisor = le.Uint16(b[8:])
+	p.NdpInPayloadRemainder = le.Uint16(b[10:])
+	p.NdpInAlignment = le.Uint16(b[12:])
+	p.NtbOutMaxSize = le.Uint32(b[16:])
+	p.NdpOutDivisor = le.Uint16(b[20:])
+	p.NdpOutPayloadRemainder = le.Uint16(b[22:])
+	p.NdpOutAlignment = le.Uint16(b[24:])
+	p.NtbOutMaxDatagrams = le.Uint16(b[26:])
+}
+
+func (p *NTBParameters) Supports(format NTBFormat) bool {
+	return p.NtbFormatsSupported&(1<<uint(format)) != 0
+}
+
+// NTBEncoder builds transfer blocks for the OUT direction, honoring the
+// device's datagram alignment.
+type NTBEncoder struct {
+	Format    NTBFormat
+	Divisor   int
+	Remainder int
+	Alignment int
+	MaxSize   int
+
+	seq uint16
+}
+
+func alignUp(off, divisor, remainder int) int {
+	if divisor <= 1 {
+		return off
+	}
+	return off + ((remainder-off)%divisor+divisor)%divisor
+}
+
+// Encode packs datagrams into one NTB with a single datagram pointer
+// table after the payload.
+func (e *NTBEncoder) Encode(datagrams [][]byte) ([]byte, error) {
+	if len(datagrams) == 0 {
+		return nil, errors.New("cdcnet: no datagrams")
+	}
+	le := binary.LittleEndian
+	hdrLen, ndpLen, entLen := nth16Length, ndp16Length, 4
+	if e.Format == NTB32 {
+		hdrLen, ndpLen, entLen = nth32Length, ndp32Length, 8
+	}
+
+	off := hdrLen
+	index := make([]int, len(datagrams))
+	for i, d := range datagrams {
+		off = alignUp(off, e.Divisor, e.Remainder)
+		index[i] = off
+		off += len(d)
+	}
+	align := e.Alignment
+	if align < 4 {
+		align = 4
+	}
+	ndpIndex := alignUp(off, align, 0)
+	// entries plus the zero terminator
+	ndpSize := ndpLen + (len(datagrams)+1)*entLen
+	total := ndpIndex + ndpSize
+	if e.MaxSize > 0 && total > e.MaxSize {
+		return nil, errors.New("cdcnet: ntb too large")
+	}
+
+	b := make([]byte, total)
+	for i, d := range datagrams {
+		copy(b[index[i]:], d)
+	}
+	ndp := b[ndpIndex:]
+	if e.Format == NTB32 {
+		le.PutUint32(b[0:], signatureNTH32)
+		le.PutUint16(b[4:], nth32Length)
+		le.PutUint16(b[6:], e.seq)
+		le.PutUint32(b[8:], uint32(total))
+		le.PutUint32(b[12:], uint32(ndpIndex))
+
+		le.PutUint32(ndp[0:], signatureNDP32)
+		le.PutUint16(ndp[4:], uint16(ndpSize))
+		for i, d := range datagrams {
+			le.PutUint32(ndp[ndpLen+i*8:], uint32(index[i]))
+			le.PutUint32(ndp[ndpLen+i*8+4:], uint32(len(d)))
+		}
+	} else {
+		if total > 0xffff {
+			return nil, errors.New("cdcnet: ntb too large")
+		}
+		le.PutUint32(b[0:], signatureNTH16)
+		le.PutUint16(b[4:], nth16Length)
+		le.PutUint16(b[6:], e.seq)
+		le.PutUint16(b[8:], uint16(total))
+		le.PutUint16(b[10:], uint16(ndpIndex))
+
+		le.PutUint32(ndp[0:], signatureNDP16)
+		le.PutUint16(ndp[4:], uint16(ndpSize))
+		for i, d := range datagrams {
+			le.PutUint16(ndp[ndpLen+i*4:], uint16(index[i]))
+			le.PutUint16(ndp[ndpLen+i*4+2:], uint16(len(d)))
+		}
+	}
+	e.seq++
+	return b, nil
+}
+
+// DecodeNTB returns the datagrams of an NTB16 or NTB32 block, detected by
+// its signature. The datagrams alias b.
+func DecodeNTB(b []byte) ([][]byte, error) {
+	if len(b) < nth16Length {
+		return nil, errors.New("cdcnet: short ntb")
+	}
+	le := binary.LittleEndian
+	var list [][]byte
+	switch le.Uint32(b) {
+	case signatureNTH16:
+		blockLen := int(le.Uint16(b[8:]))
+		if blockLen < nth16Length || blockLen > len(b) {
+			return nil, errors.New("cdcnet: truncated ntb")
+		}
+		b = b[:blockLen]
+		next := int(le.Uint16(b[10:]))
+		for n := 0; next != 0; n++ {
+			if n > 64 || next+ndp16Length > len(b) {
+				return nil, errors.New("cdcnet: bad ndp index")
+			}
+			ndp := b[next:]
+			sig := le.Uint32(ndp) &^ 0xff000000
+			if sig != signatureNDP16&^0xff000000 {
+				return nil, errors.New("cdcnet: bad ndp16 signature")
+			}
+			ndpLen := int(le.Uint16(ndp[4:]))
+			if ndpLen < ndp16Length || ndpLen > len(ndp) {
+				return nil, errors.New("cdcnet: bad ndp length")
+			}
+			next = int(le.Uint16(ndp[6:]))
+			for e := ndp[ndp16Length:ndpLen]; len(e) >= 4; e = e[4:] {
+				index, length := int(le.Uint16(e)), int(le.Uint16(e[2:]))
+				if index == 0 || length == 0 {
+					break
+				}
+				if index+length > len(b) {
+					return nil, errors.New("cdcnet: datagram out of range")
+				}
+				list = append(list, b[index:index+length])
+			}
+		}
+	case signatureNTH32:
+		if len(b) < nth32Length {
+			return nil, errors.New("cdcnet: short ntb")
+		}
+		blockLen := int(le.Uint32(b[8:]))
+		if blockLen < nth32Length || blockLen > len(b) {
+			return nil, errors.New("cdcnet: truncated ntb")
+		}
+		b = b[:blockLen]
+		next := int(le.Uint32(b[12:]))
+		for n := 0; next != 0; n++ {
+			if n > 64 || next+ndp32Length > len(b) {
+				return nil, errors.New("cdcnet: bad ndp index")
+			}
+			ndp := b[next:]
+			sig := le.Uint32(ndp) &^ 0xff000000
+			if sig != signatureNDP32&^0xff000000 {
+				return nil, errors.New("cdcnet: bad ndp32 signature")
+			}
+			ndpLen := int(le.Uint16(ndp[4:]))
+			if ndpLen < ndp32Length || ndpLen > len(ndp) {
+				return nil, errors.New("cdcnet: bad ndp length")
+			}
+			next = int(le.Uint32(ndp[8:]))
+			for e := ndp[ndp32Length:ndpLen]; len(e) >= 8; e = e[8:] {
+				index, length := int(le.Uint32(e)), int(le.Uint32(e[4:]))
+				if index == 0 || length == 0 {
+					break
+				}
+				if index+length > len(b) {
+					return nil, errors.New("cdcnet: datagram out of range")
+				}
+				list = append(list, b[index:index+length])
+			}
+		}
+	default:
+		return nil, errors.New("cdcnet: bad nth signature")
+	}
+	return list, nil
+}
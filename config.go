@@ -0,0 +1,259 @@
+package gousb
+
+import (
+	"errors"
+)
+
+// Endpoint is an endpoint descriptor together with the class-specific
+// descriptors that follow it in the configuration. Companion and
+// IsoCompanion are only set on SuperSpeed configurations.
+type Endpoint struct {
+	EndpointDescriptor `yaml:",inline"`
+	Companion          *SSEndpointCompanionDescriptor     `json:"companion,omitempty" yaml:"companion,omitempty"`
+	IsoCompanion       *SSPIsoEndpointCompanionDescriptor `json:"isoCompanion,omitempty" yaml:"isoCompanion,omitempty"`
+	Extra              []byte                             `json:"extra,omitempty" yaml:"extra,omitempty"`
+}
+
+// MaxBurst returns the number of packets the endpoint may send or receive
+// in a burst, 1 below SuperSpeed.
+func (ep *Endpoint) MaxBurst() int {
+	if ep.Companion == nil {
+		return 1
+	}
+	return int(ep.Companion.MaxBurst) + 1
+}
+
+// MaxStreams returns the number of bulk streams the endpoint supports.
+func (ep *Endpoint) MaxStreams() int {
+	if ep.Companion == nil || ep.TransferType() != TransferTypeBulk {
+		return 0
+	}
+	return ep.Companion.MaxStreams()
+}
+
+// BytesPerInterval returns the bytes a periodic endpoint reserves per
+// service interval.
+func (ep *Endpoint) BytesPerInterval() int {
+	switch {
+	case ep.IsoCompanion != nil:
+		return int(ep.IsoCompanion.BytesPerInterval)
+	case ep.Companion != nil:
+		return int(ep.Companion.BytesPerInterval)
+	}
+	// high speed high bandwidth endpoints encode extra transactions in
+	// bits 12..11
+	return int(ep.MaxPacketSize&0x7ff) * (int(ep.MaxPacketSize>>11&0x03) + 1)
+}
+
+// Interface is one alternate setting of an interface, with its endpoints
+// and the class-specific descriptors that follow the interface descriptor.
+type Interface struct {
+	InterfaceDescriptor `yaml:",inline"`
+	Endpoints           []*Endpoint `json:"endpoints" yaml:"endpoints"`
+	Extra               []byte      `json:"extra,omitempty" yaml:"extra,omitempty"`
+}
+
+func (iface *Interface) FindEndpoint(dir RequestType, typ TransferType) *Endpoint {
+	for _, ep := range iface.Endpoints {
+		if ep.InOut() == dir && ep.TransferType() == typ {
+			return ep
+		}
+	}
+	return nil
+}
+
+// Function is a group of interfaces bound by an interface association
+// descriptor. Interfaces holds all alternate settings of its interfaces;
+// it is not encoded, decoding a Configuration links it again.
+type Function struct {
+	InterfaceAssociationDescriptor `yaml:",inline"`
+	Interfaces                     []*Interface `json:"-" yaml:"-"`
+}
+
+// Contains reports whether interface number belongs to the function.
+func (fn *Function) Contains(number uint8) bool {
+	return number >= fn.FirstInterface && int(number) < int(fn.FirstInterface)+int(fn.InterfaceCount)
+}
+func (fn *Function) Interface(number, alt uint8) *Interface {
+	for _, iface := range fn.Interfaces {
+		if iface.InterfaceNumber == number && iface.AlternateSetting == alt {
+			return iface
+		}
+	}
+	return nil
+}
+
+// Configuration is a parsed configuration descriptor tree.
+type Configuration struct {
+	ConfigurationDescriptor `yaml:",inline"`
+	Index                   uint8        `json:"index" yaml:"index"`
+	Interfaces              []*Interface `json:"interfaces" yaml:"interfaces"`
+	Functions               []*Function  `json:"functions,omitempty" yaml:"functions,omitempty"`
+	Extra                   []byte       `json:"extra,omitempty" yaml:"extra,omitempty"`
+}
+
+func (cfg *Configuration) Interface(number, alt uint8) *Interface {
+	for _, iface := range cfg.Interfaces {
+		if iface.InterfaceNumber == number && iface.AlternateSetting == alt {
+			return iface
+		}
+	}
+	return nil
+}
+func (cfg *Configuration) InterfacesByClass(class uint8) []*Interface {
+	var list []*Interface
+	for _, iface := range cfg.Interfaces {
+		if iface.InterfaceClass == class {
+			list = append(list, iface)
+		}
+	}
+	return list
+}
+
+// Function returns the function that interface number belongs to, nil if
+// it is not part of an interface association.
+func (cfg *Configuration) Function(number uint8) *Function {
+	for _, fn := range cfg.Functions {
+		if fn.Contains(number) {
+			return fn
+		}
+	}
+	return nil
+}
+
+// ParseConfiguration parses a full configuration descriptor as returned by
+// GET_DESCRIPTOR(CONFIGURATION), including all interface and endpoint
+// descriptors. Interface association descriptors become Functions.
+// Descriptors it does not know are kept in Extra of the closest preceding
+// endpoint, interface or configuration.
+func ParseConfiguration(b []byte) (*Configuration, error) {
+	if len(b) < configurationDescriptorLength {
+		return nil, errors.New("too less bytes")
+	}
+	if b[1] != DescriptorTypeConfig {
+		return nil, errors.New("not a configuration descriptor")
+	}
+	cfg := new(Configuration)
+	cfg.ConfigurationDescriptor.Put(b)
+	if int(cfg.TotalLength) < len(b) {
+		b = b[:cfg.TotalLength]
+	}
+
+	var iface *Interface
+	var ep *Endpoint
+	for off := int(cfg.Length); off < len(b); {
+		length := int(b[off])
+		if length < 2 || off+length > len(b) {
+			return nil, errors.New("malformed descriptor")
+		}
+		d := b[off : off+length]
+		switch d[1] {
+		case DescriptorTypeInterface:
+			if length < interfaceDescriptorLength {
+				return nil, errors.New("descriptor length mismatch")
+			}
+			iface, ep = new(Interface), nil
+			iface.InterfaceDescriptor.Put(d)
+			cfg.Interfaces = append(cfg.Interfaces, iface)
+		case DescriptorTypeIAD:
+			if length < interfaceAssociationDescriptorLength {
+				return nil, errors.New("descriptor length mismatch")
+			}
+			fn := new(Function)
+			fn.InterfaceAssociationDescriptor.Put(d)
+			cfg.Functions = append(cfg.Functions, fn)
+		case DescriptorTypeEndpoint:
+			if length < endpointDescriptorLength {
+				return nil, errors.New("descriptor length mismatch")
+			}
+			if iface == nil {
+				return nil, errors.New("endpoint outside of interface")
+			}
+			ep = new(Endpoint)
+			ep.EndpointDescriptor.Put(d)
+			iface.Endpoints = append(iface.Endpoints, ep)
+		case DescriptorTypeSSEndpointCompanion:
+			if length < ssEndpointCompanionDescriptorLength {
+				return nil, errors.New("descriptor length mismatch")
+			}
+			if ep == nil {
+				return nil, errors.New("endpoint companion outside of endpoint")
+			}
+			ep.Companion = new(SSEndpointCompanionDescriptor)
+			ep.Companion.Put(d)
+		case DescriptorTypeSSPIsoEndpointCompanion:
+			if length < sspIsoEndpointCompanionDescriptorLength {
+				return nil, errors.New("descriptor length mismatch")
+			}
+			if ep == nil {
+				return nil, errors.New("endpoint companion outside of endpoint")
+			}
+			ep.IsoCompanion = new(SSPIsoEndpointCompanionDescriptor)
+			ep.IsoCompanion.Put(d)
+		default:
+			switch {
+			case ep != nil:
+				ep.Extra = append(ep.Extra, d...)
+			case iface != nil:
+				iface.Extra = append(iface.Extra, d...)
+			default:
+				cfg.Extra = append(cfg.Extra, d...)
+			}
+		}
+		off += length
+	}
+	cfg.linkFunctions()
+	return cfg, nil
+}
+
+// linkFunctions collects the interfaces of each function.
+func (cfg *Configuration) linkFunctions() {
+	for _, fn := range cfg.Functions {
+		fn.Interfaces = nil
+	}
+	for _, iface := range cfg.Interfaces {
+		if fn := cfg.Function(iface.InterfaceNumber); fn != nil {
+			fn.Interfaces = append(fn.Interfaces, iface)
+		}
+	}
+}
+
+func (h *Handle) GetConfigDescriptor(index uint8) (*Configuration, error) {
+	buf, err := h.GetDescriptorBuffer(DescriptorTypeConfig, index, make([]byte, configurationDescriptorLength))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < configurationDescriptorLength {
+		return nil, errors.New("too less bytes")
+	}
+	total := usbEncoding.Uint16(buf[2:])
+	if total < configurationDescriptorLength {
+		return nil, errors.New("descriptor length mismatch")
+	}
+	buf, err = h.GetDescriptorBuffer(DescriptorTypeConfig, index, make([]byte, total))
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfiguration(buf)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Index = index
+	return cfg, nil
+}
+func (h *Handle) GetActiveConfigDescriptor() (*Configuration, error) {
+	value, err := h.GetConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < int(h.dev.NumConfiguation); i++ {
+		cfg, err := h.GetConfigDescriptor(uint8(i))
+		if err != nil {
+			return nil, err
+		}
+		if int(cfg.ConfigurationValue) == value {
+			return cfg, nil
+		}
+	}
+	return nil, ErrNotFound
+}
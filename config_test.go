@@ -0,0 +1,171 @@
+package gousb
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// superSpeedConfig has one interface whose endpoints cover the endpoint
+// companion variants.
+var superSpeedConfig = []byte{
+	0x09, 0x02, 0x66, 0x00, 0x01, 0x01, 0x00, 0x80, 0x32,
+	0x09, 0x04, 0x00, 0x00, 0x06, 0xff, 0x00, 0x00, 0x00,
+	0x07, 0x05, 0x81, 0x02, 0x00, 0x04, 0x00, // bulk, 16 streams
+	0x06, 0x30, 0x0f, 0x04, 0x00, 0x00,
+	0x07, 0x05, 0x02, 0x02, 0x00, 0x04, 0x00, // bulk without streams
+	0x06, 0x30, 0x03, 0x00, 0x00, 0x00,
+	0x07, 0x05, 0x83, 0x05, 0x00, 0x04, 0x01, // isochronous, Mult 2
+	0x06, 0x30, 0x03, 0x02, 0x00, 0x30,
+	0x04, 0x25, 0x01, 0x00,
+	0x07, 0x05, 0x84, 0x05, 0x00, 0x04, 0x01, // SuperSpeedPlus isochronous
+	0x06, 0x30, 0x0f, 0x80, 0x01, 0x00,
+	0x08, 0x31, 0x00, 0x00, 0x00, 0x80, 0x01, 0x00,
+	0x07, 0x05, 0x85, 0x03, 0x40, 0x00, 0x04, // interrupt
+	0x06, 0x30, 0x00, 0x00, 0x40, 0x00,
+	0x07, 0x05, 0x86, 0x05, 0x00, 0x14, 0x01, // high speed, three transactions
+}
+
+func TestParseConfigurationCompanions(t *testing.T) {
+	cfg, err := ParseConfiguration(superSpeedConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// burst, streams, mult and bytes per interval of each endpoint
+	want := []string{
+		"16 16 0 0",
+		"4 0 0 0",
+		"4 0 2 12288",
+		"16 0 0 98304",
+		"1 0 0 64",
+		"1 0 0 3072",
+	}
+	eps := cfg.Interfaces[0].Endpoints
+	if len(eps) != len(want) {
+		t.Fatalf("%d endpoints, want %d", len(eps), len(want))
+	}
+	for i, ep := range eps {
+		mult := 0
+		if ep.Companion != nil {
+			mult = ep.Companion.Mult()
+		}
+		got := fmt.Sprintf("%d %d %d %d", ep.MaxBurst(), ep.MaxStreams(), mult, ep.BytesPerInterval())
+		if got != want[i] {
+			t.Errorf("endpoint %#02x: got %q, want %q", ep.EndpointAddress, got, want[i])
+		}
+	}
+	if eps[3].IsoCompanion == nil || eps[4].IsoCompanion != nil {
+		t.Errorf("isochronous companions %v, %v", eps[3].IsoCompanion, eps[4].IsoCompanion)
+	}
+	if string(eps[2].Extra) != "\x04\x25\x01\x00" {
+		t.Errorf("class descriptor after the companion: Extra % x", eps[2].Extra)
+	}
+
+	for in, want := range map[string]string{
+		// companions without an endpoint
+		"\x09\x04\x00\x00\x00\xff\x00\x00\x00\x06\x30\x00\x00\x00\x00":         "endpoint companion outside of endpoint",
+		"\x09\x04\x00\x00\x00\xff\x00\x00\x00\x08\x31\x00\x00\x00\x00\x00\x00": "endpoint companion outside of endpoint",
+		// short companion
+		"\x09\x04\x00\x00\x01\xff\x00\x00\x00\x07\x05\x81\x02\x00\x04\x00\x05\x30\x00\x00\x00": "descriptor length mismatch",
+	} {
+		b := append([]byte{0x09, 0x02, byte(9 + len(in)), 0x00, 0x01, 0x01, 0x00, 0x80, 0x32}, in...)
+		if _, err := ParseConfiguration(b); err == nil || err.Error() != want {
+			t.Errorf("% x: got error %v, want %q", in, err, want)
+		}
+	}
+}
+
+// interfaceNumbers lists the interface number and alternate setting of
+// each interface.
+func interfaceNumbers(list []*Interface) [][2]uint8 {
+	var n [][2]uint8
+	for _, iface := range list {
+		n = append(n, [2]uint8{iface.InterfaceNumber, iface.AlternateSetting})
+	}
+	return n
+}
+
+// compositeConfig has a CDC ACM function, a vendor interface outside of
+// any function and a UVC function with an alternate setting.
+var compositeConfig = []byte{
+	0x09, 0x02, 0x77, 0x00, 0x05, 0x01, 0x00, 0x80, 0x32,
+	0x08, 0x0b, 0x00, 0x02, 0x02, 0x00, 0x00, 0x00, // interfaces 0 and 1
+	0x09, 0x04, 0x00, 0x00, 0x01, 0x02, 0x00, 0x00, 0x00,
+	0x05, 0x24, 0x00, 0x10, 0x01, // CDC header
+	0x07, 0x05, 0x83, 0x03, 0x10, 0x00, 0x09,
+	0x09, 0x04, 0x01, 0x00, 0x02, 0x0a, 0x00, 0x00, 0x00,
+	0x07, 0x05, 0x81, 0x02, 0x00, 0x02, 0x00,
+	0x07, 0x05, 0x02, 0x02, 0x00, 0x02, 0x00,
+	0x09, 0x04, 0x02, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00,
+	0x08, 0x0b, 0x03, 0x02, 0x0e, 0x00, 0x00, 0x00, // interfaces 3 and 4
+	0x09, 0x04, 0x03, 0x00, 0x01, 0x0e, 0x00, 0x00, 0x00,
+	0x07, 0x05, 0x84, 0x03, 0x10, 0x00, 0x06,
+	0x09, 0x04, 0x04, 0x00, 0x00, 0x0e, 0x00, 0x00, 0x00,
+	0x09, 0x04, 0x04, 0x01, 0x01, 0x0e, 0x00, 0x00, 0x00,
+	0x07, 0x05, 0x85, 0x05, 0x00, 0x0c, 0x01,
+}
+
+func TestParseConfigurationFunctions(t *testing.T) {
+	cfg, err := ParseConfiguration(compositeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// decoding must link the functions again
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(Configuration)
+	if err := json.Unmarshal(b, decoded); err != nil {
+		t.Fatal(err)
+	}
+	want := [][][2]uint8{
+		{{0, 0}, {1, 0}},
+		{{3, 0}, {4, 0}, {4, 1}},
+	}
+	for _, c := range []*Configuration{cfg, decoded} {
+		var got [][][2]uint8
+		for _, fn := range c.Functions {
+			got = append(got, interfaceNumbers(fn.Interfaces))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("functions %v, want %v", got, want)
+			continue
+		}
+		for number, i := range map[uint8]int{0: 0, 1: 0, 2: -1, 3: 1, 4: 1, 5: -1} {
+			fn := c.Function(number)
+			if i < 0 && fn != nil || i >= 0 && fn != c.Functions[i] {
+				t.Errorf("Function(%d) = %v, want function %d", number, fn, i)
+			}
+		}
+	}
+	if len(cfg.Interface(0, 0).Extra) != 5 || len(cfg.Extra) != 0 {
+		t.Errorf("association descriptors end up in Extra")
+	}
+	if alt := cfg.Functions[1].Interface(4, 1); alt == nil || len(alt.Endpoints) != 1 {
+		t.Errorf("Interface(4, 1) = %v", alt)
+	}
+	if alt := cfg.Functions[1].Interface(2, 0); alt != nil {
+		t.Errorf("Interface(2, 0) = %v, want nil", alt)
+	}
+
+	if cfg, _ := ParseConfiguration(superSpeedConfig); len(cfg.Functions) != 0 || cfg.Function(0) != nil {
+		t.Errorf("configuration without associations has functions %v", cfg.Functions)
+	}
+
+	// an association naming interfaces the configuration lacks
+	cfg, err = ParseConfiguration([]byte{
+		0x09, 0x02, 0x1a, 0x00, 0x01, 0x01, 0x00, 0x80, 0x32,
+		0x08, 0x0b, 0x01, 0x01, 0x01, 0x00, 0x00, 0x00,
+		0x09, 0x04, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00,
+	})
+	if err != nil || len(cfg.Functions) != 1 || len(cfg.Functions[0].Interfaces) != 0 || cfg.Function(0) != nil || cfg.Function(1) != cfg.Functions[0] {
+		t.Errorf("association without interfaces: got %+v, %v", cfg, err)
+	}
+
+	_, err = ParseConfiguration([]byte{0x09, 0x02, 0x10, 0x00, 0x01, 0x01, 0x00, 0x80, 0x32, 0x07, 0x0b, 0x00, 0x02, 0x02, 0x00, 0x00})
+	if err == nil || err.Error() != "descriptor length mismatch" {
+		t.Errorf("short association: got error %v", err)
+	}
+}
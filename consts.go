@@ -5,6 +5,7 @@ package gousb
 	#include <libusb.h>
 */
 import "C"
+import "fmt"
 
 // UsbSpeed type
 type UsbSpeed int
@@ -32,6 +33,29 @@ func (us UsbSpeed) String() string {
 	return "unknown"
 }
 
+func (us UsbSpeed) MarshalText() ([]byte, error) {
+	return []byte(us.String()), nil
+}
+func (us *UsbSpeed) UnmarshalText(b []byte) error {
+	for _, v := range []UsbSpeed{UsbSpeedUnknown, UsbSpeedLow, UsbSpeedFull, UsbSpeedHigh, UsbSpeedSuper} {
+		if v.String() == string(b) {
+			*us = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown speed %q", b)
+}
+func (us UsbSpeed) MarshalYAML() (interface{}, error) {
+	return us.String(), nil
+}
+func (us *UsbSpeed) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return us.UnmarshalText([]byte(s))
+}
+
 // RequestType type
 type RequestType uint8
 
@@ -48,7 +72,7 @@ const (
 	RecipientDevice    = RequestType(0x00)
 	RecipientInterface = RequestType(0x01)
 	RecipientEndpoint  = RequestType(0x02)
-	RecipientOther     = RequestType(0x02)
+	RecipientOther     = RequestType(0x03)
 )
 
 // RequestType values
@@ -59,15 +83,23 @@ const (
 
 // descriptor types
 const (
-	DescriptorTypeDevice    = uint8(0x01)
-	DescriptorTypeConfig    = uint8(0x02)
-	DescriptorTypeString    = uint8(0x03)
-	DescriptorTypeInterface = uint8(0x04)
-	DescriptorTypeEndpoint  = uint8(0x05)
-	DescriptorTypeHid       = uint8(0x21)
-	DescriptorTypeReport    = uint8(0x22)
-	DescriptorTypePhysical  = uint8(0x23)
-	DescriptorTypeHub       = uint8(0x29)
+	DescriptorTypeDevice        = uint8(0x01)
+	DescriptorTypeConfig        = uint8(0x02)
+	DescriptorTypeString        = uint8(0x03)
+	DescriptorTypeInterface     = uint8(0x04)
+	DescriptorTypeEndpoint      = uint8(0x05)
+	DescriptorTypeIAD           = uint8(0x0B)
+	DescriptorTypeBOS           = uint8(0x0F)
+	DescriptorTypeDevCap        = uint8(0x10)
+	DescriptorTypeHid           = uint8(0x21)
+	DescriptorTypeDFUFunctional = uint8(0x21)
+	DescriptorTypeReport        = uint8(0x22)
+	DescriptorTypePhysical      = uint8(0x23)
+	DescriptorTypeHub           = uint8(0x29)
+	DescriptorTypeSSHub         = uint8(0x2A)
+
+	DescriptorTypeSSEndpointCompanion     = uint8(0x30)
+	DescriptorTypeSSPIsoEndpointCompanion = uint8(0x31)
 )
 
 // ControlTransfer Requests
@@ -13,20 +13,20 @@ type Descriptor interface {
 }
 
 type DeviceDescriptor struct {
-	Length          uint8
-	DescriptorType  uint8
-	BcdUSB          uint16
-	DeviceClass     uint8
-	DeviceSubClass  uint8
-	DeviceProtol    uint8
-	MaxPacketSize0  uint8
-	IDVender        uint16
-	IDProduct       uint16
-	BcdDevice       uint16
-	IdxManufacturer uint8
-	IdxProduct      uint8
-	IdxSerialNumber uint8
-	NumConfiguation uint8
+	Length          uint8  `json:"bLength" yaml:"bLength"`
+	DescriptorType  uint8  `json:"bDescriptorType" yaml:"bDescriptorType"`
+	BcdUSB          uint16 `json:"bcdUSB" yaml:"bcdUSB"`
+	DeviceClass     uint8  `json:"bDeviceClass" yaml:"bDeviceClass"`
+	DeviceSubClass  uint8  `json:"bDeviceSubClass" yaml:"bDeviceSubClass"`
+	DeviceProtol    uint8  `json:"bDeviceProtocol" yaml:"bDeviceProtocol"`
+	MaxPacketSize0  uint8  `json:"bMaxPacketSize0" yaml:"bMaxPacketSize0"`
+	IDVender        uint16 `json:"idVendor" yaml:"idVendor"`
+	IDProduct       uint16 `json:"idProduct" yaml:"idProduct"`
+	BcdDevice       uint16 `json:"bcdDevice" yaml:"bcdDevice"`
+	IdxManufacturer uint8  `json:"iManufacturer" yaml:"iManufacturer"`
+	IdxProduct      uint8  `json:"iProduct" yaml:"iProduct"`
+	IdxSerialNumber uint8  `json:"iSerialNumber" yaml:"iSerialNumber"`
+	NumConfiguation uint8  `json:"bNumConfigurations" yaml:"bNumConfigurations"`
 }
 
 func (desc *DeviceDescriptor) Len() int {
@@ -56,14 +56,14 @@ func (desc *DeviceDescriptor) Put(b []byte) {
 }
 
 type ConfigurationDescriptor struct {
-	Length             uint8
-	DescriptorType     uint8
-	TotalLength        uint16
-	NumInterfaces      uint8
-	ConfigurationValue uint8
-	IdxConfiguration   uint8
-	Attributes         uint8
-	MaxPower           uint8
+	Length             uint8  `json:"bLength" yaml:"bLength"`
+	DescriptorType     uint8  `json:"bDescriptorType" yaml:"bDescriptorType"`
+	TotalLength        uint16 `json:"wTotalLength" yaml:"wTotalLength"`
+	NumInterfaces      uint8  `json:"bNumInterfaces" yaml:"bNumInterfaces"`
+	ConfigurationValue uint8  `json:"bConfigurationValue" yaml:"bConfigurationValue"`
+	IdxConfiguration   uint8  `json:"iConfiguration" yaml:"iConfiguration"`
+	Attributes         uint8  `json:"bmAttributes" yaml:"bmAttributes"`
+	MaxPower           uint8  `json:"bMaxPower" yaml:"bMaxPower"`
 }
 
 func (desc *ConfigurationDescriptor) Len() int {
@@ -87,15 +87,15 @@ func (desc *ConfigurationDescriptor) Put(b []byte) {
 }
 
 type InterfaceDescriptor struct {
-	Length            uint8
-	DescriptorType    uint8
-	InterfaceNumber   uint8
-	AlternateSetting  uint8
-	NumEndpoint       uint8
-	InterfaceClass    uint8
-	InterfaceSubClass uint8
-	InterfaceProtocol uint8
-	IdxInterface      uint8
+	Length            uint8 `json:"bLength" yaml:"bLength"`
+	DescriptorType    uint8 `json:"bDescriptorType" yaml:"bDescriptorType"`
+	InterfaceNumber   uint8 `json:"bInterfaceNumber" yaml:"bInterfaceNumber"`
+	AlternateSetting  uint8 `json:"bAlternateSetting" yaml:"bAlternateSetting"`
+	NumEndpoint       uint8 `json:"bNumEndpoints" yaml:"bNumEndpoints"`
+	InterfaceClass    uint8 `json:"bInterfaceClass" yaml:"bInterfaceClass"`
+	InterfaceSubClass uint8 `json:"bInterfaceSubClass" yaml:"bInterfaceSubClass"`
+	InterfaceProtocol uint8 `json:"bInterfaceProtocol" yaml:"bInterfaceProtocol"`
+	IdxInterface      uint8 `json:"iInterface" yaml:"iInterface"`
 }
 
 func (desc *InterfaceDescriptor) Len() int {
@@ -119,13 +119,46 @@ func (desc *InterfaceDescriptor) Put(b []byte) {
 	desc.IdxInterface = b[8]
 }
 
+// InterfaceAssociationDescriptor groups the consecutive interfaces of one
+// function of a composite device.
+type InterfaceAssociationDescriptor struct {
+	Length           uint8 `json:"bLength" yaml:"bLength"`
+	DescriptorType   uint8 `json:"bDescriptorType" yaml:"bDescriptorType"`
+	FirstInterface   uint8 `json:"bFirstInterface" yaml:"bFirstInterface"`
+	InterfaceCount   uint8 `json:"bInterfaceCount" yaml:"bInterfaceCount"`
+	FunctionClass    uint8 `json:"bFunctionClass" yaml:"bFunctionClass"`
+	FunctionSubClass uint8 `json:"bFunctionSubClass" yaml:"bFunctionSubClass"`
+	FunctionProtocol uint8 `json:"bFunctionProtocol" yaml:"bFunctionProtocol"`
+	IdxFunction      uint8 `json:"iFunction" yaml:"iFunction"`
+}
+
+func (desc *InterfaceAssociationDescriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *InterfaceAssociationDescriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+const interfaceAssociationDescriptorLength = 8
+
+func (desc *InterfaceAssociationDescriptor) Put(b []byte) {
+	desc.Length = b[0]
+	desc.DescriptorType = b[1]
+	desc.FirstInterface = b[2]
+	desc.InterfaceCount = b[3]
+	desc.FunctionClass = b[4]
+	desc.FunctionSubClass = b[5]
+	desc.FunctionProtocol = b[6]
+	desc.IdxFunction = b[7]
+}
+
 type EndpointDescriptor struct {
-	Length          uint8
-	DescriptorType  uint8
-	EndpointAddress uint8
-	Attributes      uint8
-	MaxPacketSize   uint16
-	Interval        uint8
+	Length          uint8  `json:"bLength" yaml:"bLength"`
+	DescriptorType  uint8  `json:"bDescriptorType" yaml:"bDescriptorType"`
+	EndpointAddress uint8  `json:"bEndpointAddress" yaml:"bEndpointAddress"`
+	Attributes      uint8  `json:"bmAttributes" yaml:"bmAttributes"`
+	MaxPacketSize   uint16 `json:"wMaxPacketSize" yaml:"wMaxPacketSize"`
+	Interval        uint8  `json:"bInterval" yaml:"bInterval"`
 }
 
 func (desc *EndpointDescriptor) Len() int {
@@ -156,10 +189,81 @@ func (desc *EndpointDescriptor) TransferType() TransferType {
 	return TransferType(desc.Attributes & 0x03)
 }
 
+// SSEndpointCompanionDescriptor follows each endpoint descriptor of a
+// SuperSpeed configuration.
+type SSEndpointCompanionDescriptor struct {
+	Length           uint8  `json:"bLength" yaml:"bLength"`
+	DescriptorType   uint8  `json:"bDescriptorType" yaml:"bDescriptorType"`
+	MaxBurst         uint8  `json:"bMaxBurst" yaml:"bMaxBurst"`
+	Attributes       uint8  `json:"bmAttributes" yaml:"bmAttributes"`
+	BytesPerInterval uint16 `json:"wBytesPerInterval" yaml:"wBytesPerInterval"`
+}
+
+func (desc *SSEndpointCompanionDescriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *SSEndpointCompanionDescriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+const ssEndpointCompanionDescriptorLength = 6
+
+func (desc *SSEndpointCompanionDescriptor) Put(b []byte) {
+	desc.Length = b[0]
+	desc.DescriptorType = b[1]
+	desc.MaxBurst = b[2]
+	desc.Attributes = b[3]
+	desc.BytesPerInterval = usbEncoding.Uint16(b[4:])
+}
+
+// MaxStreams returns the number of streams a bulk endpoint supports, 0 if
+// it does not support streams.
+func (desc *SSEndpointCompanionDescriptor) MaxStreams() int {
+	if n := desc.Attributes & 0x1F; n != 0 {
+		return 1 << n
+	}
+	return 0
+}
+
+// Mult returns the Mult field of an isochronous endpoint; the endpoint
+// moves up to (Mult+1)*(MaxBurst+1) packets per service interval.
+func (desc *SSEndpointCompanionDescriptor) Mult() int {
+	return int(desc.Attributes & 0x03)
+}
+
+// SSPIsoCompanion reports whether a SuperSpeedPlus isochronous endpoint
+// companion descriptor follows.
+func (desc *SSEndpointCompanionDescriptor) SSPIsoCompanion() bool {
+	return desc.Attributes&0x80 != 0
+}
+
+// SSPIsoEndpointCompanionDescriptor carries the bytes per interval of
+// SuperSpeedPlus isochronous endpoints that exceed wBytesPerInterval.
+type SSPIsoEndpointCompanionDescriptor struct {
+	Length           uint8  `json:"bLength" yaml:"bLength"`
+	DescriptorType   uint8  `json:"bDescriptorType" yaml:"bDescriptorType"`
+	BytesPerInterval uint32 `json:"dwBytesPerInterval" yaml:"dwBytesPerInterval"`
+}
+
+func (desc *SSPIsoEndpointCompanionDescriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *SSPIsoEndpointCompanionDescriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+const sspIsoEndpointCompanionDescriptorLength = 8
+
+func (desc *SSPIsoEndpointCompanionDescriptor) Put(b []byte) {
+	desc.Length = b[0]
+	desc.DescriptorType = b[1]
+	desc.BytesPerInterval = usbEncoding.Uint32(b[4:])
+}
+
 type StringDescriptor struct {
-	Length         uint8
-	DescriptorType uint8
-	String         string
+	Length         uint8  `json:"bLength" yaml:"bLength"`
+	DescriptorType uint8  `json:"bDescriptorType" yaml:"bDescriptorType"`
+	String         string `json:"string" yaml:"string"`
 }
 
 func (desc *StringDescriptor) Len() int {
@@ -175,11 +279,16 @@ func (desc *StringDescriptor) Put(b []byte) {
 	desc.String = string(b[2:desc.Length])
 }
 
+// ParseDescriptor parses a standard descriptor. Other types are returned
+// as *RawDescriptor; use ParseClassDescriptor for class-specific ones.
 func ParseDescriptor(b []byte) (Descriptor, error) {
 	if len(b) < 2 {
 		return nil, errors.New("too less bytes")
 	}
 	length, typ := b[0], uint8(b[1])
+	if length < 2 {
+		return nil, errors.New("malformed descriptor")
+	}
 	if len(b) < int(length) {
 		return nil, errors.New("no enough data")
 	}
@@ -205,6 +314,13 @@ func ParseDescriptor(b []byte) (Descriptor, error) {
 		desc := new(InterfaceDescriptor)
 		desc.Put(b)
 		return desc, nil
+	case DescriptorTypeIAD:
+		if length != interfaceAssociationDescriptorLength {
+			return nil, errors.New("descriptor length mismatch")
+		}
+		desc := new(InterfaceAssociationDescriptor)
+		desc.Put(b)
+		return desc, nil
 	case DescriptorTypeEndpoint:
 		if length != endpointDescriptorLength {
 			return nil, errors.New("descriptor length mismatch")
@@ -212,10 +328,26 @@ func ParseDescriptor(b []byte) (Descriptor, error) {
 		desc := new(EndpointDescriptor)
 		desc.Put(b)
 		return desc, nil
+	case DescriptorTypeSSEndpointCompanion:
+		if length != ssEndpointCompanionDescriptorLength {
+			return nil, errors.New("descriptor length mismatch")
+		}
+		desc := new(SSEndpointCompanionDescriptor)
+		desc.Put(b)
+		return desc, nil
+	case DescriptorTypeSSPIsoEndpointCompanion:
+		if length != sspIsoEndpointCompanionDescriptorLength {
+			return nil, errors.New("descriptor length mismatch")
+		}
+		desc := new(SSPIsoEndpointCompanionDescriptor)
+		desc.Put(b)
+		return desc, nil
 	case DescriptorTypeString:
 		desc := new(StringDescriptor)
 		desc.Put(b)
 		return desc, nil
 	}
-	return nil, errors.New("unknown descriptor")
+	desc := new(RawDescriptor)
+	desc.Put(b)
+	return desc, nil
 }
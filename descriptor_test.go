@@ -0,0 +1,34 @@
+package gousb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDescriptor(t *testing.T) {
+	desc, err := ParseDescriptor([]byte{6, DescriptorTypeString, 'g', 'o', 'u', 's'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := desc.(*StringDescriptor); !ok || s.String != "gous" {
+		t.Errorf("got %+v, want string descriptor \"gous\"", desc)
+	}
+
+	desc, err = ParseDescriptor([]byte{4, 0x24, 0x01, 0x02})
+	if want := (&RawDescriptor{Length: 4, DescriptorType: 0x24, Data: []byte{0x01, 0x02}}); err != nil || !reflect.DeepEqual(desc, want) {
+		t.Errorf("got %+v, %v, want %+v", desc, err, want)
+	}
+
+	for _, b := range [][]byte{
+		{1, DescriptorTypeString},
+		{0, DescriptorTypeString},
+		{1, 0x24},
+	} {
+		if _, err := ParseDescriptor(b); err == nil || err.Error() != "malformed descriptor" {
+			t.Errorf("% x: got error %v, want malformed descriptor", b, err)
+		}
+	}
+	if _, err := ParseDescriptor([]byte{4, DescriptorTypeString, 'g'}); err == nil {
+		t.Errorf("truncated descriptor accepted")
+	}
+}
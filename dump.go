@@ -0,0 +1,483 @@
+package gousb
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/op0xA5/gousb/usbids"
+)
+
+// dumper writes descriptors in the layout of lsusb -v. str resolves string
+// descriptor indexes; the first write error is kept in err.
+type dumper struct {
+	w          io.Writer
+	str        func(idx uint8) string
+	superSpeed bool
+	err        error
+}
+
+func (d *dumper) printf(indent int, format string, a ...interface{}) {
+	if d.err != nil {
+		return
+	}
+	_, d.err = fmt.Fprintf(d.w, "%s"+format+"\n", append([]interface{}{strings.Repeat(" ", indent)}, a...)...)
+}
+
+// field writes one "name value note" line.
+func (d *dumper) field(indent int, name string, value interface{}, note string) {
+	if note != "" {
+		d.printf(indent, "%-20s%5v %s", name, value, note)
+	} else {
+		d.printf(indent, "%-20s%5v", name, value)
+	}
+}
+
+func (d *dumper) index(indent int, name string, idx uint8) {
+	s := ""
+	if idx != 0 && d.str != nil {
+		s = d.str(idx)
+	}
+	d.field(indent, name, idx, s)
+}
+
+func bcd(v uint16) string {
+	return fmt.Sprintf("%x.%02x", v>>8, v&0xff)
+}
+
+func hexBytes(b []byte) string {
+	s := make([]string, len(b))
+	for i, c := range b {
+		s[i] = fmt.Sprintf("%02x", c)
+	}
+	return strings.Join(s, " ")
+}
+
+func (d *dumper) device(desc *DeviceDescriptor) {
+	d.printf(0, "Device Descriptor:")
+	d.field(2, "bLength", desc.Length, "")
+	d.field(2, "bDescriptorType", desc.DescriptorType, "")
+	d.field(2, "bcdUSB", bcd(desc.BcdUSB), "")
+	d.field(2, "bDeviceClass", desc.DeviceClass, usbids.Class(desc.DeviceClass))
+	d.field(2, "bDeviceSubClass", desc.DeviceSubClass, usbids.SubClass(desc.DeviceClass, desc.DeviceSubClass))
+	d.field(2, "bDeviceProtocol", desc.DeviceProtol, usbids.Protocol(desc.DeviceClass, desc.DeviceSubClass, desc.DeviceProtol))
+	d.field(2, "bMaxPacketSize0", desc.MaxPacketSize0, "")
+	d.field(2, "idVendor", fmt.Sprintf("0x%04x", desc.IDVender), usbids.Vendor(desc.IDVender))
+	d.field(2, "idProduct", fmt.Sprintf("0x%04x", desc.IDProduct), usbids.Product(desc.IDVender, desc.IDProduct))
+	d.field(2, "bcdDevice", bcd(desc.BcdDevice), "")
+	d.index(2, "iManufacturer", desc.IdxManufacturer)
+	d.index(2, "iProduct", desc.IdxProduct)
+	d.index(2, "iSerial", desc.IdxSerialNumber)
+	d.field(2, "bNumConfigurations", desc.NumConfiguation, "")
+}
+
+func (d *dumper) configuration(cfg *Configuration) {
+	d.printf(2, "Configuration Descriptor:")
+	d.field(4, "bLength", cfg.Length, "")
+	d.field(4, "bDescriptorType", cfg.DescriptorType, "")
+	d.field(4, "wTotalLength", fmt.Sprintf("0x%04x", cfg.TotalLength), "")
+	d.field(4, "bNumInterfaces", cfg.NumInterfaces, "")
+	d.field(4, "bConfigurationValue", cfg.ConfigurationValue, "")
+	d.index(4, "iConfiguration", cfg.IdxConfiguration)
+	d.field(4, "bmAttributes", fmt.Sprintf("0x%02x", cfg.Attributes), "")
+	if cfg.Attributes&0x80 == 0 {
+		d.printf(6, "(Missing must-be-set bit!)")
+	}
+	if cfg.Attributes&0x40 != 0 {
+		d.printf(6, "Self Powered")
+	} else {
+		d.printf(6, "(Bus Powered)")
+	}
+	if cfg.Attributes&0x20 != 0 {
+		d.printf(6, "Remote Wakeup")
+	}
+	if cfg.Attributes&0x10 != 0 {
+		d.printf(6, "Battery Powered")
+	}
+	// bMaxPower is in 8mA units at SuperSpeed, 2mA below
+	if d.superSpeed {
+		d.field(4, "MaxPower", fmt.Sprintf("%dmA", int(cfg.MaxPower)*8), "")
+	} else {
+		d.field(4, "MaxPower", fmt.Sprintf("%dmA", int(cfg.MaxPower)*2), "")
+	}
+	d.extra(4, nil, cfg.Extra)
+
+	printed := make(map[*Function]bool)
+	for _, iface := range cfg.Interfaces {
+		if fn := cfg.Function(iface.InterfaceNumber); fn != nil && !printed[fn] {
+			printed[fn] = true
+			d.association(&fn.InterfaceAssociationDescriptor)
+		}
+		d.iface(iface)
+	}
+}
+
+func (d *dumper) association(desc *InterfaceAssociationDescriptor) {
+	d.printf(4, "Interface Association:")
+	d.field(6, "bLength", desc.Length, "")
+	d.field(6, "bDescriptorType", desc.DescriptorType, "")
+	d.field(6, "bFirstInterface", desc.FirstInterface, "")
+	d.field(6, "bInterfaceCount", desc.InterfaceCount, "")
+	d.field(6, "bFunctionClass", desc.FunctionClass, usbids.Class(desc.FunctionClass))
+	d.field(6, "bFunctionSubClass", desc.FunctionSubClass, usbids.SubClass(desc.FunctionClass, desc.FunctionSubClass))
+	d.field(6, "bFunctionProtocol", desc.FunctionProtocol, usbids.Protocol(desc.FunctionClass, desc.FunctionSubClass, desc.FunctionProtocol))
+	d.index(6, "iFunction", desc.IdxFunction)
+}
+
+func (d *dumper) iface(iface *Interface) {
+	d.printf(4, "Interface Descriptor:")
+	d.field(6, "bLength", iface.Length, "")
+	d.field(6, "bDescriptorType", iface.DescriptorType, "")
+	d.field(6, "bInterfaceNumber", iface.InterfaceNumber, "")
+	d.field(6, "bAlternateSetting", iface.AlternateSetting, "")
+	d.field(6, "bNumEndpoints", iface.NumEndpoint, "")
+	d.field(6, "bInterfaceClass", iface.InterfaceClass, usbids.Class(iface.InterfaceClass))
+	d.field(6, "bInterfaceSubClass", iface.InterfaceSubClass, usbids.SubClass(iface.InterfaceClass, iface.InterfaceSubClass))
+	d.field(6, "bInterfaceProtocol", iface.InterfaceProtocol, usbids.Protocol(iface.InterfaceClass, iface.InterfaceSubClass, iface.InterfaceProtocol))
+	d.index(6, "iInterface", iface.IdxInterface)
+	d.extra(6, &iface.InterfaceDescriptor, iface.Extra)
+	for _, ep := range iface.Endpoints {
+		d.endpoint(&iface.InterfaceDescriptor, ep)
+	}
+}
+
+var (
+	transferTypeNames = [...]string{"Control", "Isochronous", "Bulk", "Interrupt"}
+	syncTypeNames     = [...]string{"None", "Asynchronous", "Adaptive", "Synchronous"}
+	usageTypeNames    = [...]string{"Data", "Feedback", "Implicit feedback Data", "(reserved)"}
+)
+
+func (d *dumper) endpoint(iface *InterfaceDescriptor, ep *Endpoint) {
+	dir := "OUT"
+	if ep.InOut() == EndpointIn {
+		dir = "IN"
+	}
+	d.printf(6, "Endpoint Descriptor:")
+	d.field(8, "bLength", ep.Length, "")
+	d.field(8, "bDescriptorType", ep.DescriptorType, "")
+	d.field(8, "bEndpointAddress", fmt.Sprintf("0x%02x", ep.EndpointAddress), fmt.Sprintf(" EP %d %s", ep.Ep(), dir))
+	d.field(8, "bmAttributes", ep.Attributes, "")
+	d.printf(10, "Transfer Type            %s", transferTypeNames[ep.TransferType()])
+	if ep.TransferType() == TransferTypeIsochronous {
+		d.printf(10, "Synch Type               %s", syncTypeNames[ep.Attributes>>2&0x3])
+		d.printf(10, "Usage Type               %s", usageTypeNames[ep.Attributes>>4&0x3])
+	}
+	size := ep.MaxPacketSize & 0x7ff
+	mult := ep.MaxPacketSize>>11&0x3 + 1
+	d.field(8, "wMaxPacketSize", fmt.Sprintf("0x%04x", ep.MaxPacketSize), fmt.Sprintf(" %dx %d bytes", mult, size))
+	d.field(8, "bInterval", ep.Interval, "")
+	if c := ep.Companion; c != nil {
+		d.printf(8, "SuperSpeed Endpoint Companion:")
+		d.field(10, "bLength", c.Length, "")
+		d.field(10, "bDescriptorType", c.DescriptorType, "")
+		d.field(10, "bMaxBurst", c.MaxBurst, "")
+		switch ep.TransferType() {
+		case TransferTypeBulk:
+			d.field(10, "MaxStreams", c.MaxStreams(), "")
+		case TransferTypeIsochronous:
+			d.field(10, "Mult", c.Mult(), "")
+		}
+		d.field(10, "wBytesPerInterval", fmt.Sprintf("0x%04x", c.BytesPerInterval), "")
+	}
+	if c := ep.IsoCompanion; c != nil {
+		d.printf(8, "SuperSpeedPlus Isochronous Endpoint Companion:")
+		d.field(10, "bLength", c.Length, "")
+		d.field(10, "bDescriptorType", c.DescriptorType, "")
+		d.field(10, "dwBytesPerInterval", fmt.Sprintf("0x%08x", c.BytesPerInterval), "")
+	}
+	d.extra(8, iface, ep.Extra)
+}
+
+// extra writes the class-specific descriptors in extra, decoded with the
+// decoders registered for the class of iface.
+func (d *dumper) extra(indent int, iface *InterfaceDescriptor, extra []byte) {
+	if len(extra) == 0 {
+		return
+	}
+	list, err := ParseDescriptors(iface, extra)
+	if err != nil {
+		d.printf(indent, "** %v: %s", err, hexBytes(extra))
+		return
+	}
+	for _, desc := range list {
+		d.descriptor(indent, desc)
+	}
+}
+
+func (d *dumper) descriptor(indent int, desc Descriptor) {
+	switch desc := desc.(type) {
+	case *RawDescriptor:
+		b := append([]byte{desc.Length, desc.DescriptorType}, desc.Data...)
+		d.printf(indent, "UNRECOGNIZED: %s", hexBytes(b))
+	case *HIDDescriptor:
+		d.printf(indent, "HID Device Descriptor:")
+		d.field(indent+2, "bLength", desc.Length, "")
+		d.field(indent+2, "bDescriptorType", desc.DescriptorType, "")
+		d.field(indent+2, "bcdHID", bcd(desc.BcdHID), "")
+		d.field(indent+2, "bCountryCode", desc.CountryCode, "")
+		d.field(indent+2, "bNumDescriptors", len(desc.Descriptors), "")
+		for _, c := range desc.Descriptors {
+			d.field(indent+2, "bDescriptorType", c.DescriptorType, "")
+			d.field(indent+2, "wDescriptorLength", c.Length, "")
+		}
+	default:
+		d.generic(indent, desc)
+	}
+}
+
+// generic writes the exported fields of descriptors decoded by class
+// packages.
+func (d *dumper) generic(indent int, desc Descriptor) {
+	d.printf(indent, "%s:", strings.TrimPrefix(fmt.Sprintf("%T", desc), "*"))
+	d.fields(indent+2, reflect.ValueOf(desc))
+}
+
+// fields writes the exported fields of v, following pointers and
+// interfaces into nested structs.
+func (d *dumper) fields(indent int, v reflect.Value) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		d.printf(indent, "%v", v.Interface())
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		fv := v.Field(i)
+		switch fv.Kind() {
+		case reflect.Uint16, reflect.Uint32:
+			d.field(indent, f.Name, fmt.Sprintf("0x%0*x", fv.Type().Size()*2, fv.Uint()), "")
+		case reflect.Slice, reflect.Array:
+			if fv.Type().Elem().Kind() == reflect.Uint8 {
+				b := make([]byte, fv.Len())
+				reflect.Copy(reflect.ValueOf(b), fv)
+				d.field(indent, f.Name, "", hexBytes(b))
+				continue
+			}
+			d.field(indent, f.Name, "", fmt.Sprintf("%v", fv.Interface()))
+		case reflect.Ptr, reflect.Interface:
+			if fv.IsNil() {
+				continue
+			}
+			d.printf(indent, "%s:", f.Name)
+			d.fields(indent+2, fv)
+		default:
+			d.field(indent, f.Name, fv.Interface(), "")
+		}
+	}
+}
+
+var platformNames = map[[16]byte]string{
+	PlatformUUIDWebUSB: "WebUSB",
+	PlatformUUIDMSOS20: "Microsoft OS 2.0",
+}
+
+func (d *dumper) bos(bos *BOSDescriptor) {
+	d.printf(0, "Binary Object Store Descriptor:")
+	d.field(2, "bLength", bos.Length, "")
+	d.field(2, "bDescriptorType", bos.DescriptorType, "")
+	d.field(2, "wTotalLength", fmt.Sprintf("0x%04x", bos.TotalLength), "")
+	d.field(2, "bNumDeviceCaps", bos.NumDeviceCaps, "")
+	for _, c := range bos.Capabilities {
+		d.capability(c)
+	}
+}
+
+func (d *dumper) capability(c *DeviceCapability) {
+	header := func(title string) {
+		d.printf(2, "%s:", title)
+		d.field(4, "bLength", c.Length, "")
+		d.field(4, "bDescriptorType", c.DescriptorType, "")
+		d.field(4, "bDevCapabilityType", c.CapabilityType, "")
+	}
+	switch c.CapabilityType {
+	case CapabilityUSB2Extension:
+		ext, err := ParseUSB2Extension(c)
+		if err != nil {
+			break
+		}
+		header("USB 2.0 Extension Device Capability")
+		d.field(4, "bmAttributes", fmt.Sprintf("0x%08x", ext.Attributes), "")
+		if ext.LPM() {
+			d.printf(6, "Link Power Management (LPM) Supported")
+		}
+		if ext.BESL() {
+			d.printf(6, "BESL Link Power Management (LPM) Supported")
+		}
+		if v, ok := ext.BaselineBESL(); ok {
+			d.printf(6, "Baseline BESL value %d", v)
+		}
+		if v, ok := ext.DeepBESL(); ok {
+			d.printf(6, "Deep BESL value %d", v)
+		}
+		return
+	case CapabilitySuperSpeed:
+		ss, err := ParseSuperSpeedCapability(c)
+		if err != nil {
+			break
+		}
+		header("SuperSpeed USB Device Capability")
+		d.field(4, "bmAttributes", fmt.Sprintf("0x%02x", ss.Attributes), "")
+		if ss.LTM() {
+			d.printf(6, "Latency Tolerance Messages (LTM) Supported")
+		}
+		d.field(4, "wSpeedsSupported", fmt.Sprintf("0x%04x", ss.SpeedsSupported), "")
+		if ss.SpeedsSupported&SpeedSupportLow != 0 {
+			d.printf(6, "Device can operate at Low Speed (1Mbps)")
+		}
+		if ss.SpeedsSupported&SpeedSupportFull != 0 {
+			d.printf(6, "Device can operate at Full Speed (12Mbps)")
+		}
+		if ss.SpeedsSupported&SpeedSupportHigh != 0 {
+			d.printf(6, "Device can operate at High Speed (480Mbps)")
+		}
+		if ss.SpeedsSupported&SpeedSupportSuper != 0 {
+			d.printf(6, "Device can operate at SuperSpeed (5Gbps)")
+		}
+		d.field(4, "bFunctionalitySupport", ss.FunctionalitySupport, "")
+		d.field(4, "bU1DevExitLat", ss.U1DevExitLat, "micro seconds")
+		d.field(4, "bU2DevExitLat", ss.U2DevExitLat, "micro seconds")
+		return
+	case CapabilitySuperSpeedPlus:
+		ssp, err := ParseSuperSpeedPlusCapability(c)
+		if err != nil {
+			break
+		}
+		header("SuperSpeedPlus USB Device Capability")
+		d.field(4, "bmAttributes", fmt.Sprintf("0x%08x", ssp.Attributes), "")
+		d.printf(6, "Sublink Speed Attribute count %d", len(ssp.SublinkSpeeds))
+		d.printf(4, "wFunctionalitySupport   0x%04x", ssp.FunctionalitySupport)
+		d.printf(6, "Min functional Speed Attribute ID: %d", ssp.FunctionalitySupport&0xf)
+		d.printf(6, "Min functional RX lanes: %d", ssp.MinRxLanes())
+		d.printf(6, "Min functional TX lanes: %d", ssp.MinTxLanes())
+		for i, s := range ssp.SublinkSpeeds {
+			dir := "RX"
+			if s.Symmetric() {
+				dir = "Symmetric"
+			} else if s.Tx() {
+				dir = "TX"
+			}
+			proto := "SuperSpeed"
+			if s.Protocol() == 1 {
+				proto = "SuperSpeedPlus"
+			}
+			d.printf(4, "bmSublinkSpeedAttr[%d]   0x%08x", i, uint32(s))
+			d.printf(6, "Speed Attribute ID: %d %d Mb/s %s (%s)", s.ID(), s.BitRate()/1000000, dir, proto)
+		}
+		return
+	case CapabilityContainerID:
+		id, err := ParseContainerID(c)
+		if err != nil {
+			break
+		}
+		header("Container ID Device Capability")
+		d.printf(4, "ContainerID             %s", id)
+		return
+	case CapabilityPlatform:
+		p, err := ParsePlatformCapability(c)
+		if err != nil {
+			break
+		}
+		header("Platform Device Capability")
+		d.printf(4, "PlatformCapabilityUUID  %s %s", FormatUUID(p.UUID), platformNames[p.UUID])
+		d.printf(4, "CapabilityData          %s", hexBytes(p.Data))
+		return
+	case CapabilityBillboard:
+		bb, err := ParseBillboardCapability(c)
+		if err != nil {
+			break
+		}
+		header("Billboard Capability")
+		d.index(4, "iAdditionalInfoURL", bb.IdxAdditionalInfoURL)
+		d.field(4, "bNumberOfAlternateModes", bb.NumberOfModes, "")
+		d.field(4, "bPreferredAlternateMode", bb.PreferredMode, "")
+		d.field(4, "VCONN Power", fmt.Sprintf("0x%04x", bb.VconnPower), "")
+		d.field(4, "bcdVersion", bcd(bb.BcdVersion), "")
+		d.field(4, "bAdditionalFailureInfo", bb.AdditionalFailureInfo, "")
+		states := [...]string{"Unspecified Error", "Not attempted", "Unsuccessful", "Successful"}
+		for i, m := range bb.Modes {
+			d.printf(4, "Alternate Mode %d : %s", i, states[bb.ModeState(i)])
+			d.field(6, "wSVID", fmt.Sprintf("0x%04x", m.SVID), "")
+			d.field(6, "bAlternateMode", m.Mode, "")
+			d.index(6, "iAlternateModeString", m.IdxString)
+		}
+		return
+	}
+	header("Device Capability")
+	d.printf(4, "Data                    %s", hexBytes(c.Data))
+}
+
+// languages returns the LANGIDs of string descriptor 0.
+func (h *Handle) languages() ([]uint16, error) {
+	b, err := h.GetDescriptorBuffer(DescriptorTypeString, 0, make([]byte, 255))
+	if err != nil {
+		return nil, err
+	}
+	var list []uint16
+	for i := 2; i+1 < len(b) && i+1 < int(b[0]); i += 2 {
+		list = append(list, usbEncoding.Uint16(b[i:]))
+	}
+	return list, nil
+}
+
+// Dump writes the device descriptor, all configuration trees with their
+// class-specific descriptors and the BOS descriptor in lsusb -v style.
+// Strings are read in the device's first language. Configurations that
+// cannot be read are reported in the output; only write errors are
+// returned.
+func (h *Handle) Dump(w io.Writer) error {
+	dev := h.dev
+	d := &dumper{w: w, superSpeed: dev.Speed >= UsbSpeedSuper}
+	langs, _ := h.languages()
+	var langid uint16
+	if len(langs) > 0 {
+		langid = langs[0]
+	}
+	d.str = func(idx uint8) string {
+		s, _ := h.GetStringDescriptor(idx, langid)
+		return s
+	}
+
+	// like lsusb, prefer the database names and fall back to the strings
+	vendor, product := usbids.Vendor(dev.IDVender), usbids.Product(dev.IDVender, dev.IDProduct)
+	if vendor == "" {
+		vendor = d.str(dev.IdxManufacturer)
+	}
+	if product == "" {
+		product = d.str(dev.IdxProduct)
+	}
+	d.printf(0, "Bus %03d Device %03d: ID %04x:%04x %s %s", dev.Bus, dev.Address, dev.IDVender, dev.IDProduct, vendor, product)
+	d.printf(0, "Negotiated speed: %s", dev.Speed)
+	d.device(&dev.DeviceDescriptor)
+	for i := 0; i < int(dev.NumConfiguation); i++ {
+		cfg, err := h.GetConfigDescriptor(uint8(i))
+		if err != nil {
+			d.printf(2, "** can't read configuration %d: %v", i, err)
+			continue
+		}
+		d.configuration(cfg)
+	}
+	if dev.BcdUSB >= 0x0201 {
+		if bos, err := h.BOSDescriptor(); err == nil {
+			d.bos(bos)
+		} else {
+			d.printf(0, "** can't read BOS descriptor: %v", err)
+		}
+	}
+	if len(langs) > 0 {
+		d.printf(0, "String Descriptors:")
+		for _, l := range langs {
+			d.printf(2, "LANGID 0x%04x %s", l, usbids.Language(l))
+		}
+	}
+	return d.err
+}
@@ -0,0 +1,129 @@
+package gousb
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/op0xA5/gousb/usbids"
+)
+
+const keyboardDump = `  Configuration Descriptor:
+    bLength                 9
+    bDescriptorType         2
+    wTotalLength        0x0022
+    bNumInterfaces          1
+    bConfigurationValue     1
+    iConfiguration          4 Keyboard
+    bmAttributes         0xe0
+      Self Powered
+      Remote Wakeup
+    MaxPower            100mA
+    Interface Descriptor:
+      bLength                 9
+      bDescriptorType         4
+      bInterfaceNumber        0
+      bAlternateSetting       0
+      bNumEndpoints           1
+      bInterfaceClass         3 Human Interface Device
+      bInterfaceSubClass      1
+      bInterfaceProtocol      1
+      iInterface              0
+      HID Device Descriptor:
+        bLength                 9
+        bDescriptorType        33
+        bcdHID               1.11
+        bCountryCode            0
+        bNumDescriptors         1
+        bDescriptorType        34
+        wDescriptorLength      63
+      Endpoint Descriptor:
+        bLength                 7
+        bDescriptorType         5
+        bEndpointAddress     0x81  EP 1 IN
+        bmAttributes            3
+          Transfer Type            Interrupt
+        wMaxPacketSize      0x0008  1x 8 bytes
+        bInterval              10
+`
+
+func TestDumpConfiguration(t *testing.T) {
+	usbids.SetDefault(nil)
+	cfg, err := ParseConfiguration([]byte{
+		0x09, 0x02, 0x22, 0x00, 0x01, 0x01, 0x04, 0xe0, 0x32,
+		0x09, 0x04, 0x00, 0x00, 0x01, 0x03, 0x01, 0x01, 0x00,
+		0x09, 0x21, 0x11, 0x01, 0x00, 0x01, 0x22, 0x3f, 0x00,
+		0x07, 0x05, 0x81, 0x03, 0x08, 0x00, 0x0a,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	d := &dumper{w: &buf, str: func(idx uint8) string { return "Keyboard" }}
+	d.configuration(cfg)
+	if d.err != nil || buf.String() != keyboardDump {
+		t.Errorf("got %v\n%s", d.err, buf.String())
+	}
+
+	// SuperSpeed power units, companions and undecoded class descriptors
+	cfg, err = ParseConfiguration(superSpeedConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	d = &dumper{w: &buf, superSpeed: true}
+	d.configuration(cfg)
+	for _, line := range []string{
+		"    MaxPower            400mA\n",
+		"          MaxStreams             16\n",
+		"          Mult                    2\n",
+		"        UNRECOGNIZED: 04 25 01 00\n",
+		"          dwBytesPerInterval  0x00018000\n",
+		"        wMaxPacketSize      0x1400  3x 1024 bytes\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("missing %q in\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestDumpBOS(t *testing.T) {
+	bos, err := ParseBOSDescriptor(testBOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	d := &dumper{w: &buf}
+	d.bos(bos)
+	for _, line := range []string{
+		"  bNumDeviceCaps          6\n",
+		"      Deep BESL value 15\n",
+		"    bU2DevExitLat        2047 micro seconds\n",
+		"    wFunctionalitySupport   0x1100\n",
+		"      Speed Attribute ID: 1 5000 Mb/s Symmetric (SuperSpeedPlus)\n",
+		"    ContainerID             {76543210-ba98-fedc-0123-456789abcdef}\n",
+		"    PlatformCapabilityUUID  {d8dd60df-4589-4cc7-9cd2-659d9e648a9f} Microsoft OS 2.0\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("missing %q in\n%s", line, buf.String())
+		}
+	}
+}
+
+type failWriter struct{ n int }
+
+func (w *failWriter) Write(b []byte) (int, error) {
+	w.n++
+	return 0, errors.New("disk full")
+}
+
+func TestDumpWriteError(t *testing.T) {
+	bos, _ := ParseBOSDescriptor(testBOS)
+	w := new(failWriter)
+	d := &dumper{w: w}
+	d.bos(bos)
+	if d.err == nil || d.err.Error() != "disk full" || w.n != 1 {
+		t.Errorf("got error %v after %d writes", d.err, w.n)
+	}
+}
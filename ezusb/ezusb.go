@@ -0,0 +1,247 @@
+// Package ezusb loads firmware into the RAM of Cypress EZ-USB FX, FX2,
+// FX2LP and FX3 devices through the boot loader's 0xA0 vendor request.
+package ezusb
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/op0xA5/gousb"
+)
+
+// RequestFirmwareLoad is the boot loader's RAM read/write request.
+const RequestFirmwareLoad = uint8(0xA0)
+
+type Chip int
+
+const (
+	ChipFX Chip = iota
+	ChipFX2
+	ChipFX2LP
+	ChipFX3
+)
+
+func (c Chip) String() string {
+	switch c {
+	case ChipFX:
+		return "FX"
+	case ChipFX2:
+		return "FX2"
+	case ChipFX2LP:
+		return "FX2LP"
+	case ChipFX3:
+		return "FX3"
+	}
+	return fmt.Sprintf("Chip(%d)", int(c))
+}
+
+// CPUCS register addresses
+const (
+	cpucsFX  = uint16(0x7F92)
+	cpucsFX2 = uint16(0xE600)
+)
+
+const (
+	chunkSize    = 1024
+	chunkSizeFX3 = 4096
+)
+
+func (c Chip) cpucs() uint16 {
+	if c == ChipFX {
+		return cpucsFX
+	}
+	return cpucsFX2
+}
+
+// internal reports whether [addr, addr+n) lies in RAM the boot loader can
+// write. External RAM needs a second stage loader.
+func (c Chip) internal(addr uint32, n int) bool {
+	end := addr + uint32(n)
+	in := func(lo, hi uint32) bool {
+		return addr >= lo && end <= hi
+	}
+	switch c {
+	case ChipFX:
+		return in(0x0000, 0x1B40) || in(0x7B40, 0x7F40)
+	case ChipFX2:
+		return in(0x0000, 0x2000) || in(0xE000, 0xE200)
+	case ChipFX2LP:
+		return in(0x0000, 0x4000) || in(0xE000, 0xE200)
+	}
+	return true
+}
+
+// writeRAM writes p at addr in chunks.
+func writeRAM(h *gousb.Handle, chip Chip, addr uint32, p []byte) error {
+	size := chunkSize
+	if chip == ChipFX3 {
+		size = chunkSizeFX3
+	}
+	for len(p) > 0 {
+		n := len(p)
+		if n > size {
+			n = size
+		}
+		w, err := h.ControlWrite(RequestFirmwareLoad, uint16(addr), uint16(addr>>16), p[:n])
+		if err != nil {
+			return err
+		}
+		if w != n {
+			return fmt.Errorf("ezusb: short write at %#x", addr)
+		}
+		addr += uint32(n)
+		p = p[n:]
+	}
+	return nil
+}
+
+// readRAM reads len(p) bytes at addr.
+func readRAM(h *gousb.Handle, chip Chip, addr uint32, p []byte) error {
+	size := chunkSize
+	if chip == ChipFX3 {
+		size = chunkSizeFX3
+	}
+	for len(p) > 0 {
+		n := len(p)
+		if n > size {
+			n = size
+		}
+		r, err := h.ControlRead(RequestFirmwareLoad, uint16(addr), uint16(addr>>16), p[:n])
+		if err != nil {
+			return err
+		}
+		if r != n {
+			return fmt.Errorf("ezusb: short read at %#x", addr)
+		}
+		addr += uint32(n)
+		p = p[n:]
+	}
+	return nil
+}
+
+// SetReset holds (true) or releases (false) the 8051 reset through CPUCS.
+func SetReset(h *gousb.Handle, chip Chip, reset bool) error {
+	if chip == ChipFX3 {
+		return gousb.ErrNotSupported
+	}
+	v := []byte{0}
+	if reset {
+		v[0] = 1
+	}
+	return writeRAM(h, chip, uint32(chip.cpucs()), v)
+}
+
+// Load writes img to RAM and starts it. FX/FX2 are held in reset while
+// loading; FX3 jumps to the image entry point.
+func Load(h *gousb.Handle, chip Chip, img *Image) error {
+	for _, seg := range img.Segments {
+		if !chip.internal(seg.Addr, len(seg.Data)) {
+			return fmt.Errorf("ezusb: segment at %#x is outside %s internal RAM", seg.Addr, chip)
+		}
+	}
+	if chip == ChipFX3 {
+		for _, seg := range img.Segments {
+			if err := writeRAM(h, chip, seg.Addr, seg.Data); err != nil {
+				return err
+			}
+		}
+		// zero length write to the entry point starts the firmware; the
+		// device may drop off the bus before the status stage
+		_, err := h.ControlWrite(RequestFirmwareLoad, uint16(img.Entry), uint16(img.Entry>>16), nil)
+		if err != nil && err != gousb.ErrNoDevice && err != gousb.ErrIo && err != gousb.ErrPipe {
+			return err
+		}
+		return nil
+	}
+
+	if err := SetReset(h, chip, true); err != nil {
+		return err
+	}
+	for _, seg := range img.Segments {
+		if err := writeRAM(h, chip, seg.Addr, seg.Data); err != nil {
+			return err
+		}
+	}
+	err := SetReset(h, chip, false)
+	if err != nil && err != gousb.ErrNoDevice && err != gousb.ErrIo {
+		return err
+	}
+	return nil
+}
+
+// Verify reads back img from RAM. FX/FX2 must still be held in reset.
+func Verify(h *gousb.Handle, chip Chip, img *Image) error {
+	for _, seg := range img.Segments {
+		buf := make([]byte, len(seg.Data))
+		if err := readRAM(h, chip, seg.Addr, buf); err != nil {
+			return err
+		}
+		if !bytes.Equal(buf, seg.Data) {
+			return fmt.Errorf("ezusb: verify failed in segment at %#x", seg.Addr)
+		}
+	}
+	return nil
+}
+
+const pollInterval = 100 * time.Millisecond
+
+// WaitDevice polls the device list until match accepts a device and opens
+// it.
+func WaitDevice(match func(dev *gousb.Device) bool, timeout time.Duration) (*gousb.Handle, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		list, err := gousb.GetDeviceList()
+		if err != nil {
+			return nil, err
+		}
+		for _, dev := range list {
+			if match(dev) {
+				h, err := dev.Open()
+				if err == nil {
+					list.Close()
+					return h, nil
+				}
+			}
+		}
+		list.Close()
+		if time.Now().After(deadline) {
+			return nil, gousb.ErrTimeout
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// Boot loads img, waits for the device to re-enumerate on the same port
+// with the firmware's descriptors and opens it. h is closed once the
+// firmware is loaded. A zero vendor id accepts any device on that port.
+func Boot(h *gousb.Handle, chip Chip, img *Image, vendor, product uint16, timeout time.Duration) (*gousb.Handle, error) {
+	old := h.GetDevice()
+	bus, addr := old.Bus, old.Address
+	ports, err := old.GetPortNumbers()
+	if err != nil {
+		return nil, err
+	}
+	if err := Load(h, chip, img); err != nil {
+		return nil, err
+	}
+	h.Close()
+
+	return WaitDevice(func(dev *gousb.Device) bool {
+		if dev.Bus != bus || dev.Address == addr {
+			return false
+		}
+		if vendor != 0 && !dev.MatchVidPid(vendor, product) {
+			return false
+		}
+		p, err := dev.GetPortNumbers()
+		return err == nil && bytes.Equal(p, ports)
+	}, timeout)
+}
+
+// default boot loader ids
+const (
+	VendorCypress  = uint16(0x04B4)
+	ProductFX2     = uint16(0x8613)
+	ProductFX3Boot = uint16(0x00F3)
+)
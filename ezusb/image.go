@@ -0,0 +1,237 @@
+package ezusb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Segment is a block of firmware to be written at Addr.
+type Segment struct {
+	Addr uint32
+	Data []byte
+}
+
+// Image is a parsed firmware image. Entry is only used by FX3 images.
+type Image struct {
+	Segments []Segment
+	Entry    uint32
+}
+
+// Size returns the number of firmware bytes.
+func (img *Image) Size() int {
+	n := 0
+	for _, seg := range img.Segments {
+		n += len(seg.Data)
+	}
+	return n
+}
+
+// add appends data at addr, joining it with the previous segment when
+// they are contiguous.
+func (img *Image) add(addr uint32, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if n := len(img.Segments); n > 0 {
+		last := &img.Segments[n-1]
+		if last.Addr+uint32(len(last.Data)) == addr {
+			last.Data = append(last.Data, data...)
+			return
+		}
+	}
+	img.Segments = append(img.Segments, Segment{Addr: addr, Data: append([]byte(nil), data...)})
+}
+
+// Intel HEX record types
+const (
+	hexData             = 0x00
+	hexEOF              = 0x01
+	hexExtSegmentAddr   = 0x02
+	hexStartSegmentAddr = 0x03
+	hexExtLinearAddr    = 0x04
+	hexStartLinearAddr  = 0x05
+)
+
+// ParseHex parses an Intel HEX file. Segments are sorted by address.
+func ParseHex(r io.Reader) (*Image, error) {
+	img := &Image{}
+	var base uint32
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		s := strings.TrimSpace(sc.Text())
+		if s == "" {
+			continue
+		}
+		if s[0] != ':' {
+			return nil, fmt.Errorf("ezusb: hex line %d: missing ':'", line)
+		}
+		rec, err := hex.DecodeString(s[1:])
+		if err != nil {
+			return nil, fmt.Errorf("ezusb: hex line %d: %v", line, err)
+		}
+		if len(rec) < 5 || len(rec) != int(rec[0])+5 {
+			return nil, fmt.Errorf("ezusb: hex line %d: bad length", line)
+		}
+		var sum byte
+		for _, b := range rec {
+			sum += b
+		}
+		if sum != 0 {
+			return nil, fmt.Errorf("ezusb: hex line %d: bad checksum", line)
+		}
+		addr := uint32(binary.BigEndian.Uint16(rec[1:]))
+		data := rec[4 : len(rec)-1]
+		switch rec[3] {
+		case hexData:
+			img.add(base+addr, data)
+		case hexEOF:
+			sortSegments(img)
+			return img, nil
+		case hexExtSegmentAddr:
+			if len(data) != 2 {
+				return nil, fmt.Errorf("ezusb: hex line %d: bad segment address", line)
+			}
+			base = uint32(binary.BigEndian.Uint16(data)) << 4
+		case hexExtLinearAddr:
+			if len(data) != 2 {
+				return nil, fmt.Errorf("ezusb: hex line %d: bad linear address", line)
+			}
+			base = uint32(binary.BigEndian.Uint16(data)) << 16
+		case hexStartSegmentAddr, hexStartLinearAddr:
+			if len(data) == 4 {
+				img.Entry = binary.BigEndian.Uint32(data)
+			}
+		default:
+			return nil, fmt.Errorf("ezusb: hex line %d: unknown record type %#02x", line, rec[3])
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("ezusb: hex file without EOF record")
+}
+
+func sortSegments(img *Image) {
+	sort.SliceStable(img.Segments, func(i, j int) bool {
+		return img.Segments[i].Addr < img.Segments[j].Addr
+	})
+	// merge again, records are not required to be in order
+	segs := img.Segments
+	img.Segments = nil
+	for _, seg := range segs {
+		img.add(seg.Addr, seg.Data)
+	}
+}
+
+// EEPROM boot image header bytes
+const (
+	iicFX  = byte(0xB2)
+	iicFX2 = byte(0xC2)
+)
+
+// ParseIIC parses an FX or FX2 EEPROM image (0xB2 or 0xC2 load format).
+// The final record, which writes CPUCS to release the 8051 from reset, is
+// dropped; Load releases the reset itself.
+func ParseIIC(b []byte) (*Image, error) {
+	if len(b) < 1 {
+		return nil, errors.New("ezusb: empty iic image")
+	}
+	var off int
+	switch b[0] {
+	case iicFX:
+		off = 7
+	case iicFX2:
+		off = 8
+	default:
+		return nil, fmt.Errorf("ezusb: iic image type %#02x has no firmware", b[0])
+	}
+	img := &Image{}
+	for {
+		if off+4 > len(b) {
+			return nil, errors.New("ezusb: truncated iic image")
+		}
+		length := binary.BigEndian.Uint16(b[off:])
+		addr := uint32(binary.BigEndian.Uint16(b[off+2:]))
+		off += 4
+		if length&0x8000 != 0 {
+			// last record: CPUCS write
+			return img, nil
+		}
+		n := int(length & 0x3ff)
+		if off+n > len(b) {
+			return nil, errors.New("ezusb: truncated iic image")
+		}
+		img.add(addr, b[off:off+n])
+		off += n
+	}
+}
+
+// FX3 boot image
+const (
+	imgTypeNormal = byte(0xB0)
+	imgHeaderLen  = 4
+)
+
+// ParseIMG parses an FX3 boot image and verifies its checksum.
+func ParseIMG(b []byte) (*Image, error) {
+	if len(b) < imgHeaderLen || b[0] != 'C' || b[1] != 'Y' {
+		return nil, errors.New("ezusb: not an fx3 image")
+	}
+	if b[3] != imgTypeNormal {
+		return nil, fmt.Errorf("ezusb: unsupported fx3 image type %#02x", b[3])
+	}
+	le := binary.LittleEndian
+	img := &Image{}
+	var sum uint32
+	off := imgHeaderLen
+	for {
+		if off+8 > len(b) {
+			return nil, errors.New("ezusb: truncated fx3 image")
+		}
+		words := le.Uint32(b[off:])
+		addr := le.Uint32(b[off+4:])
+		off += 8
+		if words == 0 {
+			img.Entry = addr
+			break
+		}
+		n := int(words) * 4
+		if n < 0 || off+n > len(b) {
+			return nil, errors.New("ezusb: truncated fx3 image")
+		}
+		for i := 0; i < n; i += 4 {
+			sum += le.Uint32(b[off+i:])
+		}
+		img.add(addr, b[off:off+n])
+		off += n
+	}
+	if off+4 > len(b) {
+		return nil, errors.New("ezusb: fx3 image without checksum")
+	}
+	if le.Uint32(b[off:]) != sum {
+		return nil, errors.New("ezusb: fx3 image checksum mismatch")
+	}
+	return img, nil
+}
+
+// Parse detects the image format from its content.
+func Parse(b []byte) (*Image, error) {
+	switch {
+	case len(b) >= 2 && b[0] == 'C' && b[1] == 'Y':
+		return ParseIMG(b)
+	case len(b) >= 1 && (b[0] == iicFX || b[0] == iicFX2):
+		return ParseIIC(b)
+	case len(bytes.TrimLeft(b, " \t\r\n")) > 0 && bytes.TrimLeft(b, " \t\r\n")[0] == ':':
+		return ParseHex(bytes.NewReader(b))
+	}
+	return nil, errors.New("ezusb: unknown image format")
+}
@@ -0,0 +1,179 @@
+package ezusb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHex(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []Segment
+		eof  uint32
+	}{
+		{
+			name: "contiguous records merge",
+			in:   ":020000000102FB\n:020002000304F5\n:00000001FF\n",
+			want: []Segment{{Addr: 0, Data: []byte{1, 2, 3, 4}}},
+		},
+		{
+			name: "out of order records are sorted",
+			in:   ":0100100003EC\n:0100000001FE\n:0100110004EA\n:01000F0002EE\n:00000001FF\n",
+			want: []Segment{
+				{Addr: 0x00, Data: []byte{1}},
+				{Addr: 0x0f, Data: []byte{2, 3, 4}},
+			},
+		},
+		{
+			name: "extended linear address and entry",
+			in:   ":020000040001F9\n:01010000AA54\n:0400000500010100F5\n:00000001FF\n",
+			want: []Segment{{Addr: 0x10100, Data: []byte{0xaa}}},
+			eof:  0x10100,
+		},
+		{
+			name: "extended segment address",
+			in:   ":020000021000EC\r\n:01000100BB43\r\n:00000001FF\r\n",
+			want: []Segment{{Addr: 0x10001, Data: []byte{0xbb}}},
+		},
+		{
+			name: "blank lines",
+			in:   "\n:0100000001FE\n\n:00000001FF\n",
+			want: []Segment{{Addr: 0, Data: []byte{1}}},
+		},
+	}
+	for _, tt := range tests {
+		img, err := ParseHex(strings.NewReader(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(img.Segments, tt.want) || img.Entry != tt.eof {
+			t.Errorf("%s: got %+v, entry %#x", tt.name, img.Segments, img.Entry)
+		}
+	}
+}
+
+func TestParseHexErrors(t *testing.T) {
+	for in, want := range map[string]string{
+		":0100000001FF\n:00000001FF\n": "ezusb: hex line 1: bad checksum",
+		":0200000001FD\n:00000001FF\n": "ezusb: hex line 1: bad length",
+		":0100000001FE\n00000001FF\n":  "ezusb: hex line 2: missing ':'",
+		":00000007F9\n:00000001FF\n":   "ezusb: hex line 1: unknown record type 0x07",
+		":0100000001FE\n":              "ezusb: hex file without EOF record",
+	} {
+		if _, err := ParseHex(strings.NewReader(in)); err == nil || err.Error() != want {
+			t.Errorf("%q: got error %v, want %q", in, err, want)
+		}
+	}
+}
+
+func TestParseIIC(t *testing.T) {
+	img, err := ParseIIC([]byte{
+		iicFX2, 0x47, 0x05, 0x13, 0x86, 0x01, 0x00, 0x00, // VID, PID, DID, config
+		0x00, 0x02, 0x00, 0x00, 1, 2,
+		0x00, 0x01, 0x00, 0x02, 3,
+		0x00, 0x01, 0x01, 0x00, 4,
+		0x80, 0x01, 0xe6, 0x00, 0x00, // CPUCS, last record
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Segment{
+		{Addr: 0x0000, Data: []byte{1, 2, 3}},
+		{Addr: 0x0100, Data: []byte{4}},
+	}
+	if !reflect.DeepEqual(img.Segments, want) {
+		t.Errorf("got %+v, want %+v", img.Segments, want)
+	}
+
+	// the FX header has no config byte
+	img, err = ParseIIC([]byte{iicFX, 0x47, 0x05, 0x13, 0x86, 0x01, 0x00, 0x00, 0x01, 0x00, 0x10, 5, 0x80, 0x01, 0xe6, 0x00, 0x00})
+	if err != nil || img.Size() != 1 || img.Segments[0].Addr != 0x10 {
+		t.Errorf("fx image: got %+v, %v", img, err)
+	}
+
+	for _, tt := range []struct {
+		in  []byte
+		err string
+	}{
+		{nil, "ezusb: empty iic image"},
+		{[]byte{0xc0, 0x47, 0x05, 0x13, 0x86, 0x01, 0x00, 0x00}, "ezusb: iic image type 0xc0 has no firmware"},
+		{[]byte{iicFX2, 0, 0, 0, 0, 0, 0, 0, 0x00, 0x01}, "ezusb: truncated iic image"},
+		{[]byte{iicFX2, 0, 0, 0, 0, 0, 0, 0, 0x00, 0x04, 0x00, 0x00, 1, 2}, "ezusb: truncated iic image"},
+		{[]byte{iicFX2, 0, 0, 0, 0, 0, 0, 0, 0x00, 0x01, 0x00, 0x00, 1}, "ezusb: truncated iic image"},
+	} {
+		if _, err := ParseIIC(tt.in); err == nil || err.Error() != tt.err {
+			t.Errorf("% x: got error %v, want %q", tt.in, err, tt.err)
+		}
+	}
+}
+
+// fx3Image has two contiguous sections at 0x40000000 and one at
+// 0x10000000, all little endian 32 bit words.
+var fx3Image = []byte{
+	'C', 'Y', 0x1c, imgTypeNormal,
+	0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40,
+	0x11, 0x11, 0x11, 0x11, 0x22, 0x22, 0x22, 0x22,
+	0x01, 0x00, 0x00, 0x00, 0x08, 0x00, 0x00, 0x40,
+	0x33, 0x33, 0x33, 0x33,
+	0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10,
+	0x44, 0x44, 0x44, 0x44,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, // entry
+	0xaa, 0xaa, 0xaa, 0xaa, // checksum
+}
+
+func TestParseIMG(t *testing.T) {
+	img, err := ParseIMG(fx3Image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Image{
+		Segments: []Segment{
+			{Addr: 0x40000000, Data: []byte{0x11, 0x11, 0x11, 0x11, 0x22, 0x22, 0x22, 0x22, 0x33, 0x33, 0x33, 0x33}},
+			{Addr: 0x10000000, Data: []byte{0x44, 0x44, 0x44, 0x44}},
+		},
+		Entry: 0x40000000,
+	}
+	if !reflect.DeepEqual(img, want) {
+		t.Errorf("got %+v, want %+v", img, want)
+	}
+
+	n := len(fx3Image)
+	corrupt := append([]byte(nil), fx3Image...)
+	corrupt[n-1] ^= 0xff
+	typ := append([]byte(nil), fx3Image...)
+	typ[3] = 0xb1
+	errs := map[string][]byte{
+		"ezusb: fx3 image checksum mismatch":     corrupt,
+		"ezusb: fx3 image without checksum":      fx3Image[:n-4],
+		"ezusb: truncated fx3 image":             fx3Image[:14],
+		"ezusb: unsupported fx3 image type 0xb1": typ,
+		"ezusb: not an fx3 image":                {'C', 'Z', 0x1c, imgTypeNormal},
+	}
+	for want, in := range errs {
+		if _, err := ParseIMG(in); err == nil || err.Error() != want {
+			t.Errorf("got error %v, want %q", err, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for in, size := range map[string]int{
+		":0100000001FE\n:00000001FF\n":      1,
+		"\r\n :0100000001FE\n:00000001FF\n": 1,
+		string(fx3Image):                    16,
+		"\xc2\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00\x00\x01\x02\x80\x01\xe6\x00\x00": 2,
+	} {
+		img, err := Parse([]byte(in))
+		if err != nil || img.Size() != size {
+			t.Errorf("%q: got %v, %v, want %d bytes", in, img, err, size)
+		}
+	}
+	for _, in := range [][]byte{nil, {0x7f, 'E', 'L', 'F'}} {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("% x: no error", in)
+		}
+	}
+}
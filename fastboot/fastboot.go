@@ -0,0 +1,317 @@
+// Package fastboot implements the Android fastboot protocol over USB.
+package fastboot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/op0xA5/gousb"
+)
+
+// fastboot interface class codes
+const (
+	SubClassFastboot = uint8(0x42)
+	ProtocolFastboot = uint8(0x03)
+)
+
+const (
+	maxCommandLength  = 4096
+	maxResponseLength = 256
+	// transfers are rounded down to a multiple of the max packet size
+	defaultChunkSize = 1024 * 1024
+)
+
+// FailError is returned when the device answers FAIL.
+type FailError struct {
+	Command string
+	Message string
+}
+
+func (err *FailError) Error() string {
+	return fmt.Sprintf("fastboot: %s: %s", err.Command, err.Message)
+}
+
+// IsFastbootInterface reports whether iface is a fastboot interface.
+func IsFastbootInterface(iface *gousb.Interface) bool {
+	return iface.InterfaceClass == gousb.ClassVendorSpecific &&
+		iface.InterfaceSubClass == SubClassFastboot &&
+		iface.InterfaceProtocol == ProtocolFastboot
+}
+
+// Device is a claimed fastboot interface.
+type Device struct {
+	h     *gousb.Handle
+	iface *gousb.Interface
+
+	bulk      *gousb.BulkTransfer
+	maxPacket int
+	chunkSize int
+	claimed   bool
+
+	// Info, when set, receives INFO and TEXT messages sent by the device
+	// while a command runs.
+	Info func(string)
+}
+
+// Open finds the fastboot interface of the active configuration and
+// claims it.
+func Open(h *gousb.Handle) (*Device, error) {
+	cfg, err := h.GetActiveConfigDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range cfg.Interfaces {
+		if IsFastbootInterface(iface) {
+			return New(h, iface)
+		}
+	}
+	return nil, gousb.ErrNotFound
+}
+
+func New(h *gousb.Handle, iface *gousb.Interface) (*Device, error) {
+	in := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk)
+	out := iface.FindEndpoint(gousb.EndpointOut, gousb.TransferTypeBulk)
+	if in == nil || out == nil {
+		return nil, errors.New("fastboot: bulk endpoints not found")
+	}
+	if err := h.ClaimInterface(int(iface.InterfaceNumber)); err != nil {
+		return nil, err
+	}
+	dev := &Device{
+		h:         h,
+		iface:     iface,
+		bulk:      h.GetBulkTransfer(in.EndpointAddress, out.EndpointAddress),
+		maxPacket: int(out.MaxPacketSize & 0x7ff),
+		claimed:   true,
+	}
+	if dev.maxPacket == 0 {
+		dev.maxPacket = 512
+	}
+	dev.chunkSize = defaultChunkSize / dev.maxPacket * dev.maxPacket
+	return dev, nil
+}
+
+func (dev *Device) Close() error {
+	if !dev.claimed {
+		return nil
+	}
+	dev.claimed = false
+	return dev.h.ReleaseInterface(int(dev.iface.InterfaceNumber))
+}
+
+// SetTimeout sets the timeout of every transfer. Flash and erase may keep
+// the device busy for a long time, use generous values.
+func (dev *Device) SetTimeout(timeout uint) {
+	dev.bulk.SetTimeout(timeout)
+}
+
+// response reads responses until OKAY, FAIL or DATA. For DATA the
+// returned string is the hex size.
+func (dev *Device) response(cmd string) (string, string, error) {
+	buf := make([]byte, maxResponseLength)
+	for {
+		n, err := dev.bulk.Read(buf)
+		if err != nil {
+			return "", "", err
+		}
+		if n < 4 {
+			return "", "", errors.New("fastboot: short response")
+		}
+		status, msg := string(buf[:4]), string(buf[4:n])
+		switch status {
+		case "INFO", "TEXT":
+			if dev.Info != nil {
+				dev.Info(msg)
+			}
+		case "OKAY", "DATA":
+			return status, msg, nil
+		case "FAIL":
+			return status, msg, &FailError{Command: cmd, Message: msg}
+		default:
+			return "", "", fmt.Errorf("fastboot: unknown response %q", buf[:n])
+		}
+	}
+}
+
+// Command sends a raw command and waits for OKAY, returning its message.
+func (dev *Device) Command(cmd string) (string, error) {
+	if len(cmd) > maxCommandLength {
+		return "", errors.New("fastboot: command too long")
+	}
+	if _, err := dev.bulk.Write([]byte(cmd)); err != nil {
+		return "", err
+	}
+	status, msg, err := dev.response(cmd)
+	if err != nil {
+		return "", err
+	}
+	if status != "OKAY" {
+		return "", fmt.Errorf("fastboot: %s: unexpected %s", cmd, status)
+	}
+	return msg, nil
+}
+
+func (dev *Device) GetVar(name string) (string, error) {
+	return dev.Command("getvar:" + name)
+}
+
+// MaxDownloadSize returns the max-download-size variable.
+func (dev *Device) MaxDownloadSize() (int64, error) {
+	v, err := dev.GetVar("max-download-size")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(v), "0x"), 16, 64)
+}
+
+// Download sends size bytes from r to the device's download buffer.
+func (dev *Device) Download(r io.Reader, size int64) error {
+	cmd := fmt.Sprintf("download:%08x", size)
+	if _, err := dev.bulk.Write([]byte(cmd)); err != nil {
+		return err
+	}
+	status, msg, err := dev.response(cmd)
+	if err != nil {
+		return err
+	}
+	if status != "DATA" {
+		return fmt.Errorf("fastboot: %s: unexpected %s", cmd, status)
+	}
+	accepted, err := strconv.ParseInt(msg, 16, 64)
+	if err != nil || accepted != size {
+		return fmt.Errorf("fastboot: %s: device accepts %q", cmd, msg)
+	}
+
+	buf := make([]byte, dev.chunkSize)
+	for size > 0 {
+		chunk := buf
+		if int64(len(chunk)) > size {
+			chunk = chunk[:size]
+		}
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return err
+		}
+		if _, err := dev.bulk.Write(chunk); err != nil {
+			return err
+		}
+		size -= int64(len(chunk))
+	}
+	_, _, err = dev.response(cmd)
+	return err
+}
+
+// upload runs a command answered with DATA and reads the data into w.
+func (dev *Device) upload(cmd string, w io.Writer) error {
+	if _, err := dev.bulk.Write([]byte(cmd)); err != nil {
+		return err
+	}
+	status, msg, err := dev.response(cmd)
+	if err != nil {
+		return err
+	}
+	if status != "DATA" {
+		return fmt.Errorf("fastboot: %s: unexpected %s", cmd, status)
+	}
+	size, err := strconv.ParseInt(msg, 16, 64)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, dev.chunkSize)
+	for size > 0 {
+		n, err := dev.bulk.Read(buf)
+		if err != nil {
+			return err
+		}
+		if int64(n) > size {
+			n = int(size)
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		size -= int64(n)
+	}
+	_, _, err = dev.response(cmd)
+	return err
+}
+
+// Upload reads the data staged on the device, for example by "oem"
+// commands of some bootloaders.
+func (dev *Device) Upload(w io.Writer) error {
+	return dev.upload("upload", w)
+}
+
+// Flash writes the downloaded data to partition.
+func (dev *Device) Flash(partition string) error {
+	_, err := dev.Command("flash:" + partition)
+	return err
+}
+func (dev *Device) Erase(partition string) error {
+	_, err := dev.Command("erase:" + partition)
+	return err
+}
+func (dev *Device) SetActive(slot string) error {
+	_, err := dev.Command("set_active:" + slot)
+	return err
+}
+
+// Boot boots the downloaded image without flashing it.
+func (dev *Device) Boot() error {
+	_, err := dev.Command("boot")
+	return err
+}
+func (dev *Device) Continue() error {
+	_, err := dev.Command("continue")
+	return err
+}
+func (dev *Device) Reboot() error {
+	_, err := dev.Command("reboot")
+	return err
+}
+func (dev *Device) RebootBootloader() error {
+	_, err := dev.Command("reboot-bootloader")
+	return err
+}
+
+// FlashImage downloads and flashes data to partition. Images larger than
+// max-download-size are converted to sparse images, if they are not
+// already, and flashed in several parts.
+func (dev *Device) FlashImage(partition string, data []byte) error {
+	max, err := dev.MaxDownloadSize()
+	if err != nil || max <= 0 {
+		max = 512 * 1024 * 1024
+	}
+	if int64(len(data)) <= max {
+		if err := dev.Download(bytes.NewReader(data), int64(len(data))); err != nil {
+			return err
+		}
+		return dev.Flash(partition)
+	}
+
+	var img *SparseImage
+	if IsSparse(data) {
+		img, err = ParseSparse(data)
+		if err != nil {
+			return err
+		}
+	} else {
+		img = NewSparseImage(data, defaultBlockSize)
+	}
+	parts, err := img.Split(max)
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		b := part.Bytes()
+		if err := dev.Download(bytes.NewReader(b), int64(len(b))); err != nil {
+			return err
+		}
+		if err := dev.Flash(partition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
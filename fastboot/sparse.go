@@ -0,0 +1,273 @@
+package fastboot
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Android sparse image format
+const (
+	SparseMagic = uint32(0xed26ff3a)
+
+	ChunkRaw      = uint16(0xCAC1)
+	ChunkFill     = uint16(0xCAC2)
+	ChunkDontCare = uint16(0xCAC3)
+	ChunkCRC32    = uint16(0xCAC4)
+
+	sparseHeaderLength = 28
+	chunkHeaderLength  = 12
+	defaultBlockSize   = 4096
+)
+
+// SparseChunk is one chunk of a sparse image. Data is only used by raw
+// chunks, Fill by fill chunks and CRC by CRC32 chunks.
+type SparseChunk struct {
+	Type   uint16
+	Blocks uint32
+	Data   []byte
+	Fill   uint32
+	CRC    uint32
+}
+
+func (c *SparseChunk) size() int64 {
+	switch c.Type {
+	case ChunkRaw:
+		return chunkHeaderLength + int64(len(c.Data))
+	case ChunkFill, ChunkCRC32:
+		return chunkHeaderLength + 4
+	}
+	return chunkHeaderLength
+}
+
+// SparseImage is an Android sparse image.
+type SparseImage struct {
+	BlockSize   uint32
+	TotalBlocks uint32
+	Chunks      []SparseChunk
+}
+
+func IsSparse(b []byte) bool {
+	return len(b) >= sparseHeaderLength && binary.LittleEndian.Uint32(b) == SparseMagic
+}
+
+// ParseSparse parses a sparse image; raw chunk data refers to b.
+func ParseSparse(b []byte) (*SparseImage, error) {
+	le := binary.LittleEndian
+	if !IsSparse(b) {
+		return nil, errors.New("fastboot: not a sparse image")
+	}
+	if le.Uint16(b[4:]) != 1 {
+		return nil, errors.New("fastboot: unsupported sparse version")
+	}
+	fileHdr, chunkHdr := int(le.Uint16(b[8:])), int(le.Uint16(b[10:]))
+	if fileHdr < sparseHeaderLength || chunkHdr < chunkHeaderLength || fileHdr > len(b) {
+		return nil, errors.New("fastboot: bad sparse header")
+	}
+	img := &SparseImage{
+		BlockSize:   le.Uint32(b[12:]),
+		TotalBlocks: le.Uint32(b[16:]),
+	}
+	if img.BlockSize == 0 || img.BlockSize%4 != 0 {
+		return nil, errors.New("fastboot: bad sparse block size")
+	}
+	n := int(le.Uint32(b[20:]))
+	b = b[fileHdr:]
+	for i := 0; i < n; i++ {
+		if len(b) < chunkHdr {
+			return nil, errors.New("fastboot: truncated sparse image")
+		}
+		c := SparseChunk{
+			Type:   le.Uint16(b),
+			Blocks: le.Uint32(b[4:]),
+		}
+		total := int(le.Uint32(b[8:]))
+		if total < chunkHdr || total > len(b) {
+			return nil, errors.New("fastboot: bad sparse chunk size")
+		}
+		body := b[chunkHdr:total]
+		switch c.Type {
+		case ChunkRaw:
+			if int64(len(body)) != int64(c.Blocks)*int64(img.BlockSize) {
+				return nil, errors.New("fastboot: bad raw chunk size")
+			}
+			c.Data = body
+		case ChunkFill:
+			if len(body) < 4 {
+				return nil, errors.New("fastboot: bad fill chunk size")
+			}
+			c.Fill = le.Uint32(body)
+		case ChunkCRC32:
+			if len(body) < 4 {
+				return nil, errors.New("fastboot: bad crc chunk size")
+			}
+			c.CRC = le.Uint32(body)
+		case ChunkDontCare:
+		default:
+			return nil, errors.New("fastboot: unknown sparse chunk type")
+		}
+		img.Chunks = append(img.Chunks, c)
+		b = b[total:]
+	}
+	return img, nil
+}
+
+// NewSparseImage converts a raw image to a sparse image, turning runs of
+// blocks filled with one 32 bit pattern into fill chunks. The last block
+// is zero padded.
+func NewSparseImage(data []byte, blockSize uint32) *SparseImage {
+	bs := int(blockSize)
+	if rem := len(data) % bs; rem != 0 {
+		data = append(data[:len(data):len(data)], make([]byte, bs-rem)...)
+	}
+	img := &SparseImage{
+		BlockSize:   blockSize,
+		TotalBlocks: uint32(len(data) / bs),
+	}
+	for off := 0; off < len(data); off += bs {
+		block := data[off : off+bs]
+		fill, isFill := fillValue(block)
+		var last *SparseChunk
+		if n := len(img.Chunks); n > 0 {
+			last = &img.Chunks[n-1]
+		}
+		switch {
+		case isFill && last != nil && last.Type == ChunkFill && last.Fill == fill:
+			last.Blocks++
+		case isFill:
+			img.Chunks = append(img.Chunks, SparseChunk{Type: ChunkFill, Blocks: 1, Fill: fill})
+		case last != nil && last.Type == ChunkRaw:
+			last.Blocks++
+			last.Data = data[off-len(last.Data) : off+bs]
+		default:
+			img.Chunks = append(img.Chunks, SparseChunk{Type: ChunkRaw, Blocks: 1, Data: block})
+		}
+	}
+	return img
+}
+
+func fillValue(block []byte) (uint32, bool) {
+	v := binary.LittleEndian.Uint32(block)
+	for off := 4; off < len(block); off += 4 {
+		if binary.LittleEndian.Uint32(block[off:]) != v {
+			return 0, false
+		}
+	}
+	return v, true
+}
+
+// Size returns the encoded length of the image.
+func (img *SparseImage) Size() int64 {
+	size := int64(sparseHeaderLength)
+	for i := range img.Chunks {
+		size += img.Chunks[i].size()
+	}
+	return size
+}
+
+func (img *SparseImage) Bytes() []byte {
+	le := binary.LittleEndian
+	b := make([]byte, sparseHeaderLength, img.Size())
+	le.PutUint32(b[0:], SparseMagic)
+	le.PutUint16(b[4:], 1)
+	le.PutUint16(b[6:], 0)
+	le.PutUint16(b[8:], sparseHeaderLength)
+	le.PutUint16(b[10:], chunkHeaderLength)
+	le.PutUint32(b[12:], img.BlockSize)
+	le.PutUint32(b[16:], img.TotalBlocks)
+	le.PutUint32(b[20:], uint32(len(img.Chunks)))
+	for i := range img.Chunks {
+		c := &img.Chunks[i]
+		var hdr [chunkHeaderLength]byte
+		le.PutUint16(hdr[0:], c.Type)
+		le.PutUint32(hdr[4:], c.Blocks)
+		le.PutUint32(hdr[8:], uint32(c.size()))
+		b = append(b, hdr[:]...)
+		switch c.Type {
+		case ChunkRaw:
+			b = append(b, c.Data...)
+		case ChunkFill:
+			b = le.AppendUint32(b, c.Fill)
+		case ChunkCRC32:
+			b = le.AppendUint32(b, c.CRC)
+		}
+	}
+	return b
+}
+
+// Split cuts the image into sparse images of at most max bytes each. Every
+// part spans all blocks, covering the blocks of other parts with don't
+// care chunks, so the parts can be flashed one after another.
+func (img *SparseImage) Split(max int64) ([]*SparseImage, error) {
+	var parts []*SparseImage
+	var cur *SparseImage
+	var end uint32
+	var used int64
+
+	flush := func() {
+		if end < img.TotalBlocks {
+			cur.Chunks = append(cur.Chunks, SparseChunk{Type: ChunkDontCare, Blocks: img.TotalBlocks - end})
+		}
+		parts = append(parts, cur)
+		cur = nil
+	}
+	add := func(start uint32, c SparseChunk) {
+		if start > end {
+			cur.Chunks = append(cur.Chunks, SparseChunk{Type: ChunkDontCare, Blocks: start - end})
+		}
+		cur.Chunks = append(cur.Chunks, c)
+		end = start + c.Blocks
+	}
+
+	var pos uint32
+	for _, c := range img.Chunks {
+		start := pos
+		pos += c.Blocks
+		if c.Type == ChunkDontCare || c.Type == ChunkCRC32 {
+			continue
+		}
+		for {
+			if cur == nil {
+				cur = &SparseImage{BlockSize: img.BlockSize, TotalBlocks: img.TotalBlocks}
+				end = 0
+				// header and the trailing don't care chunk
+				used = sparseHeaderLength + chunkHeaderLength
+			}
+			gap := int64(0)
+			if start > end {
+				gap = chunkHeaderLength
+			}
+			if need := gap + c.size(); used+need <= max {
+				add(start, c)
+				used += need
+				break
+			}
+			if c.Type == ChunkRaw {
+				k := (max - used - gap - chunkHeaderLength) / int64(img.BlockSize)
+				if k > 0 {
+					head := SparseChunk{Type: ChunkRaw, Blocks: uint32(k), Data: c.Data[:k*int64(img.BlockSize)]}
+					add(start, head)
+					start += head.Blocks
+					c.Blocks -= head.Blocks
+					c.Data = c.Data[len(head.Data):]
+					flush()
+					continue
+				}
+			}
+			if len(cur.Chunks) == 0 {
+				return nil, errors.New("fastboot: sparse chunk exceeds max-download-size")
+			}
+			flush()
+		}
+	}
+	if cur != nil {
+		flush()
+	}
+	if len(parts) == 0 {
+		parts = append(parts, &SparseImage{
+			BlockSize:   img.BlockSize,
+			TotalBlocks: img.TotalBlocks,
+			Chunks:      []SparseChunk{{Type: ChunkDontCare, Blocks: img.TotalBlocks}},
+		})
+	}
+	return parts, nil
+}
@@ -0,0 +1,479 @@
+// Package ftdi drives FTDI FT232/FT2232/FT4232 USB UARTs and their MPSSE
+// engine.
+package ftdi
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/op0xA5/gousb"
+)
+
+const VendorFTDI = uint16(0x0403)
+
+// vendor requests
+const (
+	RequestReset           = uint8(0x00)
+	RequestSetModemCtrl    = uint8(0x01)
+	RequestSetFlowCtrl     = uint8(0x02)
+	RequestSetBaudRate     = uint8(0x03)
+	RequestSetData         = uint8(0x04)
+	RequestPollModemStatus = uint8(0x05)
+	RequestSetEventChar    = uint8(0x06)
+	RequestSetErrorChar    = uint8(0x07)
+	RequestSetLatency      = uint8(0x09)
+	RequestGetLatency      = uint8(0x0A)
+	RequestSetBitMode      = uint8(0x0B)
+	RequestReadPins        = uint8(0x0C)
+	RequestReadEEPROM      = uint8(0x90)
+)
+
+// RequestReset values
+const (
+	resetSIO     = uint16(0)
+	resetPurgeRX = uint16(1)
+	resetPurgeTX = uint16(2)
+)
+
+// Chip type, derived from bcdDevice.
+type Chip int
+
+// Chip values
+const (
+	ChipUnknown Chip = iota
+	ChipAM
+	ChipBM
+	Chip2232C
+	Chip232R
+	Chip2232H
+	Chip4232H
+	Chip232H
+	Chip230X
+)
+
+func (c Chip) String() string {
+	switch c {
+	case ChipAM:
+		return "FT8U232AM"
+	case ChipBM:
+		return "FT232BM"
+	case Chip2232C:
+		return "FT2232C"
+	case Chip232R:
+		return "FT232R"
+	case Chip2232H:
+		return "FT2232H"
+	case Chip4232H:
+		return "FT4232H"
+	case Chip232H:
+		return "FT232H"
+	case Chip230X:
+		return "FT230X"
+	}
+	return "unknown"
+}
+
+// IsH reports whether the chip is a hi-speed part with the 120MHz baud
+// clock and the 60MHz MPSSE clock.
+func (c Chip) IsH() bool {
+	return c == Chip2232H || c == Chip4232H || c == Chip232H
+}
+
+func chipFromBcdDevice(bcd uint16) Chip {
+	switch bcd & 0xff00 {
+	case 0x0200:
+		return ChipAM
+	case 0x0400:
+		return ChipBM
+	case 0x0500:
+		return Chip2232C
+	case 0x0600:
+		return Chip232R
+	case 0x0700:
+		return Chip2232H
+	case 0x0800:
+		return Chip4232H
+	case 0x0900:
+		return Chip232H
+	case 0x1000:
+		return Chip230X
+	}
+	return ChipUnknown
+}
+
+// Parity type
+type Parity int
+
+// Parity values
+const (
+	ParityNone  = Parity(0)
+	ParityOdd   = Parity(1)
+	ParityEven  = Parity(2)
+	ParityMark  = Parity(3)
+	ParitySpace = Parity(4)
+)
+
+// StopBits type
+type StopBits int
+
+// StopBits values
+const (
+	StopBits1   = StopBits(0)
+	StopBits1_5 = StopBits(1)
+	StopBits2   = StopBits(2)
+)
+
+// FlowControl type
+type FlowControl int
+
+// FlowControl values
+const (
+	FlowNone    = FlowControl(0)
+	FlowRTSCTS  = FlowControl(1)
+	FlowDTRDSR  = FlowControl(2)
+	FlowXONXOFF = FlowControl(4)
+)
+
+// BitMode values for SetBitMode
+const (
+	BitModeReset        = uint8(0x00)
+	BitModeAsyncBitbang = uint8(0x01)
+	BitModeMPSSE        = uint8(0x02)
+	BitModeSyncBitbang  = uint8(0x04)
+	BitModeMCU          = uint8(0x08)
+	BitModeOpto         = uint8(0x10)
+	BitModeCBUS         = uint8(0x20)
+	BitModeSyncFIFO     = uint8(0x40)
+)
+
+// modem status bits, first status byte
+const (
+	ModemCTS = uint8(0x10)
+	ModemDSR = uint8(0x20)
+	ModemRI  = uint8(0x40)
+	ModemDCD = uint8(0x80)
+)
+
+// Port is one claimed FTDI interface (channel A, B, ...). Read strips the
+// two modem status bytes the chip puts in front of every bulk IN packet.
+type Port struct {
+	h     *gousb.Handle
+	iface *gousb.Interface
+	chip  Chip
+	index uint16
+
+	bulk      *gousb.BulkTransfer
+	maxPacket int
+	timeout   uint
+	buf       []byte
+	pending   []byte
+	status    [2]byte
+	claimed   bool
+}
+
+// Open claims the first interface of an FTDI device.
+func Open(h *gousb.Handle) (*Port, error) {
+	return OpenChannel(h, 0)
+}
+
+// OpenChannel claims channel (0 for A, 1 for B, ...) of a multi-port chip.
+func OpenChannel(h *gousb.Handle, channel int) (*Port, error) {
+	cfg, err := h.GetActiveConfigDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	iface := cfg.Interface(uint8(channel), 0)
+	if iface == nil {
+		return nil, gousb.ErrNotFound
+	}
+	return New(h, iface)
+}
+
+func New(h *gousb.Handle, iface *gousb.Interface) (*Port, error) {
+	in := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk)
+	out := iface.FindEndpoint(gousb.EndpointOut, gousb.TransferTypeBulk)
+	if in == nil || out == nil {
+		return nil, errors.New("ftdi: bulk endpoints not found")
+	}
+	if err := h.ClaimInterface(int(iface.InterfaceNumber)); err != nil {
+		return nil, err
+	}
+	p := &Port{
+		h:         h,
+		iface:     iface,
+		chip:      chipFromBcdDevice(h.GetDevice().BcdDevice),
+		index:     uint16(iface.InterfaceNumber) + 1,
+		bulk:      h.GetBulkTransfer(in.EndpointAddress, out.EndpointAddress),
+		maxPacket: int(in.MaxPacketSize & 0x7ff),
+		claimed:   true,
+	}
+	if p.maxPacket <= 2 {
+		p.maxPacket = 64
+	}
+	p.buf = make([]byte, p.maxPacket*64)
+	if err := p.Reset(); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Port) Close() error {
+	if !p.claimed {
+		return nil
+	}
+	p.claimed = false
+	return p.h.ReleaseInterface(int(p.iface.InterfaceNumber))
+}
+
+func (p *Port) Chip() Chip {
+	return p.chip
+}
+
+// SetTimeout sets the read and write timeout in milliseconds; 0 waits
+// forever.
+func (p *Port) SetTimeout(timeout uint) {
+	p.timeout = timeout
+	p.bulk.SetTimeout(timeout)
+}
+
+func (p *Port) command(req uint8, value uint16) error {
+	_, err := p.h.ControlWrite(req, value, p.index, nil)
+	return err
+}
+
+func (p *Port) Reset() error {
+	p.pending = nil
+	return p.command(RequestReset, resetSIO)
+}
+
+// Purge discards the chip's receive and transmit buffers.
+func (p *Port) Purge() error {
+	p.pending = nil
+	if err := p.command(RequestReset, resetPurgeRX); err != nil {
+		return err
+	}
+	return p.command(RequestReset, resetPurgeTX)
+}
+
+// baudDivisor returns the encoded divisor for clk/clkDiv and the baud rate
+// actually achieved.
+func baudDivisor(baud, clk, clkDiv int) (uint32, int) {
+	fracCode := [8]uint32{0, 3, 2, 4, 1, 5, 6, 7}
+	switch {
+	case baud >= clk/clkDiv:
+		return 0, clk / clkDiv
+	case baud >= clk/(clkDiv+clkDiv/2):
+		return 1, clk / (clkDiv + clkDiv/2)
+	case baud >= clk/(2*clkDiv):
+		return 2, clk / (2 * clkDiv)
+	}
+	// divisor in 1/8 steps, rounded
+	div := clk * 16 / clkDiv / baud
+	div = div/2 + div&1
+	if div > 0x1ffff {
+		div = 0x1ffff
+	}
+	actual := clk * 16 / clkDiv / div
+	actual = actual/2 + actual&1
+	return uint32(div>>3) | fracCode[div&7]<<14, actual
+}
+
+// SetBaudRate programs the baud rate divisor and returns the rate the
+// chip will actually use.
+func (p *Port) SetBaudRate(baud int) (int, error) {
+	if baud <= 0 {
+		return 0, errors.New("ftdi: invalid baud rate")
+	}
+	var enc uint32
+	var actual int
+	if p.chip.IsH() && baud*10 > 120000000/0x3fff {
+		enc, actual = baudDivisor(baud, 120000000, 10)
+		enc |= 0x20000
+	} else {
+		enc, actual = baudDivisor(baud, 48000000, 16)
+	}
+	if actual*100 < baud*97 || actual*100 > baud*103 {
+		return actual, errors.New("ftdi: baud rate not supported")
+	}
+	value := uint16(enc)
+	var index uint16
+	if p.chip.IsH() || p.chip == Chip2232C {
+		index = uint16(enc>>8)&0xff00 | p.index
+	} else {
+		index = uint16(enc >> 16)
+	}
+	_, err := p.h.ControlWrite(RequestSetBaudRate, value, index, nil)
+	return actual, err
+}
+
+// SetLineProperties sets data bits (7 or 8), parity and stop bits.
+func (p *Port) SetLineProperties(dataBits int, parity Parity, stop StopBits) error {
+	if dataBits != 7 && dataBits != 8 {
+		return errors.New("ftdi: unsupported data bits")
+	}
+	value := uint16(dataBits) | uint16(parity)<<8 | uint16(stop)<<11
+	return p.command(RequestSetData, value)
+}
+
+// SetBreak asserts or clears a break condition, keeping 8N1 framing.
+func (p *Port) SetBreak(on bool) error {
+	value := uint16(8)
+	if on {
+		value |= 1 << 14
+	}
+	return p.command(RequestSetData, value)
+}
+
+// SetFlowControl selects hardware or software flow control. For
+// FlowXONXOFF the chip uses DC1 (0x11) and DC3 (0x13).
+func (p *Port) SetFlowControl(flow FlowControl) error {
+	value := uint16(0)
+	if flow == FlowXONXOFF {
+		value = 0x13<<8 | 0x11
+	}
+	_, err := p.h.ControlWrite(RequestSetFlowCtrl, value, uint16(flow)<<8|p.index, nil)
+	return err
+}
+
+func (p *Port) SetDTR(on bool) error {
+	value := uint16(0x0100)
+	if on {
+		value |= 0x01
+	}
+	return p.command(RequestSetModemCtrl, value)
+}
+func (p *Port) SetRTS(on bool) error {
+	value := uint16(0x0200)
+	if on {
+		value |= 0x02
+	}
+	return p.command(RequestSetModemCtrl, value)
+}
+
+// ModemStatus polls the two modem status bytes.
+func (p *Port) ModemStatus() (uint8, uint8, error) {
+	buf := make([]byte, 2)
+	n, err := p.h.ControlRead(RequestPollModemStatus, 0, p.index, buf)
+	if err != nil {
+		return 0, 0, err
+	}
+	if n < 2 {
+		return 0, 0, errors.New("ftdi: short modem status")
+	}
+	return buf[0], buf[1], nil
+}
+
+// SetLatencyTimer sets how long, in ms, the chip waits before sending a
+// short packet with buffered data.
+func (p *Port) SetLatencyTimer(ms uint8) error {
+	if ms == 0 {
+		return errors.New("ftdi: invalid latency")
+	}
+	return p.command(RequestSetLatency, uint16(ms))
+}
+func (p *Port) LatencyTimer() (uint8, error) {
+	buf := make([]byte, 1)
+	n, err := p.h.ControlRead(RequestGetLatency, 0, p.index, buf)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, errors.New("ftdi: short latency")
+	}
+	return buf[0], nil
+}
+
+// SetBitMode switches the interface to mode; mask selects the output
+// pins for the bitbang modes.
+func (p *Port) SetBitMode(mask uint8, mode uint8) error {
+	p.pending = nil
+	return p.command(RequestSetBitMode, uint16(mode)<<8|uint16(mask))
+}
+
+// ReadPins reads the data bus pins directly.
+func (p *Port) ReadPins() (uint8, error) {
+	buf := make([]byte, 1)
+	n, err := p.h.ControlRead(RequestReadPins, 0, p.index, buf)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, errors.New("ftdi: short pins")
+	}
+	return buf[0], nil
+}
+
+// ReadEEPROM reads one 16 bit word of the configuration EEPROM.
+func (p *Port) ReadEEPROM(addr uint16) (uint16, error) {
+	buf := make([]byte, 2)
+	n, err := p.h.ControlRead(RequestReadEEPROM, 0, addr, buf)
+	if err != nil {
+		return 0, err
+	}
+	if n < 2 {
+		return 0, errors.New("ftdi: short eeprom read")
+	}
+	return uint16(buf[0]) | uint16(buf[1])<<8, nil
+}
+
+// LastStatus returns the modem status bytes of the last bulk IN packet.
+func (p *Port) LastStatus() [2]byte {
+	return p.status
+}
+
+// Read returns data received by the chip. Packets holding only the status
+// bytes are skipped until data arrives or the timeout passes.
+func (p *Port) Read(b []byte) (int, error) {
+	var deadline time.Time
+	if p.timeout > 0 {
+		deadline = time.Now().Add(time.Duration(p.timeout) * time.Millisecond)
+	}
+	for len(p.pending) == 0 {
+		n, err := p.bulk.Read(p.buf)
+		if err != nil {
+			return 0, err
+		}
+		p.pending = p.strip(p.buf[:n])
+		if len(p.pending) == 0 && !deadline.IsZero() && time.Now().After(deadline) {
+			return 0, gousb.ErrTimeout
+		}
+	}
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+// strip removes the status bytes from each max packet sized chunk in place.
+func (p *Port) strip(b []byte) []byte {
+	out := b[:0]
+	for len(b) > 0 {
+		pkt := b
+		if len(pkt) > p.maxPacket {
+			pkt = pkt[:p.maxPacket]
+		}
+		b = b[len(pkt):]
+		if len(pkt) < 2 {
+			continue
+		}
+		p.status[0], p.status[1] = pkt[0], pkt[1]
+		out = append(out, pkt[2:]...)
+	}
+	return out
+}
+
+func (p *Port) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		m, err := p.bulk.Write(b[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if m == 0 {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
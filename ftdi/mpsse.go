@@ -0,0 +1,384 @@
+package ftdi
+
+import (
+	"errors"
+	"io"
+)
+
+// MPSSE opcodes
+const (
+	mpsseWriteNeg   = uint8(0x01)
+	mpsseBitMode    = uint8(0x02)
+	mpsseReadNeg    = uint8(0x04)
+	mpsseLSB        = uint8(0x08)
+	mpsseDoWrite    = uint8(0x10)
+	mpsseDoRead     = uint8(0x20)
+	mpsseWriteTMS   = uint8(0x40)
+	mpsseSetLow     = uint8(0x80)
+	mpsseGetLow     = uint8(0x81)
+	mpsseSetHigh    = uint8(0x82)
+	mpsseGetHigh    = uint8(0x83)
+	mpsseLoopOn     = uint8(0x84)
+	mpsseLoopOff    = uint8(0x85)
+	mpsseSetDivisor = uint8(0x86)
+	mpsseFlush      = uint8(0x87)
+	mpsseDiv5Off    = uint8(0x8A)
+	mpsseDiv5On     = uint8(0x8B)
+	mpsse3PhaseOn   = uint8(0x8C)
+	mpsse3PhaseOff  = uint8(0x8D)
+	mpsseAdaptOff   = uint8(0x97)
+	mpsseDriveZero  = uint8(0x9E)
+	mpsseBadCommand = uint8(0xFA)
+)
+
+// MPSSE queues commands for the Multi-Protocol Synchronous Serial Engine
+// of a Port and sends them on Flush or Read.
+type MPSSE struct {
+	p   *Port
+	cmd []byte
+}
+
+// MPSSE switches the port to MPSSE mode and synchronizes with the engine
+// by sending a bad command and waiting for its echo.
+func (p *Port) MPSSE() (*MPSSE, error) {
+	if err := p.SetBitMode(0, BitModeReset); err != nil {
+		return nil, err
+	}
+	if err := p.SetBitMode(0, BitModeMPSSE); err != nil {
+		return nil, err
+	}
+	if err := p.Purge(); err != nil {
+		return nil, err
+	}
+	m := &MPSSE{p: p}
+	m.cmd = append(m.cmd, 0xAA)
+	b, err := m.Read(2)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != mpsseBadCommand || b[1] != 0xAA {
+		return nil, errors.New("ftdi: mpsse sync failed")
+	}
+	m.cmd = append(m.cmd, mpsseAdaptOff, mpsse3PhaseOff, mpsseLoopOff)
+	if p.chip.IsH() {
+		m.cmd = append(m.cmd, mpsseDiv5Off)
+	}
+	return m, m.Flush()
+}
+
+// Queue appends raw MPSSE commands.
+func (m *MPSSE) Queue(cmd ...byte) {
+	m.cmd = append(m.cmd, cmd...)
+}
+
+// Flush sends the queued commands.
+func (m *MPSSE) Flush() error {
+	if len(m.cmd) == 0 {
+		return nil
+	}
+	_, err := m.p.Write(m.cmd)
+	m.cmd = m.cmd[:0]
+	return err
+}
+
+// Read flushes the queue with a send immediate and reads n result bytes.
+func (m *MPSSE) Read(n int) ([]byte, error) {
+	m.cmd = append(m.cmd, mpsseFlush)
+	if err := m.Flush(); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(m.p, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SetClock sets the TCK/SK frequency and returns the frequency in use.
+func (m *MPSSE) SetClock(hz int) (int, error) {
+	if hz <= 0 {
+		return 0, errors.New("ftdi: invalid clock frequency")
+	}
+	base := 6000000
+	if m.p.chip.IsH() {
+		base = 30000000
+	}
+	div := base/hz - 1
+	if base%hz != 0 {
+		div++
+	}
+	if div < 0 {
+		div = 0
+	}
+	if div > 0xffff {
+		return 0, errors.New("ftdi: clock too slow")
+	}
+	m.cmd = append(m.cmd, mpsseSetDivisor, byte(div), byte(div>>8))
+	return base / (div + 1), m.Flush()
+}
+
+// SetLowBits drives ADBUS0-7; dir has a 1 for every output.
+func (m *MPSSE) SetLowBits(value, dir uint8) {
+	m.cmd = append(m.cmd, mpsseSetLow, value, dir)
+}
+
+// SetHighBits drives ACBUS0-7.
+func (m *MPSSE) SetHighBits(value, dir uint8) {
+	m.cmd = append(m.cmd, mpsseSetHigh, value, dir)
+}
+
+func (m *MPSSE) ReadLowBits() (uint8, error) {
+	m.cmd = append(m.cmd, mpsseGetLow)
+	b, err := m.Read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+func (m *MPSSE) ReadHighBits() (uint8, error) {
+	m.cmd = append(m.cmd, mpsseGetHigh)
+	b, err := m.Read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// clockBytes queues a byte shift of data with the given opcode.
+func (m *MPSSE) clockBytes(op uint8, data []byte, n int) {
+	for n > 0 {
+		chunk := n
+		if chunk > 65536 {
+			chunk = 65536
+		}
+		m.cmd = append(m.cmd, op, byte(chunk-1), byte((chunk-1)>>8))
+		if op&mpsseDoWrite != 0 {
+			m.cmd = append(m.cmd, data[:chunk]...)
+			data = data[chunk:]
+		}
+		n -= chunk
+	}
+}
+
+// SPI is an SPI master on ADBUS0 (SCK), ADBUS1 (MOSI), ADBUS2 (MISO) and
+// ADBUS3 (CS, active low).
+type SPI struct {
+	m    *MPSSE
+	mode int
+	idle uint8
+}
+
+const (
+	spiSCK  = uint8(0x01)
+	spiMOSI = uint8(0x02)
+	spiCS   = uint8(0x08)
+	spiDir  = spiSCK | spiMOSI | spiCS
+)
+
+// SPI configures the engine as SPI master in mode 0 or 2 (data sampled on
+// the leading edge); hz is the clock frequency.
+func (m *MPSSE) SPI(mode int, hz int) (*SPI, error) {
+	if mode != 0 && mode != 2 {
+		return nil, errors.New("ftdi: spi mode not supported")
+	}
+	if _, err := m.SetClock(hz); err != nil {
+		return nil, err
+	}
+	s := &SPI{m: m, mode: mode, idle: spiCS}
+	if mode == 2 {
+		s.idle |= spiSCK
+	}
+	m.SetLowBits(s.idle, spiDir)
+	return s, m.Flush()
+}
+
+// Tx asserts CS, shifts out w while reading len(w) bytes, and releases CS.
+func (s *SPI) Tx(w []byte) ([]byte, error) {
+	op := mpsseDoWrite | mpsseDoRead
+	if s.mode == 0 {
+		op |= mpsseWriteNeg
+	} else {
+		op |= mpsseReadNeg
+	}
+	s.m.SetLowBits(s.idle&^spiCS, spiDir)
+	s.m.clockBytes(op, w, len(w))
+	s.m.SetLowBits(s.idle, spiDir)
+	return s.m.Read(len(w))
+}
+
+// I2C is an I2C master on ADBUS0 (SCL) and ADBUS1/ADBUS2 (SDA out/in,
+// wired together). Open drain is emulated by switching pin directions.
+type I2C struct {
+	m *MPSSE
+}
+
+const (
+	i2cSCL = uint8(0x01)
+	i2cSDA = uint8(0x02)
+)
+
+// I2C configures the engine as I2C master with three phase clocking.
+func (m *MPSSE) I2C(hz int) (*I2C, error) {
+	// three phase clocking stretches each bit to 1.5 periods
+	if _, err := m.SetClock(hz * 3 / 2); err != nil {
+		return nil, err
+	}
+	m.cmd = append(m.cmd, mpsse3PhaseOn)
+	if m.p.chip.IsH() {
+		m.cmd = append(m.cmd, mpsseDriveZero, i2cSCL|i2cSDA, 0)
+	}
+	m.SetLowBits(i2cSCL|i2cSDA, i2cSCL|i2cSDA)
+	return &I2C{m: m}, m.Flush()
+}
+
+func (c *I2C) start() {
+	c.m.SetLowBits(i2cSCL|i2cSDA, i2cSCL|i2cSDA)
+	c.m.SetLowBits(i2cSCL, i2cSCL|i2cSDA)
+	c.m.SetLowBits(0, i2cSCL|i2cSDA)
+}
+func (c *I2C) stop() {
+	c.m.SetLowBits(0, i2cSCL|i2cSDA)
+	c.m.SetLowBits(i2cSCL, i2cSCL|i2cSDA)
+	c.m.SetLowBits(i2cSCL|i2cSDA, i2cSCL|i2cSDA)
+}
+
+// writeByte queues a byte and the read of its ACK bit.
+func (c *I2C) writeByte(b byte) {
+	c.m.cmd = append(c.m.cmd, mpsseDoWrite|mpsseWriteNeg, 0, 0, b)
+	c.m.SetLowBits(0, i2cSCL)
+	c.m.cmd = append(c.m.cmd, mpsseDoRead|mpsseBitMode, 0)
+	c.m.SetLowBits(0, i2cSCL|i2cSDA)
+}
+
+// readByte queues the read of a byte and sends ACK, or NACK for the last.
+func (c *I2C) readByte(last bool) {
+	c.m.SetLowBits(0, i2cSCL)
+	c.m.cmd = append(c.m.cmd, mpsseDoRead, 0, 0)
+	ack := byte(0x00)
+	if last {
+		ack = 0xFF
+	}
+	c.m.SetLowBits(0, i2cSCL|i2cSDA)
+	c.m.cmd = append(c.m.cmd, mpsseDoWrite|mpsseWriteNeg|mpsseBitMode, 0, ack)
+}
+
+// Tx writes w to the 7 bit address addr and then, after a repeated start,
+// reads len(r) bytes into r. Either may be empty.
+func (c *I2C) Tx(addr uint8, w, r []byte) error {
+	var acks int
+	c.start()
+	if len(w) > 0 || len(r) == 0 {
+		c.writeByte(addr << 1)
+		acks++
+		for _, b := range w {
+			c.writeByte(b)
+			acks++
+		}
+	}
+	if len(r) > 0 {
+		if len(w) > 0 {
+			c.start()
+		}
+		c.writeByte(addr<<1 | 1)
+		acks++
+		for i := range r {
+			c.readByte(i == len(r)-1)
+		}
+	}
+	c.stop()
+	b, err := c.m.Read(acks + len(r))
+	if err != nil {
+		return err
+	}
+	for _, ack := range b[:acks] {
+		if ack&0x01 != 0 {
+			return errors.New("ftdi: i2c nack")
+		}
+	}
+	copy(r, b[acks:])
+	return nil
+}
+
+// JTAG drives TCK (ADBUS0), TDI (ADBUS1), TDO (ADBUS2) and TMS (ADBUS3).
+type JTAG struct {
+	m *MPSSE
+}
+
+const (
+	jtagTCK = uint8(0x01)
+	jtagTDI = uint8(0x02)
+	jtagTMS = uint8(0x08)
+	jtagDir = jtagTCK | jtagTDI | jtagTMS
+)
+
+// JTAG configures the engine for JTAG and resets the TAP to
+// Run-Test/Idle.
+func (m *MPSSE) JTAG(hz int) (*JTAG, error) {
+	if _, err := m.SetClock(hz); err != nil {
+		return nil, err
+	}
+	m.SetLowBits(jtagTMS, jtagDir)
+	j := &JTAG{m: m}
+	j.ShiftTMS(0x1f, 6)
+	return j, m.Flush()
+}
+
+// ShiftTMS clocks out the low n (at most 7) bits of tms, LSB first.
+func (j *JTAG) ShiftTMS(tms uint8, n int) {
+	j.m.cmd = append(j.m.cmd, mpsseWriteTMS|mpsseBitMode|mpsseLSB|mpsseWriteNeg, byte(n-1), tms&0x7f)
+}
+
+// shift clocks bits of tdi through the selected register, starting and
+// ending in Run-Test/Idle, and returns the captured TDO bits.
+func (j *JTAG) shift(tdi []byte, bits int, ir bool) ([]byte, error) {
+	if bits <= 0 || len(tdi)*8 < bits {
+		return nil, errors.New("ftdi: bad jtag shift length")
+	}
+	if ir {
+		j.ShiftTMS(0x03, 4) // Idle -> Select-DR -> Select-IR -> Capture-IR -> Shift-IR
+	} else {
+		j.ShiftTMS(0x01, 3) // Idle -> Select-DR -> Capture-DR -> Shift-DR
+	}
+	op := mpsseDoWrite | mpsseDoRead | mpsseLSB | mpsseWriteNeg
+	// all bits but the last are shifted with TMS low; the last goes out
+	// with the TMS transition to Exit1
+	body := bits - 1
+	nbytes, rest := body/8, body%8
+	j.m.clockBytes(op, tdi, nbytes)
+	if rest > 0 {
+		j.m.cmd = append(j.m.cmd, op|mpsseBitMode, byte(rest-1), tdi[nbytes])
+	}
+	last := tdi[body/8] >> uint(body%8) & 1
+	j.m.cmd = append(j.m.cmd, mpsseWriteTMS|mpsseDoRead|mpsseBitMode|mpsseLSB|mpsseWriteNeg, 0, last<<7|0x01)
+	j.ShiftTMS(0x01, 2) // Exit1 -> Update -> Idle
+
+	n := nbytes + 2
+	if rest == 0 {
+		n = nbytes + 1
+	}
+	b, err := j.m.Read(n)
+	if err != nil {
+		return nil, err
+	}
+	tdo := make([]byte, (bits+7)/8)
+	copy(tdo, b[:nbytes])
+	i := nbytes
+	if rest > 0 {
+		// bit reads shift in from the top
+		tdo[nbytes] = b[i] >> uint(8-rest)
+		i++
+	}
+	// the TMS read captures TDO in bit 7
+	tdo[body/8] |= (b[i] >> 7 & 1) << uint(body%8)
+	return tdo, nil
+}
+
+// ShiftIR loads the instruction register with bits of tdi.
+func (j *JTAG) ShiftIR(tdi []byte, bits int) ([]byte, error) {
+	return j.shift(tdi, bits, true)
+}
+
+// ShiftDR shifts bits of tdi through the data register.
+func (j *JTAG) ShiftDR(tdi []byte, bits int) ([]byte, error) {
+	return j.shift(tdi, bits, false)
+}
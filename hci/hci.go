@@ -0,0 +1,458 @@
+// Package hci implements the Bluetooth HCI USB transport: commands on the
+// control endpoint, events on interrupt IN, ACL data on bulk and SCO data
+// on the isochronous alternate settings of the second interface.
+package hci
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/op0xA5/gousb"
+)
+
+// wireless controller interface codes
+const (
+	SubClassRF           = uint8(0x01)
+	ProtocolBluetooth    = uint8(0x01)
+	ProtocolUWB          = uint8(0x02)
+	ProtocolRNDIS        = uint8(0x03)
+	ProtocolBluetoothAMP = uint8(0x04)
+)
+
+// H4 packet indicators
+const (
+	PacketCommand = uint8(0x01)
+	PacketACL     = uint8(0x02)
+	PacketSCO     = uint8(0x03)
+	PacketEvent   = uint8(0x04)
+	PacketISO     = uint8(0x05)
+)
+
+// header lengths per packet type
+const (
+	commandHeaderLength = 3
+	aclHeaderLength     = 4
+	scoHeaderLength     = 3
+	eventHeaderLength   = 2
+)
+
+// readTimeout bounds the reader loops so Close does not hang.
+const readTimeout = 100
+
+// isoPackets is the number of SCO packets per isochronous transfer.
+const isoPackets = 8
+
+// Packet is one HCI packet without the H4 indicator.
+type Packet struct {
+	Type uint8
+	Data []byte
+}
+
+// Opcode builds a command opcode from its group and command field.
+func Opcode(ogf, ocf uint16) uint16 {
+	return ogf<<10 | ocf&0x3ff
+}
+
+// packetLength returns the full length of the packet in b from its
+// header, or 0 if the header is incomplete.
+func packetLength(typ uint8, b []byte) int {
+	switch typ {
+	case PacketCommand:
+		if len(b) >= commandHeaderLength {
+			return commandHeaderLength + int(b[2])
+		}
+	case PacketACL:
+		if len(b) >= aclHeaderLength {
+			return aclHeaderLength + int(binary.LittleEndian.Uint16(b[2:]))
+		}
+	case PacketSCO:
+		if len(b) >= scoHeaderLength {
+			return scoHeaderLength + int(b[2])
+		}
+	case PacketEvent:
+		if len(b) >= eventHeaderLength {
+			return eventHeaderLength + int(b[1])
+		}
+	}
+	return 0
+}
+
+// IsHCIInterface reports whether iface is the primary Bluetooth HCI
+// interface.
+func IsHCIInterface(iface *gousb.Interface) bool {
+	return iface.InterfaceClass == gousb.ClassWirelessController &&
+		iface.InterfaceSubClass == SubClassRF &&
+		iface.InterfaceProtocol == ProtocolBluetooth
+}
+
+const requestCommand = gousb.EndpointOut | gousb.RequestTypeClass | gousb.RecipientDevice
+
+// Device is a claimed Bluetooth controller.
+type Device struct {
+	h     *gousb.Handle
+	cfg   *gousb.Configuration
+	iface *gousb.Interface
+
+	events *gousb.InterruptTransfer
+	aclIn  *gousb.BulkTransfer
+	aclOut *gousb.BulkTransfer
+	evtMax int
+	aclMax int
+
+	// SCO interface, nil when the controller has none
+	sco        *gousb.Interface
+	scoAlt     int
+	scoIn      *gousb.IsoTransfer
+	scoOut     *gousb.IsoTransfer
+	scoClaimed bool
+	scoStop    bool
+	scoWG      sync.WaitGroup
+
+	packets chan Packet
+	errc    chan error
+	mu      sync.Mutex
+	closing bool
+	wg      sync.WaitGroup
+	claimed bool
+
+	timeout uint
+	rbuf    []byte
+	wbuf    []byte
+}
+
+// Open finds the Bluetooth HCI interface of the active configuration and
+// claims it.
+func Open(h *gousb.Handle) (*Device, error) {
+	cfg, err := h.GetActiveConfigDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range cfg.Interfaces {
+		if IsHCIInterface(iface) && iface.AlternateSetting == 0 {
+			return New(h, cfg, iface)
+		}
+	}
+	return nil, gousb.ErrNotFound
+}
+
+// New claims the HCI interface iface and starts reading events and ACL
+// data. The SCO interface is the next interface of cfg, if present.
+func New(h *gousb.Handle, cfg *gousb.Configuration, iface *gousb.Interface) (*Device, error) {
+	evt := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeInterrupt)
+	in := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk)
+	out := iface.FindEndpoint(gousb.EndpointOut, gousb.TransferTypeBulk)
+	if evt == nil || in == nil || out == nil {
+		return nil, errors.New("hci: endpoints not found")
+	}
+	if err := h.ClaimInterface(int(iface.InterfaceNumber)); err != nil {
+		return nil, err
+	}
+	dev := &Device{
+		h:       h,
+		cfg:     cfg,
+		iface:   iface,
+		events:  h.GetInterruptTransfer(evt.EndpointAddress),
+		aclIn:   h.GetBulkTransfer(in.EndpointAddress, out.EndpointAddress),
+		aclOut:  h.GetBulkTransfer(in.EndpointAddress, out.EndpointAddress),
+		evtMax:  int(evt.MaxPacketSize & 0x7ff),
+		aclMax:  int(in.MaxPacketSize & 0x7ff),
+		sco:     cfg.Interface(iface.InterfaceNumber+1, 0),
+		packets: make(chan Packet, 64),
+		errc:    make(chan error, 1),
+		claimed: true,
+	}
+	if dev.evtMax == 0 {
+		dev.evtMax = 16
+	}
+	if dev.aclMax == 0 {
+		dev.aclMax = 64
+	}
+	if dev.sco != nil && !IsHCIInterface(dev.sco) {
+		dev.sco = nil
+	}
+	dev.events.SetTimeout(readTimeout)
+	dev.aclIn.SetTimeout(readTimeout)
+
+	dev.wg.Add(2)
+	go dev.readLoop(PacketEvent, dev.events, dev.evtMax, &dev.closing, nil)
+	go dev.readLoop(PacketACL, dev.aclIn, dev.aclMax, &dev.closing, nil)
+	return dev, nil
+}
+
+func (dev *Device) Close() error {
+	if !dev.claimed {
+		return nil
+	}
+	dev.SetSCOAlt(0)
+	dev.mu.Lock()
+	dev.closing = true
+	dev.mu.Unlock()
+	dev.wg.Wait()
+	dev.claimed = false
+	if dev.scoClaimed {
+		dev.h.ReleaseInterface(int(dev.sco.InterfaceNumber))
+		dev.scoClaimed = false
+	}
+	return dev.h.ReleaseInterface(int(dev.iface.InterfaceNumber))
+}
+
+// SetTimeout sets the timeout of commands and outgoing data.
+func (dev *Device) SetTimeout(timeout uint) {
+	dev.timeout = timeout
+	dev.aclOut.SetTimeout(timeout)
+}
+
+func (dev *Device) stopped(flag *bool) bool {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return *flag || dev.closing
+}
+
+func (dev *Device) fail(err error) {
+	select {
+	case dev.errc <- err:
+	default:
+	}
+}
+
+// deliver queues a packet unless the device is closing.
+func (dev *Device) deliver(p Packet, flag *bool) bool {
+	for {
+		select {
+		case dev.packets <- p:
+			return true
+		case <-time.After(readTimeout * time.Millisecond):
+		}
+		if dev.stopped(flag) {
+			return false
+		}
+	}
+}
+
+// readLoop reads a byte stream of typ packets from r, reassembling
+// packets split across transfers.
+func (dev *Device) readLoop(typ uint8, r interface {
+	Read([]byte) (int, error)
+}, size int, flag *bool, wg *sync.WaitGroup) {
+	if wg == nil {
+		wg = &dev.wg
+	}
+	defer wg.Done()
+	buf := make([]byte, size)
+	var pending []byte
+	for !dev.stopped(flag) {
+		n, err := r.Read(buf)
+		if err == gousb.ErrTimeout {
+			continue
+		}
+		if err != nil {
+			dev.fail(err)
+			return
+		}
+		pending = append(pending, buf[:n]...)
+		for {
+			length := packetLength(typ, pending)
+			if length == 0 || len(pending) < length {
+				break
+			}
+			p := Packet{Type: typ, Data: append([]byte(nil), pending[:length]...)}
+			pending = pending[:copy(pending, pending[length:])]
+			if !dev.deliver(p, flag) {
+				return
+			}
+		}
+	}
+}
+
+// ReadPacket returns the next event, ACL or SCO packet.
+func (dev *Device) ReadPacket() (Packet, error) {
+	select {
+	case p := <-dev.packets:
+		return p, nil
+	case err := <-dev.errc:
+		return Packet{}, err
+	}
+}
+
+// WritePacket sends a command, ACL or SCO packet.
+func (dev *Device) WritePacket(p Packet) error {
+	if length := packetLength(p.Type, p.Data); length == 0 || length != len(p.Data) {
+		return fmt.Errorf("hci: malformed packet type %#02x", p.Type)
+	}
+	switch p.Type {
+	case PacketCommand:
+		_, err := dev.h.ControlTransferTimeout(requestCommand, 0, 0, uint16(dev.iface.InterfaceNumber), p.Data, dev.timeout)
+		return err
+	case PacketACL:
+		// the header carries the length, controllers expect no ZLP
+		_, err := dev.aclOut.Write(p.Data)
+		return err
+	case PacketSCO:
+		return dev.writeSCO(p.Data)
+	}
+	return fmt.Errorf("hci: unsupported packet type %#02x", p.Type)
+}
+
+// Command sends the command opcode with params.
+func (dev *Device) Command(opcode uint16, params []byte) error {
+	if len(params) > 255 {
+		return gousb.ErrInvalidParam
+	}
+	b := make([]byte, commandHeaderLength+len(params))
+	binary.LittleEndian.PutUint16(b, opcode)
+	b[2] = uint8(len(params))
+	copy(b[3:], params)
+	return dev.WritePacket(Packet{Type: PacketCommand, Data: b})
+}
+
+// SCOAlts returns the number of alternate settings of the SCO interface.
+func (dev *Device) SCOAlts() int {
+	if dev.sco == nil {
+		return 0
+	}
+	n := 0
+	for _, iface := range dev.cfg.Interfaces {
+		if iface.InterfaceNumber == dev.sco.InterfaceNumber {
+			n++
+		}
+	}
+	return n
+}
+
+// SetSCOAlt selects the SCO alternate setting, which sets the isochronous
+// bandwidth: alt 1 for one 8-bit voice channel, 2 for one 16-bit or two
+// 8-bit channels and so on. Alt 0 stops SCO.
+func (dev *Device) SetSCOAlt(alt int) error {
+	if dev.sco == nil {
+		if alt == 0 {
+			return nil
+		}
+		return errors.New("hci: no SCO interface")
+	}
+	if alt == dev.scoAlt {
+		return nil
+	}
+	if dev.scoAlt != 0 {
+		dev.mu.Lock()
+		dev.scoStop = true
+		dev.mu.Unlock()
+		dev.scoWG.Wait()
+		dev.scoIn.Close()
+		dev.scoOut.Close()
+		dev.scoIn, dev.scoOut = nil, nil
+		dev.mu.Lock()
+		dev.scoStop = false
+		dev.mu.Unlock()
+	}
+	if !dev.scoClaimed {
+		if err := dev.h.ClaimInterface(int(dev.sco.InterfaceNumber)); err != nil {
+			return err
+		}
+		dev.scoClaimed = true
+	}
+	iface := dev.cfg.Interface(dev.sco.InterfaceNumber, uint8(alt))
+	if iface == nil {
+		return fmt.Errorf("hci: no SCO alternate setting %d", alt)
+	}
+	if err := dev.h.SetInterfaceAltSetting(int(dev.sco.InterfaceNumber), alt); err != nil {
+		return err
+	}
+	dev.scoAlt = alt
+	if alt == 0 {
+		return nil
+	}
+	in := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeIsochronous)
+	out := iface.FindEndpoint(gousb.EndpointOut, gousb.TransferTypeIsochronous)
+	if in == nil || out == nil {
+		return errors.New("hci: SCO endpoints not found")
+	}
+	dev.scoIn = dev.h.GetIsoTransfer(in.EndpointAddress, isoPackets, 0)
+	dev.scoOut = dev.h.GetIsoTransfer(out.EndpointAddress, isoPackets, 0)
+	dev.scoIn.SetTimeout(readTimeout)
+	dev.scoWG.Add(1)
+	go dev.readLoop(PacketSCO, &isoReader{dev.scoIn}, dev.scoIn.PacketSize()*isoPackets, &dev.scoStop, &dev.scoWG)
+	return nil
+}
+
+// isoReader concatenates the packets of each isochronous transfer.
+type isoReader struct {
+	it *gousb.IsoTransfer
+}
+
+func (r *isoReader) Read(p []byte) (int, error) {
+	packets, err := r.it.ReadPackets()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, pkt := range packets {
+		if pkt.Err == nil {
+			n += copy(p[n:], pkt.Data)
+		}
+	}
+	return n, nil
+}
+
+// writeSCO splits an SCO packet into isochronous packets.
+func (dev *Device) writeSCO(b []byte) error {
+	if dev.scoOut == nil {
+		return errors.New("hci: SCO not active")
+	}
+	size := dev.scoOut.PacketSize()
+	if size == 0 {
+		return errors.New("hci: SCO alternate setting has no bandwidth")
+	}
+	var packets [][]byte
+	for len(b) > 0 {
+		n := len(b)
+		if n > size {
+			n = size
+		}
+		packets = append(packets, b[:n])
+		b = b[n:]
+	}
+	return dev.scoOut.WritePackets(packets)
+}
+
+// Read returns H4 framed packets: the packet indicator followed by the
+// packet, one packet per call if p is large enough.
+func (dev *Device) Read(p []byte) (int, error) {
+	if len(dev.rbuf) == 0 {
+		pkt, err := dev.ReadPacket()
+		if err != nil {
+			return 0, err
+		}
+		dev.rbuf = append(append(dev.rbuf[:0], pkt.Type), pkt.Data...)
+	}
+	n := copy(p, dev.rbuf)
+	dev.rbuf = dev.rbuf[n:]
+	return n, nil
+}
+
+// Write accepts H4 framed packets, buffering partial packets.
+func (dev *Device) Write(p []byte) (int, error) {
+	dev.wbuf = append(dev.wbuf, p...)
+	for len(dev.wbuf) > 0 {
+		typ := dev.wbuf[0]
+		length := packetLength(typ, dev.wbuf[1:])
+		if length == 0 {
+			if typ < PacketCommand || typ > PacketEvent {
+				dev.wbuf = dev.wbuf[:0]
+				return 0, fmt.Errorf("hci: bad packet indicator %#02x", typ)
+			}
+			break
+		}
+		if len(dev.wbuf) < 1+length {
+			break
+		}
+		err := dev.WritePacket(Packet{Type: typ, Data: append([]byte(nil), dev.wbuf[1:1+length]...)})
+		dev.wbuf = dev.wbuf[:copy(dev.wbuf, dev.wbuf[1+length:])]
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,431 @@
+// Package hub implements the hub class requests: hub descriptor, port
+// status and port features, enough to power-cycle and reset ports on hubs
+// with per-port power switching.
+package hub
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/op0xA5/gousb"
+)
+
+// hub class feature selectors
+const (
+	FeatureCHubLocalPower  = uint16(0)
+	FeatureCHubOverCurrent = uint16(1)
+
+	FeaturePortConnection     = uint16(0)
+	FeaturePortEnable         = uint16(1)
+	FeaturePortSuspend        = uint16(2)
+	FeaturePortOverCurrent    = uint16(3)
+	FeaturePortReset          = uint16(4)
+	FeaturePortLinkState      = uint16(5)
+	FeaturePortPower          = uint16(8)
+	FeaturePortLowSpeed       = uint16(9)
+	FeatureCPortConnection    = uint16(16)
+	FeatureCPortEnable        = uint16(17)
+	FeatureCPortSuspend       = uint16(18)
+	FeatureCPortOverCurrent   = uint16(19)
+	FeatureCPortReset         = uint16(20)
+	FeaturePortTest           = uint16(21)
+	FeaturePortIndicator      = uint16(22)
+	FeaturePortU1Timeout      = uint16(23)
+	FeaturePortU2Timeout      = uint16(24)
+	FeatureCPortLinkState     = uint16(25)
+	FeatureCPortConfigError   = uint16(26)
+	FeaturePortRemoteWakeMask = uint16(27)
+	FeatureBHPortReset        = uint16(28)
+	FeatureCBHPortReset       = uint16(29)
+	FeatureForceLinkPMAccept  = uint16(30)
+)
+
+// PORT_INDICATOR selectors
+const (
+	IndicatorAuto  = uint8(0)
+	IndicatorAmber = uint8(1)
+	IndicatorGreen = uint8(2)
+	IndicatorOff   = uint8(3)
+)
+
+// wHubCharacteristics bits
+const (
+	CharPowerSwitchMask = uint16(0x0003)
+	CharPowerGanged     = uint16(0x0000)
+	CharPowerPerPort    = uint16(0x0001)
+	CharCompound        = uint16(0x0004)
+	CharOverCurrentMask = uint16(0x0018)
+	CharTTThinkTimeMask = uint16(0x0060)
+	CharPortIndicators  = uint16(0x0080)
+)
+
+// Descriptor is the hub descriptor, USB 2 or SuperSpeed.
+type Descriptor struct {
+	Length             uint8
+	DescriptorType     uint8
+	NbrPorts           uint8
+	HubCharacteristics uint16
+	PwrOn2PwrGood      uint8
+	HubContrCurrent    uint8
+	DeviceRemovable    []byte
+
+	// SuperSpeed only
+	HubHdrDecLat uint8
+	HubDelay     uint16
+}
+
+func (desc *Descriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *Descriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+func (desc *Descriptor) IsSuperSpeed() bool {
+	return desc.DescriptorType == gousb.DescriptorTypeSSHub
+}
+
+// PerPortPower reports whether ports are switched individually, as
+// opposed to ganged or not switched at all.
+func (desc *Descriptor) PerPortPower() bool {
+	return desc.HubCharacteristics&CharPowerSwitchMask == CharPowerPerPort
+}
+func (desc *Descriptor) HasIndicators() bool {
+	return !desc.IsSuperSpeed() && desc.HubCharacteristics&CharPortIndicators != 0
+}
+
+// PowerOnDelay returns the time from power on until power is good.
+func (desc *Descriptor) PowerOnDelay() time.Duration {
+	return time.Duration(desc.PwrOn2PwrGood) * 2 * time.Millisecond
+}
+
+// Removable reports whether the device on port is removable. Port numbers
+// start at 1.
+func (desc *Descriptor) Removable(port int) bool {
+	if port < 1 || port/8 >= len(desc.DeviceRemovable) {
+		return true
+	}
+	return desc.DeviceRemovable[port/8]&(1<<uint(port%8)) == 0
+}
+
+// ParseDescriptor parses a USB 2 or SuperSpeed hub descriptor.
+func ParseDescriptor(b []byte) (*Descriptor, error) {
+	if len(b) < 2 {
+		return nil, errors.New("too less bytes")
+	}
+	if int(b[0]) > len(b) {
+		return nil, errors.New("no enough data")
+	}
+	le := binary.LittleEndian
+	desc := &Descriptor{Length: b[0], DescriptorType: b[1]}
+	switch b[1] {
+	case gousb.DescriptorTypeHub:
+		if b[0] < 7 {
+			return nil, errors.New("descriptor length mismatch")
+		}
+		desc.NbrPorts = b[2]
+		desc.HubCharacteristics = le.Uint16(b[3:])
+		desc.PwrOn2PwrGood = b[5]
+		desc.HubContrCurrent = b[6]
+		n := (int(desc.NbrPorts) + 1 + 7) / 8
+		if 7+n > int(b[0]) {
+			n = int(b[0]) - 7
+		}
+		desc.DeviceRemovable = append([]byte(nil), b[7:7+n]...)
+	case gousb.DescriptorTypeSSHub:
+		if b[0] < 12 {
+			return nil, errors.New("descriptor length mismatch")
+		}
+		desc.NbrPorts = b[2]
+		desc.HubCharacteristics = le.Uint16(b[3:])
+		desc.PwrOn2PwrGood = b[5]
+		desc.HubContrCurrent = b[6]
+		desc.HubHdrDecLat = b[7]
+		desc.HubDelay = le.Uint16(b[8:])
+		desc.DeviceRemovable = append([]byte(nil), b[10:12]...)
+	default:
+		return nil, errors.New("not a hub descriptor")
+	}
+	return desc, nil
+}
+
+// wPortStatus bits
+const (
+	PortConnection  = uint16(0x0001)
+	PortEnable      = uint16(0x0002)
+	PortSuspend     = uint16(0x0004)
+	PortOverCurrent = uint16(0x0008)
+	PortReset       = uint16(0x0010)
+	PortL1          = uint16(0x0020)
+	PortPower       = uint16(0x0100)
+	PortLowSpeed    = uint16(0x0200)
+	PortHighSpeed   = uint16(0x0400)
+	PortTest        = uint16(0x0800)
+	PortIndicator   = uint16(0x1000)
+
+	// SuperSpeed layout differs above bit 4
+	PortSSLinkStateMask = uint16(0x01e0)
+	PortSSPower         = uint16(0x0200)
+	PortSSSpeedMask     = uint16(0x1c00)
+)
+
+// wPortChange bits
+const (
+	ChangeConnection  = uint16(0x0001)
+	ChangeEnable      = uint16(0x0002)
+	ChangeSuspend     = uint16(0x0004)
+	ChangeOverCurrent = uint16(0x0008)
+	ChangeReset       = uint16(0x0010)
+	ChangeL1          = uint16(0x0020)
+	ChangeBHReset     = uint16(0x0020)
+	ChangeLinkState   = uint16(0x0040)
+	ChangeConfigError = uint16(0x0080)
+)
+
+// PortStatus is the GET_STATUS response of a port.
+type PortStatus struct {
+	Status     uint16
+	Change     uint16
+	SuperSpeed bool
+}
+
+func (st PortStatus) Connected() bool {
+	return st.Status&PortConnection != 0
+}
+func (st PortStatus) Enabled() bool {
+	return st.Status&PortEnable != 0
+}
+func (st PortStatus) OverCurrent() bool {
+	return st.Status&PortOverCurrent != 0
+}
+func (st PortStatus) Resetting() bool {
+	return st.Status&PortReset != 0
+}
+func (st PortStatus) Powered() bool {
+	if st.SuperSpeed {
+		return st.Status&PortSSPower != 0
+	}
+	return st.Status&PortPower != 0
+}
+
+// Speed returns the attached device speed of a USB 2 port.
+func (st PortStatus) Speed() gousb.UsbSpeed {
+	switch {
+	case !st.Connected():
+		return gousb.UsbSpeedUnknown
+	case st.SuperSpeed:
+		return gousb.UsbSpeedSuper
+	case st.Status&PortLowSpeed != 0:
+		return gousb.UsbSpeedLow
+	case st.Status&PortHighSpeed != 0:
+		return gousb.UsbSpeedHigh
+	}
+	return gousb.UsbSpeedFull
+}
+
+// LinkState returns the SuperSpeed port link state (U0..Loopback).
+func (st PortStatus) LinkState() uint8 {
+	return uint8(st.Status & PortSSLinkStateMask >> 5)
+}
+
+func (st PortStatus) String() string {
+	s := fmt.Sprintf("%04x.%04x", st.Status, st.Change)
+	if st.Powered() {
+		s += " power"
+	}
+	if st.Connected() {
+		s += " connect " + st.Speed().String()
+	}
+	if st.Enabled() {
+		s += " enable"
+	}
+	if st.OverCurrent() {
+		s += " oc"
+	}
+	if st.Resetting() {
+		s += " reset"
+	}
+	return s
+}
+
+const requestHubIn = gousb.EndpointIn | gousb.RequestTypeClass | gousb.RecipientDevice
+const requestHubOut = gousb.EndpointOut | gousb.RequestTypeClass | gousb.RecipientDevice
+const requestPortIn = gousb.EndpointIn | gousb.RequestTypeClass | gousb.RecipientOther
+const requestPortOut = gousb.EndpointOut | gousb.RequestTypeClass | gousb.RecipientOther
+
+// Hub is an open hub device.
+type Hub struct {
+	h    *gousb.Handle
+	desc *Descriptor
+}
+
+// Open reads the hub descriptor of h, the SuperSpeed one for USB 3 hubs
+// running at SuperSpeed.
+func Open(h *gousb.Handle) (*Hub, error) {
+	dev := h.GetDevice()
+	if dev.DeviceClass != gousb.ClassHub {
+		return nil, errors.New("hub: not a hub")
+	}
+	typ := gousb.DescriptorTypeHub
+	if dev.BcdUSB >= 0x0300 && dev.Speed >= gousb.UsbSpeedSuper {
+		typ = gousb.DescriptorTypeSSHub
+	}
+	buf := make([]byte, 71)
+	n, err := h.ControlTransfer(requestHubIn, gousb.RequestGetDescriptor, uint16(typ)<<8, 0, buf)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := ParseDescriptor(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	return &Hub{h: h, desc: desc}, nil
+}
+
+func (hub *Hub) Descriptor() *Descriptor {
+	return hub.desc
+}
+func (hub *Hub) NumPorts() int {
+	return int(hub.desc.NbrPorts)
+}
+
+func (hub *Hub) checkPort(port int) error {
+	if port < 1 || port > int(hub.desc.NbrPorts) {
+		return fmt.Errorf("hub: no port %d", port)
+	}
+	return nil
+}
+
+// HubStatus returns wHubStatus and wHubChange.
+func (hub *Hub) HubStatus() (status, change uint16, err error) {
+	buf := make([]byte, 4)
+	n, err := hub.h.ControlTransfer(requestHubIn, gousb.RequestGetStatus, 0, 0, buf)
+	if err != nil {
+		return 0, 0, err
+	}
+	if n < 4 {
+		return 0, 0, errors.New("hub: short status")
+	}
+	return binary.LittleEndian.Uint16(buf), binary.LittleEndian.Uint16(buf[2:]), nil
+}
+
+func (hub *Hub) PortStatus(port int) (PortStatus, error) {
+	if err := hub.checkPort(port); err != nil {
+		return PortStatus{}, err
+	}
+	buf := make([]byte, 4)
+	n, err := hub.h.ControlTransfer(requestPortIn, gousb.RequestGetStatus, 0, uint16(port), buf)
+	if err != nil {
+		return PortStatus{}, err
+	}
+	if n < 4 {
+		return PortStatus{}, errors.New("hub: short port status")
+	}
+	return PortStatus{
+		Status:     binary.LittleEndian.Uint16(buf),
+		Change:     binary.LittleEndian.Uint16(buf[2:]),
+		SuperSpeed: hub.desc.IsSuperSpeed(),
+	}, nil
+}
+
+// SetPortFeature issues SET_FEATURE to port. The high byte of wIndex
+// carries the selector of features that take one.
+func (hub *Hub) SetPortFeature(port int, feature uint16, selector uint8) error {
+	if err := hub.checkPort(port); err != nil {
+		return err
+	}
+	_, err := hub.h.ControlTransfer(requestPortOut, gousb.RequestSetFeature, feature, uint16(selector)<<8|uint16(port), nil)
+	return err
+}
+func (hub *Hub) ClearPortFeature(port int, feature uint16, selector uint8) error {
+	if err := hub.checkPort(port); err != nil {
+		return err
+	}
+	_, err := hub.h.ControlTransfer(requestPortOut, gousb.RequestClearFeature, feature, uint16(selector)<<8|uint16(port), nil)
+	return err
+}
+
+// SetPortPower switches port power. On ganged hubs this affects every
+// port of the gang.
+func (hub *Hub) SetPortPower(port int, on bool) error {
+	if on {
+		return hub.SetPortFeature(port, FeaturePortPower, 0)
+	}
+	return hub.ClearPortFeature(port, FeaturePortPower, 0)
+}
+
+// PowerCycle switches port off for off and back on, waiting for power
+// good.
+func (hub *Hub) PowerCycle(port int, off time.Duration) error {
+	if err := hub.SetPortPower(port, false); err != nil {
+		return err
+	}
+	time.Sleep(off)
+	if err := hub.SetPortPower(port, true); err != nil {
+		return err
+	}
+	time.Sleep(hub.desc.PowerOnDelay())
+	return nil
+}
+
+// ResetPort resets port and waits for the reset to complete, clearing the
+// reset change bit afterwards.
+func (hub *Hub) ResetPort(port int, timeout time.Duration) error {
+	if err := hub.SetPortFeature(port, FeaturePortReset, 0); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		time.Sleep(10 * time.Millisecond)
+		st, err := hub.PortStatus(port)
+		if err != nil {
+			return err
+		}
+		if st.Change&ChangeReset != 0 || !st.Resetting() {
+			return hub.ClearPortFeature(port, FeatureCPortReset, 0)
+		}
+		if time.Now().After(deadline) {
+			return gousb.ErrTimeout
+		}
+	}
+}
+
+// SetIndicator sets the port indicator LED; only USB 2 hubs reporting
+// indicator support have them.
+func (hub *Hub) SetIndicator(port int, selector uint8) error {
+	if !hub.desc.HasIndicators() {
+		return gousb.ErrNotSupported
+	}
+	return hub.SetPortFeature(port, FeaturePortIndicator, selector)
+}
+
+// ClearChanges acknowledges all change bits set in st.
+func (hub *Hub) ClearChanges(port int, st PortStatus) error {
+	bits := []struct {
+		bit     uint16
+		feature uint16
+	}{
+		{ChangeConnection, FeatureCPortConnection},
+		{ChangeEnable, FeatureCPortEnable},
+		{ChangeSuspend, FeatureCPortSuspend},
+		{ChangeOverCurrent, FeatureCPortOverCurrent},
+		{ChangeReset, FeatureCPortReset},
+	}
+	if st.SuperSpeed {
+		bits = append(bits[:1:1], bits[3:]...)
+		bits = append(bits,
+			struct{ bit, feature uint16 }{ChangeBHReset, FeatureCBHPortReset},
+			struct{ bit, feature uint16 }{ChangeLinkState, FeatureCPortLinkState},
+			struct{ bit, feature uint16 }{ChangeConfigError, FeatureCPortConfigError})
+	}
+	for _, b := range bits {
+		if st.Change&b.bit != 0 {
+			if err := hub.ClearPortFeature(port, b.feature, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package gousb
+
+import (
+	"encoding/json"
+)
+
+// deviceJSON is the JSON and YAML form of a Device. Ports is a list of
+// numbers rather than []byte, which would be encoded as base64.
+type deviceJSON struct {
+	Bus        uint8            `json:"bus" yaml:"bus"`
+	Port       uint8            `json:"port" yaml:"port"`
+	Ports      []int            `json:"ports,omitempty" yaml:"ports,omitempty"`
+	Address    uint8            `json:"address" yaml:"address"`
+	Speed      UsbSpeed         `json:"speed" yaml:"speed"`
+	Descriptor DeviceDescriptor `json:"descriptor" yaml:"descriptor"`
+}
+
+func (dev *Device) encode() (*deviceJSON, error) {
+	v := &deviceJSON{
+		Bus:        dev.Bus,
+		Port:       dev.Port,
+		Address:    dev.Address,
+		Speed:      dev.Speed,
+		Descriptor: dev.DeviceDescriptor,
+	}
+	ports, err := dev.GetPortNumbers()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range ports {
+		v.Ports = append(v.Ports, int(p))
+	}
+	return v, nil
+}
+
+func (dev *Device) decode(v *deviceJSON) {
+	*dev = Device{
+		Bus:              v.Bus,
+		Port:             v.Port,
+		Address:          v.Address,
+		Speed:            v.Speed,
+		DeviceDescriptor: v.Descriptor,
+	}
+	for _, p := range v.Ports {
+		dev.ports = append(dev.ports, byte(p))
+	}
+}
+
+func (dev *Device) MarshalJSON() ([]byte, error) {
+	v, err := dev.encode()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes a Device encoded by MarshalJSON. The result is not
+// backed by a libusb device and cannot be opened.
+func (dev *Device) UnmarshalJSON(b []byte) error {
+	var v deviceJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	dev.decode(&v)
+	return nil
+}
+
+// MarshalYAML and UnmarshalYAML implement the gopkg.in/yaml marshaler
+// interfaces with the same layout as the JSON encoding.
+func (dev *Device) MarshalYAML() (interface{}, error) {
+	return dev.encode()
+}
+func (dev *Device) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v deviceJSON
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	dev.decode(&v)
+	return nil
+}
+
+func (cfg *Configuration) UnmarshalJSON(b []byte) error {
+	type configuration Configuration
+	if err := json.Unmarshal(b, (*configuration)(cfg)); err != nil {
+		return err
+	}
+	cfg.linkFunctions()
+	return nil
+}
+func (cfg *Configuration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type configuration Configuration
+	if err := unmarshal((*configuration)(cfg)); err != nil {
+		return err
+	}
+	cfg.linkFunctions()
+	return nil
+}
+
+// DeviceInfo is a snapshot of a device for inventories: the device with
+// its location, all configuration trees and the strings they refer to, in
+// the device's first language.
+type DeviceInfo struct {
+	Device         *Device          `json:"device" yaml:"device"`
+	Languages      []uint16         `json:"languages,omitempty" yaml:"languages,omitempty"`
+	Strings        map[uint8]string `json:"strings,omitempty" yaml:"strings,omitempty"`
+	Configurations []*Configuration `json:"configurations" yaml:"configurations"`
+}
+
+// DeviceInfo reads the configurations and strings of the device. Strings
+// that cannot be read are left out.
+func (h *Handle) DeviceInfo() (*DeviceInfo, error) {
+	dev := h.dev
+	info := &DeviceInfo{
+		Device:  dev,
+		Strings: make(map[uint8]string),
+	}
+	for i := 0; i < int(dev.NumConfiguation); i++ {
+		cfg, err := h.GetConfigDescriptor(uint8(i))
+		if err != nil {
+			return nil, err
+		}
+		info.Configurations = append(info.Configurations, cfg)
+	}
+
+	indexes := []uint8{dev.IdxManufacturer, dev.IdxProduct, dev.IdxSerialNumber}
+	for _, cfg := range info.Configurations {
+		indexes = append(indexes, cfg.IdxConfiguration)
+		for _, fn := range cfg.Functions {
+			indexes = append(indexes, fn.IdxFunction)
+		}
+		for _, iface := range cfg.Interfaces {
+			indexes = append(indexes, iface.IdxInterface)
+		}
+	}
+
+	langs, err := h.languages()
+	if err != nil || len(langs) == 0 {
+		// no string descriptors
+		return info, nil
+	}
+	info.Languages = langs
+	for _, idx := range indexes {
+		if _, ok := info.Strings[idx]; ok || idx == 0 {
+			continue
+		}
+		if s, err := h.GetStringDescriptor(idx, langs[0]); err == nil {
+			info.Strings[idx] = s
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,154 @@
+package gousb
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var testDevice = &Device{
+	Bus:     1,
+	Port:    4,
+	Address: 7,
+	Speed:   UsbSpeedHigh,
+	DeviceDescriptor: DeviceDescriptor{
+		Length:          deviceDescriptorLength,
+		DescriptorType:  DescriptorTypeDevice,
+		BcdUSB:          0x0200,
+		MaxPacketSize0:  64,
+		IDVender:        0x1d50,
+		IDProduct:       0x6018,
+		IdxProduct:      2,
+		NumConfiguation: 1,
+	},
+	ports: []byte{1, 4},
+}
+
+func TestDeviceJSON(t *testing.T) {
+	b, err := json.Marshal(testDevice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := new(Device)
+	if err := json.Unmarshal(b, dev); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dev, testDevice) {
+		t.Errorf("got %+v, want %+v", dev, testDevice)
+	}
+	if ports, _ := dev.GetPortNumbers(); string(ports) != "\x01\x04" {
+		t.Errorf("GetPortNumbers() = %v", ports)
+	}
+	if _, err := dev.Open(); err != ErrNoDevice {
+		t.Errorf("Open() on a decoded device: got error %v, want %v", err, ErrNoDevice)
+	}
+	if dev.GetParent() != nil {
+		t.Errorf("GetParent() on a decoded device is not nil")
+	}
+}
+
+// yamlRoundTrip drives the YAML marshaler methods the way a YAML library
+// does, with encoding/json standing in for the YAML encoder.
+func yamlRoundTrip(in interface{ MarshalYAML() (interface{}, error) }, out interface {
+	UnmarshalYAML(func(interface{}) error) error
+}) error {
+	v, err := in.MarshalYAML()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return out.UnmarshalYAML(func(v interface{}) error { return json.Unmarshal(b, v) })
+}
+
+func TestDeviceYAML(t *testing.T) {
+	dev := new(Device)
+	if err := yamlRoundTrip(testDevice, dev); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dev, testDevice) {
+		t.Errorf("got %+v, want %+v", dev, testDevice)
+	}
+
+	v, _ := UsbSpeedSuper.MarshalYAML()
+	if v != "super" {
+		t.Errorf("MarshalYAML() = %v, want super", v)
+	}
+	var speed UsbSpeed
+	if err := yamlRoundTrip(UsbSpeedSuper, &speed); err != nil || speed != UsbSpeedSuper {
+		t.Errorf("got %v, %v, want %v", speed, err, UsbSpeedSuper)
+	}
+}
+
+// The YAML encoding must use the same names as the JSON encoding.
+func TestYAMLTags(t *testing.T) {
+	for _, v := range []interface{}{
+		deviceJSON{}, DeviceInfo{}, DeviceDescriptor{},
+		Configuration{}, ConfigurationDescriptor{},
+		Function{}, InterfaceAssociationDescriptor{},
+		Interface{}, InterfaceDescriptor{},
+		Endpoint{}, EndpointDescriptor{},
+		SSEndpointCompanionDescriptor{}, SSPIsoEndpointCompanionDescriptor{},
+	} {
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if f.Anonymous {
+				if f.Tag.Get("yaml") != ",inline" {
+					t.Errorf("%s.%s is not inlined", typ.Name(), f.Name)
+				}
+				continue
+			}
+			if js, ym := f.Tag.Get("json"), f.Tag.Get("yaml"); js != ym {
+				t.Errorf("%s.%s: yaml tag %q, json tag %q", typ.Name(), f.Name, ym, js)
+			}
+		}
+	}
+}
+
+func TestUsbSpeedText(t *testing.T) {
+	for _, speed := range []UsbSpeed{UsbSpeedUnknown, UsbSpeedLow, UsbSpeedFull, UsbSpeedHigh, UsbSpeedSuper} {
+		b, _ := speed.MarshalText()
+		got := UsbSpeed(-1)
+		if err := got.UnmarshalText(b); err != nil || got != speed {
+			t.Errorf("%s: got %v, %v", b, got, err)
+		}
+	}
+	var speed UsbSpeed
+	if err := speed.UnmarshalText([]byte("warp")); err == nil {
+		t.Errorf("unknown speed accepted as %v", speed)
+	}
+	var dev Device
+	if err := json.Unmarshal([]byte(`{"speed":"warp"}`), &dev); err == nil {
+		t.Errorf("device with unknown speed decoded")
+	}
+}
+
+func TestDeviceInfoJSON(t *testing.T) {
+	cfg, err := ParseConfiguration(compositeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &DeviceInfo{
+		Device:         testDevice,
+		Languages:      []uint16{0x0409},
+		Strings:        map[uint8]string{2: "gousb"},
+		Configurations: []*Configuration{cfg},
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(DeviceInfo)
+	if err := json.Unmarshal(b, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, info) {
+		t.Errorf("got %+v, want %+v", decoded, info)
+	}
+}
@@ -11,9 +11,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"unicode/utf16"
 	"unicode/utf8"
 	"unsafe"
+
+	"github.com/op0xA5/gousb/usbids"
 )
 
 type Context struct {
@@ -53,6 +56,9 @@ type Device struct {
 	Speed   UsbSpeed
 
 	DeviceDescriptor
+
+	// port path of a device decoded from JSON
+	ports []byte
 }
 
 func newDevice(list **C.struct_libusb_device, ptr *C.struct_libusb_device) *Device {
@@ -117,6 +123,9 @@ func (list DeviceList) Close() {
 const maxPortDepth = 8
 
 func (dev *Device) GetPortNumbers() ([]byte, error) {
+	if dev.ptr == nil {
+		return dev.ports, nil
+	}
 	ports := [maxPortDepth]byte{}
 	rc := int(C.libusb_get_port_numbers(dev.ptr, (*C.uint8_t)(&ports[0]), (C.int)(len(ports))))
 	if rc < 0 {
@@ -124,17 +133,38 @@ func (dev *Device) GetPortNumbers() ([]byte, error) {
 	}
 	return ports[:rc], nil
 }
+
+// GetParent returns nil for root hubs and for devices that were decoded
+// rather than enumerated.
 func (dev *Device) GetParent() *Device {
-	return newDevice(nil, C.libusb_get_parent(dev.ptr))
+	if dev.ptr == nil {
+		return nil
+	}
+	ptr := C.libusb_get_parent(dev.ptr)
+	if ptr == nil {
+		return nil
+	}
+	return newDevice(nil, ptr)
 }
 func (dev *Device) GetMaxPacketSize(endpoint uint8) int {
+	if dev.ptr == nil {
+		return int(ErrNoDevice)
+	}
 	return int(C.libusb_get_max_packet_size(dev.ptr, (C.uchar)(endpoint)))
 }
 func (dev *Device) MatchVidPid(vendor_id, product_id uint16) bool {
 	return dev.IDVender == vendor_id && dev.IDProduct == product_id
 }
+
+// String returns the location and IDs of the device, followed by the
+// vendor and product names if usb.ids knows them. The first call loads
+// usb.ids, see usbids.Default.
 func (dev *Device) String() string {
-	return fmt.Sprintf("Bus=%d, Port=%d, Addr=%d, Pid:Vid=%04x:%04x", dev.Bus, dev.Port, dev.Address, dev.IDProduct, dev.IDVender)
+	s := fmt.Sprintf("Bus=%d, Port=%d, Addr=%d, Pid:Vid=%04x:%04x", dev.Bus, dev.Port, dev.Address, dev.IDProduct, dev.IDVender)
+	if name := strings.TrimSpace(usbids.Vendor(dev.IDVender) + " " + usbids.Product(dev.IDVender, dev.IDProduct)); name != "" {
+		s += " " + name
+	}
+	return s
 }
 
 type Handle struct {
@@ -145,6 +175,9 @@ type Handle struct {
 }
 
 func (dev *Device) Open() (*Handle, error) {
+	if dev.ptr == nil {
+		return nil, ErrNoDevice
+	}
 	var h Handle
 	rc := int(C.libusb_open(dev.ptr, (**C.struct_libusb_device_handle)(&h.ptr)))
 	if rc < 0 {
@@ -170,6 +203,21 @@ func (h *Handle) Close() {
 func (h *Handle) GetDevice() *Device {
 	return h.dev
 }
+func (h *Handle) GetConfiguration() (int, error) {
+	var config C.int
+	rc := int(C.libusb_get_configuration(h.ptr, &config))
+	if rc < 0 {
+		return 0, Error(rc)
+	}
+	return int(config), nil
+}
+func (h *Handle) SetConfiguration(config int) error {
+	rc := int(C.libusb_set_configuration(h.ptr, (C.int)(config)))
+	if rc < 0 {
+		return Error(rc)
+	}
+	return nil
+}
 func (h *Handle) ClaimInterface(interface_number int) error {
 	rc := int(C.libusb_claim_interface(h.ptr, (C.int)(interface_number)))
 	if rc < 0 {
@@ -184,6 +232,20 @@ func (h *Handle) ReleaseInterface(interface_number int) error {
 	}
 	return nil
 }
+func (h *Handle) SetInterfaceAltSetting(interface_number, alternate_setting int) error {
+	rc := int(C.libusb_set_interface_alt_setting(h.ptr, (C.int)(interface_number), (C.int)(alternate_setting)))
+	if rc < 0 {
+		return Error(rc)
+	}
+	return nil
+}
+func (h *Handle) ClearHalt(endpoint uint8) error {
+	rc := int(C.libusb_clear_halt(h.ptr, (C.uchar)(endpoint)))
+	if rc < 0 {
+		return Error(rc)
+	}
+	return nil
+}
 func (h *Handle) ResetDevice() error {
 	rc := int(C.libusb_reset_device(h.ptr))
 	if rc < 0 {
@@ -262,9 +324,13 @@ func (h *Handle) Command(req uint8, value, index uint16) error {
 
 func (h *Handle) bulkTransferTimeout(ep uint8, p []byte, timeout uint) (n int, err error) {
 	var transferred C.int
+	var data_ptr *C.uchar
+	if len(p) > 0 {
+		data_ptr = (*C.uchar)(&p[0])
+	}
 	rc := int(C.libusb_bulk_transfer(h.ptr,
 		C.uchar(ep),
-		(*C.uchar)(&p[0]),
+		data_ptr,
 		C.int(len(p)),
 		&transferred,
 		C.uint(timeout)))
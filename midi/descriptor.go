@@ -0,0 +1,301 @@
+package midi
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/op0xA5/gousb"
+)
+
+// class-specific descriptor types
+const (
+	DescriptorTypeCSInterface = uint8(0x24)
+	DescriptorTypeCSEndpoint  = uint8(0x25)
+)
+
+// MIDIStreaming interface descriptor subtypes
+const (
+	MSHeader      = uint8(0x01)
+	MSMIDIInJack  = uint8(0x02)
+	MSMIDIOutJack = uint8(0x03)
+	MSElement     = uint8(0x04)
+)
+
+// MIDIStreaming endpoint descriptor subtypes
+const (
+	MSGeneral = uint8(0x01)
+)
+
+// jack types
+const (
+	JackEmbedded = uint8(0x01)
+	JackExternal = uint8(0x02)
+)
+
+// Source is an input pin connection of an OUT jack or element.
+type Source struct {
+	ID  uint8
+	Pin uint8
+}
+
+// Jack is a MIDI IN or OUT jack. Sources is only set for OUT jacks.
+type Jack struct {
+	Subtype  uint8
+	JackType uint8
+	ID       uint8
+	Sources  []Source
+	IdxJack  uint8
+}
+
+func (j *Jack) IsInput() bool {
+	return j.Subtype == MSMIDIInJack
+}
+func (j *Jack) IsEmbedded() bool {
+	return j.JackType == JackEmbedded
+}
+
+// Element is a MIDI element such as a synthesizer.
+type Element struct {
+	ID              uint8
+	Sources         []Source
+	NrOutputPins    uint8
+	InTerminalLink  uint8
+	OutTerminalLink uint8
+	Capabilities    []byte
+	IdxElement      uint8
+}
+
+// MIDIStreaming holds the class-specific descriptors of a MIDIStreaming
+// interface.
+type MIDIStreaming struct {
+	BcdMSC      uint16
+	TotalLength uint16
+	Jacks       []*Jack
+	Elements    []*Element
+}
+
+func (ms *MIDIStreaming) Jack(id uint8) *Jack {
+	for _, j := range ms.Jacks {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// EndpointGeneral is the MS_GENERAL endpoint descriptor. The index into
+// AssocJackIDs is the cable number.
+type EndpointGeneral struct {
+	AssocJackIDs []uint8
+}
+
+// Header is the MIDIStreaming interface header.
+type Header struct {
+	BcdMSC      uint16
+	TotalLength uint16
+}
+
+var errShort = errors.New("midi: short descriptor")
+
+func parseSources(b []byte, n int) ([]Source, error) {
+	if len(b) < n*2 {
+		return nil, errShort
+	}
+	sources := make([]Source, n)
+	for i := range sources {
+		sources[i] = Source{ID: b[i*2], Pin: b[i*2+1]}
+	}
+	return sources, nil
+}
+
+// parseMSDescriptor decodes one MIDIStreaming interface descriptor into a
+// *Header, *Jack or *Element; other subtypes return nil.
+func parseMSDescriptor(b []byte) (interface{}, error) {
+	switch b[2] {
+	case MSHeader:
+		if len(b) < 7 {
+			return nil, errShort
+		}
+		return &Header{
+			BcdMSC:      binary.LittleEndian.Uint16(b[3:]),
+			TotalLength: binary.LittleEndian.Uint16(b[5:]),
+		}, nil
+	case MSMIDIInJack:
+		if len(b) < 6 {
+			return nil, errShort
+		}
+		return &Jack{
+			Subtype:  b[2],
+			JackType: b[3],
+			ID:       b[4],
+			IdxJack:  b[5],
+		}, nil
+	case MSMIDIOutJack:
+		if len(b) < 6 {
+			return nil, errShort
+		}
+		n := int(b[5])
+		sources, err := parseSources(b[6:], n)
+		if err != nil {
+			return nil, err
+		}
+		j := &Jack{Subtype: b[2], JackType: b[3], ID: b[4], Sources: sources}
+		if len(b) > 6+n*2 {
+			j.IdxJack = b[6+n*2]
+		}
+		return j, nil
+	case MSElement:
+		if len(b) < 5 {
+			return nil, errShort
+		}
+		n := int(b[4])
+		sources, err := parseSources(b[5:], n)
+		if err != nil {
+			return nil, err
+		}
+		off := 5 + n*2
+		if len(b) < off+4 {
+			return nil, errShort
+		}
+		el := &Element{
+			ID:              b[3],
+			Sources:         sources,
+			NrOutputPins:    b[off],
+			InTerminalLink:  b[off+1],
+			OutTerminalLink: b[off+2],
+		}
+		size := int(b[off+3])
+		off += 4
+		if len(b) < off+size {
+			return nil, errShort
+		}
+		el.Capabilities = append([]byte(nil), b[off:off+size]...)
+		if len(b) > off+size {
+			el.IdxElement = b[off+size]
+		}
+		return el, nil
+	}
+	return nil, nil
+}
+
+// parseMSEndpointDescriptor decodes an MS_GENERAL endpoint descriptor into
+// an *EndpointGeneral; other subtypes return nil.
+func parseMSEndpointDescriptor(b []byte) (interface{}, error) {
+	if b[2] != MSGeneral || len(b) < 4 {
+		return nil, nil
+	}
+	n := int(b[3])
+	if len(b) < 4+n {
+		return nil, errShort
+	}
+	return &EndpointGeneral{AssocJackIDs: append([]byte(nil), b[4:4+n]...)}, nil
+}
+
+// ClassDescriptor is a class-specific descriptor as returned by
+// gousb.ParseDescriptors for MIDIStreaming interfaces. Value is one of
+// *Header, *Jack, *Element or *EndpointGeneral.
+type ClassDescriptor struct {
+	Length            uint8
+	DescriptorType    uint8
+	DescriptorSubtype uint8
+	Value             interface{}
+}
+
+func (desc *ClassDescriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *ClassDescriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+// parseClassDescriptor decodes a single CS_INTERFACE or CS_ENDPOINT
+// descriptor of a MIDIStreaming interface; the other audio subclasses are
+// left to the uac decoders.
+func parseClassDescriptor(iface *gousb.InterfaceDescriptor, b []byte) (gousb.Descriptor, error) {
+	if len(b) < 3 || iface.InterfaceSubClass != SubClassMIDIStreaming {
+		return nil, nil
+	}
+	var v interface{}
+	var err error
+	if b[1] == DescriptorTypeCSEndpoint {
+		v, err = parseMSEndpointDescriptor(b)
+	} else {
+		v, err = parseMSDescriptor(b)
+	}
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return &ClassDescriptor{
+		Length:            b[0],
+		DescriptorType:    b[1],
+		DescriptorSubtype: b[2],
+		Value:             v,
+	}, nil
+}
+
+// classValues parses a run of MIDIStreaming descriptors with
+// gousb.ParseDescriptors and returns the decoded values.
+func classValues(extra []byte) ([]interface{}, error) {
+	iface := &gousb.InterfaceDescriptor{
+		InterfaceClass:    gousb.ClassAudio,
+		InterfaceSubClass: SubClassMIDIStreaming,
+	}
+	list, err := gousb.ParseDescriptors(iface, extra)
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for _, desc := range list {
+		if cd, ok := desc.(*ClassDescriptor); ok {
+			values = append(values, cd.Value)
+		}
+	}
+	return values, nil
+}
+
+// ParseMIDIStreaming parses the class-specific descriptors following a
+// MIDIStreaming interface descriptor.
+func ParseMIDIStreaming(extra []byte) (*MIDIStreaming, error) {
+	list, err := classValues(extra)
+	if err != nil {
+		return nil, err
+	}
+	ms := &MIDIStreaming{}
+	header := false
+	for _, v := range list {
+		switch v := v.(type) {
+		case *Header:
+			ms.BcdMSC = v.BcdMSC
+			ms.TotalLength = v.TotalLength
+			header = true
+		case *Jack:
+			ms.Jacks = append(ms.Jacks, v)
+		case *Element:
+			ms.Elements = append(ms.Elements, v)
+		}
+	}
+	if !header {
+		return nil, errors.New("midi: no MS header")
+	}
+	return ms, nil
+}
+
+// ParseEndpointGeneral parses the MS_GENERAL descriptor in the extra bytes
+// of a MIDIStreaming bulk endpoint.
+func ParseEndpointGeneral(extra []byte) (*EndpointGeneral, error) {
+	list, err := classValues(extra)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range list {
+		if eg, ok := v.(*EndpointGeneral); ok {
+			return eg, nil
+		}
+	}
+	return nil, errors.New("midi: no MS general endpoint descriptor")
+}
+
+func init() {
+	gousb.RegisterDescriptor(gousb.ClassAudio, DescriptorTypeCSInterface, parseClassDescriptor)
+	gousb.RegisterDescriptor(gousb.ClassAudio, DescriptorTypeCSEndpoint, parseClassDescriptor)
+}
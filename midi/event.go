@@ -0,0 +1,200 @@
+package midi
+
+// code index numbers
+const (
+	CINMisc          = uint8(0x0)
+	CINCableEvent    = uint8(0x1)
+	CINSysCommon2    = uint8(0x2)
+	CINSysCommon3    = uint8(0x3)
+	CINSysExStart    = uint8(0x4)
+	CINSysExEnd1     = uint8(0x5) // also single byte system common
+	CINSysExEnd2     = uint8(0x6)
+	CINSysExEnd3     = uint8(0x7)
+	CINNoteOff       = uint8(0x8)
+	CINNoteOn        = uint8(0x9)
+	CINPolyKeyPress  = uint8(0xA)
+	CINControlChange = uint8(0xB)
+	CINProgramChange = uint8(0xC)
+	CINChannelPress  = uint8(0xD)
+	CINPitchBend     = uint8(0xE)
+	CINSingleByte    = uint8(0xF)
+)
+
+// MIDI status bytes with special handling
+const (
+	statusSysEx        = byte(0xF0)
+	statusTimeCode     = byte(0xF1)
+	statusSongPosition = byte(0xF2)
+	statusSongSelect   = byte(0xF3)
+	statusTuneRequest  = byte(0xF6)
+	statusEndSysEx     = byte(0xF7)
+	statusRealtime     = byte(0xF8)
+)
+
+var cinLength = [16]int{
+	0, 0, 2, 3, 3, 1, 2, 3,
+	3, 3, 3, 3, 2, 2, 3, 1,
+}
+
+// Event is a 32-bit USB-MIDI event packet.
+type Event struct {
+	Cable uint8
+	CIN   uint8
+	Data  [3]byte
+}
+
+// Len returns the number of valid MIDI bytes in Data.
+func (ev Event) Len() int {
+	return cinLength[ev.CIN&0x0f]
+}
+func (ev Event) Bytes() []byte {
+	return ev.Data[:ev.Len()]
+}
+
+func (ev Event) Marshal() [4]byte {
+	return [4]byte{ev.Cable<<4 | ev.CIN&0x0f, ev.Data[0], ev.Data[1], ev.Data[2]}
+}
+
+// ParseEvent decodes the first 4 bytes of b.
+func ParseEvent(b []byte) Event {
+	return Event{
+		Cable: b[0] >> 4,
+		CIN:   b[0] & 0x0f,
+		Data:  [3]byte{b[1], b[2], b[3]},
+	}
+}
+
+// messageLength returns the length of a message starting with status, 0
+// for SysEx and undefined status bytes.
+func messageLength(status byte) int {
+	switch {
+	case status < 0x80:
+		return 0
+	case status < 0xC0, status >= 0xE0 && status < 0xF0:
+		return 3
+	case status < 0xE0:
+		return 2
+	case status == statusTimeCode || status == statusSongSelect:
+		return 2
+	case status == statusSongPosition:
+		return 3
+	case status == statusTuneRequest || status >= statusRealtime:
+		return 1
+	}
+	return 0
+}
+
+// Encoder turns a MIDI byte stream of one cable into event packets,
+// following running status and splitting SysEx.
+type Encoder struct {
+	Cable uint8
+
+	running byte
+	buf     []byte
+	need    int
+	sysex   bool
+}
+
+func (enc *Encoder) event(cin uint8, b []byte) Event {
+	ev := Event{Cable: enc.Cable, CIN: cin}
+	copy(ev.Data[:], b)
+	return ev
+}
+
+// Encode appends the events completed by p to events.
+func (enc *Encoder) Encode(events []Event, p []byte) []Event {
+	for _, c := range p {
+		switch {
+		case c >= statusRealtime:
+			// real time bytes may appear anywhere, even inside SysEx
+			events = append(events, enc.event(CINSingleByte, []byte{c}))
+		case c == statusSysEx:
+			enc.running = 0
+			enc.sysex = true
+			enc.buf = append(enc.buf[:0], c)
+		case c == statusEndSysEx:
+			if enc.sysex {
+				enc.buf = append(enc.buf, c)
+				events = append(events, enc.event(CINSysExEnd1+uint8(len(enc.buf)-1), enc.buf))
+			}
+			enc.sysex = false
+			enc.buf = enc.buf[:0]
+		case enc.sysex && c < 0x80:
+			enc.buf = append(enc.buf, c)
+			if len(enc.buf) == 3 {
+				events = append(events, enc.event(CINSysExStart, enc.buf))
+				enc.buf = enc.buf[:0]
+			}
+		case c >= 0x80:
+			// any other status aborts an unterminated SysEx
+			enc.sysex = false
+			enc.need = messageLength(c)
+			enc.buf = append(enc.buf[:0], c)
+			if c < 0xF0 {
+				enc.running = c
+			} else {
+				enc.running = 0
+			}
+			if enc.need == 0 {
+				enc.buf = enc.buf[:0]
+			}
+		default:
+			if len(enc.buf) == 0 {
+				if enc.running == 0 {
+					// stray data byte
+					continue
+				}
+				enc.need = messageLength(enc.running)
+				enc.buf = append(enc.buf, enc.running)
+			}
+			enc.buf = append(enc.buf, c)
+		}
+		if !enc.sysex && len(enc.buf) > 0 && len(enc.buf) == enc.need {
+			events = append(events, enc.event(enc.cin(enc.buf[0]), enc.buf))
+			enc.buf = enc.buf[:0]
+		}
+	}
+	return events
+}
+
+func (enc *Encoder) cin(status byte) uint8 {
+	if status < 0xF0 {
+		return status >> 4
+	}
+	switch messageLength(status) {
+	case 2:
+		return CINSysCommon2
+	case 3:
+		return CINSysCommon3
+	}
+	if status >= statusRealtime {
+		return CINSingleByte
+	}
+	return CINSysExEnd1
+}
+
+// Decoder assembles the events of one cable into complete MIDI messages.
+type Decoder struct {
+	sysex []byte
+}
+
+// Decode returns the message completed by ev, or nil.
+func (dec *Decoder) Decode(ev Event) []byte {
+	b := ev.Bytes()
+	switch ev.CIN {
+	case CINMisc, CINCableEvent:
+		return nil
+	case CINSysExStart:
+		dec.sysex = append(dec.sysex, b...)
+		return nil
+	case CINSysExEnd1, CINSysExEnd2, CINSysExEnd3:
+		if ev.CIN == CINSysExEnd1 && len(dec.sysex) == 0 && b[0] != statusEndSysEx {
+			// single byte system common
+			return append([]byte(nil), b...)
+		}
+		msg := append(dec.sysex, b...)
+		dec.sysex = nil
+		return msg
+	}
+	return append([]byte(nil), b...)
+}
@@ -0,0 +1,109 @@
+package midi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// wire formats events as USB-MIDI packets, as they appear on the bulk
+// endpoints.
+func wire(events []Event) string {
+	var s []string
+	for _, e := range events {
+		b := e.Marshal()
+		s = append(s, fmt.Sprintf("%x", b[:]))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestEncoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		cable  uint8
+		writes []string
+		want   string
+	}{
+		{"note on", 0, []string{"903c40"}, "09903c40"},
+		{"cable number", 2, []string{"b1077f"}, "2bb1077f"},
+		{"running status", 0, []string{"903c403e404000"}, "09903c40 09903e40 09904000"},
+		{"running status with two byte messages", 0, []string{"c00506"}, "0cc00500 0cc00600"},
+		{"message split across writes", 0, []string{"e0", "00", "4010", "20"}, "0ee00040 0ee01020"},
+		{"real time keeps running status", 0, []string{"903cf8403e40"}, "0ff80000 09903c40 09903e40"},
+		{"system common cancels running status", 0, []string{"903c40f3013c40"}, "09903c40 02f30100"},
+		{"single byte system common", 0, []string{"f6"}, "05f60000"},
+		{"song position", 0, []string{"f21020"}, "03f21020"},
+		{"stray data bytes", 0, []string{"3c40"}, ""},
+		{"sysex ending with three bytes", 0, []string{"f001020304f7"}, "04f00102 070304f7"},
+		{"sysex ending with two bytes", 0, []string{"f0010203f7"}, "04f00102 0603f700"},
+		{"sysex ending with one byte", 0, []string{"f00102", "f7"}, "04f00102 05f70000"},
+		{"empty sysex", 0, []string{"f0f7"}, "06f0f700"},
+		{"real time inside sysex", 0, []string{"f001f802f7"}, "0ff80000 04f00102 05f70000"},
+		{"status aborts sysex", 0, []string{"f001903c40"}, "09903c40"},
+		{"sysex cancels running status", 0, []string{"903c40f0f73c40"}, "09903c40 06f0f700"},
+		{"end of sysex without start", 0, []string{"f7"}, ""},
+	}
+	for _, tt := range tests {
+		enc := &Encoder{Cable: tt.cable}
+		var events []Event
+		for _, w := range tt.writes {
+			p, _ := hex.DecodeString(w)
+			events = enc.Encode(events, p)
+		}
+		if got := wire(events); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"09903c40 0cc00500", "903c40 c005"},
+		{"00010203 01010203", ""}, // misc and cable events
+		{"04f00102 04030405 0606f700", "f0010203040506f7"},
+		{"04f00102 05f70000", "f00102f7"},
+		{"07f001f7", "f001f7"},
+		{"05f60000", "f6"},
+		{"04f00102 0ff80000 05f70000", "f8 f00102f7"},
+	}
+	for _, tt := range tests {
+		packets, _ := hex.DecodeString(strings.Replace(tt.in, " ", "", -1))
+		dec := new(Decoder)
+		var msgs []string
+		for i := 0; i < len(packets); i += 4 {
+			if msg := dec.Decode(ParseEvent(packets[i:])); msg != nil {
+				msgs = append(msgs, hex.EncodeToString(msg))
+			}
+		}
+		if got := strings.Join(msgs, " "); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	stream := []byte{
+		0x90, 0x3c, 0x40,
+		0xf0, 0x7e, 0x7f, 0x06, 0x01, 0xf7,
+		0xf8,
+		0xb0, 0x07, 0x64,
+		0xf0, 0x43, 0x10, 0x4c, 0x00, 0x00, 0x7e, 0x00, 0xf7,
+		0xf6,
+		0xe0, 0x00, 0x40,
+	}
+	enc := new(Encoder)
+	dec := new(Decoder)
+	var out []byte
+	for _, e := range enc.Encode(nil, stream) {
+		b := e.Marshal()
+		out = append(out, dec.Decode(ParseEvent(b[:]))...)
+	}
+	if !bytes.Equal(out, stream) {
+		t.Errorf("got % x, want % x", out, stream)
+	}
+}
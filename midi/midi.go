@@ -0,0 +1,286 @@
+// Package midi drives USB MIDI 1.0 devices: MIDIStreaming descriptors,
+// USB-MIDI event packets on the bulk endpoints and per-cable message
+// streams.
+package midi
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/op0xA5/gousb"
+)
+
+// SubClassMIDIStreaming is the Audio class MIDIStreaming interface
+// subclass.
+const SubClassMIDIStreaming = uint8(0x03)
+
+// IsMIDIInterface reports whether iface is a MIDIStreaming interface.
+func IsMIDIInterface(iface *gousb.Interface) bool {
+	return iface.InterfaceClass == gousb.ClassAudio &&
+		iface.InterfaceSubClass == SubClassMIDIStreaming
+}
+
+// Device is a claimed MIDIStreaming interface.
+type Device struct {
+	h     *gousb.Handle
+	iface *gousb.Interface
+	ms    *MIDIStreaming
+
+	in, out    *gousb.Endpoint
+	inGeneral  *EndpointGeneral
+	outGeneral *EndpointGeneral
+	bulk       *gousb.BulkTransfer
+	maxPacket  int
+	claimed    bool
+
+	mu     sync.Mutex
+	cables map[uint8]*Cable
+	rmu    sync.Mutex
+	rbuf   []byte
+	wmu    sync.Mutex
+}
+
+// Open finds the first MIDIStreaming interface of the active
+// configuration and claims it.
+func Open(h *gousb.Handle) (*Device, error) {
+	cfg, err := h.GetActiveConfigDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range cfg.Interfaces {
+		if IsMIDIInterface(iface) {
+			return New(h, iface)
+		}
+	}
+	return nil, gousb.ErrNotFound
+}
+
+func New(h *gousb.Handle, iface *gousb.Interface) (*Device, error) {
+	ms, err := ParseMIDIStreaming(iface.Extra)
+	if err != nil {
+		return nil, err
+	}
+	dev := &Device{h: h, iface: iface, ms: ms, cables: make(map[uint8]*Cable)}
+	dev.in = iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk)
+	dev.out = iface.FindEndpoint(gousb.EndpointOut, gousb.TransferTypeBulk)
+	if dev.in == nil && dev.out == nil {
+		return nil, errors.New("midi: bulk endpoints not found")
+	}
+	var epIn, epOut uint8
+	if dev.in != nil {
+		epIn = dev.in.EndpointAddress
+		dev.inGeneral, _ = ParseEndpointGeneral(dev.in.Extra)
+		dev.maxPacket = int(dev.in.MaxPacketSize & 0x7ff)
+	}
+	if dev.out != nil {
+		epOut = dev.out.EndpointAddress
+		dev.outGeneral, _ = ParseEndpointGeneral(dev.out.Extra)
+	}
+	if dev.maxPacket < 4 {
+		dev.maxPacket = 64
+	}
+	if err := h.ClaimInterface(int(iface.InterfaceNumber)); err != nil {
+		return nil, err
+	}
+	dev.bulk = h.GetBulkTransfer(epIn, epOut)
+	dev.claimed = true
+	return dev, nil
+}
+
+func (dev *Device) Close() error {
+	if !dev.claimed {
+		return nil
+	}
+	dev.claimed = false
+	return dev.h.ReleaseInterface(int(dev.iface.InterfaceNumber))
+}
+
+func (dev *Device) SetTimeout(timeout uint) {
+	dev.bulk.SetTimeout(timeout)
+}
+
+func (dev *Device) MIDIStreaming() *MIDIStreaming {
+	return dev.ms
+}
+
+// InCables returns the number of cables on the IN endpoint, OutCables the
+// number on the OUT endpoint.
+func (dev *Device) InCables() int {
+	if dev.inGeneral == nil {
+		return 0
+	}
+	return len(dev.inGeneral.AssocJackIDs)
+}
+func (dev *Device) OutCables() int {
+	if dev.outGeneral == nil {
+		return 0
+	}
+	return len(dev.outGeneral.AssocJackIDs)
+}
+
+// ReadEvents reads one bulk transfer of event packets. Empty padding
+// packets are dropped.
+func (dev *Device) ReadEvents() ([]Event, error) {
+	if dev.in == nil {
+		return nil, errors.New("midi: no IN endpoint")
+	}
+	dev.rmu.Lock()
+	defer dev.rmu.Unlock()
+	if dev.rbuf == nil {
+		dev.rbuf = make([]byte, dev.maxPacket)
+	}
+	n, err := dev.bulk.Read(dev.rbuf)
+	if err != nil {
+		return nil, err
+	}
+	var events []Event
+	for i := 0; i+4 <= n; i += 4 {
+		if dev.rbuf[i] == 0 && dev.rbuf[i+1] == 0 && dev.rbuf[i+2] == 0 && dev.rbuf[i+3] == 0 {
+			continue
+		}
+		events = append(events, ParseEvent(dev.rbuf[i:]))
+	}
+	return events, nil
+}
+
+// WriteEvents sends events, packing as many per transfer as the endpoint
+// allows.
+func (dev *Device) WriteEvents(events []Event) error {
+	if dev.out == nil {
+		return errors.New("midi: no OUT endpoint")
+	}
+	dev.wmu.Lock()
+	defer dev.wmu.Unlock()
+	size := int(dev.out.MaxPacketSize&0x7ff) / 4 * 4
+	if size == 0 {
+		size = 64
+	}
+	buf := make([]byte, 0, size)
+	for len(events) > 0 {
+		buf = buf[:0]
+		for len(events) > 0 && len(buf) < size {
+			b := events[0].Marshal()
+			buf = append(buf, b[:]...)
+			events = events[1:]
+		}
+		if _, err := dev.bulk.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cable returns the message stream of cable number n.
+func (dev *Device) Cable(n uint8) *Cable {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	c, ok := dev.cables[n]
+	if !ok {
+		c = &Cable{dev: dev, number: n, enc: Encoder{Cable: n}}
+		dev.cables[n] = c
+	}
+	return c
+}
+
+// Cable is one virtual MIDI cable of a device. Reading from any cable
+// receives for all of them; messages for other cables obtained through
+// Device.Cable are queued, the rest are dropped.
+type Cable struct {
+	dev    *Device
+	number uint8
+
+	enc      Encoder
+	dec      Decoder
+	messages [][]byte
+	rbuf     []byte
+}
+
+func (c *Cable) Number() uint8 {
+	return c.number
+}
+
+// Jack returns the embedded jack of the cable in the given direction.
+func (c *Cable) Jack(in bool) *Jack {
+	general := c.dev.outGeneral
+	if in {
+		general = c.dev.inGeneral
+	}
+	if general == nil || int(c.number) >= len(general.AssocJackIDs) {
+		return nil
+	}
+	return c.dev.ms.Jack(general.AssocJackIDs[c.number])
+}
+
+// receive reads until the cable has a queued message.
+func (c *Cable) receive() error {
+	dev := c.dev
+	for {
+		dev.mu.Lock()
+		if len(c.messages) > 0 {
+			dev.mu.Unlock()
+			return nil
+		}
+		dev.mu.Unlock()
+		events, err := dev.ReadEvents()
+		if err != nil {
+			return err
+		}
+		dev.mu.Lock()
+		for _, ev := range events {
+			dst, ok := dev.cables[ev.Cable]
+			if !ok {
+				continue
+			}
+			if msg := dst.dec.Decode(ev); msg != nil {
+				dst.messages = append(dst.messages, msg)
+			}
+		}
+		dev.mu.Unlock()
+	}
+}
+
+// ReadMessage returns the next complete MIDI message of the cable,
+// SysEx included. Running status is expanded.
+func (c *Cable) ReadMessage() ([]byte, error) {
+	if err := c.receive(); err != nil {
+		return nil, err
+	}
+	c.dev.mu.Lock()
+	msg := c.messages[0]
+	c.messages = c.messages[1:]
+	c.dev.mu.Unlock()
+	return msg, nil
+}
+
+// WriteMessage sends one or more MIDI messages.
+func (c *Cable) WriteMessage(msg []byte) error {
+	_, err := c.Write(msg)
+	return err
+}
+
+// Read returns the received MIDI byte stream.
+func (c *Cable) Read(p []byte) (int, error) {
+	if len(c.rbuf) == 0 {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.rbuf = msg
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+// Write sends a MIDI byte stream. Incomplete messages are kept until a
+// following Write completes them.
+func (c *Cable) Write(p []byte) (int, error) {
+	events := c.enc.Encode(nil, p)
+	if len(events) == 0 {
+		return len(p), nil
+	}
+	if err := c.dev.WriteEvents(events); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
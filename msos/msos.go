@@ -0,0 +1,263 @@
+// Package msos reads Microsoft OS descriptors: the 1.0 OS string with its
+// extended compat ID and extended properties feature descriptors, and the
+// 2.0 descriptor sets announced in the BOS platform capability.
+package msos
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/op0xA5/gousb"
+)
+
+// OSStringIndex is the string descriptor index of the MS OS 1.0 string.
+const OSStringIndex = uint8(0xEE)
+
+// MS OS 1.0 feature descriptor indexes
+const (
+	IndexGenre              = uint16(0x0001)
+	IndexExtendedCompatID   = uint16(0x0004)
+	IndexExtendedProperties = uint16(0x0005)
+)
+
+// registry property data types
+const (
+	RegSZ                = uint32(1)
+	RegExpandSZ          = uint32(2)
+	RegBinary            = uint32(3)
+	RegDWordLittleEndian = uint32(4)
+	RegDWordBigEndian    = uint32(5)
+	RegLink              = uint32(6)
+	RegMultiSZ           = uint32(7)
+)
+
+const (
+	osStringLength       = 18
+	compatIDHeaderLength = 16
+	compatIDFunctionLen  = 24
+	propertiesHeaderLen  = 10
+	maxFeatureDescriptor = 0x10000 - 1
+)
+
+const requestDeviceIn = gousb.EndpointIn | gousb.RequestTypeVendor | gousb.RecipientDevice
+const requestInterfaceIn = gousb.EndpointIn | gousb.RequestTypeVendor | gousb.RecipientInterface
+
+// decodeUTF16 decodes little endian UTF-16 up to the first NUL.
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// cstring returns the ASCII string in b up to the first NUL.
+func cstring(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// OSString is the MS OS 1.0 string descriptor.
+type OSString struct {
+	Signature  string
+	VendorCode uint8
+	Flags      uint8
+}
+
+// GetOSString reads string descriptor 0xEE. Devices without MS OS 1.0
+// support usually stall, which is returned as gousb.ErrPipe.
+func GetOSString(h *gousb.Handle) (*OSString, error) {
+	buf := make([]byte, osStringLength)
+	b, err := h.GetDescriptorBuffer(gousb.DescriptorTypeString, OSStringIndex, buf)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < osStringLength || b[0] < osStringLength || b[1] != gousb.DescriptorTypeString {
+		return nil, errors.New("msos: bad OS string descriptor")
+	}
+	s := &OSString{
+		Signature:  decodeUTF16(b[2:16]),
+		VendorCode: b[16],
+		Flags:      b[17],
+	}
+	if s.Signature != "MSFT100" {
+		return nil, fmt.Errorf("msos: unknown OS string signature %q", s.Signature)
+	}
+	return s, nil
+}
+
+// getFeature reads a feature descriptor whose total length is the
+// leading dwLength.
+func getFeature(h *gousb.Handle, typ gousb.RequestType, vendorCode uint8, value, index uint16, header int) ([]byte, error) {
+	buf := make([]byte, header)
+	n, err := h.ControlTransfer(typ, vendorCode, value, index, buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < header {
+		return nil, errors.New("msos: short feature descriptor header")
+	}
+	length := binary.LittleEndian.Uint32(buf)
+	if length < uint32(header) || length > maxFeatureDescriptor {
+		return nil, errors.New("msos: bad feature descriptor length")
+	}
+	buf = make([]byte, length)
+	n, err = h.ControlTransfer(typ, vendorCode, value, index, buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < int(length) {
+		return nil, errors.New("msos: short feature descriptor")
+	}
+	return buf, nil
+}
+
+// CompatibleID is the compatible and sub-compatible ID of a function, as
+// in "WINUSB".
+type CompatibleID struct {
+	ID    string
+	SubID string
+}
+
+// CompatIDFunction is one function section of the extended compat ID
+// descriptor.
+type CompatIDFunction struct {
+	FirstInterface uint8
+	CompatibleID
+}
+
+// GetExtendedCompatID reads the extended compat ID descriptor with the
+// vendor code from the OS string.
+func GetExtendedCompatID(h *gousb.Handle, vendorCode uint8) ([]CompatIDFunction, error) {
+	b, err := getFeature(h, requestDeviceIn, vendorCode, 0, IndexExtendedCompatID, compatIDHeaderLength)
+	if err != nil {
+		return nil, err
+	}
+	return ParseExtendedCompatID(b)
+}
+
+func ParseExtendedCompatID(b []byte) ([]CompatIDFunction, error) {
+	if len(b) < compatIDHeaderLength {
+		return nil, errors.New("msos: short compat id descriptor")
+	}
+	if binary.LittleEndian.Uint16(b[6:]) != IndexExtendedCompatID {
+		return nil, errors.New("msos: not a compat id descriptor")
+	}
+	count := int(b[8])
+	if len(b) < compatIDHeaderLength+count*compatIDFunctionLen {
+		return nil, errors.New("msos: short compat id descriptor")
+	}
+	list := make([]CompatIDFunction, count)
+	for i := range list {
+		f := b[compatIDHeaderLength+i*compatIDFunctionLen:]
+		list[i] = CompatIDFunction{
+			FirstInterface: f[0],
+			CompatibleID: CompatibleID{
+				ID:    cstring(f[2:10]),
+				SubID: cstring(f[10:18]),
+			},
+		}
+	}
+	return list, nil
+}
+
+// Property is a registry property from an extended properties descriptor
+// or an MS OS 2.0 registry property feature.
+type Property struct {
+	Type uint32
+	Name string
+	Data []byte
+}
+
+// String returns REG_SZ, REG_EXPAND_SZ and REG_LINK values.
+func (p *Property) String() string {
+	return decodeUTF16(p.Data)
+}
+
+// Strings returns the values of a REG_MULTI_SZ property.
+func (p *Property) Strings() []string {
+	var list []string
+	var u []uint16
+	for i := 0; i+1 < len(p.Data); i += 2 {
+		c := binary.LittleEndian.Uint16(p.Data[i:])
+		if c != 0 {
+			u = append(u, c)
+			continue
+		}
+		if len(u) == 0 {
+			// empty string terminates the list
+			break
+		}
+		list = append(list, string(utf16.Decode(u)))
+		u = u[:0]
+	}
+	return list
+}
+
+// Uint32 returns DWORD values.
+func (p *Property) Uint32() uint32 {
+	if len(p.Data) < 4 {
+		return 0
+	}
+	if p.Type == RegDWordBigEndian {
+		return binary.BigEndian.Uint32(p.Data)
+	}
+	return binary.LittleEndian.Uint32(p.Data)
+}
+
+// GetExtendedProperties reads the extended properties descriptor of
+// interface iface.
+func GetExtendedProperties(h *gousb.Handle, vendorCode uint8, iface uint8) ([]*Property, error) {
+	b, err := getFeature(h, requestInterfaceIn, vendorCode, uint16(iface)<<8, IndexExtendedProperties, propertiesHeaderLen)
+	if err != nil {
+		return nil, err
+	}
+	return ParseExtendedProperties(b)
+}
+
+func ParseExtendedProperties(b []byte) ([]*Property, error) {
+	le := binary.LittleEndian
+	if len(b) < propertiesHeaderLen {
+		return nil, errors.New("msos: short properties descriptor")
+	}
+	if le.Uint16(b[6:]) != IndexExtendedProperties {
+		return nil, errors.New("msos: not a properties descriptor")
+	}
+	count := int(le.Uint16(b[8:]))
+	b = b[propertiesHeaderLen:]
+	list := make([]*Property, 0, count)
+	for i := 0; i < count; i++ {
+		if len(b) < 14 {
+			return nil, errors.New("msos: short property section")
+		}
+		size := int(le.Uint32(b))
+		if size < 14 || size > len(b) {
+			return nil, errors.New("msos: bad property section size")
+		}
+		s := b[:size]
+		nameLen := int(le.Uint16(s[8:]))
+		if 10+nameLen+4 > size {
+			return nil, errors.New("msos: bad property name length")
+		}
+		dataLen := int(le.Uint32(s[10+nameLen:]))
+		if 14+nameLen+dataLen > size {
+			return nil, errors.New("msos: bad property data length")
+		}
+		list = append(list, &Property{
+			Type: le.Uint32(s[4:]),
+			Name: decodeUTF16(s[10 : 10+nameLen]),
+			Data: append([]byte(nil), s[14+nameLen:14+nameLen+dataLen]...),
+		})
+		b = b[size:]
+	}
+	return list, nil
+}
@@ -0,0 +1,253 @@
+package msos
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/op0xA5/gousb"
+)
+
+// IndexDescriptorSet is the wIndex of the MS OS 2.0 descriptor set
+// request; IndexSetAltEnum selects an alternate enumeration.
+const (
+	IndexDescriptorSet = uint16(0x07)
+	IndexSetAltEnum    = uint16(0x08)
+)
+
+// MS OS 2.0 descriptor types
+const (
+	SetHeaderDescriptor       = uint16(0x00)
+	SubsetHeaderConfiguration = uint16(0x01)
+	SubsetHeaderFunction      = uint16(0x02)
+	FeatureCompatibleID       = uint16(0x03)
+	FeatureRegProperty        = uint16(0x04)
+	FeatureMinResumeTime      = uint16(0x05)
+	FeatureModelID            = uint16(0x06)
+	FeatureCCGPDevice         = uint16(0x07)
+	FeatureVendorRevision     = uint16(0x08)
+)
+
+// PlatformUUID is the MS OS 2.0 platform capability UUID.
+var PlatformUUID = gousb.PlatformUUIDMSOS20
+
+// SetInfo is one descriptor set information block of the platform
+// capability.
+type SetInfo struct {
+	WindowsVersion uint32
+	TotalLength    uint16
+	VendorCode     uint8
+	AltEnumCode    uint8
+}
+
+// ParsePlatformCapability parses the capability data that follows the
+// UUID of the MS OS 2.0 platform capability.
+func ParsePlatformCapability(data []byte) ([]SetInfo, error) {
+	if len(data) < 8 || len(data)%8 != 0 {
+		return nil, errors.New("msos: bad platform capability length")
+	}
+	le := binary.LittleEndian
+	list := make([]SetInfo, len(data)/8)
+	for i := range list {
+		b := data[i*8:]
+		list[i] = SetInfo{
+			WindowsVersion: le.Uint32(b),
+			TotalLength:    le.Uint16(b[4:]),
+			VendorCode:     b[6],
+			AltEnumCode:    b[7],
+		}
+	}
+	return list, nil
+}
+
+// Features are the feature descriptors that apply to a device,
+// configuration or function.
+type Features struct {
+	CompatibleID   *CompatibleID
+	Properties     []*Property
+	MinResumeTime  *MinResumeTime
+	ModelID        []byte
+	CCGP           bool
+	VendorRevision uint16
+}
+
+// MinResumeTime is the minimum resume time feature, both in ms.
+type MinResumeTime struct {
+	ResumeRecoveryTime  uint8
+	ResumeSignalingTime uint8
+}
+
+// FunctionSubset applies to the function starting at FirstInterface.
+type FunctionSubset struct {
+	FirstInterface uint8
+	Features
+}
+
+// ConfigurationSubset applies to the configuration with index
+// ConfigurationValue. Despite the name it is the configuration's index,
+// not its bConfigurationValue.
+type ConfigurationSubset struct {
+	ConfigurationValue uint8
+	Features
+	Functions []*FunctionSubset
+}
+
+// DescriptorSet is a decoded MS OS 2.0 descriptor set.
+type DescriptorSet struct {
+	WindowsVersion uint32
+	Features
+	Configurations []*ConfigurationSubset
+}
+
+// PlatformCapability returns the MS OS 2.0 set information from the BOS
+// descriptor.
+func PlatformCapability(h *gousb.Handle) ([]SetInfo, error) {
+	bos, err := h.BOSDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	p := bos.Platform(PlatformUUID)
+	if p == nil {
+		return nil, gousb.ErrNotFound
+	}
+	return ParsePlatformCapability(p.Data)
+}
+
+// GetDescriptorSet reads the descriptor set described by info.
+func GetDescriptorSet(h *gousb.Handle, info SetInfo) (*DescriptorSet, error) {
+	buf := make([]byte, info.TotalLength)
+	n, err := h.ControlTransfer(requestDeviceIn, info.VendorCode, 0, IndexDescriptorSet, buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < int(info.TotalLength) {
+		return nil, errors.New("msos: short descriptor set")
+	}
+	return ParseDescriptorSet(buf)
+}
+
+// ReadDescriptorSet reads the first descriptor set announced in the BOS
+// descriptor.
+func ReadDescriptorSet(h *gousb.Handle) (*DescriptorSet, error) {
+	infos, err := PlatformCapability(h)
+	if err != nil {
+		return nil, err
+	}
+	return GetDescriptorSet(h, infos[0])
+}
+
+func (f *Features) parse(typ uint16, b []byte) error {
+	le := binary.LittleEndian
+	switch typ {
+	case FeatureCompatibleID:
+		if len(b) < 20 {
+			return errors.New("msos: short compatible id feature")
+		}
+		f.CompatibleID = &CompatibleID{ID: cstring(b[4:12]), SubID: cstring(b[12:20])}
+	case FeatureRegProperty:
+		if len(b) < 8 {
+			return errors.New("msos: short registry property feature")
+		}
+		nameLen := int(le.Uint16(b[6:]))
+		if 8+nameLen+2 > len(b) {
+			return errors.New("msos: bad registry property name length")
+		}
+		dataLen := int(le.Uint16(b[8+nameLen:]))
+		if 10+nameLen+dataLen > len(b) {
+			return errors.New("msos: bad registry property data length")
+		}
+		f.Properties = append(f.Properties, &Property{
+			Type: uint32(le.Uint16(b[4:])),
+			Name: decodeUTF16(b[8 : 8+nameLen]),
+			Data: append([]byte(nil), b[10+nameLen:10+nameLen+dataLen]...),
+		})
+	case FeatureMinResumeTime:
+		if len(b) < 6 {
+			return errors.New("msos: short min resume time feature")
+		}
+		f.MinResumeTime = &MinResumeTime{ResumeRecoveryTime: b[4], ResumeSignalingTime: b[5]}
+	case FeatureModelID:
+		if len(b) < 20 {
+			return errors.New("msos: short model id feature")
+		}
+		f.ModelID = append([]byte(nil), b[4:20]...)
+	case FeatureCCGPDevice:
+		f.CCGP = true
+	case FeatureVendorRevision:
+		if len(b) < 6 {
+			return errors.New("msos: short vendor revision feature")
+		}
+		f.VendorRevision = le.Uint16(b[4:])
+	default:
+		return fmt.Errorf("msos: unknown descriptor type %#04x", typ)
+	}
+	return nil
+}
+
+// ParseDescriptorSet decodes an MS OS 2.0 descriptor set.
+func ParseDescriptorSet(b []byte) (*DescriptorSet, error) {
+	le := binary.LittleEndian
+	if len(b) < 10 || le.Uint16(b[2:]) != SetHeaderDescriptor {
+		return nil, errors.New("msos: no descriptor set header")
+	}
+	set := &DescriptorSet{WindowsVersion: le.Uint32(b[4:])}
+	if total := int(le.Uint16(b[8:])); total < len(b) {
+		b = b[:total]
+	}
+	features := &set.Features
+	var cfg *ConfigurationSubset
+	var cfgEnd, fnEnd int
+	off := int(le.Uint16(b))
+	for off < len(b) {
+		if off+4 > len(b) {
+			return nil, errors.New("msos: truncated descriptor set")
+		}
+		length := int(le.Uint16(b[off:]))
+		typ := le.Uint16(b[off+2:])
+		if length < 4 || off+length > len(b) {
+			return nil, errors.New("msos: bad descriptor length")
+		}
+		// leave subsets whose wTotalLength has been consumed
+		if fnEnd != 0 && off >= fnEnd {
+			fnEnd = 0
+			features = &cfg.Features
+		}
+		if cfgEnd != 0 && off >= cfgEnd {
+			cfgEnd, fnEnd = 0, 0
+			cfg = nil
+			features = &set.Features
+		}
+		d := b[off : off+length]
+		switch typ {
+		case SubsetHeaderConfiguration:
+			if length < 8 {
+				return nil, errors.New("msos: short configuration subset header")
+			}
+			cfg = &ConfigurationSubset{ConfigurationValue: d[4]}
+			set.Configurations = append(set.Configurations, cfg)
+			features = &cfg.Features
+			cfgEnd = off + int(le.Uint16(d[6:]))
+			fnEnd = 0
+		case SubsetHeaderFunction:
+			if length < 8 {
+				return nil, errors.New("msos: short function subset header")
+			}
+			if cfg == nil {
+				return nil, errors.New("msos: function subset outside configuration subset")
+			}
+			fnEnd = off + int(le.Uint16(d[6:]))
+			if fnEnd > cfgEnd {
+				return nil, errors.New("msos: function subset exceeds configuration subset")
+			}
+			fn := &FunctionSubset{FirstInterface: d[4]}
+			cfg.Functions = append(cfg.Functions, fn)
+			features = &fn.Features
+		default:
+			if err := features.parse(typ, d); err != nil {
+				return nil, err
+			}
+		}
+		off += length
+	}
+	return set, nil
+}
@@ -0,0 +1,105 @@
+package msos
+
+import (
+	"reflect"
+	"testing"
+)
+
+// compositeSet describes a configuration with a WinUSB function and an
+// RNDIS function, and a second configuration for MTP.
+var compositeSet = []byte{
+	0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x06, 0x94, 0x00, // set header, Windows 8.1
+	0x08, 0x00, 0x01, 0x00, 0x00, 0x00, 0x68, 0x00, // configuration subset 0
+	0x08, 0x00, 0x02, 0x00, 0x00, 0x00, 0x1c, 0x00, // function subset, interface 0
+	0x14, 0x00, 0x03, 0x00, 'W', 'I', 'N', 'U', 'S', 'B', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0x08, 0x00, 0x02, 0x00, 0x02, 0x00, 0x3e, 0x00, // function subset, interface 2
+	0x14, 0x00, 0x03, 0x00, 'R', 'N', 'D', 'I', 'S', 0, 0, 0, '5', '1', '6', '2', '0', '0', '1', 0,
+	0x22, 0x00, 0x04, 0x00, 0x01, 0x00, // REG_SZ property
+	0x0c, 0x00, 'L', 0, 'a', 0, 'b', 0, 'e', 0, 'l', 0, 0, 0,
+	0x0c, 0x00, 'g', 0, 'o', 0, 'u', 0, 's', 0, 'b', 0, 0, 0,
+	0x06, 0x00, 0x05, 0x00, 0x01, 0x02, // minimum resume time of configuration 0
+	0x08, 0x00, 0x01, 0x00, 0x01, 0x00, 0x1c, 0x00, // configuration subset 1
+	0x14, 0x00, 0x03, 0x00, 'M', 'T', 'P', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0x06, 0x00, 0x08, 0x00, 0x01, 0x01, // vendor revision of the set
+}
+
+func TestParseDescriptorSet(t *testing.T) {
+	set, err := ParseDescriptorSet(compositeSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &DescriptorSet{
+		WindowsVersion: 0x06030000,
+		Features:       Features{VendorRevision: 0x0101},
+		Configurations: []*ConfigurationSubset{
+			{
+				Features: Features{MinResumeTime: &MinResumeTime{ResumeRecoveryTime: 1, ResumeSignalingTime: 2}},
+				Functions: []*FunctionSubset{
+					{FirstInterface: 0, Features: Features{CompatibleID: &CompatibleID{ID: "WINUSB"}}},
+					{FirstInterface: 2, Features: Features{
+						CompatibleID: &CompatibleID{ID: "RNDIS", SubID: "5162001"},
+						Properties:   []*Property{{Type: RegSZ, Name: "Label", Data: []byte("g\x00o\x00u\x00s\x00b\x00\x00\x00")}},
+					}},
+				},
+			},
+			{ConfigurationValue: 1, Features: Features{CompatibleID: &CompatibleID{ID: "MTP"}}},
+		},
+	}
+	if !reflect.DeepEqual(set, want) {
+		t.Errorf("got %+v, want %+v", set, want)
+	}
+	if s := set.Configurations[0].Functions[1].Properties[0].String(); s != "gousb" {
+		t.Errorf("property value %q", s)
+	}
+
+	// device wide features, followed by bytes past wTotalLength
+	set, err = ParseDescriptorSet([]byte{
+		0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x06, 0x28, 0x00,
+		0x04, 0x00, 0x07, 0x00, // CCGP device
+		0x06, 0x00, 0x05, 0x00, 0x05, 0x0a, // minimum resume time
+		0x14, 0x00, 0x03, 0x00, 'W', 'I', 'N', 'U', 'S', 'B', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0xff, 0xff, 0xff,
+	})
+	want = &DescriptorSet{
+		WindowsVersion: 0x06030000,
+		Features: Features{
+			CompatibleID:  &CompatibleID{ID: "WINUSB"},
+			MinResumeTime: &MinResumeTime{ResumeRecoveryTime: 5, ResumeSignalingTime: 10},
+			CCGP:          true,
+		},
+	}
+	if err != nil || !reflect.DeepEqual(set, want) {
+		t.Errorf("device features: got %+v, %v", set, err)
+	}
+}
+
+func TestParseDescriptorSetErrors(t *testing.T) {
+	// header of a set with n bytes of descriptors
+	header := func(n int) []byte {
+		return []byte{0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x06, byte(10 + n), 0x00}
+	}
+	for _, tt := range []struct {
+		body []byte
+		err  string
+	}{
+		{[]byte{0x08, 0x00, 0x02, 0x00, 0x00, 0x00, 0x08, 0x00}, "msos: function subset outside configuration subset"},
+		// a function subset whose wTotalLength runs past its configuration
+		{[]byte{
+			0x08, 0x00, 0x01, 0x00, 0x00, 0x00, 0x10, 0x00,
+			0x08, 0x00, 0x02, 0x00, 0x00, 0x00, 0x64, 0x00,
+			0x04, 0x00, 0x07, 0x00,
+		}, "msos: function subset exceeds configuration subset"},
+		{[]byte{0x20, 0x00, 0x07, 0x00}, "msos: bad descriptor length"},
+		{[]byte{0x04, 0x00}, "msos: truncated descriptor set"},
+		{[]byte{0x07, 0x00, 0x03, 0x00, 'W', 'I', 'N'}, "msos: short compatible id feature"},
+		{[]byte{0x04, 0x00, 0x09, 0x00}, "msos: unknown descriptor type 0x0009"},
+	} {
+		b := append(header(len(tt.body)), tt.body...)
+		if _, err := ParseDescriptorSet(b); err == nil || err.Error() != tt.err {
+			t.Errorf("% x: got error %v, want %q", tt.body, err, tt.err)
+		}
+	}
+	if _, err := ParseDescriptorSet([]byte{0x04, 0x00, 0x07, 0x00}); err == nil {
+		t.Errorf("set without header accepted")
+	}
+}
@@ -0,0 +1,235 @@
+// Package printer implements the USB printer class (ClassPrinter).
+package printer
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/op0xA5/gousb"
+)
+
+// class-specific requests
+const (
+	RequestGetDeviceID   = uint8(0x00)
+	RequestGetPortStatus = uint8(0x01)
+	RequestSoftReset     = uint8(0x02)
+)
+
+// interface protocols
+const (
+	ProtocolUnidirectional = uint8(0x01)
+	ProtocolBidirectional  = uint8(0x02)
+	Protocol1284_4         = uint8(0x03)
+)
+
+// PortStatus is the status byte returned by GET_PORT_STATUS.
+type PortStatus uint8
+
+// PortStatus bits
+const (
+	PortStatusNotError   = PortStatus(1 << 3)
+	PortStatusSelect     = PortStatus(1 << 4)
+	PortStatusPaperEmpty = PortStatus(1 << 5)
+)
+
+func (st PortStatus) Error() bool {
+	return st&PortStatusNotError == 0
+}
+func (st PortStatus) Selected() bool {
+	return st&PortStatusSelect != 0
+}
+func (st PortStatus) PaperEmpty() bool {
+	return st&PortStatusPaperEmpty != 0
+}
+
+// DeviceID is a parsed IEEE 1284 device ID string. Keys are stored as
+// they appear in the string, values are trimmed.
+type DeviceID map[string]string
+
+// ParseDeviceID parses a "KEY:value;KEY:value;" IEEE 1284 device ID.
+func ParseDeviceID(s string) DeviceID {
+	id := make(DeviceID)
+	for _, field := range strings.Split(s, ";") {
+		i := strings.IndexByte(field, ':')
+		if i < 0 {
+			continue
+		}
+		key := strings.TrimSpace(field[:i])
+		if key == "" {
+			continue
+		}
+		id[key] = strings.TrimSpace(field[i+1:])
+	}
+	return id
+}
+
+func (id DeviceID) lookup(keys ...string) string {
+	for _, key := range keys {
+		if v, ok := id[key]; ok {
+			return v
+		}
+	}
+	return ""
+}
+func (id DeviceID) Manufacturer() string {
+	return id.lookup("MFG", "MANUFACTURER")
+}
+func (id DeviceID) Model() string {
+	return id.lookup("MDL", "MODEL")
+}
+func (id DeviceID) Description() string {
+	return id.lookup("DES", "DESCRIPTION")
+}
+func (id DeviceID) SerialNumber() string {
+	return id.lookup("SN", "SERN", "SERIALNUMBER")
+}
+func (id DeviceID) CommandSet() []string {
+	v := id.lookup("CMD", "COMMAND SET")
+	if v == "" {
+		return nil
+	}
+	list := strings.Split(v, ",")
+	for i := range list {
+		list[i] = strings.TrimSpace(list[i])
+	}
+	return list
+}
+
+// Printer is a claimed printer class interface. Read and Write go to the
+// bulk IN and OUT endpoints; Read fails on unidirectional printers.
+type Printer struct {
+	h      *gousb.Handle
+	config uint8
+	iface  *gousb.Interface
+
+	r       *gousb.BulkTransfer
+	w       *gousb.BulkTransfer
+	claimed bool
+}
+
+// Open finds the first printer interface of the active configuration,
+// preferring a bidirectional alternate setting, and claims it.
+func Open(h *gousb.Handle) (*Printer, error) {
+	cfg, err := h.GetActiveConfigDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	var found *gousb.Interface
+	for _, iface := range cfg.InterfacesByClass(gousb.ClassPrinter) {
+		if found == nil || iface.InterfaceProtocol == ProtocolBidirectional && found.InterfaceProtocol != ProtocolBidirectional {
+			found = iface
+		}
+	}
+	if found == nil {
+		return nil, gousb.ErrNotFound
+	}
+	return New(h, cfg.Index, found)
+}
+
+// New claims iface, selecting its alternate setting if needed.
+func New(h *gousb.Handle, config uint8, iface *gousb.Interface) (*Printer, error) {
+	out := iface.FindEndpoint(gousb.EndpointOut, gousb.TransferTypeBulk)
+	if out == nil {
+		return nil, errors.New("printer: no bulk out endpoint")
+	}
+	if err := h.ClaimInterface(int(iface.InterfaceNumber)); err != nil {
+		return nil, err
+	}
+	if iface.AlternateSetting != 0 {
+		if err := h.SetInterfaceAltSetting(int(iface.InterfaceNumber), int(iface.AlternateSetting)); err != nil {
+			h.ReleaseInterface(int(iface.InterfaceNumber))
+			return nil, err
+		}
+	}
+	p := &Printer{
+		h:       h,
+		config:  config,
+		iface:   iface,
+		claimed: true,
+	}
+	p.w = h.GetBulkTransfer(0, out.EndpointAddress)
+	if in := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk); in != nil {
+		p.r = h.GetBulkTransfer(in.EndpointAddress, 0)
+	}
+	return p, nil
+}
+
+func (p *Printer) Close() error {
+	if !p.claimed {
+		return nil
+	}
+	p.claimed = false
+	return p.h.ReleaseInterface(int(p.iface.InterfaceNumber))
+}
+
+func (p *Printer) Interface() *gousb.Interface {
+	return p.iface
+}
+
+func (p *Printer) SetTimeout(timeout uint) {
+	p.w.SetTimeout(timeout)
+	if p.r != nil {
+		p.r.SetTimeout(timeout)
+	}
+}
+
+func (p *Printer) Read(b []byte) (n int, err error) {
+	if p.r == nil {
+		return 0, errors.New("printer: unidirectional interface")
+	}
+	return p.r.Read(b)
+}
+func (p *Printer) Write(b []byte) (n int, err error) {
+	return p.w.Write(b)
+}
+
+const requestIn = gousb.EndpointIn | gousb.RequestTypeClass | gousb.RecipientInterface
+const requestOut = gousb.EndpointOut | gousb.RequestTypeClass | gousb.RecipientInterface
+
+// DeviceIDString issues GET_DEVICE_ID and returns the raw IEEE 1284 string.
+func (p *Printer) DeviceIDString() (string, error) {
+	buf := make([]byte, 1024)
+	index := uint16(p.iface.InterfaceNumber)<<8 | uint16(p.iface.AlternateSetting)
+	n, err := p.h.ControlTransfer(requestIn, RequestGetDeviceID, uint16(p.config), index, buf)
+	if err != nil {
+		return "", err
+	}
+	if n < 2 {
+		return "", errors.New("printer: short device id")
+	}
+	// the length is big endian and includes the two length bytes
+	length := int(buf[0])<<8 | int(buf[1])
+	if length > n {
+		length = n
+	}
+	if length < 2 {
+		return "", nil
+	}
+	return string(buf[2:length]), nil
+}
+func (p *Printer) DeviceID() (DeviceID, error) {
+	s, err := p.DeviceIDString()
+	if err != nil {
+		return nil, err
+	}
+	return ParseDeviceID(s), nil
+}
+
+func (p *Printer) PortStatus() (PortStatus, error) {
+	buf := make([]byte, 1)
+	n, err := p.h.ControlTransfer(requestIn, RequestGetPortStatus, 0, uint16(p.iface.InterfaceNumber), buf)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, errors.New("printer: short port status")
+	}
+	return PortStatus(buf[0]), nil
+}
+
+// SoftReset flushes all buffers and resets the bulk pipes to their
+// default state.
+func (p *Printer) SoftReset() error {
+	_, err := p.h.ControlTransfer(requestOut, RequestSoftReset, 0, uint16(p.iface.InterfaceNumber), nil)
+	return err
+}
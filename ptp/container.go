@@ -0,0 +1,203 @@
+package ptp
+
+import (
+	"encoding/binary"
+	"errors"
+	"unicode/utf16"
+)
+
+// container types
+const (
+	ContainerCommand  = uint16(1)
+	ContainerData     = uint16(2)
+	ContainerResponse = uint16(3)
+	ContainerEvent    = uint16(4)
+)
+
+const headerLength = 12
+
+var le = binary.LittleEndian
+
+// Container is a PTP bulk or interrupt container. Params is only used by
+// command, response and event containers.
+type Container struct {
+	Type          uint16
+	Code          uint16
+	TransactionID uint32
+	Params        []uint32
+}
+
+func (c *Container) MarshalBinary() ([]byte, error) {
+	if len(c.Params) > 5 {
+		return nil, errors.New("ptp: too many parameters")
+	}
+	b := make([]byte, headerLength+4*len(c.Params))
+	le.PutUint32(b, uint32(len(b)))
+	le.PutUint16(b[4:], c.Type)
+	le.PutUint16(b[6:], c.Code)
+	le.PutUint32(b[8:], c.TransactionID)
+	for i, p := range c.Params {
+		le.PutUint32(b[headerLength+4*i:], p)
+	}
+	return b, nil
+}
+func (c *Container) UnmarshalBinary(b []byte) error {
+	if len(b) < headerLength {
+		return errors.New("ptp: short container")
+	}
+	length := int(le.Uint32(b))
+	if length < headerLength || length > len(b) {
+		return errors.New("ptp: bad container length")
+	}
+	c.Type = le.Uint16(b[4:])
+	c.Code = le.Uint16(b[6:])
+	c.TransactionID = le.Uint32(b[8:])
+	c.Params = c.Params[:0]
+	for off := headerLength; off+4 <= length; off += 4 {
+		c.Params = append(c.Params, le.Uint32(b[off:]))
+	}
+	return nil
+}
+
+// Decoder reads PTP datasets. The first error sticks and is returned by
+// Err; reads after an error return zero values.
+type Decoder struct {
+	b   []byte
+	err error
+}
+
+func NewDecoder(b []byte) *Decoder {
+	return &Decoder{b: b}
+}
+
+func (d *Decoder) Err() error {
+	return d.err
+}
+func (d *Decoder) Len() int {
+	return len(d.b)
+}
+
+func (d *Decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.b) < n {
+		d.err = errors.New("ptp: dataset too short")
+		return nil
+	}
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p
+}
+
+func (d *Decoder) Uint8() uint8 {
+	if p := d.next(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+func (d *Decoder) Uint16() uint16 {
+	if p := d.next(2); p != nil {
+		return le.Uint16(p)
+	}
+	return 0
+}
+func (d *Decoder) Uint32() uint32 {
+	if p := d.next(4); p != nil {
+		return le.Uint32(p)
+	}
+	return 0
+}
+func (d *Decoder) Uint64() uint64 {
+	if p := d.next(8); p != nil {
+		return le.Uint64(p)
+	}
+	return 0
+}
+func (d *Decoder) Bytes(n int) []byte {
+	return d.next(n)
+}
+
+// String reads a PTP string: a character count including the terminating
+// NUL, followed by UTF-16LE code units.
+func (d *Decoder) String() string {
+	n := int(d.Uint8())
+	p := d.next(2 * n)
+	if n == 0 || p == nil {
+		return ""
+	}
+	u16s := make([]uint16, n)
+	for i := range u16s {
+		u16s[i] = le.Uint16(p[2*i:])
+	}
+	if u16s[n-1] == 0 {
+		u16s = u16s[:n-1]
+	}
+	return string(utf16.Decode(u16s))
+}
+func (d *Decoder) Uint16Array() []uint16 {
+	n := int(d.Uint32())
+	if d.err == nil && n*2 > len(d.b) {
+		d.err = errors.New("ptp: dataset too short")
+	}
+	if d.err != nil {
+		return nil
+	}
+	list := make([]uint16, n)
+	for i := range list {
+		list[i] = d.Uint16()
+	}
+	return list
+}
+func (d *Decoder) Uint32Array() []uint32 {
+	n := int(d.Uint32())
+	if d.err == nil && n*4 > len(d.b) {
+		d.err = errors.New("ptp: dataset too short")
+	}
+	if d.err != nil {
+		return nil
+	}
+	list := make([]uint32, n)
+	for i := range list {
+		list[i] = d.Uint32()
+	}
+	return list
+}
+
+// Encoder builds PTP datasets.
+type Encoder struct {
+	b []byte
+}
+
+func (e *Encoder) Bytes() []byte {
+	return e.b
+}
+
+func (e *Encoder) Uint8(v uint8) {
+	e.b = append(e.b, v)
+}
+func (e *Encoder) Uint16(v uint16) {
+	e.b = append(e.b, byte(v), byte(v>>8))
+}
+func (e *Encoder) Uint32(v uint32) {
+	e.b = append(e.b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+func (e *Encoder) Uint64(v uint64) {
+	e.Uint32(uint32(v))
+	e.Uint32(uint32(v >> 32))
+}
+func (e *Encoder) String(s string) {
+	if s == "" {
+		e.Uint8(0)
+		return
+	}
+	u16s := utf16.Encode([]rune(s))
+	if len(u16s) > 254 {
+		u16s = u16s[:254]
+	}
+	e.Uint8(uint8(len(u16s) + 1))
+	for _, u := range u16s {
+		e.Uint16(u)
+	}
+	e.Uint16(0)
+}
@@ -0,0 +1,197 @@
+package ptp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// MTP operation codes
+const (
+	OpGetObjectPropsSupported = uint16(0x9801)
+	OpGetObjectPropDesc       = uint16(0x9802)
+	OpGetObjectPropValue      = uint16(0x9803)
+	OpSetObjectPropValue      = uint16(0x9804)
+	OpGetObjectPropList       = uint16(0x9805)
+	OpGetObjectReferences     = uint16(0x9810)
+)
+
+// MTP object property codes
+const (
+	PropStorageID        = uint16(0xDC01)
+	PropObjectFormat     = uint16(0xDC02)
+	PropProtectionStatus = uint16(0xDC03)
+	PropObjectSize       = uint16(0xDC04)
+	PropObjectFileName   = uint16(0xDC07)
+	PropDateCreated      = uint16(0xDC08)
+	PropDateModified     = uint16(0xDC09)
+	PropParentObject     = uint16(0xDC0B)
+	PropPersistentUID    = uint16(0xDC41)
+	PropName             = uint16(0xDC44)
+	PropDisplayName      = uint16(0xDCE0)
+)
+
+// datatype codes
+const (
+	TypeUndefined = uint16(0x0000)
+	TypeInt8      = uint16(0x0001)
+	TypeUint8     = uint16(0x0002)
+	TypeInt16     = uint16(0x0003)
+	TypeUint16    = uint16(0x0004)
+	TypeInt32     = uint16(0x0005)
+	TypeUint32    = uint16(0x0006)
+	TypeInt64     = uint16(0x0007)
+	TypeUint64    = uint16(0x0008)
+	TypeInt128    = uint16(0x0009)
+	TypeUint128   = uint16(0x000A)
+	TypeArray     = uint16(0x4000)
+	TypeString    = uint16(0xFFFF)
+)
+
+// typeSize returns the size of a scalar datatype, or 0.
+func typeSize(typ uint16) int {
+	switch typ {
+	case TypeInt8, TypeUint8:
+		return 1
+	case TypeInt16, TypeUint16:
+		return 2
+	case TypeInt32, TypeUint32:
+		return 4
+	case TypeInt64, TypeUint64:
+		return 8
+	case TypeInt128, TypeUint128:
+		return 16
+	}
+	return 0
+}
+
+// DecodeValue reads a value of datatype typ. Integers up to 64 bits are
+// returned as int64 or uint64, 128 bit integers as raw 16 byte slices,
+// strings as string and arrays as []interface{}.
+func DecodeValue(d *Decoder, typ uint16) (interface{}, error) {
+	switch {
+	case typ == TypeString:
+		return d.String(), d.Err()
+	case typ&TypeArray != 0:
+		elem := typ &^ TypeArray
+		if typeSize(elem) == 0 {
+			return nil, fmt.Errorf("ptp: unsupported datatype 0x%04x", typ)
+		}
+		n := int(d.Uint32())
+		if d.Err() == nil && n*typeSize(elem) > d.Len() {
+			return nil, errors.New("ptp: dataset too short")
+		}
+		list := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := DecodeValue(d, elem)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, d.Err()
+	}
+	switch typ {
+	case TypeInt8:
+		return int64(int8(d.Uint8())), d.Err()
+	case TypeUint8:
+		return uint64(d.Uint8()), d.Err()
+	case TypeInt16:
+		return int64(int16(d.Uint16())), d.Err()
+	case TypeUint16:
+		return uint64(d.Uint16()), d.Err()
+	case TypeInt32:
+		return int64(int32(d.Uint32())), d.Err()
+	case TypeUint32:
+		return uint64(d.Uint32()), d.Err()
+	case TypeInt64:
+		return int64(d.Uint64()), d.Err()
+	case TypeUint64:
+		return d.Uint64(), d.Err()
+	case TypeInt128, TypeUint128:
+		return append([]byte(nil), d.Bytes(16)...), d.Err()
+	}
+	return nil, fmt.Errorf("ptp: unsupported datatype 0x%04x", typ)
+}
+
+// ObjectProp is one element of an object property list.
+type ObjectProp struct {
+	Handle   uint32
+	Code     uint16
+	DataType uint16
+	Value    interface{}
+}
+
+func (dev *Device) GetObjectPropsSupported(format uint16) ([]uint16, error) {
+	b, err := dev.query(OpGetObjectPropsSupported, uint32(format))
+	if err != nil {
+		return nil, err
+	}
+	d := NewDecoder(b)
+	props := d.Uint16Array()
+	return props, d.Err()
+}
+
+// GetObjectPropValue returns the raw encoded value of an object property.
+func (dev *Device) GetObjectPropValue(handle uint32, prop uint16) ([]byte, error) {
+	return dev.query(OpGetObjectPropValue, handle, uint32(prop))
+}
+
+// SetObjectPropValue sets an object property to a value already encoded
+// with an Encoder.
+func (dev *Device) SetObjectPropValue(handle uint32, prop uint16, value []byte) error {
+	_, err := dev.Transact(OpSetObjectPropValue, []uint32{handle, uint32(prop)}, bytes.NewReader(value), int64(len(value)), nil)
+	return err
+}
+
+func (dev *Device) GetObjectPropString(handle uint32, prop uint16) (string, error) {
+	b, err := dev.GetObjectPropValue(handle, prop)
+	if err != nil {
+		return "", err
+	}
+	d := NewDecoder(b)
+	s := d.String()
+	return s, d.Err()
+}
+func (dev *Device) SetObjectPropString(handle uint32, prop uint16, s string) error {
+	e := new(Encoder)
+	e.String(s)
+	return dev.SetObjectPropValue(handle, prop, e.Bytes())
+}
+
+// GetObjectPropList returns all properties of handle. Pass 0xFFFFFFFF
+// as handle for every object; some responders refuse that.
+func (dev *Device) GetObjectPropList(handle uint32) ([]ObjectProp, error) {
+	b, err := dev.query(OpGetObjectPropList, handle, 0, 0xFFFFFFFF, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDecoder(b)
+	n := int(d.Uint32())
+	if d.Err() == nil && n*8 > d.Len() {
+		return nil, errors.New("ptp: dataset too short")
+	}
+	list := make([]ObjectProp, 0, n)
+	for i := 0; i < n; i++ {
+		prop := ObjectProp{
+			Handle:   d.Uint32(),
+			Code:     d.Uint16(),
+			DataType: d.Uint16(),
+		}
+		if prop.Value, err = DecodeValue(d, prop.DataType); err != nil {
+			return nil, err
+		}
+		list = append(list, prop)
+	}
+	return list, d.Err()
+}
+
+func (dev *Device) GetObjectReferences(handle uint32) ([]uint32, error) {
+	b, err := dev.query(OpGetObjectReferences, handle)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDecoder(b)
+	refs := d.Uint32Array()
+	return refs, d.Err()
+}
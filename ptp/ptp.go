@@ -0,0 +1,600 @@
+// Package ptp implements the Picture Transfer Protocol and its Media
+// Transfer Protocol extensions over USB still image class interfaces
+// (ClassImage).
+package ptp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/op0xA5/gousb"
+)
+
+// interface subclass and protocol of a PTP still image interface
+const (
+	SubClassStillImage = uint8(0x01)
+	ProtocolPTP        = uint8(0x01)
+)
+
+// operation codes
+const (
+	OpGetDeviceInfo    = uint16(0x1001)
+	OpOpenSession      = uint16(0x1002)
+	OpCloseSession     = uint16(0x1003)
+	OpGetStorageIDs    = uint16(0x1004)
+	OpGetStorageInfo   = uint16(0x1005)
+	OpGetNumObjects    = uint16(0x1006)
+	OpGetObjectHandles = uint16(0x1007)
+	OpGetObjectInfo    = uint16(0x1008)
+	OpGetObject        = uint16(0x1009)
+	OpGetThumb         = uint16(0x100A)
+	OpDeleteObject     = uint16(0x100B)
+	OpSendObjectInfo   = uint16(0x100C)
+	OpSendObject       = uint16(0x100D)
+	OpInitiateCapture  = uint16(0x100E)
+	OpGetDevicePropVal = uint16(0x1015)
+	OpSetDevicePropVal = uint16(0x1016)
+	OpGetPartialObject = uint16(0x101B)
+)
+
+// response codes
+const (
+	RespOK                    = uint16(0x2001)
+	RespGeneralError          = uint16(0x2002)
+	RespSessionNotOpen        = uint16(0x2003)
+	RespInvalidTransactionID  = uint16(0x2004)
+	RespOperationNotSupported = uint16(0x2005)
+	RespParameterNotSupported = uint16(0x2006)
+	RespIncompleteTransfer    = uint16(0x2007)
+	RespInvalidStorageID      = uint16(0x2008)
+	RespInvalidObjectHandle   = uint16(0x2009)
+	RespStoreFull             = uint16(0x200C)
+	RespObjectWriteProtected  = uint16(0x200D)
+	RespStoreReadOnly         = uint16(0x200E)
+	RespAccessDenied          = uint16(0x200F)
+	RespDeviceBusy            = uint16(0x2019)
+	RespInvalidParentObject   = uint16(0x201A)
+	RespInvalidParameter      = uint16(0x201D)
+	RespSessionAlreadyOpen    = uint16(0x201E)
+	RespTransactionCancelled  = uint16(0x201F)
+)
+
+// event codes
+const (
+	EventCancelTransaction = uint16(0x4001)
+	EventObjectAdded       = uint16(0x4002)
+	EventObjectRemoved     = uint16(0x4003)
+	EventStoreAdded        = uint16(0x4004)
+	EventStoreRemoved      = uint16(0x4005)
+	EventDevicePropChanged = uint16(0x4006)
+	EventObjectInfoChanged = uint16(0x4007)
+	EventDeviceInfoChanged = uint16(0x4008)
+	EventStorageInfoChange = uint16(0x400C)
+	EventCaptureComplete   = uint16(0x400D)
+)
+
+// object formats
+const (
+	FormatUndefined   = uint16(0x3000)
+	FormatAssociation = uint16(0x3001)
+	FormatEXIFJPEG    = uint16(0x3801)
+)
+
+// wildcard values for GetObjectHandles and GetNumObjects
+const (
+	StorageAll = uint32(0xFFFFFFFF)
+	FormatAll  = uint16(0x0000)
+	ParentAll  = uint32(0x00000000)
+	ParentRoot = uint32(0xFFFFFFFF)
+)
+
+// size of bulk transfers, a multiple of every max packet size
+const bufferSize = 512 * 128
+
+// ResponseError is returned when the responder answers with a response
+// code other than RespOK.
+type ResponseError struct {
+	Op   uint16
+	Code uint16
+}
+
+func (err *ResponseError) Error() string {
+	return fmt.Sprintf("ptp: operation 0x%04x: response 0x%04x", err.Op, err.Code)
+}
+
+type DeviceInfo struct {
+	StandardVersion           uint16
+	VendorExtensionID         uint32
+	VendorExtensionVersion    uint16
+	VendorExtensionDesc       string
+	FunctionalMode            uint16
+	OperationsSupported       []uint16
+	EventsSupported           []uint16
+	DevicePropertiesSupported []uint16
+	CaptureFormats            []uint16
+	ImageFormats              []uint16
+	Manufacturer              string
+	Model                     string
+	DeviceVersion             string
+	SerialNumber              string
+}
+
+func (info *DeviceInfo) Decode(d *Decoder) error {
+	info.StandardVersion = d.Uint16()
+	info.VendorExtensionID = d.Uint32()
+	info.VendorExtensionVersion = d.Uint16()
+	info.VendorExtensionDesc = d.String()
+	info.FunctionalMode = d.Uint16()
+	info.OperationsSupported = d.Uint16Array()
+	info.EventsSupported = d.Uint16Array()
+	info.DevicePropertiesSupported = d.Uint16Array()
+	info.CaptureFormats = d.Uint16Array()
+	info.ImageFormats = d.Uint16Array()
+	info.Manufacturer = d.String()
+	info.Model = d.String()
+	info.DeviceVersion = d.String()
+	info.SerialNumber = d.String()
+	return d.Err()
+}
+
+func (info *DeviceInfo) SupportsOperation(op uint16) bool {
+	for _, v := range info.OperationsSupported {
+		if v == op {
+			return true
+		}
+	}
+	return false
+}
+
+type StorageInfo struct {
+	StorageType        uint16
+	FilesystemType     uint16
+	AccessCapability   uint16
+	MaxCapacity        uint64
+	FreeSpaceInBytes   uint64
+	FreeSpaceInImages  uint32
+	StorageDescription string
+	VolumeLabel        string
+}
+
+func (info *StorageInfo) Decode(d *Decoder) error {
+	info.StorageType = d.Uint16()
+	info.FilesystemType = d.Uint16()
+	info.AccessCapability = d.Uint16()
+	info.MaxCapacity = d.Uint64()
+	info.FreeSpaceInBytes = d.Uint64()
+	info.FreeSpaceInImages = d.Uint32()
+	info.StorageDescription = d.String()
+	info.VolumeLabel = d.String()
+	return d.Err()
+}
+
+// ObjectInfo is the ObjectInfo dataset. Dates use the PTP
+// "YYYYMMDDThhmmss" string format.
+type ObjectInfo struct {
+	StorageID            uint32
+	ObjectFormat         uint16
+	ProtectionStatus     uint16
+	ObjectCompressedSize uint32
+	ThumbFormat          uint16
+	ThumbCompressedSize  uint32
+	ThumbPixWidth        uint32
+	ThumbPixHeight       uint32
+	ImagePixWidth        uint32
+	ImagePixHeight       uint32
+	ImageBitDepth        uint32
+	ParentObject         uint32
+	AssociationType      uint16
+	AssociationDesc      uint32
+	SequenceNumber       uint32
+	Filename             string
+	CaptureDate          string
+	ModificationDate     string
+	Keywords             string
+}
+
+func (info *ObjectInfo) Decode(d *Decoder) error {
+	info.StorageID = d.Uint32()
+	info.ObjectFormat = d.Uint16()
+	info.ProtectionStatus = d.Uint16()
+	info.ObjectCompressedSize = d.Uint32()
+	info.ThumbFormat = d.Uint16()
+	info.ThumbCompressedSize = d.Uint32()
+	info.ThumbPixWidth = d.Uint32()
+	info.ThumbPixHeight = d.Uint32()
+	info.ImagePixWidth = d.Uint32()
+	info.ImagePixHeight = d.Uint32()
+	info.ImageBitDepth = d.Uint32()
+	info.ParentObject = d.Uint32()
+	info.AssociationType = d.Uint16()
+	info.AssociationDesc = d.Uint32()
+	info.SequenceNumber = d.Uint32()
+	info.Filename = d.String()
+	info.CaptureDate = d.String()
+	info.ModificationDate = d.String()
+	info.Keywords = d.String()
+	return d.Err()
+}
+func (info *ObjectInfo) Encode(e *Encoder) {
+	e.Uint32(info.StorageID)
+	e.Uint16(info.ObjectFormat)
+	e.Uint16(info.ProtectionStatus)
+	e.Uint32(info.ObjectCompressedSize)
+	e.Uint16(info.ThumbFormat)
+	e.Uint32(info.ThumbCompressedSize)
+	e.Uint32(info.ThumbPixWidth)
+	e.Uint32(info.ThumbPixHeight)
+	e.Uint32(info.ImagePixWidth)
+	e.Uint32(info.ImagePixHeight)
+	e.Uint32(info.ImageBitDepth)
+	e.Uint32(info.ParentObject)
+	e.Uint16(info.AssociationType)
+	e.Uint32(info.AssociationDesc)
+	e.Uint32(info.SequenceNumber)
+	e.String(info.Filename)
+	e.String(info.CaptureDate)
+	e.String(info.ModificationDate)
+	e.String(info.Keywords)
+}
+
+// Device is a claimed PTP/MTP interface. Operations are serialized; events
+// may be read concurrently with ReadEvent.
+type Device struct {
+	h     *gousb.Handle
+	iface *gousb.Interface
+
+	bulk      *gousb.BulkTransfer
+	intr      *gousb.InterruptTransfer
+	maxPacket int
+	claimed   bool
+
+	mu        sync.Mutex
+	sessionID uint32
+	tid       uint32
+}
+
+// Open finds the first PTP still image interface of the active
+// configuration and claims it. MTP devices presenting a vendor specific
+// interface have to be opened with New.
+func Open(h *gousb.Handle) (*Device, error) {
+	cfg, err := h.GetActiveConfigDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range cfg.InterfacesByClass(gousb.ClassImage) {
+		if iface.InterfaceSubClass == SubClassStillImage && iface.InterfaceProtocol == ProtocolPTP {
+			return New(h, iface)
+		}
+	}
+	return nil, gousb.ErrNotFound
+}
+
+func New(h *gousb.Handle, iface *gousb.Interface) (*Device, error) {
+	in := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk)
+	out := iface.FindEndpoint(gousb.EndpointOut, gousb.TransferTypeBulk)
+	if in == nil || out == nil {
+		return nil, errors.New("ptp: bulk endpoints not found")
+	}
+	if err := h.ClaimInterface(int(iface.InterfaceNumber)); err != nil {
+		return nil, err
+	}
+	dev := &Device{
+		h:         h,
+		iface:     iface,
+		bulk:      h.GetBulkTransfer(in.EndpointAddress, out.EndpointAddress),
+		maxPacket: int(out.MaxPacketSize & 0x7ff),
+		claimed:   true,
+	}
+	if dev.maxPacket == 0 {
+		dev.maxPacket = 512
+	}
+	if ep := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeInterrupt); ep != nil {
+		dev.intr = h.GetInterruptTransfer(ep.EndpointAddress)
+	}
+	return dev, nil
+}
+
+func (dev *Device) Close() error {
+	if !dev.claimed {
+		return nil
+	}
+	if dev.sessionID != 0 {
+		dev.CloseSession()
+	}
+	dev.claimed = false
+	return dev.h.ReleaseInterface(int(dev.iface.InterfaceNumber))
+}
+
+func (dev *Device) SetTimeout(timeout uint) {
+	dev.bulk.SetTimeout(timeout)
+}
+
+func (dev *Device) writeContainer(c *Container) error {
+	b, err := c.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = dev.bulk.Write(b)
+	return err
+}
+
+func (dev *Device) readContainer(buf []byte) (int, error) {
+	for {
+		n, err := dev.bulk.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		// skip the zero length packet terminating a data phase
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// sendData runs the data-out phase: one data container of size bytes
+// read from r, terminated by a short packet.
+func (dev *Device) sendData(op uint16, tid uint32, r io.Reader, size int64) error {
+	buf := make([]byte, bufferSize)
+	le.PutUint32(buf, uint32(headerLength+size))
+	if headerLength+size > 0xFFFFFFFF {
+		le.PutUint32(buf, 0xFFFFFFFF)
+	}
+	le.PutUint16(buf[4:], ContainerData)
+	le.PutUint16(buf[6:], op)
+	le.PutUint32(buf[8:], tid)
+
+	r = io.LimitReader(r, size)
+	off, total := headerLength, int64(0)
+	for {
+		n, err := io.ReadFull(r, buf[off:])
+		total += int64(n)
+		n += off
+		off = 0
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if total != size {
+				return errors.New("ptp: object size mismatch")
+			}
+			// a full last buffer leaves nothing for this write
+			if n > 0 {
+				if _, err := dev.bulk.Write(buf[:n]); err != nil {
+					return err
+				}
+			}
+			// the container ends with a short packet, a ZLP if its
+			// length is a multiple of the packet size
+			if (headerLength+size)%int64(dev.maxPacket) == 0 {
+				_, err := dev.bulk.Write(nil)
+				return err
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := dev.bulk.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+// Transact runs one PTP transaction. When out is non-nil a data-out phase
+// of outSize bytes follows the command; when in is non-nil the data-in
+// phase is copied to it.
+func (dev *Device) Transact(op uint16, params []uint32, out io.Reader, outSize int64, in io.Writer) (*Container, error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+
+	tid := dev.tid
+	if op != OpOpenSession {
+		dev.tid++
+		tid = dev.tid
+	}
+	cmd := &Container{Type: ContainerCommand, Code: op, TransactionID: tid, Params: params}
+	if err := dev.writeContainer(cmd); err != nil {
+		return nil, err
+	}
+	if out != nil {
+		if err := dev.sendData(op, tid, out, outSize); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := make([]byte, bufferSize)
+	n, err := dev.readContainer(buf)
+	if err != nil {
+		return nil, err
+	}
+	resp := new(Container)
+	if n >= headerLength && le.Uint16(buf[4:]) == ContainerData {
+		// objects over 4GiB report a length of 0xFFFFFFFF, for them only
+		// the short packet ends the data phase
+		length := le.Uint32(buf)
+		remain := int64(length) - int64(n)
+		if in == nil {
+			in = io.Discard
+		}
+		if _, err := in.Write(buf[headerLength:n]); err != nil {
+			return nil, err
+		}
+		for n == len(buf) && (remain > 0 || length == 0xFFFFFFFF) {
+			if n, err = dev.bulk.Read(buf); err != nil {
+				return nil, err
+			}
+			if _, err := in.Write(buf[:n]); err != nil {
+				return nil, err
+			}
+			remain -= int64(n)
+		}
+		if n, err = dev.readContainer(buf); err != nil {
+			return nil, err
+		}
+	}
+	if err := resp.UnmarshalBinary(buf[:n]); err != nil {
+		return nil, err
+	}
+	if resp.Type != ContainerResponse || resp.TransactionID != tid {
+		return nil, errors.New("ptp: unexpected container")
+	}
+	if resp.Code != RespOK {
+		return resp, &ResponseError{Op: op, Code: resp.Code}
+	}
+	return resp, nil
+}
+
+// query runs a transaction with a data-in phase and returns the data.
+func (dev *Device) query(op uint16, params ...uint32) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := dev.Transact(op, params, nil, 0, &buf)
+	return buf.Bytes(), err
+}
+
+func (dev *Device) GetDeviceInfo() (*DeviceInfo, error) {
+	b, err := dev.query(OpGetDeviceInfo)
+	if err != nil {
+		return nil, err
+	}
+	info := new(DeviceInfo)
+	return info, info.Decode(NewDecoder(b))
+}
+
+// OpenSession opens session 1. An already open session is reused.
+func (dev *Device) OpenSession() error {
+	dev.mu.Lock()
+	dev.tid = 0
+	dev.mu.Unlock()
+	_, err := dev.Transact(OpOpenSession, []uint32{1}, nil, 0, nil)
+	if rerr, ok := err.(*ResponseError); ok && rerr.Code == RespSessionAlreadyOpen {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	dev.sessionID = 1
+	return nil
+}
+func (dev *Device) CloseSession() error {
+	_, err := dev.Transact(OpCloseSession, nil, nil, 0, nil)
+	dev.sessionID = 0
+	return err
+}
+
+func (dev *Device) GetStorageIDs() ([]uint32, error) {
+	b, err := dev.query(OpGetStorageIDs)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDecoder(b)
+	ids := d.Uint32Array()
+	return ids, d.Err()
+}
+func (dev *Device) GetStorageInfo(storage uint32) (*StorageInfo, error) {
+	b, err := dev.query(OpGetStorageInfo, storage)
+	if err != nil {
+		return nil, err
+	}
+	info := new(StorageInfo)
+	return info, info.Decode(NewDecoder(b))
+}
+func (dev *Device) GetNumObjects(storage uint32, format uint16, parent uint32) (int, error) {
+	resp, err := dev.Transact(OpGetNumObjects, []uint32{storage, uint32(format), parent}, nil, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Params) < 1 {
+		return 0, errors.New("ptp: missing response parameter")
+	}
+	return int(resp.Params[0]), nil
+}
+
+// GetObjectHandles enumerates objects. Use StorageAll, FormatAll and
+// ParentAll or ParentRoot as wildcards.
+func (dev *Device) GetObjectHandles(storage uint32, format uint16, parent uint32) ([]uint32, error) {
+	b, err := dev.query(OpGetObjectHandles, storage, uint32(format), parent)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDecoder(b)
+	handles := d.Uint32Array()
+	return handles, d.Err()
+}
+func (dev *Device) GetObjectInfo(handle uint32) (*ObjectInfo, error) {
+	b, err := dev.query(OpGetObjectInfo, handle)
+	if err != nil {
+		return nil, err
+	}
+	info := new(ObjectInfo)
+	return info, info.Decode(NewDecoder(b))
+}
+
+// GetObject streams the object data to w.
+func (dev *Device) GetObject(handle uint32, w io.Writer) error {
+	_, err := dev.Transact(OpGetObject, []uint32{handle}, nil, 0, w)
+	return err
+}
+
+// GetPartialObject streams at most length bytes starting at offset to w
+// and returns the number of bytes sent by the device.
+func (dev *Device) GetPartialObject(handle uint32, offset, length uint32, w io.Writer) (int, error) {
+	resp, err := dev.Transact(OpGetPartialObject, []uint32{handle, offset, length}, nil, 0, w)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Params) < 1 {
+		return 0, errors.New("ptp: missing response parameter")
+	}
+	return int(resp.Params[0]), nil
+}
+func (dev *Device) GetThumb(handle uint32, w io.Writer) error {
+	_, err := dev.Transact(OpGetThumb, []uint32{handle}, nil, 0, w)
+	return err
+}
+func (dev *Device) DeleteObject(handle uint32) error {
+	_, err := dev.Transact(OpDeleteObject, []uint32{handle, 0}, nil, 0, nil)
+	return err
+}
+
+// SendObjectInfo announces a new object. It returns the storage, parent
+// and handle chosen by the responder; SendObject must follow.
+func (dev *Device) SendObjectInfo(storage, parent uint32, info *ObjectInfo) (uint32, uint32, uint32, error) {
+	e := new(Encoder)
+	info.Encode(e)
+	b := e.Bytes()
+	resp, err := dev.Transact(OpSendObjectInfo, []uint32{storage, parent}, bytes.NewReader(b), int64(len(b)), nil)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if len(resp.Params) < 3 {
+		return 0, 0, 0, errors.New("ptp: missing response parameter")
+	}
+	return resp.Params[0], resp.Params[1], resp.Params[2], nil
+}
+
+// SendObject streams size bytes from r as the object announced by the
+// previous SendObjectInfo.
+func (dev *Device) SendObject(r io.Reader, size int64) error {
+	_, err := dev.Transact(OpSendObject, nil, r, size, nil)
+	return err
+}
+
+// ReadEvent waits for the next event on the interrupt endpoint.
+func (dev *Device) ReadEvent() (*Container, error) {
+	if dev.intr == nil {
+		return nil, errors.New("ptp: no interrupt endpoint")
+	}
+	buf := make([]byte, 64)
+	n, err := dev.intr.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	ev := new(Container)
+	if err := ev.UnmarshalBinary(buf[:n]); err != nil {
+		return nil, err
+	}
+	if ev.Type != ContainerEvent {
+		return nil, errors.New("ptp: unexpected container")
+	}
+	return ev, nil
+}
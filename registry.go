@@ -0,0 +1,235 @@
+package gousb
+
+import (
+	"errors"
+	"sync"
+)
+
+// RawDescriptor is a descriptor no decoder is known for; Data holds the
+// bytes following bDescriptorType.
+type RawDescriptor struct {
+	Length         uint8
+	DescriptorType uint8
+	Data           []byte
+}
+
+func (desc *RawDescriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *RawDescriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+func (desc *RawDescriptor) Put(b []byte) {
+	desc.Length = b[0]
+	desc.DescriptorType = b[1]
+	desc.Data = append([]byte(nil), b[2:b[0]]...)
+}
+
+// NewRawDescriptor returns b as a RawDescriptor, for decoders that only
+// know some subtypes of a descriptor type.
+func NewRawDescriptor(b []byte) *RawDescriptor {
+	desc := new(RawDescriptor)
+	desc.Put(b)
+	return desc
+}
+
+// DescriptorDecoder decodes one class-specific descriptor. b holds
+// exactly bLength bytes; iface is the interface the descriptor follows,
+// nil outside of interfaces. A decoder returns a nil Descriptor and error
+// to leave the descriptor to the next decoder.
+type DescriptorDecoder func(iface *InterfaceDescriptor, b []byte) (Descriptor, error)
+
+var (
+	classDecodersMu sync.RWMutex
+	classDecoders   = make(map[uint16][]DescriptorDecoder)
+)
+
+// RegisterDescriptor registers dec for descriptors of type typ that appear
+// in interfaces of class. Class drivers call it from init. Several
+// decoders may share a class and type, such as audio control and MIDI
+// streaming under the audio class; they are tried in registration order.
+func RegisterDescriptor(class, typ uint8, dec DescriptorDecoder) {
+	classDecodersMu.Lock()
+	defer classDecodersMu.Unlock()
+	key := uint16(class)<<8 | uint16(typ)
+	classDecoders[key] = append(classDecoders[key], dec)
+}
+
+func classDecoder(class, typ uint8) []DescriptorDecoder {
+	classDecodersMu.RLock()
+	defer classDecodersMu.RUnlock()
+	return classDecoders[uint16(class)<<8|uint16(typ)]
+}
+
+// ParseClassDescriptor parses a descriptor following the interface
+// descriptor iface or one of its endpoints, preferring the decoders
+// registered for the interface class over the standard descriptors.
+// iface may be nil for descriptors outside of interfaces. Types nobody
+// knows are returned as *RawDescriptor.
+func ParseClassDescriptor(iface *InterfaceDescriptor, b []byte) (Descriptor, error) {
+	if len(b) < 2 {
+		return nil, errors.New("too less bytes")
+	}
+	if int(b[0]) < 2 || len(b) < int(b[0]) {
+		return nil, errors.New("no enough data")
+	}
+	b = b[:b[0]]
+	if iface != nil {
+		for _, dec := range classDecoder(iface.InterfaceClass, b[1]) {
+			desc, err := dec(iface, b)
+			if desc != nil || err != nil {
+				return desc, err
+			}
+		}
+	}
+	return ParseDescriptor(b)
+}
+
+// ParseDescriptors splits and parses a run of descriptors, such as the
+// Extra of an interface or its endpoints, with ParseClassDescriptor.
+func ParseDescriptors(iface *InterfaceDescriptor, extra []byte) ([]Descriptor, error) {
+	var list []Descriptor
+	for len(extra) > 0 {
+		if len(extra) < 2 || extra[0] < 2 || int(extra[0]) > len(extra) {
+			return nil, errors.New("malformed descriptor")
+		}
+		desc, err := ParseClassDescriptor(iface, extra[:extra[0]])
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, desc)
+		extra = extra[extra[0]:]
+	}
+	return list, nil
+}
+
+// Descriptors parses the class-specific descriptors following the
+// interface descriptor.
+func (iface *Interface) Descriptors() ([]Descriptor, error) {
+	return ParseDescriptors(&iface.InterfaceDescriptor, iface.Extra)
+}
+
+// EndpointDescriptors parses the class-specific descriptors following
+// endpoint ep of the interface.
+func (iface *Interface) EndpointDescriptors(ep *Endpoint) ([]Descriptor, error) {
+	return ParseDescriptors(&iface.InterfaceDescriptor, ep.Extra)
+}
+
+// HIDDescriptor is the HID class descriptor; Descriptors lists the type
+// and length of the report and physical descriptors.
+type HIDDescriptor struct {
+	Length         uint8
+	DescriptorType uint8
+	BcdHID         uint16
+	CountryCode    uint8
+	Descriptors    []HIDClassDescriptor
+}
+
+type HIDClassDescriptor struct {
+	DescriptorType uint8
+	Length         uint16
+}
+
+func (desc *HIDDescriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *HIDDescriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+const hidDescriptorLength = 9
+
+func (desc *HIDDescriptor) Put(b []byte) {
+	desc.Length = b[0]
+	desc.DescriptorType = b[1]
+	desc.BcdHID = usbEncoding.Uint16(b[2:])
+	desc.CountryCode = b[4]
+	desc.Descriptors = make([]HIDClassDescriptor, b[5])
+	for i := range desc.Descriptors {
+		desc.Descriptors[i].DescriptorType = b[6+i*3]
+		desc.Descriptors[i].Length = usbEncoding.Uint16(b[7+i*3:])
+	}
+}
+
+// ReportLength returns the length of the report descriptor.
+func (desc *HIDDescriptor) ReportLength() int {
+	for _, d := range desc.Descriptors {
+		if d.DescriptorType == DescriptorTypeReport {
+			return int(d.Length)
+		}
+	}
+	return 0
+}
+
+func parseHIDDescriptor(iface *InterfaceDescriptor, b []byte) (Descriptor, error) {
+	if len(b) < hidDescriptorLength || len(b) < 6+int(b[5])*3 {
+		return nil, errors.New("descriptor length mismatch")
+	}
+	desc := new(HIDDescriptor)
+	desc.Put(b)
+	return desc, nil
+}
+
+// DFU functional descriptor bmAttributes bits
+const (
+	DFUCanDownload           = uint8(0x01)
+	DFUCanUpload             = uint8(0x02)
+	DFUManifestationTolerant = uint8(0x04)
+	DFUWillDetach            = uint8(0x08)
+)
+
+// SubClassDFU is the application specific interface subclass of DFU.
+const SubClassDFU = uint8(0x01)
+
+// DFUFunctionalDescriptor is the DFU functional descriptor. BcdDFUVersion
+// is 0 for DFU 1.0 devices, whose descriptor ends before it.
+type DFUFunctionalDescriptor struct {
+	Length         uint8
+	DescriptorType uint8
+	Attributes     uint8
+	DetachTimeOut  uint16
+	TransferSize   uint16
+	BcdDFUVersion  uint16
+}
+
+func (desc *DFUFunctionalDescriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *DFUFunctionalDescriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+const (
+	dfuFunctionalDescriptorLength   = 9
+	dfu10FunctionalDescriptorLength = 7
+)
+
+func (desc *DFUFunctionalDescriptor) Put(b []byte) {
+	desc.Length = b[0]
+	desc.DescriptorType = b[1]
+	desc.Attributes = b[2]
+	desc.DetachTimeOut = usbEncoding.Uint16(b[3:])
+	desc.TransferSize = usbEncoding.Uint16(b[5:])
+	if len(b) >= dfuFunctionalDescriptorLength {
+		desc.BcdDFUVersion = usbEncoding.Uint16(b[7:])
+	}
+}
+
+func parseDFUFunctionalDescriptor(iface *InterfaceDescriptor, b []byte) (Descriptor, error) {
+	// other application specific subclasses reuse type 0x21
+	if iface.InterfaceSubClass != SubClassDFU {
+		return nil, nil
+	}
+	if len(b) < dfu10FunctionalDescriptorLength {
+		return nil, errors.New("descriptor length mismatch")
+	}
+	desc := new(DFUFunctionalDescriptor)
+	desc.Put(b)
+	return desc, nil
+}
+
+func init() {
+	RegisterDescriptor(ClassHID, DescriptorTypeHid, parseHIDDescriptor)
+	RegisterDescriptor(ClassApplicationSpecific, DescriptorTypeDFUFunctional, parseDFUFunctionalDescriptor)
+}
@@ -0,0 +1,542 @@
+// Package rndis is an RNDIS host driver: device initialization, OID query
+// and set through encapsulated commands, and Ethernet frame I/O over the
+// bulk data pipes.
+package rndis
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/op0xA5/gousb"
+)
+
+// interface codes RNDIS functions are found with
+const (
+	SubClassACM           = uint8(0x02)
+	ProtocolVendor        = uint8(0xFF)
+	SubClassRF            = uint8(0x01)
+	ProtocolWirelessRNDIS = uint8(0x03)
+	SubClassMiscRNDIS     = uint8(0x04)
+	ProtocolMiscRNDIS     = uint8(0x01)
+)
+
+// CDC encapsulated command requests
+const (
+	RequestSendEncapsulatedCommand = uint8(0x00)
+	RequestGetEncapsulatedResponse = uint8(0x01)
+)
+
+// message types
+const (
+	MsgPacket         = uint32(0x00000001)
+	MsgInitialize     = uint32(0x00000002)
+	MsgHalt           = uint32(0x00000003)
+	MsgQuery          = uint32(0x00000004)
+	MsgSet            = uint32(0x00000005)
+	MsgReset          = uint32(0x00000006)
+	MsgIndicateStatus = uint32(0x00000007)
+	MsgKeepalive      = uint32(0x00000008)
+	MsgCompletion     = uint32(0x80000000)
+)
+
+// status codes
+const (
+	StatusSuccess         = uint32(0x00000000)
+	StatusFailure         = uint32(0xC0000001)
+	StatusInvalidData     = uint32(0xC0010015)
+	StatusNotSupported    = uint32(0xC00000BB)
+	StatusMediaConnect    = uint32(0x4001000B)
+	StatusMediaDisconnect = uint32(0x4001000C)
+)
+
+// object identifiers
+const (
+	OIDGenSupportedList       = uint32(0x00010101)
+	OIDGenMaximumFrameSize    = uint32(0x00010106)
+	OIDGenLinkSpeed           = uint32(0x00010107)
+	OIDGenCurrentPacketFilter = uint32(0x0001010E)
+	OIDGenMaximumTotalSize    = uint32(0x00010111)
+	OIDGenMediaConnectStatus  = uint32(0x00010114)
+	OIDGenPhysicalMedium      = uint32(0x00010202)
+	OID8023PermanentAddress   = uint32(0x01010101)
+	OID8023CurrentAddress     = uint32(0x01010102)
+	OID8023MulticastList      = uint32(0x01010103)
+	OID8023MaximumListSize    = uint32(0x01010104)
+)
+
+// packet filter bits
+const (
+	FilterDirected     = uint32(0x00000001)
+	FilterMulticast    = uint32(0x00000002)
+	FilterAllMulticast = uint32(0x00000004)
+	FilterBroadcast    = uint32(0x00000008)
+	FilterPromiscuous  = uint32(0x00000020)
+)
+
+const (
+	initializeLength    = 24
+	initCompleteLength  = 52
+	queryLength         = 28
+	packetHeaderLength  = 44
+	notificationLength  = 8
+	responseBufferSize  = 1025
+	defaultMaxTransfer  = 0x4000
+	responseRetries     = 10
+	notificationTimeout = 500
+)
+
+const requestIn = gousb.EndpointIn | gousb.RequestTypeClass | gousb.RecipientInterface
+const requestOut = gousb.EndpointOut | gousb.RequestTypeClass | gousb.RecipientInterface
+
+// StatusError is a non-success status of a completion message.
+type StatusError uint32
+
+func (err StatusError) Error() string {
+	switch uint32(err) {
+	case StatusFailure:
+		return "rndis: failure"
+	case StatusInvalidData:
+		return "rndis: invalid data"
+	case StatusNotSupported:
+		return "rndis: not supported"
+	}
+	return fmt.Sprintf("rndis: status %#08x", uint32(err))
+}
+
+// IsRNDISInterface reports whether iface is an RNDIS control interface.
+func IsRNDISInterface(iface *gousb.Interface) bool {
+	switch iface.InterfaceClass {
+	case gousb.ClassCDCControl:
+		return iface.InterfaceSubClass == SubClassACM && iface.InterfaceProtocol == ProtocolVendor
+	case gousb.ClassWirelessController:
+		return iface.InterfaceSubClass == SubClassRF && iface.InterfaceProtocol == ProtocolWirelessRNDIS
+	case gousb.ClassMiscellaneous:
+		return iface.InterfaceSubClass == SubClassMiscRNDIS && iface.InterfaceProtocol == ProtocolMiscRNDIS
+	}
+	return false
+}
+
+// Device is an initialized RNDIS function.
+type Device struct {
+	h    *gousb.Handle
+	ctrl *gousb.Interface
+	data *gousb.Interface
+
+	r       *gousb.BulkTransfer
+	w       *gousb.BulkTransfer
+	intr    *gousb.InterruptTransfer
+	maxOut  int
+	claimed bool
+
+	// from INITIALIZE_CMPLT
+	maxPackets  uint32
+	maxTransfer uint32
+	alignment   uint32
+
+	cmu       sync.Mutex
+	requestID uint32
+
+	// read side
+	rbuf    []byte
+	pending [][]byte
+
+	wmu sync.Mutex
+
+	mu        sync.Mutex
+	connected bool
+}
+
+// Open finds the first RNDIS function of the active configuration,
+// claims it and initializes the device.
+func Open(h *gousb.Handle) (*Device, error) {
+	cfg, err := h.GetActiveConfigDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	for _, ctrl := range cfg.Interfaces {
+		if !IsRNDISInterface(ctrl) {
+			continue
+		}
+		// the data interface follows the control interface
+		for _, data := range cfg.Interfaces {
+			if data.InterfaceNumber == ctrl.InterfaceNumber+1 && data.InterfaceClass == gousb.ClassCDCData &&
+				data.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk) != nil {
+				return New(h, ctrl, data)
+			}
+		}
+	}
+	return nil, gousb.ErrNotFound
+}
+
+// New claims the control interface ctrl and the data interface data and
+// sends REMOTE_NDIS_INITIALIZE_MSG.
+func New(h *gousb.Handle, ctrl, data *gousb.Interface) (*Device, error) {
+	in := data.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk)
+	out := data.FindEndpoint(gousb.EndpointOut, gousb.TransferTypeBulk)
+	if in == nil || out == nil {
+		return nil, errors.New("rndis: bulk endpoints not found")
+	}
+	if err := h.ClaimInterface(int(ctrl.InterfaceNumber)); err != nil {
+		return nil, err
+	}
+	if err := h.ClaimInterface(int(data.InterfaceNumber)); err != nil {
+		h.ReleaseInterface(int(ctrl.InterfaceNumber))
+		return nil, err
+	}
+	d := &Device{
+		h:       h,
+		ctrl:    ctrl,
+		data:    data,
+		r:       h.GetBulkTransfer(in.EndpointAddress, 0),
+		w:       h.GetBulkTransfer(0, out.EndpointAddress),
+		maxOut:  int(out.MaxPacketSize & 0x7ff),
+		claimed: true,
+		rbuf:    make([]byte, defaultMaxTransfer),
+	}
+	if d.maxOut == 0 {
+		d.maxOut = 64
+	}
+	if ep := ctrl.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeInterrupt); ep != nil {
+		d.intr = h.GetInterruptTransfer(ep.EndpointAddress)
+		d.intr.SetTimeout(notificationTimeout)
+	}
+	if err := d.initialize(); err != nil {
+		d.release()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Device) initialize() error {
+	msg := make([]byte, initializeLength)
+	le := binary.LittleEndian
+	le.PutUint32(msg[0:], MsgInitialize)
+	le.PutUint32(msg[4:], initializeLength)
+	le.PutUint32(msg[12:], 1) // major version
+	le.PutUint32(msg[16:], 0) // minor version
+	le.PutUint32(msg[20:], defaultMaxTransfer)
+	resp, err := d.command(msg)
+	if err != nil {
+		return err
+	}
+	if len(resp) < initCompleteLength {
+		return errors.New("rndis: short initialize completion")
+	}
+	if medium := le.Uint32(resp[28:]); medium != 0 {
+		return fmt.Errorf("rndis: unsupported medium %d", medium)
+	}
+	d.maxPackets = le.Uint32(resp[32:])
+	d.maxTransfer = le.Uint32(resp[36:])
+	d.alignment = le.Uint32(resp[40:])
+	return d.SetPacketFilter(FilterDirected | FilterBroadcast | FilterAllMulticast)
+}
+
+func (d *Device) release() {
+	d.claimed = false
+	d.h.ReleaseInterface(int(d.data.InterfaceNumber))
+	d.h.ReleaseInterface(int(d.ctrl.InterfaceNumber))
+}
+
+// Close sends REMOTE_NDIS_HALT_MSG and releases the interfaces.
+func (d *Device) Close() error {
+	if !d.claimed {
+		return nil
+	}
+	msg := make([]byte, 12)
+	binary.LittleEndian.PutUint32(msg[0:], MsgHalt)
+	binary.LittleEndian.PutUint32(msg[4:], 12)
+	d.cmu.Lock()
+	d.requestID++
+	binary.LittleEndian.PutUint32(msg[8:], d.requestID)
+	_, err := d.h.ControlTransfer(requestOut, RequestSendEncapsulatedCommand, 0, uint16(d.ctrl.InterfaceNumber), msg)
+	d.cmu.Unlock()
+	d.release()
+	return err
+}
+
+// SetTimeout sets the timeout of the data pipes; commands use the
+// handle's timeout.
+func (d *Device) SetTimeout(timeout uint) {
+	d.r.SetTimeout(timeout)
+	d.w.SetTimeout(timeout)
+}
+
+// command sends an encapsulated command and returns its completion,
+// handling status indications and keepalives that arrive in between.
+func (d *Device) command(msg []byte) ([]byte, error) {
+	d.cmu.Lock()
+	defer d.cmu.Unlock()
+
+	le := binary.LittleEndian
+	d.requestID++
+	id := d.requestID
+	le.PutUint32(msg[8:], id)
+	typ := le.Uint32(msg) | MsgCompletion
+	if _, err := d.h.ControlTransfer(requestOut, RequestSendEncapsulatedCommand, 0, uint16(d.ctrl.InterfaceNumber), msg); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, responseBufferSize)
+	for i := 0; i < responseRetries; i++ {
+		if d.intr != nil {
+			// RESPONSE_AVAILABLE; some devices never send it
+			notify := make([]byte, notificationLength)
+			if _, err := d.intr.Read(notify); err != nil && err != gousb.ErrTimeout {
+				return nil, err
+			}
+		}
+		n, err := d.h.ControlTransfer(requestIn, RequestGetEncapsulatedResponse, 0, uint16(d.ctrl.InterfaceNumber), buf)
+		if err != nil {
+			return nil, err
+		}
+		if n < 12 {
+			continue
+		}
+		resp := buf[:n]
+		switch le.Uint32(resp) {
+		case typ:
+			if le.Uint32(resp[8:]) != id {
+				continue
+			}
+			if n >= 16 {
+				if status := le.Uint32(resp[12:]); status != StatusSuccess {
+					return nil, StatusError(status)
+				}
+			}
+			return append([]byte(nil), resp...), nil
+		case MsgIndicateStatus:
+			d.indicate(le.Uint32(resp[8:]))
+		case MsgKeepalive:
+			reply := make([]byte, 16)
+			le.PutUint32(reply[0:], MsgKeepalive|MsgCompletion)
+			le.PutUint32(reply[4:], 16)
+			copy(reply[8:12], resp[8:12])
+			le.PutUint32(reply[12:], StatusSuccess)
+			if _, err := d.h.ControlTransfer(requestOut, RequestSendEncapsulatedCommand, 0, uint16(d.ctrl.InterfaceNumber), reply); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, gousb.ErrTimeout
+}
+
+func (d *Device) indicate(status uint32) {
+	d.mu.Lock()
+	switch status {
+	case StatusMediaConnect:
+		d.connected = true
+	case StatusMediaDisconnect:
+		d.connected = false
+	}
+	d.mu.Unlock()
+}
+
+// Query returns the value of oid. in is passed as the input buffer, most
+// OIDs take none.
+func (d *Device) Query(oid uint32, in []byte) ([]byte, error) {
+	msg := make([]byte, queryLength+len(in))
+	le := binary.LittleEndian
+	le.PutUint32(msg[0:], MsgQuery)
+	le.PutUint32(msg[4:], uint32(len(msg)))
+	le.PutUint32(msg[12:], oid)
+	le.PutUint32(msg[16:], uint32(len(in)))
+	le.PutUint32(msg[20:], queryLength-8)
+	copy(msg[queryLength:], in)
+	resp, err := d.command(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 24 {
+		return nil, errors.New("rndis: short query completion")
+	}
+	length := int(le.Uint32(resp[16:]))
+	offset := 8 + int(le.Uint32(resp[20:]))
+	if length == 0 {
+		return nil, nil
+	}
+	if offset < 24 || offset+length > len(resp) {
+		return nil, errors.New("rndis: bad query completion")
+	}
+	return resp[offset : offset+length], nil
+}
+
+// Set sets oid to data.
+func (d *Device) Set(oid uint32, data []byte) error {
+	msg := make([]byte, queryLength+len(data))
+	le := binary.LittleEndian
+	le.PutUint32(msg[0:], MsgSet)
+	le.PutUint32(msg[4:], uint32(len(msg)))
+	le.PutUint32(msg[12:], oid)
+	le.PutUint32(msg[16:], uint32(len(data)))
+	le.PutUint32(msg[20:], queryLength-8)
+	copy(msg[queryLength:], data)
+	_, err := d.command(msg)
+	return err
+}
+
+func (d *Device) queryUint32(oid uint32) (uint32, error) {
+	b, err := d.Query(oid, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) < 4 {
+		return 0, errors.New("rndis: short oid value")
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+// Reset sends REMOTE_NDIS_RESET_MSG.
+func (d *Device) Reset() error {
+	msg := make([]byte, 12)
+	binary.LittleEndian.PutUint32(msg[0:], MsgReset)
+	binary.LittleEndian.PutUint32(msg[4:], 12)
+	d.cmu.Lock()
+	_, err := d.h.ControlTransfer(requestOut, RequestSendEncapsulatedCommand, 0, uint16(d.ctrl.InterfaceNumber), msg)
+	d.cmu.Unlock()
+	if err != nil {
+		return err
+	}
+	// RESET_CMPLT carries no request id; just drain it
+	buf := make([]byte, responseBufferSize)
+	if d.intr != nil {
+		d.intr.Read(buf[:notificationLength])
+	}
+	_, err = d.h.ControlTransfer(requestIn, RequestGetEncapsulatedResponse, 0, uint16(d.ctrl.InterfaceNumber), buf)
+	return err
+}
+
+// MACAddress returns the current Ethernet address.
+func (d *Device) MACAddress() (net.HardwareAddr, error) {
+	b, err := d.Query(OID8023CurrentAddress, nil)
+	if err != nil {
+		b, err = d.Query(OID8023PermanentAddress, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 6 {
+		return nil, errors.New("rndis: bad mac address")
+	}
+	return net.HardwareAddr(append([]byte(nil), b[:6]...)), nil
+}
+
+func (d *Device) SetPacketFilter(filter uint32) error {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, filter)
+	return d.Set(OIDGenCurrentPacketFilter, b)
+}
+
+// LinkSpeed returns the link speed in bits per second.
+func (d *Device) LinkSpeed() (uint64, error) {
+	v, err := d.queryUint32(OIDGenLinkSpeed)
+	if err != nil {
+		return 0, err
+	}
+	// reported in units of 100 bps
+	return uint64(v) * 100, nil
+}
+
+// Connected queries the media connect status.
+func (d *Device) Connected() (bool, error) {
+	v, err := d.queryUint32(OIDGenMediaConnectStatus)
+	if err != nil {
+		return false, err
+	}
+	d.mu.Lock()
+	d.connected = v == 0
+	d.mu.Unlock()
+	return v == 0, nil
+}
+
+// MTU returns the maximum frame size without the Ethernet header.
+func (d *Device) MTU() (int, error) {
+	v, err := d.queryUint32(OIDGenMaximumFrameSize)
+	if err != nil {
+		return 0, err
+	}
+	return int(v), nil
+}
+
+// ReadPacket reads the next Ethernet frame into b. Transfers carrying
+// several packet messages are returned one frame per call.
+func (d *Device) ReadPacket(b []byte) (int, error) {
+	for len(d.pending) == 0 {
+		n, err := d.r.Read(d.rbuf)
+		if err != nil {
+			return 0, err
+		}
+		if d.pending, err = splitPackets(d.pending, d.rbuf[:n]); err != nil {
+			return 0, err
+		}
+	}
+	frame := d.pending[0]
+	d.pending = d.pending[1:]
+	if len(frame) > len(b) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(b, frame), nil
+}
+
+// splitPackets appends the frames of the packet messages in p to frames.
+// Anything after the last packet message is dropped.
+func splitPackets(frames [][]byte, p []byte) ([][]byte, error) {
+	le := binary.LittleEndian
+	for len(p) >= packetHeaderLength {
+		if le.Uint32(p) != MsgPacket {
+			break
+		}
+		length := int(le.Uint32(p[4:]))
+		offset := 8 + int(le.Uint32(p[8:]))
+		size := int(le.Uint32(p[12:]))
+		if length < packetHeaderLength || length > len(p) || offset+size > length {
+			return frames, errors.New("rndis: malformed packet message")
+		}
+		frames = append(frames, p[offset:offset+size])
+		p = p[length:]
+	}
+	return frames, nil
+}
+
+// WritePacket sends one Ethernet frame in a packet message.
+func (d *Device) WritePacket(b []byte) error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+
+	if d.maxTransfer != 0 && packetHeaderLength+len(b) > int(d.maxTransfer) {
+		return gousb.ErrOverflow
+	}
+	_, err := d.w.Write(packetMessage(b, d.maxOut))
+	return err
+}
+
+// packetMessage wraps frame in a packet message for an endpoint with
+// packets of maxPacket bytes.
+func packetMessage(frame []byte, maxPacket int) []byte {
+	length := packetHeaderLength + len(frame)
+	msg := make([]byte, length, length+1)
+	le := binary.LittleEndian
+	le.PutUint32(msg[0:], MsgPacket)
+	le.PutUint32(msg[4:], uint32(length))
+	le.PutUint32(msg[8:], packetHeaderLength-8)
+	le.PutUint32(msg[12:], uint32(len(frame)))
+	copy(msg[packetHeaderLength:], frame)
+	// many RNDIS devices choke on ZLPs; pad a byte outside the message
+	// instead, as the Linux host does
+	if len(msg)%maxPacket == 0 {
+		msg = append(msg, 0)
+	}
+	return msg
+}
+
+// MaxPacketsPerTransfer and MaxTransferSize are the device's limits from
+// the initialize completion.
+func (d *Device) MaxPacketsPerTransfer() int {
+	return int(d.maxPackets)
+}
+func (d *Device) MaxTransferSize() int {
+	return int(d.maxTransfer)
+}
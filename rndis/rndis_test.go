@@ -0,0 +1,60 @@
+package rndis
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// packet is a REMOTE_NDIS_PACKET_MSG carrying the frame "aabbcc".
+const packet = "01000000 2f000000 24000000 03000000" +
+	" 00000000 00000000 00000000 00000000 00000000 00000000 00000000" +
+	" aabbcc"
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestPacketMessage(t *testing.T) {
+	if got := hex.EncodeToString(packetMessage(unhex("aabbcc"), 512)); got != strings.Replace(packet, " ", "", -1) {
+		t.Errorf("got %s", got)
+	}
+	// a message filling whole packets gets a pad byte instead of a ZLP
+	if msg := packetMessage(make([]byte, 20), 64); len(msg) != 65 || msg[4] != 64 {
+		t.Errorf("padded message: % x", msg)
+	}
+}
+
+func TestSplitPackets(t *testing.T) {
+	frames, err := splitPackets(nil, unhex(packet+packet+" 0200000018000000"))
+	if err != nil || len(frames) != 2 || hex.EncodeToString(frames[0]) != "aabbcc" || hex.EncodeToString(frames[1]) != "aabbcc" {
+		t.Errorf("got %x, %v", frames, err)
+	}
+	// the pad byte after a message is ignored
+	if frames, err := splitPackets(nil, packetMessage(make([]byte, 20), 64)); err != nil || len(frames) != 1 || len(frames[0]) != 20 {
+		t.Errorf("padded message: got %x, %v", frames, err)
+	}
+
+	for _, in := range []string{
+		"01000000 30000000" + packet[17:],                   // length past the transfer
+		"01000000 2f000000 24000000 04000000" + packet[35:], // data past the message
+		"01000000 20000000 24000000 00000000" + packet[35:], // shorter than the header
+	} {
+		if _, err := splitPackets(nil, unhex(in)); err == nil || err.Error() != "rndis: malformed packet message" {
+			t.Errorf("%s: got error %v", in, err)
+		}
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	if s := StatusError(StatusNotSupported).Error(); s != "rndis: not supported" {
+		t.Errorf("got %q", s)
+	}
+	if s := StatusError(0xc0000002).Error(); s != "rndis: status 0xc0000002" {
+		t.Errorf("got %q", s)
+	}
+}
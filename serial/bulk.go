@@ -0,0 +1,51 @@
+package serial
+
+import (
+	"errors"
+
+	"github.com/op0xA5/gousb"
+)
+
+// bulkPort is the data path shared by the adapters that move plain
+// payload over one bulk endpoint pair.
+type bulkPort struct {
+	h       *gousb.Handle
+	iface   *gousb.Interface
+	bulk    *gousb.BulkTransfer
+	claimed bool
+}
+
+func (p *bulkPort) open(h *gousb.Handle, iface *gousb.Interface) error {
+	in := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk)
+	out := iface.FindEndpoint(gousb.EndpointOut, gousb.TransferTypeBulk)
+	if in == nil || out == nil {
+		return errors.New("serial: bulk endpoints not found")
+	}
+	if err := h.ClaimInterface(int(iface.InterfaceNumber)); err != nil {
+		return err
+	}
+	p.h = h
+	p.iface = iface
+	p.bulk = h.GetBulkTransfer(in.EndpointAddress, out.EndpointAddress)
+	p.claimed = true
+	return nil
+}
+
+func (p *bulkPort) Close() error {
+	if !p.claimed {
+		return nil
+	}
+	p.claimed = false
+	return p.h.ReleaseInterface(int(p.iface.InterfaceNumber))
+}
+
+func (p *bulkPort) SetTimeout(timeout uint) {
+	p.bulk.SetTimeout(timeout)
+}
+
+func (p *bulkPort) Read(b []byte) (int, error) {
+	return p.bulk.Read(b)
+}
+func (p *bulkPort) Write(b []byte) (int, error) {
+	return p.bulk.Write(b)
+}
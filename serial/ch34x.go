@@ -0,0 +1,275 @@
+package serial
+
+import (
+	"errors"
+
+	"github.com/op0xA5/gousb"
+)
+
+// CH34x vendor requests
+const (
+	ch34xReadVersion = uint8(0x5F)
+	ch34xReadReg     = uint8(0x95)
+	ch34xWriteReg    = uint8(0x9A)
+	ch34xSerialInit  = uint8(0xA1)
+	ch34xModemCtrl   = uint8(0xA4)
+)
+
+// CH34x registers, written in pairs as reg2<<8|reg1
+const (
+	ch34xRegPrescaler = 0x12
+	ch34xRegDivisor   = 0x13
+	ch34xRegLCR       = 0x18
+	ch34xRegLCR2      = 0x25
+	ch34xRegBreak     = 0x05
+	ch34xRegStatus    = 0x06
+	ch34xRegStatus2   = 0x07
+	ch34xRegFlow      = 0x27
+)
+
+// LCR bits
+const (
+	ch34xLCREnableRX  = uint8(0x80)
+	ch34xLCREnableTX  = uint8(0x40)
+	ch34xLCRMarkSpace = uint8(0x20)
+	ch34xLCRParEven   = uint8(0x10)
+	ch34xLCREnablePar = uint8(0x08)
+	ch34xLCRStopBits2 = uint8(0x04)
+)
+
+// modem control and status bits
+const (
+	ch34xDTR = uint8(0x20)
+	ch34xRTS = uint8(0x40)
+
+	ch34xCTS = uint8(0x01)
+	ch34xDSR = uint8(0x02)
+	ch34xRI  = uint8(0x04)
+	ch34xDCD = uint8(0x08)
+)
+
+const ch34xClock = 48000000
+
+// CH34x is a WCH CH340/CH341 port.
+type CH34x struct {
+	bulkPort
+	version uint8
+	baud    int
+	lcr     uint8
+	mcr     uint8
+}
+
+// NewCH34x claims iface and initializes the UART at 9600 8N1.
+func NewCH34x(h *gousb.Handle, iface *gousb.Interface) (*CH34x, error) {
+	p := &CH34x{
+		baud: 9600,
+		lcr:  ch34xLCREnableRX | ch34xLCREnableTX | 0x03,
+	}
+	if err := p.open(h, iface); err != nil {
+		return nil, err
+	}
+	if err := p.init(); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *CH34x) init() error {
+	b := make([]byte, 2)
+	n, err := p.h.ControlRead(ch34xReadVersion, 0, 0, b)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		p.version = b[0]
+	}
+	if _, err := p.h.ControlWrite(ch34xSerialInit, 0, 0, nil); err != nil {
+		return err
+	}
+	if _, err := p.SetBaudRate(p.baud); err != nil {
+		return err
+	}
+	return p.setModem()
+}
+
+func (p *CH34x) writeReg(reg uint16, value uint16) error {
+	_, err := p.h.ControlWrite(ch34xWriteReg, reg, value, nil)
+	return err
+}
+func (p *CH34x) readReg(reg uint16) ([]byte, error) {
+	b := make([]byte, 2)
+	n, err := p.h.ControlRead(ch34xReadReg, reg, 0, b)
+	if err != nil {
+		return nil, err
+	}
+	if n < 2 {
+		return nil, errors.New("serial: ch34x: short register read")
+	}
+	return b, nil
+}
+
+// ch34xDivisor returns the prescaler/divisor register value for baud and
+// the rate it produces.
+func ch34xDivisor(baud int) (uint16, int, error) {
+	clkDiv := func(ps, fact int) int {
+		return 1 << uint(12-3*ps-fact)
+	}
+	if baud < 46 {
+		baud = 46
+	}
+	if baud > 3000000 {
+		baud = 3000000
+	}
+	ps := 3
+	for ; ps >= 0; ps-- {
+		if baud > ch34xClock/(clkDiv(ps, 1)*512) {
+			break
+		}
+	}
+	if ps < 0 {
+		return 0, 0, errors.New("serial: unsupported baud rate")
+	}
+	fact := 1
+	cd := clkDiv(ps, fact)
+	div := ch34xClock / (cd * baud)
+	if div < 9 || div > 255 {
+		div /= 2
+		cd *= 2
+		fact = 0
+	}
+	if div < 2 {
+		return 0, 0, errors.New("serial: unsupported baud rate")
+	}
+	// round to the closest rate
+	if 16*ch34xClock/(cd*div)-16*baud >= 16*baud-16*ch34xClock/(cd*(div+1)) {
+		div++
+	}
+	actual := ch34xClock / (cd * div)
+	// prefer half the divisor without the extra factor of two
+	if fact == 1 && div%2 == 0 {
+		div /= 2
+		fact = 0
+	}
+	return uint16(0x100-div)<<8 | uint16(fact)<<2 | uint16(ps), actual, nil
+}
+
+func (p *CH34x) SetBaudRate(baud int) (int, error) {
+	value, actual, err := ch34xDivisor(baud)
+	if err != nil {
+		return 0, err
+	}
+	// newer chips need bit 7 to not lose data on short transfers
+	if p.version > 0x27 {
+		value |= 1 << 7
+	}
+	if err := p.writeReg(ch34xRegDivisor<<8|ch34xRegPrescaler, value); err != nil {
+		return 0, err
+	}
+	if err := p.writeReg(ch34xRegLCR2<<8|ch34xRegLCR, uint16(p.lcr)); err != nil {
+		return 0, err
+	}
+	p.baud = baud
+	return actual, nil
+}
+
+func (p *CH34x) SetLineProperties(dataBits int, parity Parity, stop StopBits) error {
+	if dataBits < 5 || dataBits > 8 {
+		return errors.New("serial: unsupported data bits")
+	}
+	lcr := ch34xLCREnableRX | ch34xLCREnableTX | uint8(dataBits-5)
+	switch parity {
+	case ParityNone:
+	case ParityOdd:
+		lcr |= ch34xLCREnablePar
+	case ParityEven:
+		lcr |= ch34xLCREnablePar | ch34xLCRParEven
+	case ParityMark:
+		lcr |= ch34xLCREnablePar | ch34xLCRMarkSpace
+	case ParitySpace:
+		lcr |= ch34xLCREnablePar | ch34xLCRMarkSpace | ch34xLCRParEven
+	}
+	switch stop {
+	case StopBits1:
+	case StopBits2:
+		lcr |= ch34xLCRStopBits2
+	default:
+		return errors.New("serial: unsupported stop bits")
+	}
+	if err := p.writeReg(ch34xRegLCR2<<8|ch34xRegLCR, uint16(lcr)); err != nil {
+		return err
+	}
+	p.lcr = lcr
+	return nil
+}
+
+func (p *CH34x) SetFlowControl(flow FlowControl) error {
+	switch flow {
+	case FlowNone:
+		return p.writeReg(ch34xRegFlow<<8|ch34xRegFlow, 0)
+	case FlowRTSCTS:
+		return p.writeReg(ch34xRegFlow<<8|ch34xRegFlow, 0x0101)
+	}
+	return errors.New("serial: unsupported flow control")
+}
+
+// setModem writes the modem control lines; the chip takes them inverted.
+func (p *CH34x) setModem() error {
+	_, err := p.h.ControlWrite(ch34xModemCtrl, uint16(^p.mcr), 0, nil)
+	return err
+}
+func (p *CH34x) SetDTR(on bool) error {
+	if on {
+		p.mcr |= ch34xDTR
+	} else {
+		p.mcr &^= ch34xDTR
+	}
+	return p.setModem()
+}
+func (p *CH34x) SetRTS(on bool) error {
+	if on {
+		p.mcr |= ch34xRTS
+	} else {
+		p.mcr &^= ch34xRTS
+	}
+	return p.setModem()
+}
+
+func (p *CH34x) SetBreak(on bool) error {
+	reg := uint16(ch34xRegLCR<<8 | ch34xRegBreak)
+	b, err := p.readReg(reg)
+	if err != nil {
+		return err
+	}
+	if on {
+		b[0] &^= 0x01
+		b[1] &^= ch34xLCREnableTX
+	} else {
+		b[0] |= 0x01
+		b[1] |= ch34xLCREnableTX
+	}
+	return p.writeReg(reg, uint16(b[1])<<8|uint16(b[0]))
+}
+
+func (p *CH34x) ModemStatus() (ModemStatus, error) {
+	b, err := p.readReg(ch34xRegStatus2<<8 | ch34xRegStatus)
+	if err != nil {
+		return 0, err
+	}
+	// status lines are active low
+	st := ^b[0]
+	var ms ModemStatus
+	if st&ch34xCTS != 0 {
+		ms |= ModemCTS
+	}
+	if st&ch34xDSR != 0 {
+		ms |= ModemDSR
+	}
+	if st&ch34xRI != 0 {
+		ms |= ModemRI
+	}
+	if st&ch34xDCD != 0 {
+		ms |= ModemDCD
+	}
+	return ms, nil
+}
@@ -0,0 +1,28 @@
+package serial
+
+import "testing"
+
+func TestCH34xDivisor(t *testing.T) {
+	for _, tt := range []struct {
+		baud   int
+		value  uint16
+		actual int
+	}{
+		{50, 0x1600, 50},
+		{1200, 0xb201, 1201},
+		{9600, 0xb202, 9615},
+		{38400, 0x6403, 38461},
+		{115200, 0xcc03, 115384},
+		{921600, 0xf307, 923076},
+		{2000000, 0xfd03, 2000000},
+		{3000000, 0xfe03, 3000000},
+		// out of range rates are clamped
+		{0, 0x0100, 45},
+		{4000000, 0xfe03, 3000000},
+	} {
+		value, actual, err := ch34xDivisor(tt.baud)
+		if err != nil || value != tt.value || actual != tt.actual {
+			t.Errorf("%d baud: got %#04x, %d, %v, want %#04x, %d", tt.baud, value, actual, err, tt.value, tt.actual)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package serial
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/op0xA5/gousb"
+)
+
+// CP210x interface requests (AN571)
+const (
+	cp210xIfcEnable   = uint8(0x00)
+	cp210xSetLineCtl  = uint8(0x03)
+	cp210xSetBreak    = uint8(0x05)
+	cp210xSetMHS      = uint8(0x07)
+	cp210xGetMdmSts   = uint8(0x08)
+	cp210xPurge       = uint8(0x12)
+	cp210xSetFlow     = uint8(0x13)
+	cp210xGetFlow     = uint8(0x14)
+	cp210xGetBaudRate = uint8(0x1D)
+	cp210xSetBaudRate = uint8(0x1E)
+)
+
+// SET_FLOW bits
+const (
+	cp210xDTRMask      = uint32(0x03)
+	cp210xDTRActive    = uint32(0x01)
+	cp210xDTRFlow      = uint32(0x02)
+	cp210xCTSHandshake = uint32(0x08)
+	cp210xDSRHandshake = uint32(0x10)
+	cp210xAutoTransmit = uint32(0x01)
+	cp210xAutoReceive  = uint32(0x02)
+	cp210xRTSMask      = uint32(0xC0)
+	cp210xRTSActive    = uint32(0x40)
+	cp210xRTSFlow      = uint32(0x80)
+)
+
+// CP210x is a Silicon Labs CP210x port.
+type CP210x struct {
+	bulkPort
+}
+
+// NewCP210x claims iface and enables the UART.
+func NewCP210x(h *gousb.Handle, iface *gousb.Interface) (*CP210x, error) {
+	p := new(CP210x)
+	if err := p.open(h, iface); err != nil {
+		return nil, err
+	}
+	if err := p.command(cp210xIfcEnable, 1); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *CP210x) Close() error {
+	if p.claimed {
+		p.command(cp210xIfcEnable, 0)
+	}
+	return p.bulkPort.Close()
+}
+
+func (p *CP210x) out(req uint8, value uint16, data []byte) error {
+	typ := gousb.EndpointOut | gousb.RequestTypeVendor | gousb.RecipientInterface
+	_, err := p.h.ControlTransfer(typ, req, value, uint16(p.iface.InterfaceNumber), data)
+	return err
+}
+func (p *CP210x) in(req uint8, data []byte) error {
+	typ := gousb.EndpointIn | gousb.RequestTypeVendor | gousb.RecipientInterface
+	n, err := p.h.ControlTransfer(typ, req, 0, uint16(p.iface.InterfaceNumber), data)
+	if err != nil {
+		return err
+	}
+	if n < len(data) {
+		return errors.New("serial: cp210x: short response")
+	}
+	return nil
+}
+func (p *CP210x) command(req uint8, value uint16) error {
+	return p.out(req, value, nil)
+}
+
+func (p *CP210x) SetBaudRate(baud int) (int, error) {
+	if baud <= 0 {
+		return 0, errors.New("serial: invalid baud rate")
+	}
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(baud))
+	if err := p.out(cp210xSetBaudRate, 0, b); err != nil {
+		return 0, err
+	}
+	if err := p.in(cp210xGetBaudRate, b); err != nil {
+		return baud, nil
+	}
+	return int(binary.LittleEndian.Uint32(b)), nil
+}
+
+func (p *CP210x) SetLineProperties(dataBits int, parity Parity, stop StopBits) error {
+	if dataBits < 5 || dataBits > 8 {
+		return errors.New("serial: unsupported data bits")
+	}
+	return p.command(cp210xSetLineCtl, uint16(dataBits)<<8|uint16(parity)<<4|uint16(stop))
+}
+
+func (p *CP210x) SetFlowControl(flow FlowControl) error {
+	b := make([]byte, 16)
+	if err := p.in(cp210xGetFlow, b); err != nil {
+		return err
+	}
+	hs := binary.LittleEndian.Uint32(b[0:])
+	repl := binary.LittleEndian.Uint32(b[4:])
+
+	hs &^= cp210xDTRMask | cp210xCTSHandshake | cp210xDSRHandshake
+	repl &^= cp210xRTSMask | cp210xAutoTransmit | cp210xAutoReceive
+	hs |= cp210xDTRActive
+	repl |= cp210xRTSActive
+	switch flow {
+	case FlowNone:
+	case FlowRTSCTS:
+		hs |= cp210xCTSHandshake
+		repl = repl&^cp210xRTSMask | cp210xRTSFlow
+	case FlowDTRDSR:
+		hs = hs&^cp210xDTRMask | cp210xDTRFlow | cp210xDSRHandshake
+	case FlowXONXOFF:
+		repl |= cp210xAutoTransmit | cp210xAutoReceive
+		binary.LittleEndian.PutUint32(b[8:], 128)
+		binary.LittleEndian.PutUint32(b[12:], 128)
+	default:
+		return errors.New("serial: unsupported flow control")
+	}
+	binary.LittleEndian.PutUint32(b[0:], hs)
+	binary.LittleEndian.PutUint32(b[4:], repl)
+	return p.out(cp210xSetFlow, 0, b)
+}
+
+func (p *CP210x) setMHS(mask, bit uint16, on bool) error {
+	value := mask
+	if on {
+		value |= bit
+	}
+	return p.command(cp210xSetMHS, value)
+}
+func (p *CP210x) SetDTR(on bool) error {
+	return p.setMHS(0x0100, 0x01, on)
+}
+func (p *CP210x) SetRTS(on bool) error {
+	return p.setMHS(0x0200, 0x02, on)
+}
+
+func (p *CP210x) SetBreak(on bool) error {
+	value := uint16(0)
+	if on {
+		value = 1
+	}
+	return p.command(cp210xSetBreak, value)
+}
+
+func (p *CP210x) ModemStatus() (ModemStatus, error) {
+	b := make([]byte, 1)
+	if err := p.in(cp210xGetMdmSts, b); err != nil {
+		return 0, err
+	}
+	return ModemStatus(b[0]) & (ModemCTS | ModemDSR | ModemRI | ModemDCD), nil
+}
+
+// Purge discards the transmit and receive queues.
+func (p *CP210x) Purge() error {
+	return p.command(cp210xPurge, 0x0f)
+}
@@ -0,0 +1,36 @@
+package serial
+
+import (
+	"github.com/op0xA5/gousb"
+	"github.com/op0xA5/gousb/ftdi"
+)
+
+// ftdiPort adapts an ftdi.Port to Port.
+type ftdiPort struct {
+	*ftdi.Port
+}
+
+func newFTDI(h *gousb.Handle, iface *gousb.Interface) (Port, error) {
+	p, err := ftdi.New(h, iface)
+	if err != nil {
+		return nil, err
+	}
+	return ftdiPort{p}, nil
+}
+
+func (p ftdiPort) SetLineProperties(dataBits int, parity Parity, stop StopBits) error {
+	return p.Port.SetLineProperties(dataBits, ftdi.Parity(parity), ftdi.StopBits(stop))
+}
+
+func (p ftdiPort) SetFlowControl(flow FlowControl) error {
+	return p.Port.SetFlowControl(ftdi.FlowControl(flow))
+}
+
+func (p ftdiPort) ModemStatus() (ModemStatus, error) {
+	st, _, err := p.Port.ModemStatus()
+	if err != nil {
+		return 0, err
+	}
+	// the first status byte has the same layout as ModemStatus
+	return ModemStatus(st) & (ModemCTS | ModemDSR | ModemRI | ModemDCD), nil
+}
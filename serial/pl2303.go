@@ -0,0 +1,279 @@
+package serial
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/op0xA5/gousb"
+)
+
+// PL2303 vendor request, used for both register reads and writes
+const pl2303Vendor = uint8(0x01)
+
+// PL2303 class requests (CDC ACM subset)
+const (
+	pl2303SetLine    = uint8(0x20)
+	pl2303GetLine    = uint8(0x21)
+	pl2303SetControl = uint8(0x22)
+	pl2303Break      = uint8(0x23)
+)
+
+// SET_CONTROL bits
+const (
+	pl2303DTR = uint16(0x01)
+	pl2303RTS = uint16(0x02)
+)
+
+// UART state bits in the interrupt notification
+const (
+	pl2303StateOffset = 8
+
+	pl2303DCD = uint8(0x01)
+	pl2303DSR = uint8(0x02)
+	pl2303RI  = uint8(0x08)
+	pl2303CTS = uint8(0x80)
+)
+
+// rates the chip takes verbatim, others need the divisor encoding
+var pl2303Rates = []int{
+	75, 150, 300, 600, 1200, 1800, 2400, 3600, 4800, 7200, 9600, 14400,
+	19200, 28800, 38400, 57600, 115200, 230400, 460800, 614400, 921600,
+	1228800, 2457600, 3000000, 6000000,
+}
+
+// PL2303 is a Prolific PL2303 port.
+type PL2303 struct {
+	bulkPort
+	intr    *gousb.InterruptTransfer
+	control uint16
+	line    [7]byte
+	state   uint8
+	hx      bool
+}
+
+// NewPL2303 claims iface and runs the vendor init sequence.
+func NewPL2303(h *gousb.Handle, iface *gousb.Interface) (*PL2303, error) {
+	p := new(PL2303)
+	if err := p.open(h, iface); err != nil {
+		return nil, err
+	}
+	if ep := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeInterrupt); ep != nil {
+		p.intr = h.GetInterruptTransfer(ep.EndpointAddress)
+		p.intr.SetTimeout(10)
+	}
+	if err := p.init(); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *PL2303) init() error {
+	dev := p.h.GetDevice()
+	// HX and later report a 64 byte EP0
+	p.hx = dev.MaxPacketSize0 == 64
+
+	steps := []struct {
+		read         bool
+		value, index uint16
+	}{
+		{true, 0x8484, 0},
+		{false, 0x0404, 0},
+		{true, 0x8484, 0},
+		{true, 0x8383, 0},
+		{true, 0x8484, 0},
+		{false, 0x0404, 1},
+		{true, 0x8484, 0},
+		{true, 0x8383, 0},
+		{false, 0, 1},
+		{false, 1, 0},
+	}
+	for _, s := range steps {
+		var err error
+		if s.read {
+			_, err = p.vendorRead(s.value)
+		} else {
+			err = p.vendorWrite(s.value, s.index)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if p.hx {
+		return p.vendorWrite(2, 0x44)
+	}
+	return p.vendorWrite(2, 0x24)
+}
+
+func (p *PL2303) vendorRead(value uint16) (uint8, error) {
+	b := make([]byte, 1)
+	if _, err := p.h.ControlRead(pl2303Vendor, value, 0, b); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+func (p *PL2303) vendorWrite(value, index uint16) error {
+	_, err := p.h.ControlWrite(pl2303Vendor, value, index, nil)
+	return err
+}
+
+func (p *PL2303) class(typ gousb.RequestType, req uint8, value uint16, data []byte) (int, error) {
+	typ |= gousb.RequestTypeClass | gousb.RecipientInterface
+	return p.h.ControlTransfer(typ, req, value, uint16(p.iface.InterfaceNumber), data)
+}
+
+// getLine reads the current line coding once so partial updates keep the
+// other fields.
+func (p *PL2303) getLine() error {
+	if p.line[6] != 0 {
+		return nil
+	}
+	n, err := p.class(gousb.EndpointIn, pl2303GetLine, 0, p.line[:])
+	if err != nil {
+		return err
+	}
+	if n < len(p.line) {
+		return errors.New("serial: pl2303: short line coding")
+	}
+	return nil
+}
+func (p *PL2303) setLine(line [7]byte) error {
+	if _, err := p.class(gousb.EndpointOut, pl2303SetLine, 0, line[:]); err != nil {
+		return err
+	}
+	p.line = line
+	return nil
+}
+
+// pl2303Divisor encodes baud as mantissa and exponent of the 12MHz*32
+// baseline and returns the rate it produces.
+func pl2303Divisor(baud int) (uint32, int) {
+	const baseline = 12000000 * 32
+	mantissa := baseline / baud
+	if mantissa == 0 {
+		mantissa = 1
+	}
+	exponent := 0
+	for mantissa >= 512 {
+		if exponent < 7 {
+			mantissa >>= 2
+			exponent++
+		} else {
+			mantissa = 511
+			break
+		}
+	}
+	value := uint32(0x80)<<24 | uint32(exponent<<1|mantissa>>8)<<8 | uint32(mantissa&0xff)
+	return value, (baseline / mantissa) >> uint(exponent<<1)
+}
+
+func (p *PL2303) SetBaudRate(baud int) (int, error) {
+	if baud <= 0 {
+		return 0, errors.New("serial: invalid baud rate")
+	}
+	if err := p.getLine(); err != nil {
+		return 0, err
+	}
+	line := p.line
+	actual := 0
+	for _, r := range pl2303Rates {
+		if r == baud {
+			actual = baud
+			binary.LittleEndian.PutUint32(line[0:], uint32(baud))
+			break
+		}
+	}
+	if actual == 0 {
+		var value uint32
+		value, actual = pl2303Divisor(baud)
+		binary.LittleEndian.PutUint32(line[0:], value)
+	}
+	if err := p.setLine(line); err != nil {
+		return 0, err
+	}
+	return actual, nil
+}
+
+func (p *PL2303) SetLineProperties(dataBits int, parity Parity, stop StopBits) error {
+	if dataBits < 5 || dataBits > 8 {
+		return errors.New("serial: unsupported data bits")
+	}
+	if err := p.getLine(); err != nil {
+		return err
+	}
+	line := p.line
+	line[4] = uint8(stop)
+	line[5] = uint8(parity)
+	line[6] = uint8(dataBits)
+	return p.setLine(line)
+}
+
+func (p *PL2303) SetFlowControl(flow FlowControl) error {
+	switch flow {
+	case FlowNone:
+		return p.vendorWrite(0, 0)
+	case FlowRTSCTS:
+		if !p.hx {
+			return p.vendorWrite(0, 0x41)
+		}
+		return p.vendorWrite(0, 0x61)
+	}
+	return gousb.ErrNotSupported
+}
+
+func (p *PL2303) setControl(bit uint16, on bool) error {
+	control := p.control &^ bit
+	if on {
+		control |= bit
+	}
+	if _, err := p.class(gousb.EndpointOut, pl2303SetControl, control, nil); err != nil {
+		return err
+	}
+	p.control = control
+	return nil
+}
+func (p *PL2303) SetDTR(on bool) error {
+	return p.setControl(pl2303DTR, on)
+}
+func (p *PL2303) SetRTS(on bool) error {
+	return p.setControl(pl2303RTS, on)
+}
+
+func (p *PL2303) SetBreak(on bool) error {
+	value := uint16(0)
+	if on {
+		value = 0xffff
+	}
+	_, err := p.class(gousb.EndpointOut, pl2303Break, value, nil)
+	return err
+}
+
+// ModemStatus polls the interrupt endpoint for a state notification and
+// returns the last known lines.
+func (p *PL2303) ModemStatus() (ModemStatus, error) {
+	if p.intr == nil {
+		return 0, gousb.ErrNotSupported
+	}
+	b := make([]byte, 10)
+	n, err := p.intr.Read(b)
+	if err != nil && err != gousb.ErrTimeout {
+		return 0, err
+	}
+	if n > pl2303StateOffset {
+		p.state = b[pl2303StateOffset]
+	}
+	var ms ModemStatus
+	if p.state&pl2303CTS != 0 {
+		ms |= ModemCTS
+	}
+	if p.state&pl2303DSR != 0 {
+		ms |= ModemDSR
+	}
+	if p.state&pl2303RI != 0 {
+		ms |= ModemRI
+	}
+	if p.state&pl2303DCD != 0 {
+		ms |= ModemDCD
+	}
+	return ms, nil
+}
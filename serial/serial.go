@@ -0,0 +1,178 @@
+// Package serial provides a common serial port interface over USB serial
+// adapters: FTDI, Silicon Labs CP210x, WCH CH34x and Prolific PL2303.
+package serial
+
+import (
+	"io"
+
+	"github.com/op0xA5/gousb"
+)
+
+// Parity type
+type Parity int
+
+// Parity values
+const (
+	ParityNone  = Parity(0)
+	ParityOdd   = Parity(1)
+	ParityEven  = Parity(2)
+	ParityMark  = Parity(3)
+	ParitySpace = Parity(4)
+)
+
+// StopBits type
+type StopBits int
+
+// StopBits values
+const (
+	StopBits1   = StopBits(0)
+	StopBits1_5 = StopBits(1)
+	StopBits2   = StopBits(2)
+)
+
+// FlowControl type
+type FlowControl int
+
+// FlowControl values
+const (
+	FlowNone    = FlowControl(0)
+	FlowRTSCTS  = FlowControl(1)
+	FlowDTRDSR  = FlowControl(2)
+	FlowXONXOFF = FlowControl(4)
+)
+
+// ModemStatus holds the modem input lines.
+type ModemStatus uint8
+
+// ModemStatus bits
+const (
+	ModemCTS = ModemStatus(0x10)
+	ModemDSR = ModemStatus(0x20)
+	ModemRI  = ModemStatus(0x40)
+	ModemDCD = ModemStatus(0x80)
+)
+
+// Port is a USB serial adapter port.
+type Port interface {
+	io.ReadWriteCloser
+
+	// SetTimeout sets the transfer timeout in milliseconds, 0 is forever.
+	SetTimeout(timeout uint)
+	// SetBaudRate returns the baud rate the adapter actually uses.
+	SetBaudRate(baud int) (int, error)
+	SetLineProperties(dataBits int, parity Parity, stop StopBits) error
+	SetFlowControl(flow FlowControl) error
+	SetDTR(on bool) error
+	SetRTS(on bool) error
+	SetBreak(on bool) error
+	ModemStatus() (ModemStatus, error)
+}
+
+// Chip type
+type Chip int
+
+// Chip values
+const (
+	ChipUnknown Chip = iota
+	ChipFTDI
+	ChipCP210x
+	ChipCH34x
+	ChipPL2303
+)
+
+func (c Chip) String() string {
+	switch c {
+	case ChipFTDI:
+		return "ftdi"
+	case ChipCP210x:
+		return "cp210x"
+	case ChipCH34x:
+		return "ch34x"
+	case ChipPL2303:
+		return "pl2303"
+	}
+	return "unknown"
+}
+
+type deviceID struct {
+	vendor, product uint16
+}
+
+var knownDevices = map[deviceID]Chip{
+	{0x0403, 0x6001}: ChipFTDI,
+	{0x0403, 0x6010}: ChipFTDI,
+	{0x0403, 0x6011}: ChipFTDI,
+	{0x0403, 0x6014}: ChipFTDI,
+	{0x0403, 0x6015}: ChipFTDI,
+
+	{0x10c4, 0xea60}: ChipCP210x,
+	{0x10c4, 0xea61}: ChipCP210x,
+	{0x10c4, 0xea63}: ChipCP210x,
+	{0x10c4, 0xea70}: ChipCP210x,
+	{0x10c4, 0xea71}: ChipCP210x,
+
+	{0x1a86, 0x5523}: ChipCH34x,
+	{0x1a86, 0x7522}: ChipCH34x,
+	{0x1a86, 0x7523}: ChipCH34x,
+
+	{0x067b, 0x2303}: ChipPL2303,
+	{0x067b, 0x04bb}: ChipPL2303,
+	{0x0557, 0x2008}: ChipPL2303,
+}
+
+// Lookup returns the chip of a known adapter.
+func Lookup(vendor, product uint16) Chip {
+	return knownDevices[deviceID{vendor, product}]
+}
+
+// Register adds a VID:PID to the table used by Open, for adapters with
+// custom IDs.
+func Register(vendor, product uint16, chip Chip) {
+	knownDevices[deviceID{vendor, product}] = chip
+}
+
+// Open opens the first port of a known adapter, selecting the driver by
+// IDVender and IDProduct.
+func Open(h *gousb.Handle) (Port, error) {
+	return OpenPort(h, 0)
+}
+
+// OpenPort opens port n of a multi-port adapter like CP2105 or FT4232H.
+func OpenPort(h *gousb.Handle, n int) (Port, error) {
+	dev := h.GetDevice()
+	chip := Lookup(dev.IDVender, dev.IDProduct)
+	if chip == ChipUnknown {
+		return nil, gousb.ErrNotSupported
+	}
+	cfg, err := h.GetActiveConfigDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	iface := cfg.Interface(uint8(n), 0)
+	if iface == nil {
+		return nil, gousb.ErrNotFound
+	}
+	return New(h, iface, chip)
+}
+
+// New opens iface with the driver for chip.
+func New(h *gousb.Handle, iface *gousb.Interface, chip Chip) (Port, error) {
+	var p Port
+	var err error
+	switch chip {
+	case ChipFTDI:
+		return newFTDI(h, iface)
+	case ChipCP210x:
+		p, err = NewCP210x(h, iface)
+	case ChipCH34x:
+		p, err = NewCH34x(h, iface)
+	case ChipPL2303:
+		p, err = NewPL2303(h, iface)
+	default:
+		return nil, gousb.ErrNotSupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
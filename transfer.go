@@ -0,0 +1,433 @@
+package gousb
+
+/*
+#cgo CFLAGS: -I/usr/local/include/libusb-1.0
+#include <stdlib.h>
+#include <libusb.h>
+
+static void LIBUSB_CALL gousb_transfer_cb(struct libusb_transfer *t) {
+	*(int *)t->user_data = 1;
+}
+
+static void gousb_fill_iso(struct libusb_transfer *t, libusb_device_handle *h,
+	unsigned char ep, unsigned char *buf, int length, int num_iso_packets,
+	int *completed, unsigned int timeout) {
+	t->dev_handle = h;
+	t->endpoint = ep;
+	t->type = LIBUSB_TRANSFER_TYPE_ISOCHRONOUS;
+	t->timeout = timeout;
+	t->buffer = buf;
+	t->length = length;
+	t->num_iso_packets = num_iso_packets;
+	t->user_data = completed;
+	t->callback = gousb_transfer_cb;
+}
+
+static void gousb_fill_bulk_stream(struct libusb_transfer *t, libusb_device_handle *h,
+	unsigned char ep, uint32_t stream_id, unsigned char *buf, int length,
+	int *completed, unsigned int timeout) {
+	t->dev_handle = h;
+	t->endpoint = ep;
+	t->type = LIBUSB_TRANSFER_TYPE_BULK_STREAM;
+	t->timeout = timeout;
+	t->buffer = buf;
+	t->length = length;
+	t->num_iso_packets = 0;
+	t->user_data = completed;
+	t->callback = gousb_transfer_cb;
+	libusb_transfer_set_stream_id(t, stream_id);
+}
+
+static struct libusb_iso_packet_descriptor *gousb_iso_desc(struct libusb_transfer *t, int i) {
+	return &t->iso_packet_desc[i];
+}
+*/
+import "C"
+import (
+	"errors"
+	"unsafe"
+)
+
+func transferStatusError(status C.enum_libusb_transfer_status) error {
+	switch status {
+	case C.LIBUSB_TRANSFER_COMPLETED:
+		return nil
+	case C.LIBUSB_TRANSFER_TIMED_OUT:
+		return ErrTimeout
+	case C.LIBUSB_TRANSFER_CANCELLED:
+		return ErrInterrupted
+	case C.LIBUSB_TRANSFER_STALL:
+		return ErrPipe
+	case C.LIBUSB_TRANSFER_NO_DEVICE:
+		return ErrNoDevice
+	case C.LIBUSB_TRANSFER_OVERFLOW:
+		return ErrOverflow
+	}
+	return ErrIo
+}
+
+// transfer is an asynchronous libusb transfer with C-allocated buffer and
+// completion flag, so libusb may keep them across calls.
+type transfer struct {
+	t    *C.struct_libusb_transfer
+	buf  *C.uchar
+	size int
+	done *C.int
+	busy bool
+}
+
+func newTransfer(numIsoPackets, size int) (*transfer, error) {
+	t := C.libusb_alloc_transfer(C.int(numIsoPackets))
+	if t == nil {
+		return nil, ErrNoMem
+	}
+	x := &transfer{
+		t:    t,
+		size: size,
+		done: (*C.int)(C.malloc(C.size_t(unsafe.Sizeof(C.int(0))))),
+	}
+	if size > 0 {
+		x.buf = (*C.uchar)(C.malloc(C.size_t(size)))
+	}
+	return x, nil
+}
+
+func (x *transfer) submit() error {
+	*x.done = 0
+	rc := int(C.libusb_submit_transfer(x.t))
+	if rc < 0 {
+		return Error(rc)
+	}
+	x.busy = true
+	return nil
+}
+
+// wait runs the event loop until the transfer completes. If event handling
+// fails the transfer is cancelled and still waited for, so its memory may
+// be reused.
+func (x *transfer) wait() error {
+	if !x.busy {
+		return nil
+	}
+	ctx := Init()
+	for *x.done == 0 {
+		rc := int(C.libusb_handle_events_completed(ctx.handle, x.done))
+		if rc < 0 && rc != int(C.LIBUSB_ERROR_INTERRUPTED) {
+			C.libusb_cancel_transfer(x.t)
+		}
+	}
+	x.busy = false
+	return transferStatusError(x.t.status)
+}
+
+func (x *transfer) cancel() {
+	if x.busy {
+		C.libusb_cancel_transfer(x.t)
+		x.wait()
+	}
+}
+
+func (x *transfer) free() {
+	x.cancel()
+	C.libusb_free_transfer(x.t)
+	C.free(unsafe.Pointer(x.done))
+	if x.buf != nil {
+		C.free(unsafe.Pointer(x.buf))
+	}
+}
+
+func (x *transfer) bytes() []byte {
+	return (*[1 << 30]byte)(unsafe.Pointer(x.buf))[:x.size:x.size]
+}
+
+func (dev *Device) GetMaxIsoPacketSize(endpoint uint8) int {
+	if dev.ptr == nil {
+		return int(ErrNoDevice)
+	}
+	return int(C.libusb_get_max_iso_packet_size(dev.ptr, (C.uchar)(endpoint)))
+}
+
+// IsoPacket is one packet of an isochronous transfer.
+type IsoPacket struct {
+	Data []byte
+	Err  error
+}
+
+// isoTransferDepth is the number of transfers kept in flight, so the bus
+// is not idle while a completed transfer is being consumed.
+const isoTransferDepth = 4
+
+// IsoTransfer streams an isochronous endpoint. Reads keep several
+// transfers queued and return them in order; writes queue up to the same
+// depth before blocking.
+type IsoTransfer struct {
+	h          *Handle
+	ep         uint8
+	numPackets int
+	packetSize int
+	timeout    uint
+
+	xfers []*transfer
+	next  int
+}
+
+// GetIsoTransfer returns an IsoTransfer of numPackets packets per transfer.
+// A packetSize of 0 uses the endpoint's maximum for the current alternate
+// setting.
+func (h *Handle) GetIsoTransfer(ep uint8, numPackets, packetSize int) *IsoTransfer {
+	if packetSize <= 0 {
+		packetSize = h.dev.GetMaxIsoPacketSize(ep)
+	}
+	return &IsoTransfer{
+		h:          h,
+		ep:         ep,
+		numPackets: numPackets,
+		packetSize: packetSize,
+		timeout:    h.timeout,
+	}
+}
+
+func (it *IsoTransfer) SetTimeout(timeout uint) {
+	it.timeout = timeout
+}
+func (it *IsoTransfer) PacketSize() int {
+	return it.packetSize
+}
+
+func (it *IsoTransfer) alloc() error {
+	if it.xfers != nil {
+		return nil
+	}
+	if it.packetSize <= 0 || it.numPackets <= 0 {
+		return ErrInvalidParam
+	}
+	for i := 0; i < isoTransferDepth; i++ {
+		x, err := newTransfer(it.numPackets, it.numPackets*it.packetSize)
+		if err != nil {
+			it.Close()
+			return err
+		}
+		it.xfers = append(it.xfers, x)
+	}
+	return nil
+}
+
+func (it *IsoTransfer) fill(x *transfer, lengths []int) {
+	total := 0
+	for _, l := range lengths {
+		total += l
+	}
+	C.gousb_fill_iso(x.t, it.h.ptr, C.uchar(it.ep), x.buf, C.int(total), C.int(len(lengths)), x.done, C.uint(it.timeout))
+	for i, l := range lengths {
+		C.gousb_iso_desc(x.t, C.int(i)).length = C.uint(l)
+	}
+}
+
+// ReadPackets returns the packets of the next completed transfer. Packet
+// data is only valid until the next call.
+func (it *IsoTransfer) ReadPackets() ([]IsoPacket, error) {
+	if it.ep&uint8(EndpointIn) == 0 {
+		return nil, errors.New("iso transfer: cannot read")
+	}
+	if err := it.alloc(); err != nil {
+		return nil, err
+	}
+	lengths := make([]int, it.numPackets)
+	for i := range lengths {
+		lengths[i] = it.packetSize
+	}
+	for _, x := range it.xfers {
+		if !x.busy {
+			it.fill(x, lengths)
+			if err := x.submit(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	x := it.xfers[it.next]
+	it.next = (it.next + 1) % len(it.xfers)
+	if err := x.wait(); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, x.size)
+	copy(buf, x.bytes())
+	packets := make([]IsoPacket, it.numPackets)
+	for i := range packets {
+		desc := C.gousb_iso_desc(x.t, C.int(i))
+		off := i * it.packetSize
+		packets[i].Data = buf[off : off+int(desc.actual_length)]
+		packets[i].Err = transferStatusError(desc.status)
+	}
+	return packets, nil
+}
+
+// WritePackets queues one transfer with a packet per element of packets,
+// waiting for the oldest queued transfer if all are in flight.
+func (it *IsoTransfer) WritePackets(packets [][]byte) error {
+	if it.ep&uint8(EndpointIn) != 0 {
+		return errors.New("iso transfer: cannot write")
+	}
+	if len(packets) > it.numPackets {
+		return ErrInvalidParam
+	}
+	if err := it.alloc(); err != nil {
+		return err
+	}
+	x := it.xfers[it.next]
+	if err := x.wait(); err != nil {
+		return err
+	}
+	it.next = (it.next + 1) % len(it.xfers)
+	lengths := make([]int, len(packets))
+	b, off := x.bytes(), 0
+	for i, p := range packets {
+		if len(p) > it.packetSize {
+			return ErrOverflow
+		}
+		off += copy(b[off:], p)
+		lengths[i] = len(p)
+	}
+	it.fill(x, lengths)
+	return x.submit()
+}
+
+// Flush waits for all queued writes.
+func (it *IsoTransfer) Flush() error {
+	var err error
+	for i := range it.xfers {
+		x := it.xfers[(it.next+i)%len(it.xfers)]
+		if e := x.wait(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Close cancels queued transfers and frees them.
+func (it *IsoTransfer) Close() error {
+	for _, x := range it.xfers {
+		x.free()
+	}
+	it.xfers = nil
+	it.next = 0
+	return nil
+}
+
+// AllocStreams allocates numStreams bulk streams on each of the endpoints
+// and returns the number actually allocated. Stream IDs are 1 to n; all
+// endpoints get the same number of streams.
+func (h *Handle) AllocStreams(numStreams int, endpoints []uint8) (int, error) {
+	if len(endpoints) == 0 {
+		return 0, ErrInvalidParam
+	}
+	rc := int(C.libusb_alloc_streams(h.ptr, C.uint32_t(numStreams), (*C.uchar)(&endpoints[0]), C.int(len(endpoints))))
+	if rc < 0 {
+		return 0, Error(rc)
+	}
+	return rc, nil
+}
+
+// FreeStreams frees the streams allocated on the endpoints.
+func (h *Handle) FreeStreams(endpoints []uint8) error {
+	if len(endpoints) == 0 {
+		return ErrInvalidParam
+	}
+	rc := int(C.libusb_free_streams(h.ptr, (*C.uchar)(&endpoints[0]), C.int(len(endpoints))))
+	if rc < 0 {
+		return Error(rc)
+	}
+	return nil
+}
+
+// BulkStreamTransfer reads or writes one stream of a bulk endpoint with
+// streams allocated by AllocStreams. Transfers on different streams may
+// run concurrently from separate goroutines.
+type BulkStreamTransfer struct {
+	h        *Handle
+	ep       uint8
+	streamID uint32
+	timeout  uint
+
+	x *transfer
+}
+
+func (h *Handle) GetBulkStreamTransfer(ep uint8, streamID uint32) *BulkStreamTransfer {
+	return &BulkStreamTransfer{
+		h:        h,
+		ep:       ep,
+		streamID: streamID,
+		timeout:  h.timeout,
+	}
+}
+
+func (st *BulkStreamTransfer) SetTimeout(timeout uint) {
+	st.timeout = timeout
+}
+func (st *BulkStreamTransfer) StreamID() uint32 {
+	return st.streamID
+}
+
+// alloc makes sure the transfer buffer holds at least size bytes. The
+// buffer is never empty so zero length packets still have one.
+func (st *BulkStreamTransfer) alloc(size int) error {
+	if size == 0 {
+		size = 1
+	}
+	if st.x != nil && st.x.size >= size {
+		return nil
+	}
+	if st.x != nil {
+		st.x.free()
+		st.x = nil
+	}
+	x, err := newTransfer(0, size)
+	if err != nil {
+		return err
+	}
+	st.x = x
+	return nil
+}
+
+func (st *BulkStreamTransfer) do(n int) (int, error) {
+	x := st.x
+	C.gousb_fill_bulk_stream(x.t, st.h.ptr, C.uchar(st.ep), C.uint32_t(st.streamID), x.buf, C.int(n), x.done, C.uint(st.timeout))
+	if err := x.submit(); err != nil {
+		return 0, err
+	}
+	err := x.wait()
+	return int(x.t.actual_length), err
+}
+
+func (st *BulkStreamTransfer) Read(p []byte) (n int, err error) {
+	if st.ep&uint8(EndpointIn) == 0 {
+		return 0, errors.New("bulk stream transfer: cannot read")
+	}
+	if err := st.alloc(len(p)); err != nil {
+		return 0, err
+	}
+	n, err = st.do(len(p))
+	copy(p, st.x.bytes()[:n])
+	return n, err
+}
+
+// Write sends p on the stream; Write(nil) sends a zero length packet.
+func (st *BulkStreamTransfer) Write(p []byte) (n int, err error) {
+	if st.ep&uint8(EndpointIn) != 0 {
+		return 0, errors.New("bulk stream transfer: cannot write")
+	}
+	if err := st.alloc(len(p)); err != nil {
+		return 0, err
+	}
+	copy(st.x.bytes(), p)
+	return st.do(len(p))
+}
+
+// Close cancels a pending transfer and frees it.
+func (st *BulkStreamTransfer) Close() error {
+	if st.x != nil {
+		st.x.free()
+		st.x = nil
+	}
+	return nil
+}
@@ -0,0 +1,623 @@
+package uac
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/op0xA5/gousb"
+)
+
+// class-specific descriptor types
+const (
+	DescriptorTypeCSInterface = uint8(0x24)
+	DescriptorTypeCSEndpoint  = uint8(0x25)
+)
+
+// AudioControl descriptor subtypes, shared by UAC1 and UAC2
+const (
+	ACHeader         = uint8(0x01)
+	ACInputTerminal  = uint8(0x02)
+	ACOutputTerminal = uint8(0x03)
+	ACMixerUnit      = uint8(0x04)
+	ACSelectorUnit   = uint8(0x05)
+	ACFeatureUnit    = uint8(0x06)
+)
+
+// UAC2 only AudioControl descriptor subtypes
+const (
+	AC2EffectUnit          = uint8(0x07)
+	AC2ProcessingUnit      = uint8(0x08)
+	AC2ExtensionUnit       = uint8(0x09)
+	AC2ClockSource         = uint8(0x0A)
+	AC2ClockSelector       = uint8(0x0B)
+	AC2ClockMultiplier     = uint8(0x0C)
+	AC2SampleRateConverter = uint8(0x0D)
+)
+
+// AudioStreaming descriptor subtypes
+const (
+	ASGeneral    = uint8(0x01)
+	ASFormatType = uint8(0x02)
+)
+
+// format types
+const (
+	FormatTypeI   = uint8(0x01)
+	FormatTypeII  = uint8(0x02)
+	FormatTypeIII = uint8(0x03)
+)
+
+// terminal types
+const (
+	TerminalUSBStreaming  = uint16(0x0101)
+	TerminalMicrophone    = uint16(0x0201)
+	TerminalSpeaker       = uint16(0x0301)
+	TerminalHeadphones    = uint16(0x0302)
+	TerminalHeadset       = uint16(0x0402)
+	TerminalLineConnector = uint16(0x0603)
+	TerminalSPDIF         = uint16(0x0605)
+)
+
+// Terminal is an input or output terminal.
+type Terminal struct {
+	Subtype       uint8
+	ID            uint8
+	TerminalType  uint16
+	AssocTerminal uint8
+	SourceID      uint8 // output terminals only
+	ClockSourceID uint8 // UAC2 only
+	NrChannels    uint8 // input terminals only
+	ChannelConfig uint32
+	Controls      uint16 // UAC2 only
+	IdxTerminal   uint8
+}
+
+func (t *Terminal) IsInput() bool {
+	return t.Subtype == ACInputTerminal
+}
+
+// FeatureUnit is a feature unit. Controls holds the bmaControls bitmap of
+// the master channel at index 0 followed by each logical channel.
+type FeatureUnit struct {
+	ID         uint8
+	SourceID   uint8
+	Controls   []uint32
+	IdxFeature uint8
+}
+
+// Clock is a UAC2 clock source, selector or multiplier.
+type Clock struct {
+	Subtype       uint8
+	ID            uint8
+	Attributes    uint8 // sources only
+	Controls      uint8
+	AssocTerminal uint8   // sources only
+	SourceIDs     []uint8 // selectors and multipliers
+	IdxClock      uint8
+}
+
+// Unit is any other unit, kept with its source links so the topology can
+// be followed.
+type Unit struct {
+	Subtype   uint8
+	ID        uint8
+	SourceIDs []uint8
+}
+
+// AudioControl holds the parsed class-specific descriptors of an
+// AudioControl interface.
+type AudioControl struct {
+	Version          int
+	BcdADC           uint16
+	Category         uint8 // UAC2 only
+	TotalLength      uint16
+	InterfaceNumbers []uint8 // UAC1 only
+	Terminals        []*Terminal
+	FeatureUnits     []*FeatureUnit
+	Clocks           []*Clock
+	Units            []*Unit
+}
+
+func (ac *AudioControl) Terminal(id uint8) *Terminal {
+	for _, t := range ac.Terminals {
+		if t.ID == id {
+			return t
+		}
+	}
+	return nil
+}
+func (ac *AudioControl) FeatureUnit(id uint8) *FeatureUnit {
+	for _, fu := range ac.FeatureUnits {
+		if fu.ID == id {
+			return fu
+		}
+	}
+	return nil
+}
+func (ac *AudioControl) Clock(id uint8) *Clock {
+	for _, c := range ac.Clocks {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// sources returns the entities feeding id.
+func (ac *AudioControl) sources(id uint8) []uint8 {
+	if t := ac.Terminal(id); t != nil && !t.IsInput() {
+		return []uint8{t.SourceID}
+	}
+	if fu := ac.FeatureUnit(id); fu != nil {
+		return []uint8{fu.SourceID}
+	}
+	for _, u := range ac.Units {
+		if u.ID == id {
+			return u.SourceIDs
+		}
+	}
+	return nil
+}
+
+// FeatureUnitFor returns the first feature unit found walking upstream
+// from terminal id, or downstream to it for input terminals.
+func (ac *AudioControl) FeatureUnitFor(id uint8) *FeatureUnit {
+	t := ac.Terminal(id)
+	if t == nil {
+		return nil
+	}
+	if t.IsInput() {
+		// walk towards the output terminals
+		for _, fu := range ac.FeatureUnits {
+			if ac.reaches(fu.ID, id, 0) {
+				return fu
+			}
+		}
+		return nil
+	}
+	seen := map[uint8]bool{}
+	queue := ac.sources(id)
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if seen[cur] {
+			continue
+		}
+		seen[cur] = true
+		if fu := ac.FeatureUnit(cur); fu != nil {
+			return fu
+		}
+		queue = append(queue, ac.sources(cur)...)
+	}
+	return nil
+}
+
+// reaches reports whether from is fed by to.
+func (ac *AudioControl) reaches(from, to uint8, depth int) bool {
+	if depth > 16 {
+		return false
+	}
+	for _, src := range ac.sources(from) {
+		if src == to || ac.reaches(src, to, depth+1) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClockSourceFor returns the clock source feeding a UAC2 terminal,
+// following the first input of selectors and multipliers.
+func (ac *AudioControl) ClockSourceFor(terminal uint8) *Clock {
+	t := ac.Terminal(terminal)
+	if t == nil {
+		return nil
+	}
+	id := t.ClockSourceID
+	for i := 0; i < 16; i++ {
+		c := ac.Clock(id)
+		if c == nil {
+			return nil
+		}
+		if c.Subtype == AC2ClockSource {
+			return c
+		}
+		if len(c.SourceIDs) == 0 {
+			return nil
+		}
+		id = c.SourceIDs[0]
+	}
+	return nil
+}
+
+// classValues parses a run of descriptors with gousb.ParseDescriptors and
+// returns the values of the audio class descriptors among them.
+func classValues(iface *gousb.InterfaceDescriptor, extra []byte) ([]interface{}, error) {
+	list, err := gousb.ParseDescriptors(iface, extra)
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for _, desc := range list {
+		if cd, ok := desc.(*ClassDescriptor); ok {
+			values = append(values, cd.Value)
+		}
+	}
+	return values, nil
+}
+
+var errShort = errors.New("uac: short descriptor")
+
+// Header is the AudioControl interface header.
+type Header struct {
+	BcdADC           uint16
+	Category         uint8 // UAC2 only
+	TotalLength      uint16
+	InterfaceNumbers []uint8 // UAC1 only
+}
+
+// parseACDescriptor decodes one AudioControl descriptor into a *Header,
+// *Terminal, *FeatureUnit, *Clock or *Unit; other subtypes return nil.
+func parseACDescriptor(d []byte, version int) (interface{}, error) {
+	if d[1] != DescriptorTypeCSInterface || len(d) < 4 {
+		return nil, nil
+	}
+	le := binary.LittleEndian
+	switch {
+	case d[2] == ACHeader && version == 1:
+		if len(d) < 8 || len(d) < 8+int(d[7]) {
+			return nil, errShort
+		}
+		return &Header{
+			BcdADC:           le.Uint16(d[3:]),
+			TotalLength:      le.Uint16(d[5:]),
+			InterfaceNumbers: append([]uint8(nil), d[8:8+int(d[7])]...),
+		}, nil
+	case d[2] == ACHeader:
+		if len(d) < 9 {
+			return nil, errShort
+		}
+		return &Header{
+			BcdADC:      le.Uint16(d[3:]),
+			Category:    d[5],
+			TotalLength: le.Uint16(d[6:]),
+		}, nil
+	case d[2] == ACInputTerminal && version == 1:
+		if len(d) < 12 {
+			return nil, errShort
+		}
+		return &Terminal{
+			Subtype:       d[2],
+			ID:            d[3],
+			TerminalType:  le.Uint16(d[4:]),
+			AssocTerminal: d[6],
+			NrChannels:    d[7],
+			ChannelConfig: uint32(le.Uint16(d[8:])),
+			IdxTerminal:   d[11],
+		}, nil
+	case d[2] == ACInputTerminal:
+		if len(d) < 17 {
+			return nil, errShort
+		}
+		return &Terminal{
+			Subtype:       d[2],
+			ID:            d[3],
+			TerminalType:  le.Uint16(d[4:]),
+			AssocTerminal: d[6],
+			ClockSourceID: d[7],
+			NrChannels:    d[8],
+			ChannelConfig: le.Uint32(d[9:]),
+			Controls:      le.Uint16(d[14:]),
+			IdxTerminal:   d[16],
+		}, nil
+	case d[2] == ACOutputTerminal && version == 1:
+		if len(d) < 9 {
+			return nil, errShort
+		}
+		return &Terminal{
+			Subtype:       d[2],
+			ID:            d[3],
+			TerminalType:  le.Uint16(d[4:]),
+			AssocTerminal: d[6],
+			SourceID:      d[7],
+			IdxTerminal:   d[8],
+		}, nil
+	case d[2] == ACOutputTerminal:
+		if len(d) < 12 {
+			return nil, errShort
+		}
+		return &Terminal{
+			Subtype:       d[2],
+			ID:            d[3],
+			TerminalType:  le.Uint16(d[4:]),
+			AssocTerminal: d[6],
+			SourceID:      d[7],
+			ClockSourceID: d[8],
+			Controls:      le.Uint16(d[9:]),
+			IdxTerminal:   d[11],
+		}, nil
+	case d[2] == ACFeatureUnit && version == 1:
+		if len(d) < 7 || d[5] == 0 {
+			return nil, errShort
+		}
+		size := int(d[5])
+		fu := &FeatureUnit{ID: d[3], SourceID: d[4], IdxFeature: d[len(d)-1]}
+		for off := 6; off+size < len(d); off += size {
+			var v uint32
+			for i := size - 1; i >= 0; i-- {
+				v = v<<8 | uint32(d[off+i])
+			}
+			fu.Controls = append(fu.Controls, v)
+		}
+		return fu, nil
+	case d[2] == ACFeatureUnit:
+		if len(d) < 10 {
+			return nil, errShort
+		}
+		fu := &FeatureUnit{ID: d[3], SourceID: d[4], IdxFeature: d[len(d)-1]}
+		for off := 5; off+4 < len(d); off += 4 {
+			fu.Controls = append(fu.Controls, le.Uint32(d[off:]))
+		}
+		return fu, nil
+	case version == 2 && d[2] == AC2ClockSource:
+		if len(d) < 8 {
+			return nil, errShort
+		}
+		return &Clock{
+			Subtype:       d[2],
+			ID:            d[3],
+			Attributes:    d[4],
+			Controls:      d[5],
+			AssocTerminal: d[6],
+			IdxClock:      d[7],
+		}, nil
+	case version == 2 && d[2] == AC2ClockSelector:
+		if len(d) < 5 || len(d) < 7+int(d[4]) {
+			return nil, errShort
+		}
+		n := int(d[4])
+		return &Clock{
+			Subtype:   d[2],
+			ID:        d[3],
+			SourceIDs: append([]uint8(nil), d[5:5+n]...),
+			Controls:  d[5+n],
+			IdxClock:  d[6+n],
+		}, nil
+	case version == 2 && d[2] == AC2ClockMultiplier:
+		if len(d) < 7 {
+			return nil, errShort
+		}
+		return &Clock{
+			Subtype:   d[2],
+			ID:        d[3],
+			SourceIDs: []uint8{d[4]},
+			Controls:  d[5],
+			IdxClock:  d[6],
+		}, nil
+	case d[2] == ACMixerUnit || d[2] == ACSelectorUnit:
+		if len(d) < 5 || len(d) < 5+int(d[4]) {
+			return nil, errShort
+		}
+		return &Unit{
+			Subtype:   d[2],
+			ID:        d[3],
+			SourceIDs: append([]uint8(nil), d[5:5+int(d[4])]...),
+		}, nil
+	}
+	return nil, nil
+}
+
+// ParseAudioControl parses the class-specific descriptors following an
+// AudioControl interface descriptor; version is 1 or 2.
+func ParseAudioControl(extra []byte, version int) (*AudioControl, error) {
+	iface := &gousb.InterfaceDescriptor{
+		InterfaceClass:    gousb.ClassAudio,
+		InterfaceSubClass: SubClassAudioControl,
+	}
+	if version == 2 {
+		iface.InterfaceProtocol = ProtocolUAC2
+	}
+	list, err := classValues(iface, extra)
+	if err != nil {
+		return nil, err
+	}
+	ac := &AudioControl{Version: version}
+	for _, v := range list {
+		switch v := v.(type) {
+		case *Header:
+			ac.BcdADC = v.BcdADC
+			ac.Category = v.Category
+			ac.TotalLength = v.TotalLength
+			ac.InterfaceNumbers = v.InterfaceNumbers
+		case *Terminal:
+			ac.Terminals = append(ac.Terminals, v)
+		case *FeatureUnit:
+			ac.FeatureUnits = append(ac.FeatureUnits, v)
+		case *Clock:
+			ac.Clocks = append(ac.Clocks, v)
+		case *Unit:
+			ac.Units = append(ac.Units, v)
+		}
+	}
+	return ac, nil
+}
+
+// General is the AS_GENERAL descriptor of an AudioStreaming interface.
+type General struct {
+	TerminalLink  uint8
+	Delay         uint8  // UAC1 only
+	FormatTag     uint16 // UAC1 only
+	Controls      uint8  // UAC2 only
+	FormatType    uint8  // UAC2 only
+	Formats       uint32 // UAC2 only
+	NrChannels    uint8  // UAC2 only
+	ChannelConfig uint32 // UAC2 only
+}
+
+// Format is a Type I format descriptor.
+type Format struct {
+	FormatType    uint8
+	NrChannels    uint8 // UAC1 only, UAC2 keeps it in General
+	SubslotSize   uint8
+	BitResolution uint8
+
+	// UAC1 only: discrete rates, or a continuous range when
+	// SampleRates is empty.
+	SampleRates   []uint32
+	MinSampleRate uint32
+	MaxSampleRate uint32
+}
+
+// EndpointGeneral is the class-specific isochronous endpoint descriptor.
+type EndpointGeneral struct {
+	Attributes     uint8
+	Controls       uint8 // UAC2 only
+	LockDelayUnits uint8
+	LockDelay      uint16
+}
+
+// SampleRateControl reports whether a UAC1 endpoint supports setting the
+// sampling frequency.
+func (eg *EndpointGeneral) SampleRateControl() bool {
+	return eg.Attributes&0x01 != 0
+}
+
+func parseGeneral(d []byte, version int) (*General, error) {
+	le := binary.LittleEndian
+	if version == 1 {
+		if len(d) < 7 {
+			return nil, errShort
+		}
+		return &General{TerminalLink: d[3], Delay: d[4], FormatTag: le.Uint16(d[5:])}, nil
+	}
+	if len(d) < 16 {
+		return nil, errShort
+	}
+	return &General{
+		TerminalLink:  d[3],
+		Controls:      d[4],
+		FormatType:    d[5],
+		Formats:       le.Uint32(d[6:]),
+		NrChannels:    d[10],
+		ChannelConfig: le.Uint32(d[11:]),
+	}, nil
+}
+
+func parseFormat(d []byte, version int) (*Format, error) {
+	if len(d) < 6 {
+		return nil, errShort
+	}
+	if d[3] != FormatTypeI {
+		return &Format{FormatType: d[3]}, nil
+	}
+	if version == 2 {
+		return &Format{FormatType: d[3], SubslotSize: d[4], BitResolution: d[5]}, nil
+	}
+	if len(d) < 8 {
+		return nil, errShort
+	}
+	f := &Format{FormatType: d[3], NrChannels: d[4], SubslotSize: d[5], BitResolution: d[6]}
+	n := int(d[7])
+	rate := func(off int) uint32 {
+		return uint32(d[off]) | uint32(d[off+1])<<8 | uint32(d[off+2])<<16
+	}
+	if n == 0 {
+		if len(d) < 14 {
+			return nil, errShort
+		}
+		f.MinSampleRate, f.MaxSampleRate = rate(8), rate(11)
+		return f, nil
+	}
+	if len(d) < 8+3*n {
+		return nil, errShort
+	}
+	for i := 0; i < n; i++ {
+		f.SampleRates = append(f.SampleRates, rate(8+3*i))
+	}
+	return f, nil
+}
+
+func parseEndpointDescriptor(d []byte, version int) *EndpointGeneral {
+	if d[1] != DescriptorTypeCSEndpoint || len(d) < 3 || d[2] != ASGeneral {
+		return nil
+	}
+	if version == 1 && len(d) >= 7 {
+		return &EndpointGeneral{Attributes: d[3], LockDelayUnits: d[4], LockDelay: binary.LittleEndian.Uint16(d[5:])}
+	}
+	if version == 2 && len(d) >= 8 {
+		return &EndpointGeneral{Attributes: d[3], Controls: d[4], LockDelayUnits: d[5], LockDelay: binary.LittleEndian.Uint16(d[6:])}
+	}
+	return nil
+}
+
+// SupportsRate reports whether a UAC1 format lists rate.
+func (f *Format) SupportsRate(rate uint32) bool {
+	if len(f.SampleRates) == 0 {
+		return rate >= f.MinSampleRate && rate <= f.MaxSampleRate
+	}
+	for _, r := range f.SampleRates {
+		if r == rate {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassDescriptor is a class-specific descriptor as returned by
+// gousb.ParseDescriptors for audio interfaces. Value is one of *Header,
+// *Terminal, *FeatureUnit, *Clock, *Unit, *General, *Format or
+// *EndpointGeneral.
+type ClassDescriptor struct {
+	Length            uint8
+	DescriptorType    uint8
+	DescriptorSubtype uint8
+	Value             interface{}
+}
+
+func (desc *ClassDescriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *ClassDescriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+// parseClassDescriptor decodes a single CS_INTERFACE or CS_ENDPOINT
+// descriptor. The layout depends on the interface subclass and on the
+// UAC version in the interface protocol; MIDI streaming interfaces are
+// left to other decoders.
+func parseClassDescriptor(iface *gousb.InterfaceDescriptor, b []byte) (gousb.Descriptor, error) {
+	if len(b) < 3 || iface.InterfaceSubClass == SubClassMIDIStreaming {
+		return nil, nil
+	}
+	version := 1
+	if iface.InterfaceProtocol == ProtocolUAC2 {
+		version = 2
+	}
+	var v interface{}
+	var err error
+	switch {
+	case b[1] == DescriptorTypeCSEndpoint:
+		if eg := parseEndpointDescriptor(b, version); eg != nil {
+			v = eg
+		}
+	case iface.InterfaceSubClass == SubClassAudioControl:
+		v, err = parseACDescriptor(b, version)
+	case iface.InterfaceSubClass == SubClassAudioStreaming && b[2] == ASGeneral:
+		v, err = parseGeneral(b, version)
+	case iface.InterfaceSubClass == SubClassAudioStreaming && b[2] == ASFormatType:
+		v, err = parseFormat(b, version)
+	}
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return &ClassDescriptor{
+		Length:            b[0],
+		DescriptorType:    b[1],
+		DescriptorSubtype: b[2],
+		Value:             v,
+	}, nil
+}
+
+func init() {
+	gousb.RegisterDescriptor(gousb.ClassAudio, DescriptorTypeCSInterface, parseClassDescriptor)
+	gousb.RegisterDescriptor(gousb.ClassAudio, DescriptorTypeCSEndpoint, parseClassDescriptor)
+}
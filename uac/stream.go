@@ -0,0 +1,297 @@
+package uac
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/op0xA5/gousb"
+)
+
+// packetsPerTransfer is the number of service intervals per isochronous
+// transfer, 8ms at full speed.
+const packetsPerTransfer = 8
+
+// Stream is a running PCM stream on one alternate setting. OUT streams are
+// written, IN streams are read; both carry interleaved little endian
+// samples in the alternate setting's subslot size.
+type Stream struct {
+	d   *Device
+	s   *Streaming
+	alt *Alt
+	iso *gousb.IsoTransfer
+
+	frameSize   int
+	interval    uint32 // (micro)frames per service interval
+	intervalsPS int    // service intervals per second
+	nominal     uint32 // samples per interval, 16.16
+	accum       uint32
+	pending     []byte
+	rbuf        []byte
+
+	fb      *gousb.IsoTransfer
+	mu      sync.Mutex
+	rate    uint32 // samples per interval from feedback, 16.16
+	closing bool
+	wg      sync.WaitGroup
+}
+
+func (d *Device) streamOf(alt *Alt) *Streaming {
+	for _, s := range d.streams {
+		for _, a := range s.Alts {
+			if a == alt {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+// Start selects alt, sets the sample rate and starts streaming.
+func (d *Device) Start(alt *Alt, rate uint32) (*Stream, error) {
+	s := d.streamOf(alt)
+	if s == nil {
+		return nil, errors.New("uac: unknown alternate setting")
+	}
+	if alt.Format.FormatType != FormatTypeI || alt.FrameSize() == 0 {
+		return nil, errors.New("uac: not a pcm format")
+	}
+	if rate == 0 {
+		return nil, errors.New("uac: invalid sample rate")
+	}
+	if err := d.claim(s); err != nil {
+		return nil, err
+	}
+	if d.ac.Version == 2 {
+		// UAC2 clocks are set before the alternate setting is selected
+		if err := d.SetSampleRate(alt, rate); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.h.SetInterfaceAltSetting(int(s.Number), int(alt.Interface.AlternateSetting)); err != nil {
+		return nil, err
+	}
+	if d.ac.Version == 1 {
+		if err := d.SetSampleRate(alt, rate); err != nil {
+			d.h.SetInterfaceAltSetting(int(s.Number), 0)
+			return nil, err
+		}
+	}
+
+	ep := alt.Endpoint
+	interval := uint32(1)
+	if ep.Interval > 1 {
+		interval = 1 << uint(ep.Interval-1)
+	}
+	ips := 1000
+	if speed := d.h.GetDevice().Speed; speed >= gousb.UsbSpeedHigh {
+		ips = 8000
+	}
+	st := &Stream{
+		d:           d,
+		s:           s,
+		alt:         alt,
+		frameSize:   alt.FrameSize(),
+		interval:    interval,
+		intervalsPS: ips / int(interval),
+	}
+	st.nominal = uint32(uint64(rate) << 16 / uint64(st.intervalsPS))
+	st.iso = d.h.GetIsoTransfer(ep.EndpointAddress, packetsPerTransfer, 0)
+	if st.iso.PacketSize()/st.frameSize == 0 {
+		// not even one frame fits a packet, Write could never send
+		st.iso.Close()
+		d.h.SetInterfaceAltSetting(int(s.Number), 0)
+		return nil, errors.New("uac: packet size below frame size")
+	}
+
+	if alt.Feedback != nil && !alt.IsInput() {
+		st.fb = d.h.GetIsoTransfer(alt.Feedback.EndpointAddress, 1, 0)
+		st.fb.SetTimeout(100)
+		st.wg.Add(1)
+		go st.feedback()
+	}
+	return st, nil
+}
+
+func (st *Stream) Alt() *Alt {
+	return st.alt
+}
+
+func (st *Stream) SetTimeout(timeout uint) {
+	st.iso.SetTimeout(timeout)
+}
+
+// feedback keeps reading the explicit feedback endpoint and tracks the
+// device's requested rate.
+func (st *Stream) feedback() {
+	defer st.wg.Done()
+	for {
+		st.mu.Lock()
+		closing := st.closing
+		st.mu.Unlock()
+		if closing {
+			return
+		}
+		packets, err := st.fb.ReadPackets()
+		if err == gousb.ErrTimeout {
+			continue
+		}
+		if err != nil {
+			// keep streaming at the last rate the device asked for
+			return
+		}
+		for _, p := range packets {
+			if p.Err != nil {
+				continue
+			}
+			var v uint32
+			switch len(p.Data) {
+			case 3:
+				// full speed 10.14
+				v = (uint32(p.Data[0]) | uint32(p.Data[1])<<8 | uint32(p.Data[2])<<16) << 2
+			case 4:
+				// high speed 16.16
+				v = uint32(p.Data[0]) | uint32(p.Data[1])<<8 | uint32(p.Data[2])<<16 | uint32(p.Data[3])<<24
+			default:
+				continue
+			}
+			// feedback counts samples per (micro)frame
+			v *= st.interval
+			// ignore values more than 25% off, some devices send a
+			// different format than the spec asks for
+			if v < st.nominal-st.nominal/4 || v > st.nominal+st.nominal/4 {
+				continue
+			}
+			st.mu.Lock()
+			st.rate = v
+			st.mu.Unlock()
+		}
+	}
+}
+
+// Rate returns the effective sample rate, following the feedback endpoint
+// if there is one.
+func (st *Stream) Rate() float64 {
+	st.mu.Lock()
+	rate := st.rate
+	st.mu.Unlock()
+	if rate == 0 {
+		rate = st.nominal
+	}
+	return float64(rate) * float64(st.intervalsPS) / 65536
+}
+
+// packetFrames returns the sample frames for the next count packets
+// without committing the accumulator.
+func (st *Stream) packetFrames(count int) ([]int, uint32) {
+	st.mu.Lock()
+	rate := st.rate
+	st.mu.Unlock()
+	if rate == 0 {
+		rate = st.nominal
+	}
+	maxFrames := st.iso.PacketSize() / st.frameSize
+	frames := make([]int, count)
+	accum := st.accum
+	for i := range frames {
+		accum += rate
+		n := int(accum >> 16)
+		accum &= 0xffff
+		if n > maxFrames {
+			n = maxFrames
+		}
+		frames[i] = n
+	}
+	return frames, accum
+}
+
+// Write queues PCM data. Data is sent in whole transfers; the remainder is
+// kept for the next Write or Flush.
+func (st *Stream) Write(p []byte) (int, error) {
+	if st.alt.IsInput() {
+		return 0, errors.New("uac: input stream")
+	}
+	st.pending = append(st.pending, p...)
+	for {
+		frames, accum := st.packetFrames(packetsPerTransfer)
+		total := 0
+		for _, n := range frames {
+			total += n * st.frameSize
+		}
+		if total == 0 || total > len(st.pending) {
+			break
+		}
+		if err := st.send(frames); err != nil {
+			return 0, err
+		}
+		st.accum = accum
+	}
+	return len(p), nil
+}
+
+func (st *Stream) send(frames []int) error {
+	packets := make([][]byte, len(frames))
+	off := 0
+	for i, n := range frames {
+		size := n * st.frameSize
+		if off+size > len(st.pending) {
+			size = (len(st.pending) - off) / st.frameSize * st.frameSize
+		}
+		packets[i] = st.pending[off : off+size]
+		off += size
+	}
+	if err := st.iso.WritePackets(packets); err != nil {
+		return err
+	}
+	st.pending = st.pending[:copy(st.pending, st.pending[off:])]
+	return nil
+}
+
+// Flush sends the queued remainder and waits for all transfers.
+func (st *Stream) Flush() error {
+	if st.alt.IsInput() {
+		return nil
+	}
+	if len(st.pending) >= st.frameSize {
+		frames, accum := st.packetFrames(packetsPerTransfer)
+		if err := st.send(frames); err != nil {
+			return err
+		}
+		st.accum = accum
+	}
+	st.pending = st.pending[:0]
+	return st.iso.Flush()
+}
+
+// Read returns captured PCM data.
+func (st *Stream) Read(p []byte) (int, error) {
+	if !st.alt.IsInput() {
+		return 0, errors.New("uac: output stream")
+	}
+	for len(st.rbuf) == 0 {
+		packets, err := st.iso.ReadPackets()
+		if err != nil {
+			return 0, err
+		}
+		for _, pkt := range packets {
+			if pkt.Err == nil {
+				st.rbuf = append(st.rbuf, pkt.Data...)
+			}
+		}
+	}
+	n := copy(p, st.rbuf)
+	st.rbuf = st.rbuf[:copy(st.rbuf, st.rbuf[n:])]
+	return n, nil
+}
+
+// Close stops the stream and selects the zero-bandwidth setting.
+func (st *Stream) Close() error {
+	if st.fb != nil {
+		st.mu.Lock()
+		st.closing = true
+		st.mu.Unlock()
+		st.wg.Wait()
+		st.fb.Close()
+	}
+	st.iso.Close()
+	return st.d.h.SetInterfaceAltSetting(int(st.s.Number), 0)
+}
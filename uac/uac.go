@@ -0,0 +1,459 @@
+// Package uac drives USB Audio Class 1 and 2 devices: descriptor parsing,
+// sample rate and feature unit controls, and isochronous PCM streaming.
+package uac
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/op0xA5/gousb"
+)
+
+// interface subclasses and protocols
+const (
+	SubClassAudioControl   = uint8(0x01)
+	SubClassAudioStreaming = uint8(0x02)
+	SubClassMIDIStreaming  = uint8(0x03)
+
+	ProtocolUAC1 = uint8(0x00)
+	ProtocolUAC2 = uint8(0x20)
+)
+
+// UAC1 requests
+const (
+	RequestSetCur = uint8(0x01)
+	RequestGetCur = uint8(0x81)
+	RequestGetMin = uint8(0x82)
+	RequestGetMax = uint8(0x83)
+	RequestGetRes = uint8(0x84)
+)
+
+// UAC2 requests, direction given by the request type
+const (
+	Request2Cur   = uint8(0x01)
+	Request2Range = uint8(0x02)
+)
+
+// feature unit control selectors
+const (
+	FUMuteControl   = uint8(0x01)
+	FUVolumeControl = uint8(0x02)
+	FUBassControl   = uint8(0x03)
+	FUMidControl    = uint8(0x04)
+	FUTrebleControl = uint8(0x05)
+	FUAGCControl    = uint8(0x07)
+)
+
+// sampling frequency control selectors
+const (
+	EPSamplingFreqControl = uint8(0x01) // UAC1 endpoint
+	CSSamFreqControl      = uint8(0x01) // UAC2 clock source
+	CSClockValidControl   = uint8(0x02)
+)
+
+const requestIn = gousb.EndpointIn | gousb.RequestTypeClass | gousb.RecipientInterface
+const requestOut = gousb.EndpointOut | gousb.RequestTypeClass | gousb.RecipientInterface
+const endpointIn = gousb.EndpointIn | gousb.RequestTypeClass | gousb.RecipientEndpoint
+const endpointOut = gousb.EndpointOut | gousb.RequestTypeClass | gousb.RecipientEndpoint
+
+// Alt is one alternate setting of an AudioStreaming interface.
+type Alt struct {
+	Interface *gousb.Interface
+	General   *General
+	Format    *Format
+	Endpoint  *gousb.Endpoint
+	// Feedback is the explicit feedback endpoint of an asynchronous OUT
+	// endpoint, nil otherwise.
+	Feedback        *gousb.Endpoint
+	EndpointGeneral *EndpointGeneral
+}
+
+// Channels returns the channel count of the alternate setting.
+func (alt *Alt) Channels() int {
+	if alt.Format.NrChannels != 0 {
+		return int(alt.Format.NrChannels)
+	}
+	return int(alt.General.NrChannels)
+}
+
+// FrameSize returns the bytes per sample frame over all channels.
+func (alt *Alt) FrameSize() int {
+	return alt.Channels() * int(alt.Format.SubslotSize)
+}
+
+func (alt *Alt) IsInput() bool {
+	return alt.Endpoint.InOut() == gousb.EndpointIn
+}
+
+// Asynchronous reports whether the data endpoint uses asynchronous
+// synchronization.
+func (alt *Alt) Asynchronous() bool {
+	return alt.Endpoint.Attributes&0x0C == 0x04
+}
+
+// Streaming is an AudioStreaming interface with its operational alternate
+// settings.
+type Streaming struct {
+	Number uint8
+	Alts   []*Alt
+
+	claimed bool
+}
+
+// FindAlt returns the first alternate setting of a PCM format with the
+// channel count and bit resolution.
+func (s *Streaming) FindAlt(channels, bits int) *Alt {
+	for _, alt := range s.Alts {
+		if alt.Format.FormatType == FormatTypeI && alt.Channels() == channels && int(alt.Format.BitResolution) == bits {
+			return alt
+		}
+	}
+	return nil
+}
+
+// Device is a claimed AudioControl interface and its streaming
+// interfaces.
+type Device struct {
+	h       *gousb.Handle
+	ctrl    *gousb.Interface
+	ac      *AudioControl
+	streams []*Streaming
+	claimed bool
+}
+
+// Open finds the first AudioControl interface of the active configuration
+// and claims it.
+func Open(h *gousb.Handle) (*Device, error) {
+	cfg, err := h.GetActiveConfigDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range cfg.InterfacesByClass(gousb.ClassAudio) {
+		if iface.InterfaceSubClass == SubClassAudioControl {
+			return New(h, cfg, iface)
+		}
+	}
+	return nil, gousb.ErrNotFound
+}
+
+// New claims the AudioControl interface ctrl and collects its streaming
+// interfaces from cfg.
+func New(h *gousb.Handle, cfg *gousb.Configuration, ctrl *gousb.Interface) (*Device, error) {
+	version := 1
+	if ctrl.InterfaceProtocol == ProtocolUAC2 {
+		version = 2
+	}
+	ac, err := ParseAudioControl(ctrl.Extra, version)
+	if err != nil {
+		return nil, err
+	}
+	d := &Device{h: h, ctrl: ctrl, ac: ac}
+
+	numbers := ac.InterfaceNumbers
+	if version == 2 {
+		// UAC2 has no collection in the header; the interface association
+		// groups the function, without one take the streaming interfaces
+		// following the control interface
+		interfaces := cfg.Interfaces
+		fn := cfg.Function(ctrl.InterfaceNumber)
+		if fn != nil {
+			interfaces = fn.Interfaces
+		}
+		for _, iface := range interfaces {
+			if iface.InterfaceClass == gousb.ClassAudio && iface.InterfaceSubClass == SubClassAudioStreaming &&
+				iface.AlternateSetting == 0 && (fn != nil || iface.InterfaceNumber > ctrl.InterfaceNumber) {
+				numbers = append(numbers, iface.InterfaceNumber)
+			}
+		}
+	}
+	for _, number := range numbers {
+		s := &Streaming{Number: number}
+		for _, iface := range cfg.Interfaces {
+			if iface.InterfaceNumber != number || iface.InterfaceSubClass != SubClassAudioStreaming {
+				continue
+			}
+			if alt := parseAlt(iface); alt != nil {
+				s.Alts = append(s.Alts, alt)
+			}
+		}
+		if len(s.Alts) > 0 {
+			d.streams = append(d.streams, s)
+		}
+	}
+	if err := h.ClaimInterface(int(ctrl.InterfaceNumber)); err != nil {
+		return nil, err
+	}
+	d.claimed = true
+	return d, nil
+}
+
+func parseAlt(iface *gousb.Interface) *Alt {
+	list, err := classValues(&iface.InterfaceDescriptor, iface.Extra)
+	if err != nil {
+		return nil
+	}
+	alt := &Alt{Interface: iface}
+	for _, v := range list {
+		switch v := v.(type) {
+		case *General:
+			alt.General = v
+		case *Format:
+			alt.Format = v
+		}
+	}
+	for _, ep := range iface.Endpoints {
+		if ep.TransferType() != gousb.TransferTypeIsochronous {
+			continue
+		}
+		// usage type bits 4..5: 0 data, 1 explicit feedback
+		if ep.Attributes&0x30 == 0x10 {
+			alt.Feedback = ep
+		} else if alt.Endpoint == nil {
+			alt.Endpoint = ep
+			list, _ := classValues(&iface.InterfaceDescriptor, ep.Extra)
+			for _, v := range list {
+				if eg, ok := v.(*EndpointGeneral); ok {
+					alt.EndpointGeneral = eg
+				}
+			}
+		}
+	}
+	if alt.General == nil || alt.Format == nil || alt.Endpoint == nil {
+		return nil
+	}
+	return alt
+}
+
+func (d *Device) Close() error {
+	if !d.claimed {
+		return nil
+	}
+	d.claimed = false
+	for _, s := range d.streams {
+		d.release(s)
+	}
+	return d.h.ReleaseInterface(int(d.ctrl.InterfaceNumber))
+}
+
+func (d *Device) Version() int {
+	return d.ac.Version
+}
+func (d *Device) AudioControl() *AudioControl {
+	return d.ac
+}
+func (d *Device) Streams() []*Streaming {
+	return d.streams
+}
+
+func (d *Device) claim(s *Streaming) error {
+	if s.claimed {
+		return nil
+	}
+	if err := d.h.ClaimInterface(int(s.Number)); err != nil {
+		return err
+	}
+	s.claimed = true
+	return nil
+}
+func (d *Device) release(s *Streaming) {
+	if s.claimed {
+		d.h.SetInterfaceAltSetting(int(s.Number), 0)
+		d.h.ReleaseInterface(int(s.Number))
+		s.claimed = false
+	}
+}
+
+func (d *Device) entityIndex(id uint8) uint16 {
+	return uint16(id)<<8 | uint16(d.ctrl.InterfaceNumber)
+}
+
+// get and set issue a UAC1 GET_xxx/SET_CUR or a UAC2 CUR/RANGE request
+// to an entity of the control interface.
+func (d *Device) get(req, id, selector, channel uint8, b []byte) error {
+	n, err := d.h.ControlTransfer(requestIn, req, uint16(selector)<<8|uint16(channel), d.entityIndex(id), b)
+	if err != nil {
+		return err
+	}
+	if n < len(b) {
+		return errors.New("uac: short control value")
+	}
+	return nil
+}
+func (d *Device) set(req, id, selector, channel uint8, b []byte) error {
+	_, err := d.h.ControlTransfer(requestOut, req, uint16(selector)<<8|uint16(channel), d.entityIndex(id), b)
+	return err
+}
+
+// volume values are signed 1/256 dB
+func volumeDB(v uint16) float64 {
+	return float64(int16(v)) / 256
+}
+func dbVolume(db float64) uint16 {
+	v := db * 256
+	if v < -32767 {
+		v = -32767
+	}
+	if v > 32767 {
+		v = 32767
+	}
+	return uint16(int16(v))
+}
+
+// Volume returns the volume of channel (0 is master) in dB.
+func (d *Device) Volume(unit, channel uint8) (float64, error) {
+	req := RequestGetCur
+	if d.ac.Version == 2 {
+		req = Request2Cur
+	}
+	b := make([]byte, 2)
+	if err := d.get(req, unit, FUVolumeControl, channel, b); err != nil {
+		return 0, err
+	}
+	return volumeDB(binary.LittleEndian.Uint16(b)), nil
+}
+func (d *Device) SetVolume(unit, channel uint8, db float64) error {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, dbVolume(db))
+	return d.set(RequestSetCur, unit, FUVolumeControl, channel, b)
+}
+
+// VolumeRange returns the minimum, maximum and resolution in dB.
+func (d *Device) VolumeRange(unit, channel uint8) (min, max, res float64, err error) {
+	le := binary.LittleEndian
+	if d.ac.Version == 2 {
+		// wNumSubRanges followed by MIN, MAX, RES triplets; report the
+		// overall span and the first resolution
+		b := make([]byte, 2+6*8)
+		n, err := d.h.ControlTransfer(requestIn, Request2Range, uint16(FUVolumeControl)<<8|uint16(channel), d.entityIndex(unit), b)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if n < 8 || le.Uint16(b) == 0 {
+			return 0, 0, 0, errors.New("uac: short range")
+		}
+		count := int(le.Uint16(b))
+		if 2+6*count > n {
+			count = (n - 2) / 6
+		}
+		min, max, res = volumeDB(le.Uint16(b[2:])), volumeDB(le.Uint16(b[4:])), volumeDB(le.Uint16(b[6:]))
+		for i := 1; i < count; i++ {
+			if v := volumeDB(le.Uint16(b[4+6*i:])); v > max {
+				max = v
+			}
+		}
+		return min, max, res, nil
+	}
+	var vals [3]float64
+	for i, req := range []uint8{RequestGetMin, RequestGetMax, RequestGetRes} {
+		b := make([]byte, 2)
+		if err := d.get(req, unit, FUVolumeControl, channel, b); err != nil {
+			return 0, 0, 0, err
+		}
+		vals[i] = volumeDB(le.Uint16(b))
+	}
+	return vals[0], vals[1], vals[2], nil
+}
+
+func (d *Device) Mute(unit, channel uint8) (bool, error) {
+	req := RequestGetCur
+	if d.ac.Version == 2 {
+		req = Request2Cur
+	}
+	b := make([]byte, 1)
+	if err := d.get(req, unit, FUMuteControl, channel, b); err != nil {
+		return false, err
+	}
+	return b[0] != 0, nil
+}
+func (d *Device) SetMute(unit, channel uint8, mute bool) error {
+	b := []byte{0}
+	if mute {
+		b[0] = 1
+	}
+	return d.set(RequestSetCur, unit, FUMuteControl, channel, b)
+}
+
+// SampleRate returns the current rate of the stream's endpoint (UAC1) or
+// clock source (UAC2).
+func (d *Device) SampleRate(alt *Alt) (uint32, error) {
+	if d.ac.Version == 2 {
+		clock := d.ac.ClockSourceFor(alt.General.TerminalLink)
+		if clock == nil {
+			return 0, errors.New("uac: clock source not found")
+		}
+		b := make([]byte, 4)
+		if err := d.get(Request2Cur, clock.ID, CSSamFreqControl, 0, b); err != nil {
+			return 0, err
+		}
+		return binary.LittleEndian.Uint32(b), nil
+	}
+	b := make([]byte, 3)
+	n, err := d.h.ControlTransfer(endpointIn, RequestGetCur, uint16(EPSamplingFreqControl)<<8, uint16(alt.Endpoint.EndpointAddress), b)
+	if err != nil {
+		return 0, err
+	}
+	if n < 3 {
+		return 0, errors.New("uac: short sample rate")
+	}
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16, nil
+}
+
+// clockSupportsRate reports whether rate is in one of the sampling
+// frequency ranges of a UAC2 clock source.
+func (d *Device) clockSupportsRate(clock *Clock, rate uint32) (bool, error) {
+	le := binary.LittleEndian
+	// wNumSubRanges followed by MIN, MAX, RES triplets of 4 bytes
+	b := make([]byte, 2+12*32)
+	n, err := d.h.ControlTransfer(requestIn, Request2Range, uint16(CSSamFreqControl)<<8, d.entityIndex(clock.ID), b)
+	if err != nil {
+		return false, err
+	}
+	if n < 14 {
+		return false, errors.New("uac: short range")
+	}
+	count := int(le.Uint16(b))
+	if 2+12*count > n {
+		count = (n - 2) / 12
+	}
+	for i := 0; i < count; i++ {
+		min, max, res := le.Uint32(b[2+12*i:]), le.Uint32(b[6+12*i:]), le.Uint32(b[10+12*i:])
+		if rate < min || rate > max {
+			continue
+		}
+		if res == 0 || (rate-min)%res == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetSampleRate sets the sampling frequency for alt. UAC1 sets it on the
+// endpoint, so alt has to be selected first.
+func (d *Device) SetSampleRate(alt *Alt, rate uint32) error {
+	if d.ac.Version == 2 {
+		clock := d.ac.ClockSourceFor(alt.General.TerminalLink)
+		if clock == nil {
+			return errors.New("uac: clock source not found")
+		}
+		ok, err := d.clockSupportsRate(clock, rate)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("uac: unsupported sample rate")
+		}
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, rate)
+		return d.set(Request2Cur, clock.ID, CSSamFreqControl, 0, b)
+	}
+	if !alt.Format.SupportsRate(rate) {
+		return errors.New("uac: unsupported sample rate")
+	}
+	if alt.EndpointGeneral != nil && !alt.EndpointGeneral.SampleRateControl() {
+		// fixed rate endpoint
+		return nil
+	}
+	b := []byte{uint8(rate), uint8(rate >> 8), uint8(rate >> 16)}
+	_, err := d.h.ControlTransfer(endpointOut, RequestSetCur, uint16(EPSamplingFreqControl)<<8, uint16(alt.Endpoint.EndpointAddress), b)
+	return err
+}
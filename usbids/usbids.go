@@ -0,0 +1,330 @@
+// Package usbids looks up vendor, product, class, HID usage and language
+// names in the usb.ids database maintained at http://www.linux-usb.org/usb-ids.html.
+// The database is loaded from the first of Paths that exists; without one
+// only the base class names are known.
+package usbids
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Paths are the locations Default looks for usb.ids in.
+var Paths = []string{
+	"/usr/share/hwdata/usb.ids",
+	"/usr/share/misc/usb.ids",
+	"/usr/share/usb.ids",
+	"/var/lib/usbutils/usb.ids",
+	"/usr/local/share/usb.ids",
+}
+
+type vendor struct {
+	name     string
+	products map[uint16]string
+}
+
+type class struct {
+	name       string
+	subclasses map[uint8]*subclass
+}
+
+type subclass struct {
+	name      string
+	protocols map[uint8]string
+}
+
+type usagePage struct {
+	name   string
+	usages map[uint16]string
+}
+
+type language struct {
+	name     string
+	dialects map[uint8]string
+}
+
+// DB is a parsed usb.ids database.
+type DB struct {
+	vendors   map[uint16]*vendor
+	classes   map[uint8]*class
+	pages     map[uint16]*usagePage
+	languages map[uint16]*language
+}
+
+func newDB() *DB {
+	return &DB{
+		vendors:   make(map[uint16]*vendor),
+		classes:   make(map[uint8]*class),
+		pages:     make(map[uint16]*usagePage),
+		languages: make(map[uint16]*language),
+	}
+}
+
+// splitID splits "hhhh  name" into the hex ID and the name.
+func splitID(s string, bits int) (uint64, string, bool) {
+	i := strings.IndexAny(s, " \t")
+	if i <= 0 {
+		return 0, "", false
+	}
+	id, err := strconv.ParseUint(s[:i], 16, bits)
+	if err != nil {
+		return 0, "", false
+	}
+	return id, strings.TrimSpace(s[i:]), true
+}
+
+// Parse reads a database in usb.ids format. Sections other than vendors,
+// classes, HID usages and languages are skipped; malformed lines are
+// ignored like lsusb does.
+func Parse(r io.Reader) (*DB, error) {
+	db := newDB()
+	var (
+		v    *vendor
+		c    *class
+		sc   *subclass
+		page *usagePage
+		lang *language
+	)
+	reset := func() {
+		v, c, sc, page, lang = nil, nil, nil, nil, nil
+	}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "\t\t"):
+			if sc != nil {
+				if id, name, ok := splitID(line[2:], 8); ok {
+					sc.protocols[uint8(id)] = name
+				}
+			}
+			// interfaces of products are not kept
+		case line[0] == '\t':
+			switch {
+			case v != nil:
+				if id, name, ok := splitID(line[1:], 16); ok {
+					v.products[uint16(id)] = name
+				}
+			case c != nil:
+				if id, name, ok := splitID(line[1:], 8); ok {
+					sc = &subclass{name: name, protocols: make(map[uint8]string)}
+					c.subclasses[uint8(id)] = sc
+				}
+			case page != nil:
+				if id, name, ok := splitID(line[1:], 16); ok {
+					page.usages[uint16(id)] = name
+				}
+			case lang != nil:
+				if id, name, ok := splitID(line[1:], 8); ok {
+					lang.dialects[uint8(id)] = name
+				}
+			}
+		default:
+			reset()
+			switch {
+			case strings.HasPrefix(line, "C "):
+				if id, name, ok := splitID(line[2:], 8); ok {
+					c = &class{name: name, subclasses: make(map[uint8]*subclass)}
+					db.classes[uint8(id)] = c
+				}
+			case strings.HasPrefix(line, "HUT "):
+				if id, name, ok := splitID(line[4:], 16); ok {
+					page = &usagePage{name: name, usages: make(map[uint16]string)}
+					db.pages[uint16(id)] = page
+				}
+			case strings.HasPrefix(line, "L "):
+				if id, name, ok := splitID(line[2:], 16); ok {
+					lang = &language{name: name, dialects: make(map[uint8]string)}
+					db.languages[uint16(id)] = lang
+				}
+			default:
+				// vendor lines start with four hex digits, other
+				// sections with a keyword
+				if id, name, ok := splitID(line, 16); ok && len(line) > 4 && line[4] == ' ' {
+					v = &vendor{name: name, products: make(map[uint16]string)}
+					db.vendors[uint16(id)] = v
+				}
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Load parses the database at path.
+func Load(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// base class names, used when no database is found
+var baseClasses = map[uint8]string{
+	0x00: "(Defined at Interface level)",
+	0x01: "Audio",
+	0x02: "Communications",
+	0x03: "Human Interface Device",
+	0x05: "Physical Interface Device",
+	0x06: "Imaging",
+	0x07: "Printer",
+	0x08: "Mass Storage",
+	0x09: "Hub",
+	0x0a: "CDC Data",
+	0x0b: "Chip/SmartCard",
+	0x0d: "Content Security",
+	0x0e: "Video",
+	0x0f: "Personal Healthcare",
+	0x10: "Audio/Video",
+	0x11: "Billboard",
+	0x12: "Type-C Bridge",
+	0xdc: "Diagnostic",
+	0xe0: "Wireless",
+	0xef: "Miscellaneous Device",
+	0xfe: "Application Specific Interface",
+	0xff: "Vendor Specific Class",
+}
+
+var (
+	defaultOnce sync.Once
+	defaultMu   sync.RWMutex
+	defaultDB   *DB
+)
+
+// Default returns the database loaded from Paths on first use.
+func Default() *DB {
+	defaultOnce.Do(func() {
+		defaultMu.RLock()
+		set := defaultDB != nil
+		defaultMu.RUnlock()
+		if set {
+			return
+		}
+		var db *DB
+		for _, path := range Paths {
+			var err error
+			if db, err = Load(path); err == nil {
+				break
+			}
+		}
+		if db == nil {
+			db = baseDB()
+		}
+		defaultMu.Lock()
+		if defaultDB == nil {
+			defaultDB = db
+		}
+		defaultMu.Unlock()
+	})
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultDB
+}
+
+// baseDB returns the database used without usb.ids, which only knows the
+// base class names.
+func baseDB() *DB {
+	db := newDB()
+	for id, name := range baseClasses {
+		db.classes[id] = &class{name: name, subclasses: make(map[uint8]*subclass)}
+	}
+	return db
+}
+
+// SetDefault replaces the database used by the package level lookups, for
+// programs that ship their own copy of usb.ids. A nil db behaves as if no
+// usb.ids was found.
+func SetDefault(db *DB) {
+	if db == nil {
+		db = baseDB()
+	}
+	defaultMu.Lock()
+	defaultDB = db
+	defaultMu.Unlock()
+}
+
+// Vendor returns the name of vendor ID v, "" if unknown.
+func (db *DB) Vendor(v uint16) string {
+	if vendor := db.vendors[v]; vendor != nil {
+		return vendor.name
+	}
+	return ""
+}
+func (db *DB) Product(v, p uint16) string {
+	if vendor := db.vendors[v]; vendor != nil {
+		return vendor.products[p]
+	}
+	return ""
+}
+
+func (db *DB) Class(c uint8) string {
+	if class := db.classes[c]; class != nil {
+		return class.name
+	}
+	return ""
+}
+func (db *DB) SubClass(c, s uint8) string {
+	if class := db.classes[c]; class != nil {
+		if sc := class.subclasses[s]; sc != nil {
+			return sc.name
+		}
+	}
+	return ""
+}
+func (db *DB) Protocol(c, s, p uint8) string {
+	if class := db.classes[c]; class != nil {
+		if sc := class.subclasses[s]; sc != nil {
+			return sc.protocols[p]
+		}
+	}
+	return ""
+}
+
+// UsagePage and Usage return HID usage table names.
+func (db *DB) UsagePage(page uint16) string {
+	if p := db.pages[page]; p != nil {
+		return p.name
+	}
+	return ""
+}
+func (db *DB) Usage(page, usage uint16) string {
+	if p := db.pages[page]; p != nil {
+		return p.usages[usage]
+	}
+	return ""
+}
+
+// Language returns the name of a LANGID as in "English (US)": the low 10
+// bits select the primary language, the upper 6 bits its dialect.
+func (db *DB) Language(langid uint16) string {
+	lang := db.languages[langid&0x3ff]
+	if lang == nil {
+		return ""
+	}
+	if dialect := lang.dialects[uint8(langid>>10)]; dialect != "" {
+		return lang.name + " (" + dialect + ")"
+	}
+	return lang.name
+}
+
+// package level lookups in the Default database
+
+func Vendor(v uint16) string          { return Default().Vendor(v) }
+func Product(v, p uint16) string      { return Default().Product(v, p) }
+func Class(c uint8) string            { return Default().Class(c) }
+func SubClass(c, s uint8) string      { return Default().SubClass(c, s) }
+func Protocol(c, s, p uint8) string   { return Default().Protocol(c, s, p) }
+func UsagePage(page uint16) string    { return Default().UsagePage(page) }
+func Usage(page, usage uint16) string { return Default().Usage(page, usage) }
+func Language(langid uint16) string   { return Default().Language(langid) }
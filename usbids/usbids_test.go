@@ -0,0 +1,67 @@
+package usbids
+
+import (
+	"strings"
+	"testing"
+)
+
+const testIDs = `# usb.ids excerpt
+1d50  OpenMoko, Inc.
+	6018  Black Magic Debug Probe (Application)
+		00  interfaces of products are not kept
+	zzzz  malformed product
+046d  Logitech, Inc.
+	c52b  Unifying Receiver
+C 02  Communications
+	02  Abstract (modem)
+		01  AT-commands (v.25ter)
+C 03  Human Interface Device
+HUT 01  Generic Desktop Controls
+	006  Keyboard
+L 0009  English
+	01  US
+	02  UK
+AT 0001  Audio terminal types are skipped
+`
+
+func TestParse(t *testing.T) {
+	db, err := Parse(strings.NewReader(testIDs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct{ got, want string }{
+		{db.Vendor(0x1d50), "OpenMoko, Inc."},
+		{db.Product(0x1d50, 0x6018), "Black Magic Debug Probe (Application)"},
+		{db.Product(0x046d, 0xc52b), "Unifying Receiver"},
+		{db.Product(0x046d, 0x6018), ""},
+		{db.Vendor(0x0001), ""},
+		{db.Class(0x02), "Communications"},
+		{db.SubClass(0x02, 0x02), "Abstract (modem)"},
+		{db.Protocol(0x02, 0x02, 0x01), "AT-commands (v.25ter)"},
+		{db.Protocol(0x03, 0x01, 0x01), ""},
+		{db.UsagePage(0x01), "Generic Desktop Controls"},
+		{db.Usage(0x01, 0x06), "Keyboard"},
+		{db.Language(0x0409), "English (US)"},
+		{db.Language(0x0809), "English (UK)"},
+		{db.Language(0x7c09), "English"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+	if len(db.vendors) != 2 || len(db.vendors[0x1d50].products) != 1 {
+		t.Errorf("vendors %v", db.vendors)
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	db, _ := Parse(strings.NewReader(testIDs))
+	SetDefault(db)
+	if Vendor(0x1d50) != "OpenMoko, Inc." || Class(0x08) != "" {
+		t.Errorf("package lookups do not use the database set")
+	}
+	SetDefault(nil)
+	if Vendor(0x1d50) != "" || Class(0x08) != "Mass Storage" {
+		t.Errorf("without a database: vendor %q, class %q", Vendor(0x1d50), Class(0x08))
+	}
+}
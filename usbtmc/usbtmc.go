@@ -0,0 +1,516 @@
+// Package usbtmc implements the USB Test and Measurement Class and its
+// USB488 subclass.
+package usbtmc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/op0xA5/gousb"
+)
+
+// interface class codes
+const (
+	SubClassTMC    = uint8(0x03)
+	ProtocolUSBTMC = uint8(0x00)
+	ProtocolUSB488 = uint8(0x01)
+)
+
+const (
+	headerLength     = 12
+	defaultChunkSize = 1024 * 1024
+)
+
+// bulk MsgID values
+const (
+	MsgDevDepMsgOut            = uint8(1)
+	MsgRequestDevDepMsgIn      = uint8(2)
+	MsgDevDepMsgIn             = uint8(2)
+	MsgVendorSpecificOut       = uint8(126)
+	MsgRequestVendorSpecificIn = uint8(127)
+	MsgVendorSpecificIn        = uint8(127)
+	MsgTrigger                 = uint8(128)
+)
+
+// class-specific requests
+const (
+	RequestInitiateAbortBulkOut    = uint8(1)
+	RequestCheckAbortBulkOutStatus = uint8(2)
+	RequestInitiateAbortBulkIn     = uint8(3)
+	RequestCheckAbortBulkInStatus  = uint8(4)
+	RequestInitiateClear           = uint8(5)
+	RequestCheckClearStatus        = uint8(6)
+	RequestGetCapabilities         = uint8(7)
+	RequestIndicatorPulse          = uint8(64)
+
+	RequestReadStatusByte = uint8(128)
+	RequestRENControl     = uint8(160)
+	RequestGoToLocal      = uint8(161)
+	RequestLocalLockout   = uint8(162)
+)
+
+// USBTMC_status values
+const (
+	StatusSuccess               = uint8(0x01)
+	StatusPending               = uint8(0x02)
+	StatusInterruptInBusy       = uint8(0x20)
+	StatusFailed                = uint8(0x80)
+	StatusTransferNotInProgress = uint8(0x81)
+	StatusSplitNotInProgress    = uint8(0x82)
+	StatusSplitInProgress       = uint8(0x83)
+)
+
+// StatusError is returned when a control request answers with a status
+// other than StatusSuccess.
+type StatusError struct {
+	Request uint8
+	Status  uint8
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("usbtmc: request %d: status 0x%02x", err.Request, err.Status)
+}
+
+// Capabilities is the response of GET_CAPABILITIES.
+type Capabilities struct {
+	BcdUSBTMC           uint16
+	InterfaceCaps       uint8
+	DeviceCaps          uint8
+	BcdUSB488           uint16
+	USB488InterfaceCaps uint8
+	USB488DeviceCaps    uint8
+}
+
+func (c *Capabilities) IndicatorPulse() bool {
+	return c.InterfaceCaps&0x04 != 0
+}
+func (c *Capabilities) TalkOnly() bool {
+	return c.InterfaceCaps&0x02 != 0
+}
+func (c *Capabilities) ListenOnly() bool {
+	return c.InterfaceCaps&0x01 != 0
+}
+func (c *Capabilities) TermChar() bool {
+	return c.DeviceCaps&0x01 != 0
+}
+func (c *Capabilities) Trigger() bool {
+	return c.USB488InterfaceCaps&0x01 != 0
+}
+func (c *Capabilities) RemoteLocal() bool {
+	return c.USB488InterfaceCaps&0x02 != 0
+}
+
+// Instrument is a claimed USBTMC interface.
+type Instrument struct {
+	h     *gousb.Handle
+	iface *gousb.Interface
+
+	epIn, epOut uint8
+	maxPacket   int
+	bulk        *gousb.BulkTransfer
+	intr        *gousb.InterruptTransfer
+
+	tag       uint8
+	statusTag uint8
+	termChar  byte
+	useTerm   bool
+	chunkSize int
+	caps      *Capabilities
+	claimed   bool
+}
+
+// Open finds the first USBTMC interface of the active configuration and
+// claims it.
+func Open(h *gousb.Handle) (*Instrument, error) {
+	cfg, err := h.GetActiveConfigDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range cfg.InterfacesByClass(gousb.ClassApplicationSpecific) {
+		if iface.InterfaceSubClass == SubClassTMC {
+			return New(h, iface)
+		}
+	}
+	return nil, gousb.ErrNotFound
+}
+
+func New(h *gousb.Handle, iface *gousb.Interface) (*Instrument, error) {
+	in := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk)
+	out := iface.FindEndpoint(gousb.EndpointOut, gousb.TransferTypeBulk)
+	if in == nil || out == nil {
+		return nil, errors.New("usbtmc: bulk endpoints not found")
+	}
+	if err := h.ClaimInterface(int(iface.InterfaceNumber)); err != nil {
+		return nil, err
+	}
+	inst := &Instrument{
+		h:         h,
+		iface:     iface,
+		epIn:      in.EndpointAddress,
+		epOut:     out.EndpointAddress,
+		maxPacket: int(in.MaxPacketSize & 0x7ff),
+		bulk:      h.GetBulkTransfer(in.EndpointAddress, out.EndpointAddress),
+		termChar:  '\n',
+		chunkSize: defaultChunkSize,
+		claimed:   true,
+	}
+	if inst.maxPacket == 0 {
+		inst.maxPacket = 512
+	}
+	if ep := iface.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeInterrupt); ep != nil {
+		inst.intr = h.GetInterruptTransfer(ep.EndpointAddress)
+	}
+	return inst, nil
+}
+
+func (inst *Instrument) Close() error {
+	if !inst.claimed {
+		return nil
+	}
+	inst.claimed = false
+	return inst.h.ReleaseInterface(int(inst.iface.InterfaceNumber))
+}
+
+func (inst *Instrument) SetTimeout(timeout uint) {
+	inst.bulk.SetTimeout(timeout)
+	if inst.intr != nil {
+		inst.intr.SetTimeout(timeout)
+	}
+}
+
+// SetTermChar makes the device end bulk IN transfers at c. It only works
+// on devices reporting Capabilities.TermChar.
+func (inst *Instrument) SetTermChar(c byte, enable bool) {
+	inst.termChar = c
+	inst.useTerm = enable
+}
+
+func (inst *Instrument) nextTag() uint8 {
+	inst.tag++
+	if inst.tag == 0 {
+		inst.tag = 1
+	}
+	return inst.tag
+}
+
+func (inst *Instrument) header(msgID uint8, size int, attr uint8, term byte) []byte {
+	tag := inst.nextTag()
+	b := make([]byte, headerLength)
+	b[0] = msgID
+	b[1] = tag
+	b[2] = ^tag
+	binary.LittleEndian.PutUint32(b[4:], uint32(size))
+	b[8] = attr
+	b[9] = term
+	return b
+}
+
+func (inst *Instrument) classIn(recipient gousb.RequestType, req uint8, value, index uint16, length int) ([]byte, error) {
+	buf := make([]byte, length)
+	n, err := inst.h.ControlTransfer(gousb.EndpointIn|gousb.RequestTypeClass|recipient, req, value, index, buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 {
+		return nil, errors.New("usbtmc: short control response")
+	}
+	return buf[:n], nil
+}
+func (inst *Instrument) interfaceRequest(req uint8, value uint16, length int) ([]byte, error) {
+	b, err := inst.classIn(gousb.RecipientInterface, req, value, uint16(inst.iface.InterfaceNumber), length)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != StatusSuccess {
+		return b, &StatusError{Request: req, Status: b[0]}
+	}
+	return b, nil
+}
+
+// Capabilities issues GET_CAPABILITIES; the result is cached.
+func (inst *Instrument) Capabilities() (*Capabilities, error) {
+	if inst.caps != nil {
+		return inst.caps, nil
+	}
+	b, err := inst.interfaceRequest(RequestGetCapabilities, 0, 0x18)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 16 {
+		return nil, errors.New("usbtmc: short capabilities")
+	}
+	inst.caps = &Capabilities{
+		BcdUSBTMC:           binary.LittleEndian.Uint16(b[2:]),
+		InterfaceCaps:       b[4],
+		DeviceCaps:          b[5],
+		BcdUSB488:           binary.LittleEndian.Uint16(b[12:]),
+		USB488InterfaceCaps: b[14],
+		USB488DeviceCaps:    b[15],
+	}
+	return inst.caps, nil
+}
+
+// Write sends p as one device dependent message, split into transfers of
+// at most the chunk size. The last transfer carries EOM.
+func (inst *Instrument) Write(p []byte) (n int, err error) {
+	for {
+		chunk := p[n:]
+		attr := uint8(0x01)
+		if len(chunk) > inst.chunkSize {
+			chunk, attr = chunk[:inst.chunkSize], 0
+		}
+		b := append(inst.header(MsgDevDepMsgOut, len(chunk), attr, 0), chunk...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+		if _, err := inst.bulk.Write(b); err != nil {
+			inst.AbortBulkOut()
+			return n, err
+		}
+		n += len(chunk)
+		if n == len(p) {
+			return n, nil
+		}
+	}
+}
+
+// read requests at most len(p) bytes and reports whether the device
+// flagged the end of the message.
+func (inst *Instrument) read(p []byte) (n int, eom bool, err error) {
+	attr := uint8(0)
+	if inst.useTerm {
+		attr = 0x02
+	}
+	req := inst.header(MsgRequestDevDepMsgIn, len(p), attr, inst.termChar)
+	tag := req[1]
+	if _, err := inst.bulk.Write(req); err != nil {
+		return 0, false, err
+	}
+
+	size := headerLength + len(p) + 3
+	if r := size % inst.maxPacket; r != 0 {
+		size += inst.maxPacket - r
+	}
+	buf := make([]byte, size)
+	got, err := inst.bulk.Read(buf)
+	if err != nil {
+		inst.AbortBulkIn()
+		return 0, false, err
+	}
+	if got < headerLength || buf[0] != MsgDevDepMsgIn || buf[1] != tag || buf[2] != ^tag {
+		inst.AbortBulkIn()
+		return 0, false, errors.New("usbtmc: bad response header")
+	}
+	length := int(binary.LittleEndian.Uint32(buf[4:]))
+	if length > len(p) {
+		return 0, false, errors.New("usbtmc: response exceeds request")
+	}
+	for got < headerLength+length {
+		m, err := inst.bulk.Read(buf[got:])
+		if err != nil {
+			return 0, false, err
+		}
+		if m == 0 {
+			return 0, false, errors.New("usbtmc: short response")
+		}
+		got += m
+	}
+	n = copy(p, buf[headerLength:headerLength+length])
+	return n, buf[8]&0x01 != 0, nil
+}
+
+// Read reads part of a device dependent message.
+func (inst *Instrument) Read(p []byte) (n int, err error) {
+	n, _, err = inst.read(p)
+	return n, err
+}
+
+// ReadMessage reads a whole device dependent message, up to EOM.
+func (inst *Instrument) ReadMessage() ([]byte, error) {
+	var msg []byte
+	buf := make([]byte, 4096)
+	for {
+		n, eom, err := inst.read(buf)
+		if err != nil {
+			return msg, err
+		}
+		msg = append(msg, buf[:n]...)
+		if eom {
+			return msg, nil
+		}
+	}
+}
+
+// Command writes a SCPI command, adding a newline terminator.
+func (inst *Instrument) Command(cmd string) error {
+	if !strings.HasSuffix(cmd, "\n") {
+		cmd += "\n"
+	}
+	_, err := inst.Write([]byte(cmd))
+	return err
+}
+
+// Query writes a SCPI command and returns the response without its
+// trailing newline.
+func (inst *Instrument) Query(cmd string) (string, error) {
+	if err := inst.Command(cmd); err != nil {
+		return "", err
+	}
+	b, err := inst.ReadMessage()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+const pollInterval = 10 * time.Millisecond
+
+// AbortBulkOut aborts the last bulk OUT transfer.
+func (inst *Instrument) AbortBulkOut() error {
+	b, err := inst.classIn(gousb.RecipientEndpoint, RequestInitiateAbortBulkOut, uint16(inst.tag), uint16(inst.epOut), 2)
+	if err != nil {
+		return err
+	}
+	if b[0] != StatusSuccess {
+		return &StatusError{Request: RequestInitiateAbortBulkOut, Status: b[0]}
+	}
+	for {
+		b, err = inst.classIn(gousb.RecipientEndpoint, RequestCheckAbortBulkOutStatus, 0, uint16(inst.epOut), 8)
+		if err != nil {
+			return err
+		}
+		if b[0] != StatusPending {
+			break
+		}
+		time.Sleep(pollInterval)
+	}
+	if b[0] != StatusSuccess {
+		return &StatusError{Request: RequestCheckAbortBulkOutStatus, Status: b[0]}
+	}
+	return inst.h.ClearHalt(inst.epOut)
+}
+
+// AbortBulkIn aborts the last bulk IN transfer and drains the endpoint.
+func (inst *Instrument) AbortBulkIn() error {
+	b, err := inst.classIn(gousb.RecipientEndpoint, RequestInitiateAbortBulkIn, uint16(inst.tag), uint16(inst.epIn), 2)
+	if err != nil {
+		return err
+	}
+	if b[0] != StatusSuccess {
+		return &StatusError{Request: RequestInitiateAbortBulkIn, Status: b[0]}
+	}
+	buf := make([]byte, inst.maxPacket)
+	for {
+		b, err = inst.classIn(gousb.RecipientEndpoint, RequestCheckAbortBulkInStatus, 0, uint16(inst.epIn), 8)
+		if err != nil {
+			return err
+		}
+		if b[0] != StatusPending {
+			break
+		}
+		if len(b) > 1 && b[1]&0x01 != 0 {
+			inst.drain(buf)
+		}
+		time.Sleep(pollInterval)
+	}
+	if b[0] != StatusSuccess {
+		return &StatusError{Request: RequestCheckAbortBulkInStatus, Status: b[0]}
+	}
+	return nil
+}
+
+func (inst *Instrument) drain(buf []byte) {
+	for {
+		n, err := inst.bulk.Read(buf)
+		if err != nil || n < len(buf) {
+			return
+		}
+	}
+}
+
+// Clear clears all input and output buffers of the device (INITIATE_CLEAR).
+func (inst *Instrument) Clear() error {
+	if _, err := inst.interfaceRequest(RequestInitiateClear, 0, 1); err != nil {
+		return err
+	}
+	buf := make([]byte, inst.maxPacket)
+	for {
+		b, err := inst.classIn(gousb.RecipientInterface, RequestCheckClearStatus, 0, uint16(inst.iface.InterfaceNumber), 2)
+		if err != nil {
+			return err
+		}
+		if b[0] == StatusSuccess {
+			break
+		}
+		if b[0] != StatusPending {
+			return &StatusError{Request: RequestCheckClearStatus, Status: b[0]}
+		}
+		if len(b) > 1 && b[1]&0x01 != 0 {
+			inst.drain(buf)
+		}
+		time.Sleep(pollInterval)
+	}
+	return inst.h.ClearHalt(inst.epOut)
+}
+
+func (inst *Instrument) IndicatorPulse() error {
+	_, err := inst.interfaceRequest(RequestIndicatorPulse, 0, 1)
+	return err
+}
+
+// ReadStatusByte issues the USB488 READ_STATUS_BYTE request. When the
+// interface has an interrupt endpoint the status byte arrives there.
+func (inst *Instrument) ReadStatusByte() (uint8, error) {
+	inst.statusTag++
+	if inst.statusTag < 2 || inst.statusTag > 127 {
+		inst.statusTag = 2
+	}
+	tag := inst.statusTag
+	b, err := inst.interfaceRequest(RequestReadStatusByte, uint16(tag), 3)
+	if err != nil {
+		return 0, err
+	}
+	if inst.intr == nil {
+		if len(b) < 3 {
+			return 0, errors.New("usbtmc: short status response")
+		}
+		return b[2], nil
+	}
+	buf := make([]byte, 2)
+	for {
+		n, err := inst.intr.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		if n == 2 && buf[0] == 0x80|tag {
+			return buf[1], nil
+		}
+	}
+}
+
+// RemoteEnable asserts or deasserts the USB488 REN line.
+func (inst *Instrument) RemoteEnable(enable bool) error {
+	value := uint16(0)
+	if enable {
+		value = 1
+	}
+	_, err := inst.interfaceRequest(RequestRENControl, value, 1)
+	return err
+}
+func (inst *Instrument) GoToLocal() error {
+	_, err := inst.interfaceRequest(RequestGoToLocal, 0, 1)
+	return err
+}
+func (inst *Instrument) LocalLockout() error {
+	_, err := inst.interfaceRequest(RequestLocalLockout, 0, 1)
+	return err
+}
+
+// Trigger sends the USB488 TRIGGER bulk message (like GPIB GET).
+func (inst *Instrument) Trigger() error {
+	_, err := inst.bulk.Write(inst.header(MsgTrigger, 0, 0, 0))
+	return err
+}
@@ -0,0 +1,267 @@
+package uvc
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// camera terminal control selectors
+const (
+	CTScanningModeControl         = uint8(0x01)
+	CTAEModeControl               = uint8(0x02)
+	CTAEPriorityControl           = uint8(0x03)
+	CTExposureTimeAbsoluteControl = uint8(0x04)
+	CTExposureTimeRelativeControl = uint8(0x05)
+	CTFocusAbsoluteControl        = uint8(0x06)
+	CTFocusRelativeControl        = uint8(0x07)
+	CTFocusAutoControl            = uint8(0x08)
+	CTIrisAbsoluteControl         = uint8(0x09)
+	CTIrisRelativeControl         = uint8(0x0A)
+	CTZoomAbsoluteControl         = uint8(0x0B)
+	CTZoomRelativeControl         = uint8(0x0C)
+	CTPanTiltAbsoluteControl      = uint8(0x0D)
+	CTPanTiltRelativeControl      = uint8(0x0E)
+	CTRollAbsoluteControl         = uint8(0x0F)
+	CTRollRelativeControl         = uint8(0x10)
+	CTPrivacyControl              = uint8(0x11)
+)
+
+// processing unit control selectors
+const (
+	PUBacklightCompensationControl       = uint8(0x01)
+	PUBrightnessControl                  = uint8(0x02)
+	PUContrastControl                    = uint8(0x03)
+	PUGainControl                        = uint8(0x04)
+	PUPowerLineFrequencyControl          = uint8(0x05)
+	PUHueControl                         = uint8(0x06)
+	PUSaturationControl                  = uint8(0x07)
+	PUSharpnessControl                   = uint8(0x08)
+	PUGammaControl                       = uint8(0x09)
+	PUWhiteBalanceTemperatureControl     = uint8(0x0A)
+	PUWhiteBalanceTemperatureAutoControl = uint8(0x0B)
+	PUWhiteBalanceComponentControl       = uint8(0x0C)
+	PUWhiteBalanceComponentAutoControl   = uint8(0x0D)
+	PUDigitalMultiplierControl           = uint8(0x0E)
+	PUDigitalMultiplierLimitControl      = uint8(0x0F)
+	PUHueAutoControl                     = uint8(0x10)
+)
+
+// CT_AE_MODE_CONTROL values
+const (
+	AEModeManual           = uint8(0x01)
+	AEModeAuto             = uint8(0x02)
+	AEModeShutterPriority  = uint8(0x04)
+	AEModeAperturePriority = uint8(0x08)
+)
+
+// GET_INFO bits
+const (
+	InfoGet      = uint8(0x01)
+	InfoSet      = uint8(0x02)
+	InfoDisabled = uint8(0x04)
+	InfoAuto     = uint8(0x08)
+	InfoAsync    = uint8(0x10)
+)
+
+// GetControl issues a GET request for selector of unit or terminal into b.
+func (c *Camera) GetControl(req, unit, selector uint8, b []byte) (int, error) {
+	index := uint16(unit)<<8 | uint16(c.ctrl.InterfaceNumber)
+	return c.h.ControlTransfer(requestIn, req, uint16(selector)<<8, index, b)
+}
+
+// SetControl issues SET_CUR for selector of unit or terminal.
+func (c *Camera) SetControl(unit, selector uint8, b []byte) error {
+	index := uint16(unit)<<8 | uint16(c.ctrl.InterfaceNumber)
+	_, err := c.h.ControlTransfer(requestOut, RequestSetCur, uint16(selector)<<8, index, b)
+	return err
+}
+
+// ControlInfo returns the GET_INFO capabilities of a control.
+func (c *Camera) ControlInfo(unit, selector uint8) (uint8, error) {
+	b := make([]byte, 1)
+	if _, err := c.GetControl(RequestGetInfo, unit, selector, b); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// ControlLen returns the GET_LEN size of a control.
+func (c *Camera) ControlLen(unit, selector uint8) (int, error) {
+	b := make([]byte, 2)
+	if _, err := c.GetControl(RequestGetLen, unit, selector, b); err != nil {
+		return 0, err
+	}
+	return int(binary.LittleEndian.Uint16(b)), nil
+}
+
+func getInt(b []byte, signed bool) int {
+	var v uint32
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint32(b[i])
+	}
+	if signed && len(b) < 4 {
+		shift := uint(32 - 8*len(b))
+		return int(int32(v<<shift) >> shift)
+	}
+	if signed {
+		return int(int32(v))
+	}
+	return int(v)
+}
+func putInt(b []byte, v int) {
+	for i := range b {
+		b[i] = uint8(v >> uint(8*i))
+	}
+}
+
+// GetInt reads a size byte scalar control with req.
+func (c *Camera) GetInt(req, unit, selector uint8, size int, signed bool) (int, error) {
+	b := make([]byte, size)
+	n, err := c.GetControl(req, unit, selector, b)
+	if err != nil {
+		return 0, err
+	}
+	if n < size {
+		return 0, errors.New("uvc: short control value")
+	}
+	return getInt(b, signed), nil
+}
+
+// SetInt writes a size byte scalar control.
+func (c *Camera) SetInt(unit, selector uint8, size int, v int) error {
+	b := make([]byte, size)
+	putInt(b, v)
+	return c.SetControl(unit, selector, b)
+}
+
+// Range is the range of a scalar control.
+type Range struct {
+	Min, Max, Res, Def int
+}
+
+// ControlRange reads GET_MIN, GET_MAX, GET_RES and GET_DEF of a scalar
+// control.
+func (c *Camera) ControlRange(unit, selector uint8, size int, signed bool) (*Range, error) {
+	r := new(Range)
+	for _, q := range []struct {
+		req uint8
+		v   *int
+	}{
+		{RequestGetMin, &r.Min},
+		{RequestGetMax, &r.Max},
+		{RequestGetRes, &r.Res},
+		{RequestGetDef, &r.Def},
+	} {
+		v, err := c.GetInt(q.req, unit, selector, size, signed)
+		if err != nil {
+			return nil, err
+		}
+		*q.v = v
+	}
+	return r, nil
+}
+
+func (c *Camera) cameraTerminal() (uint8, error) {
+	t := c.vc.CameraTerminal()
+	if t == nil {
+		return 0, errors.New("uvc: no camera terminal")
+	}
+	return t.ID, nil
+}
+func (c *Camera) processingUnit() (uint8, error) {
+	u := c.vc.ProcessingUnit()
+	if u == nil {
+		return 0, errors.New("uvc: no processing unit")
+	}
+	return u.ID, nil
+}
+
+// ExposureTime returns the absolute exposure time in 100us units.
+func (c *Camera) ExposureTime() (uint32, error) {
+	ct, err := c.cameraTerminal()
+	if err != nil {
+		return 0, err
+	}
+	v, err := c.GetInt(RequestGetCur, ct, CTExposureTimeAbsoluteControl, 4, false)
+	return uint32(v), err
+}
+
+// SetExposureTime sets the absolute exposure time in 100us units. Auto
+// exposure has to be off.
+func (c *Camera) SetExposureTime(t uint32) error {
+	ct, err := c.cameraTerminal()
+	if err != nil {
+		return err
+	}
+	return c.SetInt(ct, CTExposureTimeAbsoluteControl, 4, int(t))
+}
+
+// SetAutoExposure switches between manual and the automatic mode the
+// camera supports, preferring aperture priority as most webcams do.
+func (c *Camera) SetAutoExposure(on bool) error {
+	ct, err := c.cameraTerminal()
+	if err != nil {
+		return err
+	}
+	mode := AEModeManual
+	if on {
+		// GET_RES returns the bitmap of supported modes
+		modes, err := c.GetInt(RequestGetRes, ct, CTAEModeControl, 1, false)
+		if err != nil {
+			return err
+		}
+		switch {
+		case uint8(modes)&AEModeAperturePriority != 0:
+			mode = AEModeAperturePriority
+		case uint8(modes)&AEModeAuto != 0:
+			mode = AEModeAuto
+		default:
+			return errors.New("uvc: auto exposure not supported")
+		}
+	}
+	return c.SetInt(ct, CTAEModeControl, 1, int(mode))
+}
+
+// Focus returns the absolute focus distance in millimeters.
+func (c *Camera) Focus() (uint16, error) {
+	ct, err := c.cameraTerminal()
+	if err != nil {
+		return 0, err
+	}
+	v, err := c.GetInt(RequestGetCur, ct, CTFocusAbsoluteControl, 2, false)
+	return uint16(v), err
+}
+func (c *Camera) SetFocus(focus uint16) error {
+	ct, err := c.cameraTerminal()
+	if err != nil {
+		return err
+	}
+	return c.SetInt(ct, CTFocusAbsoluteControl, 2, int(focus))
+}
+func (c *Camera) SetAutoFocus(on bool) error {
+	ct, err := c.cameraTerminal()
+	if err != nil {
+		return err
+	}
+	v := 0
+	if on {
+		v = 1
+	}
+	return c.SetInt(ct, CTFocusAutoControl, 1, v)
+}
+
+func (c *Camera) Brightness() (int16, error) {
+	pu, err := c.processingUnit()
+	if err != nil {
+		return 0, err
+	}
+	v, err := c.GetInt(RequestGetCur, pu, PUBrightnessControl, 2, true)
+	return int16(v), err
+}
+func (c *Camera) SetBrightness(v int16) error {
+	pu, err := c.processingUnit()
+	if err != nil {
+		return err
+	}
+	return c.SetInt(pu, PUBrightnessControl, 2, int(v))
+}
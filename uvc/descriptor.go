@@ -0,0 +1,615 @@
+package uvc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/op0xA5/gousb"
+)
+
+// class-specific descriptor types
+const (
+	DescriptorTypeCSInterface = uint8(0x24)
+	DescriptorTypeCSEndpoint  = uint8(0x25)
+)
+
+// VideoControl descriptor subtypes
+const (
+	VCHeader         = uint8(0x01)
+	VCInputTerminal  = uint8(0x02)
+	VCOutputTerminal = uint8(0x03)
+	VCSelectorUnit   = uint8(0x04)
+	VCProcessingUnit = uint8(0x05)
+	VCExtensionUnit  = uint8(0x06)
+	VCEncodingUnit   = uint8(0x07)
+)
+
+// VideoStreaming descriptor subtypes
+const (
+	VSInputHeader         = uint8(0x01)
+	VSOutputHeader        = uint8(0x02)
+	VSStillImageFrame     = uint8(0x03)
+	VSFormatUncompressed  = uint8(0x04)
+	VSFrameUncompressed   = uint8(0x05)
+	VSFormatMJPEG         = uint8(0x06)
+	VSFrameMJPEG          = uint8(0x07)
+	VSFormatMPEG2TS       = uint8(0x0A)
+	VSFormatDV            = uint8(0x0C)
+	VSColorFormat         = uint8(0x0D)
+	VSFormatFrameBased    = uint8(0x10)
+	VSFrameFrameBased     = uint8(0x11)
+	VSFormatStreamBased   = uint8(0x12)
+	VSFormatH264          = uint8(0x13)
+	VSFrameH264           = uint8(0x14)
+	VSFormatH264Simulcast = uint8(0x15)
+)
+
+// terminal types
+const (
+	TerminalVendorSpecific = uint16(0x0100)
+	TerminalStreaming      = uint16(0x0101)
+	TerminalCamera         = uint16(0x0201)
+	TerminalMediaTransport = uint16(0x0202)
+	TerminalDisplay        = uint16(0x0301)
+)
+
+// VCHeaderDescriptor is the VideoControl interface header.
+type VCHeaderDescriptor struct {
+	BcdUVC           uint16
+	TotalLength      uint16
+	ClockFrequency   uint32
+	InterfaceNumbers []uint8
+}
+
+// Terminal is an input or output terminal.
+type Terminal struct {
+	Subtype       uint8
+	ID            uint8
+	TerminalType  uint16
+	AssocTerminal uint8
+	SourceID      uint8 // output terminals only
+	IdxTerminal   uint8
+
+	// camera terminals only
+	ObjectiveFocalLengthMin uint16
+	ObjectiveFocalLengthMax uint16
+	OcularFocalLength       uint16
+	Controls                []byte
+}
+
+func (t *Terminal) IsInput() bool {
+	return t.Subtype == VCInputTerminal
+}
+
+// Unit is a selector, processing, extension or encoding unit.
+type Unit struct {
+	Subtype   uint8
+	ID        uint8
+	SourceIDs []uint8
+	Controls  []byte
+	IdxUnit   uint8
+
+	// processing units only
+	MaxMultiplier  uint16
+	VideoStandards uint8
+
+	// extension units only
+	GUID        [16]byte
+	NumControls uint8
+}
+
+// hasControl reports whether the bmControls bit for selector is set;
+// selector n maps to bit n-1.
+func hasControl(controls []byte, selector uint8) bool {
+	if selector == 0 {
+		return false
+	}
+	bit := int(selector - 1)
+	return bit/8 < len(controls) && controls[bit/8]&(1<<uint(bit%8)) != 0
+}
+func (t *Terminal) HasControl(selector uint8) bool {
+	return hasControl(t.Controls, selector)
+}
+func (u *Unit) HasControl(selector uint8) bool {
+	return hasControl(u.Controls, selector)
+}
+
+// VideoControl holds the parsed class-specific descriptors of a
+// VideoControl interface.
+type VideoControl struct {
+	Header    VCHeaderDescriptor
+	Terminals []*Terminal
+	Units     []*Unit
+}
+
+func (vc *VideoControl) Terminal(id uint8) *Terminal {
+	for _, t := range vc.Terminals {
+		if t.ID == id {
+			return t
+		}
+	}
+	return nil
+}
+func (vc *VideoControl) Unit(id uint8) *Unit {
+	for _, u := range vc.Units {
+		if u.ID == id {
+			return u
+		}
+	}
+	return nil
+}
+
+// CameraTerminal returns the first camera input terminal.
+func (vc *VideoControl) CameraTerminal() *Terminal {
+	for _, t := range vc.Terminals {
+		if t.IsInput() && t.TerminalType == TerminalCamera {
+			return t
+		}
+	}
+	return nil
+}
+
+// ProcessingUnit returns the first processing unit.
+func (vc *VideoControl) ProcessingUnit() *Unit {
+	for _, u := range vc.Units {
+		if u.Subtype == VCProcessingUnit {
+			return u
+		}
+	}
+	return nil
+}
+
+// classDescriptors parses extra with the decoders registered for
+// interfaces of the video class and subclass.
+func classDescriptors(subclass uint8, extra []byte) ([]interface{}, error) {
+	iface := &gousb.InterfaceDescriptor{
+		InterfaceClass:    gousb.ClassVideo,
+		InterfaceSubClass: subclass,
+	}
+	list, err := gousb.ParseDescriptors(iface, extra)
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for _, desc := range list {
+		if cd, ok := desc.(*ClassDescriptor); ok {
+			values = append(values, cd.Value)
+		}
+	}
+	return values, nil
+}
+
+// parseVCDescriptor decodes one VideoControl descriptor into a
+// *VCHeaderDescriptor, *Terminal or *Unit; other subtypes return nil.
+func parseVCDescriptor(d []byte) (interface{}, error) {
+	if d[1] != DescriptorTypeCSInterface || len(d) < 4 {
+		return nil, nil
+	}
+	le := binary.LittleEndian
+	short := fmt.Errorf("uvc: short descriptor subtype 0x%02x", d[2])
+	switch d[2] {
+	case VCHeader:
+		if len(d) < 12 || len(d) < 12+int(d[11]) {
+			return nil, short
+		}
+		return &VCHeaderDescriptor{
+			BcdUVC:           le.Uint16(d[3:]),
+			TotalLength:      le.Uint16(d[5:]),
+			ClockFrequency:   le.Uint32(d[7:]),
+			InterfaceNumbers: append([]uint8(nil), d[12:12+int(d[11])]...),
+		}, nil
+	case VCInputTerminal:
+		if len(d) < 8 {
+			return nil, short
+		}
+		t := &Terminal{
+			Subtype:       d[2],
+			ID:            d[3],
+			TerminalType:  le.Uint16(d[4:]),
+			AssocTerminal: d[6],
+			IdxTerminal:   d[7],
+		}
+		if t.TerminalType == TerminalCamera && len(d) >= 15 && len(d) >= 15+int(d[14]) {
+			t.ObjectiveFocalLengthMin = le.Uint16(d[8:])
+			t.ObjectiveFocalLengthMax = le.Uint16(d[10:])
+			t.OcularFocalLength = le.Uint16(d[12:])
+			t.Controls = append([]byte(nil), d[15:15+int(d[14])]...)
+		}
+		return t, nil
+	case VCOutputTerminal:
+		if len(d) < 9 {
+			return nil, short
+		}
+		return &Terminal{
+			Subtype:       d[2],
+			ID:            d[3],
+			TerminalType:  le.Uint16(d[4:]),
+			AssocTerminal: d[6],
+			SourceID:      d[7],
+			IdxTerminal:   d[8],
+		}, nil
+	case VCSelectorUnit:
+		if len(d) < 5 || len(d) < 6+int(d[4]) {
+			return nil, short
+		}
+		n := int(d[4])
+		return &Unit{
+			Subtype:   d[2],
+			ID:        d[3],
+			SourceIDs: append([]uint8(nil), d[5:5+n]...),
+			IdxUnit:   d[5+n],
+		}, nil
+	case VCProcessingUnit:
+		if len(d) < 8 || len(d) < 9+int(d[7]) {
+			return nil, short
+		}
+		n := int(d[7])
+		u := &Unit{
+			Subtype:       d[2],
+			ID:            d[3],
+			SourceIDs:     []uint8{d[4]},
+			MaxMultiplier: le.Uint16(d[5:]),
+			Controls:      append([]byte(nil), d[8:8+n]...),
+			IdxUnit:       d[8+n],
+		}
+		if len(d) > 9+n {
+			u.VideoStandards = d[9+n]
+		}
+		return u, nil
+	case VCExtensionUnit:
+		if len(d) < 22 || len(d) < 23+int(d[21]) {
+			return nil, short
+		}
+		p := int(d[21])
+		if len(d) < 24+p+int(d[22+p]) {
+			return nil, short
+		}
+		n := int(d[22+p])
+		u := &Unit{
+			Subtype:     d[2],
+			ID:          d[3],
+			NumControls: d[20],
+			SourceIDs:   append([]uint8(nil), d[22:22+p]...),
+			Controls:    append([]byte(nil), d[23+p:23+p+n]...),
+			IdxUnit:     d[23+p+n],
+		}
+		copy(u.GUID[:], d[4:20])
+		return u, nil
+	case VCEncodingUnit:
+		if len(d) < 10 || len(d) < 7+int(d[6]) {
+			return nil, short
+		}
+		return &Unit{
+			Subtype:   d[2],
+			ID:        d[3],
+			SourceIDs: []uint8{d[4]},
+			IdxUnit:   d[5],
+			Controls:  append([]byte(nil), d[7:7+int(d[6])]...),
+		}, nil
+	}
+	return nil, nil
+}
+
+// ParseVideoControl parses the class-specific descriptors following a
+// VideoControl interface descriptor.
+func ParseVideoControl(extra []byte) (*VideoControl, error) {
+	list, err := classDescriptors(SubClassVideoControl, extra)
+	if err != nil {
+		return nil, err
+	}
+	vc := new(VideoControl)
+	header := false
+	for _, v := range list {
+		switch v := v.(type) {
+		case *VCHeaderDescriptor:
+			vc.Header = *v
+			header = true
+		case *Terminal:
+			vc.Terminals = append(vc.Terminals, v)
+		case *Unit:
+			vc.Units = append(vc.Units, v)
+		}
+	}
+	if !header {
+		return nil, errors.New("uvc: videocontrol header not found")
+	}
+	return vc, nil
+}
+
+// InputHeader is the VideoStreaming input header.
+type InputHeader struct {
+	NumFormats         uint8
+	TotalLength        uint16
+	EndpointAddress    uint8
+	Info               uint8
+	TerminalLink       uint8
+	StillCaptureMethod uint8
+	TriggerSupport     uint8
+	TriggerUsage       uint8
+	Controls           [][]byte
+}
+
+// Frame is a frame descriptor of a format.
+type Frame struct {
+	Subtype                 uint8
+	Index                   uint8
+	Capabilities            uint8
+	Width                   uint16
+	Height                  uint16
+	MinBitRate              uint32
+	MaxBitRate              uint32
+	MaxVideoFrameBufferSize uint32
+	DefaultFrameInterval    uint32
+	BytesPerLine            uint32
+
+	// FrameIntervals lists the discrete intervals in 100ns units. When it
+	// is empty the frame supports the continuous range below.
+	FrameIntervals    []uint32
+	MinFrameInterval  uint32
+	MaxFrameInterval  uint32
+	FrameIntervalStep uint32
+}
+
+// Format is a format descriptor with its frames.
+type Format struct {
+	Subtype           uint8
+	Index             uint8
+	GUID              [16]byte
+	BitsPerPixel      uint8
+	DefaultFrameIndex uint8
+	AspectRatioX      uint8
+	AspectRatioY      uint8
+	InterlaceFlags    uint8
+	CopyProtect       uint8
+	Flags             uint8 // MJPEG bmFlags
+	VariableSize      bool
+	Frames            []*Frame
+}
+
+// FourCC returns the four character code of the format, taken from the
+// GUID for uncompressed and frame based formats.
+func (f *Format) FourCC() string {
+	switch f.Subtype {
+	case VSFormatMJPEG:
+		return "MJPG"
+	case VSFormatUncompressed, VSFormatFrameBased:
+		b := f.GUID[:4]
+		for i, c := range b {
+			if c == 0 {
+				return string(b[:i])
+			}
+		}
+		return string(b)
+	case VSFormatH264, VSFormatH264Simulcast:
+		return "H264"
+	}
+	return ""
+}
+
+func (f *Format) Frame(index uint8) *Frame {
+	for _, fr := range f.Frames {
+		if fr.Index == index {
+			return fr
+		}
+	}
+	return nil
+}
+
+// VideoStreaming holds the parsed class-specific descriptors of a
+// VideoStreaming interface.
+type VideoStreaming struct {
+	Header  InputHeader
+	Formats []*Format
+}
+
+func (vs *VideoStreaming) Format(index uint8) *Format {
+	for _, f := range vs.Formats {
+		if f.Index == index {
+			return f
+		}
+	}
+	return nil
+}
+
+func parseFrame(d []byte) (*Frame, error) {
+	le := binary.LittleEndian
+	fr := &Frame{
+		Subtype:      d[2],
+		Index:        d[3],
+		Capabilities: d[4],
+		Width:        le.Uint16(d[5:]),
+		Height:       le.Uint16(d[7:]),
+		MinBitRate:   le.Uint32(d[9:]),
+		MaxBitRate:   le.Uint32(d[13:]),
+	}
+	var typ int
+	var off int
+	if d[2] == VSFrameFrameBased {
+		fr.DefaultFrameInterval = le.Uint32(d[17:])
+		typ = int(d[21])
+		fr.BytesPerLine = le.Uint32(d[22:])
+		off = 26
+	} else {
+		fr.MaxVideoFrameBufferSize = le.Uint32(d[17:])
+		fr.DefaultFrameInterval = le.Uint32(d[21:])
+		typ = int(d[25])
+		off = 26
+	}
+	if typ == 0 {
+		if len(d) < off+12 {
+			return nil, errors.New("uvc: short frame descriptor")
+		}
+		fr.MinFrameInterval = le.Uint32(d[off:])
+		fr.MaxFrameInterval = le.Uint32(d[off+4:])
+		fr.FrameIntervalStep = le.Uint32(d[off+8:])
+		return fr, nil
+	}
+	if len(d) < off+4*typ {
+		return nil, errors.New("uvc: short frame descriptor")
+	}
+	for i := 0; i < typ; i++ {
+		fr.FrameIntervals = append(fr.FrameIntervals, le.Uint32(d[off+4*i:]))
+	}
+	return fr, nil
+}
+
+// parseVSDescriptor decodes one VideoStreaming descriptor into an
+// *InputHeader, *Format or *Frame; other subtypes return nil.
+func parseVSDescriptor(d []byte) (interface{}, error) {
+	if d[1] != DescriptorTypeCSInterface || len(d) < 4 {
+		return nil, nil
+	}
+	le := binary.LittleEndian
+	short := fmt.Errorf("uvc: short descriptor subtype 0x%02x", d[2])
+	switch d[2] {
+	case VSInputHeader:
+		if len(d) < 13 {
+			return nil, short
+		}
+		h := InputHeader{
+			NumFormats:         d[3],
+			TotalLength:        le.Uint16(d[4:]),
+			EndpointAddress:    d[6],
+			Info:               d[7],
+			TerminalLink:       d[8],
+			StillCaptureMethod: d[9],
+			TriggerSupport:     d[10],
+			TriggerUsage:       d[11],
+		}
+		size := int(d[12])
+		for i := 0; i < int(h.NumFormats) && 13+(i+1)*size <= len(d); i++ {
+			h.Controls = append(h.Controls, append([]byte(nil), d[13+i*size:13+(i+1)*size]...))
+		}
+		return &h, nil
+	case VSFormatUncompressed, VSFormatFrameBased:
+		if len(d) < 27 {
+			return nil, short
+		}
+		format := &Format{
+			Subtype:           d[2],
+			Index:             d[3],
+			BitsPerPixel:      d[21],
+			DefaultFrameIndex: d[22],
+			AspectRatioX:      d[23],
+			AspectRatioY:      d[24],
+			InterlaceFlags:    d[25],
+			CopyProtect:       d[26],
+		}
+		copy(format.GUID[:], d[5:21])
+		if d[2] == VSFormatFrameBased && len(d) > 27 {
+			format.VariableSize = d[27] != 0
+		}
+		return format, nil
+	case VSFormatMJPEG:
+		if len(d) < 11 {
+			return nil, short
+		}
+		format := &Format{
+			Subtype:           d[2],
+			Index:             d[3],
+			Flags:             d[5],
+			DefaultFrameIndex: d[6],
+			AspectRatioX:      d[7],
+			AspectRatioY:      d[8],
+			InterlaceFlags:    d[9],
+			CopyProtect:       d[10],
+		}
+		return format, nil
+	case VSFrameUncompressed, VSFrameMJPEG, VSFrameFrameBased:
+		if len(d) < 26 {
+			return nil, short
+		}
+		return parseFrame(d)
+	}
+	return nil, nil
+}
+
+// ParseVideoStreaming parses the class-specific descriptors following a
+// VideoStreaming interface descriptor.
+func ParseVideoStreaming(extra []byte) (*VideoStreaming, error) {
+	list, err := classDescriptors(SubClassVideoStreaming, extra)
+	if err != nil {
+		return nil, err
+	}
+	vs := new(VideoStreaming)
+	var format *Format
+	for _, v := range list {
+		switch v := v.(type) {
+		case *InputHeader:
+			vs.Header = *v
+		case *Format:
+			format = v
+			vs.Formats = append(vs.Formats, format)
+		case *Frame:
+			if format == nil {
+				return nil, errors.New("uvc: frame outside of format")
+			}
+			format.Frames = append(format.Frames, v)
+		}
+	}
+	return vs, nil
+}
+
+// EPInterrupt is the subtype of the class-specific VideoControl interrupt
+// endpoint descriptor.
+const EPInterrupt = uint8(0x03)
+
+// InterruptEndpoint is the class-specific VideoControl interrupt endpoint
+// descriptor.
+type InterruptEndpoint struct {
+	MaxTransferSize uint16
+}
+
+// ClassDescriptor is a class-specific descriptor as returned by
+// gousb.ParseDescriptors for video interfaces. Value is one of
+// *VCHeaderDescriptor, *Terminal, *Unit, *InputHeader, *Format, *Frame or
+// *InterruptEndpoint.
+type ClassDescriptor struct {
+	Length            uint8
+	DescriptorType    uint8
+	DescriptorSubtype uint8
+	Value             interface{}
+}
+
+func (desc *ClassDescriptor) Len() int {
+	return int(desc.Length)
+}
+func (desc *ClassDescriptor) Type() uint8 {
+	return desc.DescriptorType
+}
+
+// parseClassDescriptor decodes a single CS_INTERFACE or CS_ENDPOINT
+// descriptor; VideoControl and VideoStreaming share subtype numbers, so
+// the interface subclass picks the decoder.
+func parseClassDescriptor(iface *gousb.InterfaceDescriptor, b []byte) (gousb.Descriptor, error) {
+	if len(b) < 3 {
+		return nil, nil
+	}
+	var v interface{}
+	var err error
+	switch {
+	case b[1] == DescriptorTypeCSEndpoint:
+		if b[2] == EPInterrupt && len(b) >= 5 {
+			v = &InterruptEndpoint{MaxTransferSize: binary.LittleEndian.Uint16(b[3:])}
+		}
+	case iface.InterfaceSubClass == SubClassVideoControl:
+		v, err = parseVCDescriptor(b)
+	case iface.InterfaceSubClass == SubClassVideoStreaming:
+		v, err = parseVSDescriptor(b)
+	}
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return &ClassDescriptor{
+		Length:            b[0],
+		DescriptorType:    b[1],
+		DescriptorSubtype: b[2],
+		Value:             v,
+	}, nil
+}
+
+func init() {
+	gousb.RegisterDescriptor(gousb.ClassVideo, DescriptorTypeCSInterface, parseClassDescriptor)
+	gousb.RegisterDescriptor(gousb.ClassVideo, DescriptorTypeCSEndpoint, parseClassDescriptor)
+}
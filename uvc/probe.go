@@ -0,0 +1,129 @@
+package uvc
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// probe and commit control lengths per UVC version
+const (
+	probeLength10 = 26
+	probeLength11 = 34
+	probeLength15 = 48
+)
+
+// bmHint bits
+const (
+	HintFrameInterval = uint16(0x0001)
+	HintKeyFrameRate  = uint16(0x0002)
+	HintPFrameRate    = uint16(0x0004)
+	HintCompQuality   = uint16(0x0008)
+	HintCompWindow    = uint16(0x0010)
+)
+
+// ProbeCommit is the VS_PROBE_CONTROL / VS_COMMIT_CONTROL block.
+type ProbeCommit struct {
+	Hint                   uint16
+	FormatIndex            uint8
+	FrameIndex             uint8
+	FrameInterval          uint32
+	KeyFrameRate           uint16
+	PFrameRate             uint16
+	CompQuality            uint16
+	CompWindowSize         uint16
+	Delay                  uint16
+	MaxVideoFrameSize      uint32
+	MaxPayloadTransferSize uint32
+
+	// UVC 1.1
+	ClockFrequency  uint32
+	FramingInfo     uint8
+	PreferedVersion uint8
+	MinVersion      uint8
+	MaxVersion      uint8
+
+	// UVC 1.5
+	Usage                     uint8
+	BitDepthLuma              uint8
+	Settings                  uint8
+	MaxNumberOfRefFramesPlus1 uint8
+	RateControlModes          uint16
+	LayoutPerStream           uint64
+}
+
+// probeLength returns the control length for the bcdUVC version.
+func probeLength(bcdUVC uint16) int {
+	switch {
+	case bcdUVC >= 0x0150:
+		return probeLength15
+	case bcdUVC >= 0x0110:
+		return probeLength11
+	}
+	return probeLength10
+}
+
+// MarshalBinary encodes the control; the result is cut to the length for
+// the UVC version by the caller.
+func (pc *ProbeCommit) MarshalBinary() ([]byte, error) {
+	le := binary.LittleEndian
+	b := make([]byte, probeLength15)
+	le.PutUint16(b[0:], pc.Hint)
+	b[2] = pc.FormatIndex
+	b[3] = pc.FrameIndex
+	le.PutUint32(b[4:], pc.FrameInterval)
+	le.PutUint16(b[8:], pc.KeyFrameRate)
+	le.PutUint16(b[10:], pc.PFrameRate)
+	le.PutUint16(b[12:], pc.CompQuality)
+	le.PutUint16(b[14:], pc.CompWindowSize)
+	le.PutUint16(b[16:], pc.Delay)
+	le.PutUint32(b[18:], pc.MaxVideoFrameSize)
+	le.PutUint32(b[22:], pc.MaxPayloadTransferSize)
+	le.PutUint32(b[26:], pc.ClockFrequency)
+	b[30] = pc.FramingInfo
+	b[31] = pc.PreferedVersion
+	b[32] = pc.MinVersion
+	b[33] = pc.MaxVersion
+	b[34] = pc.Usage
+	b[35] = pc.BitDepthLuma
+	b[36] = pc.Settings
+	b[37] = pc.MaxNumberOfRefFramesPlus1
+	le.PutUint16(b[38:], pc.RateControlModes)
+	le.PutUint64(b[40:], pc.LayoutPerStream)
+	return b, nil
+}
+
+func (pc *ProbeCommit) UnmarshalBinary(b []byte) error {
+	if len(b) < probeLength10 {
+		return errors.New("uvc: short probe control")
+	}
+	le := binary.LittleEndian
+	*pc = ProbeCommit{
+		Hint:                   le.Uint16(b[0:]),
+		FormatIndex:            b[2],
+		FrameIndex:             b[3],
+		FrameInterval:          le.Uint32(b[4:]),
+		KeyFrameRate:           le.Uint16(b[8:]),
+		PFrameRate:             le.Uint16(b[10:]),
+		CompQuality:            le.Uint16(b[12:]),
+		CompWindowSize:         le.Uint16(b[14:]),
+		Delay:                  le.Uint16(b[16:]),
+		MaxVideoFrameSize:      le.Uint32(b[18:]),
+		MaxPayloadTransferSize: le.Uint32(b[22:]),
+	}
+	if len(b) >= probeLength11 {
+		pc.ClockFrequency = le.Uint32(b[26:])
+		pc.FramingInfo = b[30]
+		pc.PreferedVersion = b[31]
+		pc.MinVersion = b[32]
+		pc.MaxVersion = b[33]
+	}
+	if len(b) >= probeLength15 {
+		pc.Usage = b[34]
+		pc.BitDepthLuma = b[35]
+		pc.Settings = b[36]
+		pc.MaxNumberOfRefFramesPlus1 = b[37]
+		pc.RateControlModes = le.Uint16(b[38:])
+		pc.LayoutPerStream = le.Uint64(b[40:])
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package uvc
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/op0xA5/gousb"
+)
+
+// payload header bmHeaderInfo bits
+const (
+	HeaderFID = uint8(0x01)
+	HeaderEOF = uint8(0x02)
+	HeaderPTS = uint8(0x04)
+	HeaderSCR = uint8(0x08)
+	HeaderRES = uint8(0x10)
+	HeaderSTI = uint8(0x20)
+	HeaderERR = uint8(0x40)
+	HeaderEOH = uint8(0x80)
+)
+
+// isoPacketsPerTransfer is the number of service intervals per
+// isochronous transfer, 4ms at high speed.
+const isoPacketsPerTransfer = 32
+
+// VideoFrame is one reassembled frame.
+type VideoFrame struct {
+	Data   []byte
+	PTS    uint32
+	HasPTS bool
+	// Error is set when a payload reported an error, a packet was lost
+	// or an uncompressed frame has the wrong size.
+	Error bool
+}
+
+// Stream is a running video stream.
+type Stream struct {
+	c      *Camera
+	s      *Streaming
+	pc     ProbeCommit
+	format *Format
+
+	iso  *gousb.IsoTransfer
+	bulk *gousb.BulkTransfer
+	ep   uint8
+	buf  []byte
+
+	payloads [][]byte
+	lost     bool
+	fid      int
+	frame    VideoFrame
+}
+
+// Start negotiates format, frame and interval on s and starts streaming.
+func (c *Camera) Start(s *Streaming, format, frame uint8, interval uint32) (*Stream, error) {
+	pc, err := c.Negotiate(s, format, frame, interval)
+	if err != nil {
+		return nil, err
+	}
+	st := &Stream{
+		c:      c,
+		s:      s,
+		pc:     *pc,
+		format: s.Descriptors.Format(format),
+		fid:    -1,
+	}
+	if ep := s.Alts[0].FindEndpoint(gousb.EndpointIn, gousb.TransferTypeBulk); ep != nil {
+		// bulk streaming uses the single alternate setting; each
+		// transfer carries one payload
+		size := int(pc.MaxPayloadTransferSize)
+		if size == 0 {
+			size = int(pc.MaxVideoFrameSize)
+		}
+		st.ep = ep.EndpointAddress
+		st.bulk = c.h.GetBulkTransfer(ep.EndpointAddress, 0)
+		st.buf = make([]byte, size)
+		return st, nil
+	}
+	alt, ep := selectAlt(s, pc.MaxPayloadTransferSize)
+	if alt == nil {
+		return nil, errors.New("uvc: no isochronous alternate setting")
+	}
+	if err := c.h.SetInterfaceAltSetting(int(s.Number), int(alt.AlternateSetting)); err != nil {
+		return nil, err
+	}
+	st.ep = ep.EndpointAddress
+	st.iso = c.h.GetIsoTransfer(ep.EndpointAddress, isoPacketsPerTransfer, 0)
+	return st, nil
+}
+
+func (st *Stream) ProbeCommit() ProbeCommit {
+	return st.pc
+}
+func (st *Stream) Format() *Format {
+	return st.format
+}
+
+func (st *Stream) SetTimeout(timeout uint) {
+	if st.iso != nil {
+		st.iso.SetTimeout(timeout)
+	} else {
+		st.bulk.SetTimeout(timeout)
+	}
+}
+
+// Close stops the stream by selecting the zero-bandwidth setting, or by
+// halting the bulk endpoint.
+func (st *Stream) Close() error {
+	if st.iso != nil {
+		st.iso.Close()
+		return st.c.h.SetInterfaceAltSetting(int(st.s.Number), 0)
+	}
+	return st.c.h.ClearHalt(st.ep)
+}
+
+func (st *Stream) fill() error {
+	if st.bulk != nil {
+		n, err := st.bulk.Read(st.buf)
+		if err != nil {
+			return err
+		}
+		st.payloads = append(st.payloads, st.buf[:n])
+		return nil
+	}
+	packets, err := st.iso.ReadPackets()
+	if err != nil {
+		return err
+	}
+	for _, p := range packets {
+		if p.Err != nil {
+			st.lost = true
+			continue
+		}
+		if len(p.Data) > 0 {
+			st.payloads = append(st.payloads, p.Data)
+		}
+	}
+	return nil
+}
+
+// emit finishes the current frame. It returns nil for empty frames.
+func (st *Stream) emit() *VideoFrame {
+	fr := st.frame
+	st.frame = VideoFrame{}
+	if len(fr.Data) == 0 {
+		return nil
+	}
+	if st.format.Subtype == VSFormatUncompressed && uint32(len(fr.Data)) != st.pc.MaxVideoFrameSize {
+		fr.Error = true
+	}
+	return &fr
+}
+
+// ReadFrame returns the next complete frame. A frame ends at a payload
+// with EOF set or when the frame ID toggles.
+func (st *Stream) ReadFrame() (*VideoFrame, error) {
+	for {
+		for len(st.payloads) > 0 {
+			p := st.payloads[0]
+			if len(p) < 2 || int(p[0]) < 2 || int(p[0]) > len(p) {
+				st.payloads = st.payloads[1:]
+				st.frame.Error = true
+				continue
+			}
+			info := p[1]
+			fid := int(info & HeaderFID)
+			if st.fid >= 0 && fid != st.fid && len(st.frame.Data) > 0 {
+				// the previous frame ended without EOF; keep this
+				// payload for the next one
+				st.fid = fid
+				if fr := st.emit(); fr != nil {
+					return fr, nil
+				}
+			}
+			st.fid = fid
+			st.payloads = st.payloads[1:]
+
+			if st.lost {
+				st.frame.Error = true
+				st.lost = false
+			}
+			if info&HeaderERR != 0 {
+				st.frame.Error = true
+			}
+			if info&HeaderPTS != 0 && p[0] >= 6 {
+				st.frame.PTS = binary.LittleEndian.Uint32(p[2:])
+				st.frame.HasPTS = true
+			}
+			st.frame.Data = append(st.frame.Data, p[p[0]:]...)
+			if info&HeaderEOF != 0 {
+				st.fid = -1
+				if fr := st.emit(); fr != nil {
+					return fr, nil
+				}
+			}
+		}
+		if err := st.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
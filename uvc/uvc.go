@@ -0,0 +1,236 @@
+// Package uvc captures video from USB Video Class cameras: descriptor
+// parsing, stream negotiation, payload reassembly and camera controls.
+package uvc
+
+import (
+	"errors"
+
+	"github.com/op0xA5/gousb"
+)
+
+// interface subclasses
+const (
+	SubClassVideoControl   = uint8(0x01)
+	SubClassVideoStreaming = uint8(0x02)
+)
+
+// class-specific requests
+const (
+	RequestSetCur  = uint8(0x01)
+	RequestGetCur  = uint8(0x81)
+	RequestGetMin  = uint8(0x82)
+	RequestGetMax  = uint8(0x83)
+	RequestGetRes  = uint8(0x84)
+	RequestGetLen  = uint8(0x85)
+	RequestGetInfo = uint8(0x86)
+	RequestGetDef  = uint8(0x87)
+)
+
+// VideoStreaming interface control selectors
+const (
+	VSProbeControl  = uint8(0x01)
+	VSCommitControl = uint8(0x02)
+)
+
+const requestIn = gousb.EndpointIn | gousb.RequestTypeClass | gousb.RecipientInterface
+const requestOut = gousb.EndpointOut | gousb.RequestTypeClass | gousb.RecipientInterface
+
+// Streaming is a VideoStreaming interface with all its alternate settings.
+type Streaming struct {
+	Number      uint8
+	Descriptors *VideoStreaming
+	Alts        []*gousb.Interface
+
+	claimed bool
+}
+
+// Camera is a claimed VideoControl interface and the VideoStreaming
+// interfaces it owns.
+type Camera struct {
+	h       *gousb.Handle
+	ctrl    *gousb.Interface
+	vc      *VideoControl
+	streams []*Streaming
+	claimed bool
+}
+
+// Open finds the first VideoControl interface of the active configuration
+// and claims it.
+func Open(h *gousb.Handle) (*Camera, error) {
+	cfg, err := h.GetActiveConfigDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range cfg.InterfacesByClass(gousb.ClassVideo) {
+		if iface.InterfaceSubClass == SubClassVideoControl {
+			return New(h, cfg, iface)
+		}
+	}
+	return nil, gousb.ErrNotFound
+}
+
+// New claims the VideoControl interface ctrl and collects the streaming
+// interfaces named in its header from cfg.
+func New(h *gousb.Handle, cfg *gousb.Configuration, ctrl *gousb.Interface) (*Camera, error) {
+	vc, err := ParseVideoControl(ctrl.Extra)
+	if err != nil {
+		return nil, err
+	}
+	c := &Camera{
+		h:    h,
+		ctrl: ctrl,
+		vc:   vc,
+	}
+	for _, number := range vc.Header.InterfaceNumbers {
+		s := &Streaming{Number: number}
+		for _, iface := range cfg.Interfaces {
+			if iface.InterfaceNumber != number {
+				continue
+			}
+			if iface.AlternateSetting == 0 {
+				if s.Descriptors, err = ParseVideoStreaming(iface.Extra); err != nil {
+					return nil, err
+				}
+			}
+			s.Alts = append(s.Alts, iface)
+		}
+		if s.Descriptors == nil {
+			return nil, errors.New("uvc: streaming interface not found")
+		}
+		c.streams = append(c.streams, s)
+	}
+	if err := h.ClaimInterface(int(ctrl.InterfaceNumber)); err != nil {
+		return nil, err
+	}
+	c.claimed = true
+	return c, nil
+}
+
+func (c *Camera) Close() error {
+	if !c.claimed {
+		return nil
+	}
+	c.claimed = false
+	for _, s := range c.streams {
+		c.release(s)
+	}
+	return c.h.ReleaseInterface(int(c.ctrl.InterfaceNumber))
+}
+
+func (c *Camera) VideoControl() *VideoControl {
+	return c.vc
+}
+func (c *Camera) Streams() []*Streaming {
+	return c.streams
+}
+
+func (c *Camera) claim(s *Streaming) error {
+	if s.claimed {
+		return nil
+	}
+	if err := c.h.ClaimInterface(int(s.Number)); err != nil {
+		return err
+	}
+	s.claimed = true
+	return nil
+}
+func (c *Camera) release(s *Streaming) {
+	if s.claimed {
+		c.h.SetInterfaceAltSetting(int(s.Number), 0)
+		c.h.ReleaseInterface(int(s.Number))
+		s.claimed = false
+	}
+}
+
+func (c *Camera) streamControl(req, selector uint8, s *Streaming, b []byte) (int, error) {
+	typ := requestOut
+	if req&0x80 != 0 {
+		typ = requestIn
+	}
+	return c.h.ControlTransfer(typ, req, uint16(selector)<<8, uint16(s.Number), b)
+}
+
+// Negotiate runs PROBE/COMMIT for format and frame index at interval (in
+// 100ns units, 0 for the frame's default) and returns the committed
+// parameters.
+func (c *Camera) Negotiate(s *Streaming, format, frame uint8, interval uint32) (*ProbeCommit, error) {
+	f := s.Descriptors.Format(format)
+	if f == nil {
+		return nil, errors.New("uvc: unknown format")
+	}
+	fr := f.Frame(frame)
+	if fr == nil {
+		return nil, errors.New("uvc: unknown frame")
+	}
+	if interval == 0 {
+		interval = fr.DefaultFrameInterval
+	}
+	if err := c.claim(s); err != nil {
+		return nil, err
+	}
+	if err := c.h.SetInterfaceAltSetting(int(s.Number), 0); err != nil {
+		return nil, err
+	}
+
+	size := probeLength(c.vc.Header.BcdUVC)
+	pc := &ProbeCommit{
+		Hint:          HintFrameInterval,
+		FormatIndex:   format,
+		FrameIndex:    frame,
+		FrameInterval: interval,
+	}
+	b, _ := pc.MarshalBinary()
+	if _, err := c.streamControl(RequestSetCur, VSProbeControl, s, b[:size]); err != nil {
+		return nil, err
+	}
+	n, err := c.streamControl(RequestGetCur, VSProbeControl, s, b[:size])
+	if err != nil {
+		return nil, err
+	}
+	if err := pc.UnmarshalBinary(b[:n]); err != nil {
+		return nil, err
+	}
+	if pc.FormatIndex != format || pc.FrameIndex != frame {
+		return nil, errors.New("uvc: format rejected by device")
+	}
+	if pc.MaxVideoFrameSize == 0 {
+		pc.MaxVideoFrameSize = fr.MaxVideoFrameBufferSize
+	}
+	if pc.MaxVideoFrameSize == 0 && f.Subtype == VSFormatUncompressed {
+		pc.MaxVideoFrameSize = uint32(fr.Width) * uint32(fr.Height) * uint32(f.BitsPerPixel) / 8
+	}
+	if _, err := c.streamControl(RequestSetCur, VSCommitControl, s, b[:size]); err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// payloadSize returns the bytes per service interval of an isochronous
+// endpoint, including additional high-bandwidth transactions.
+func payloadSize(ep *gousb.Endpoint) int {
+	mps := int(ep.MaxPacketSize)
+	return (mps & 0x7ff) * (1 + (mps>>11)&0x03)
+}
+
+// selectAlt returns the alternate setting with the smallest isochronous
+// endpoint that carries size bytes per interval, or the largest one.
+func selectAlt(s *Streaming, size uint32) (*gousb.Interface, *gousb.Endpoint) {
+	var best *gousb.Interface
+	var bestEp *gousb.Endpoint
+	for _, alt := range s.Alts {
+		ep := alt.FindEndpoint(gousb.EndpointIn, gousb.TransferTypeIsochronous)
+		if ep == nil {
+			continue
+		}
+		n := payloadSize(ep)
+		switch {
+		case best == nil:
+		case n >= int(size) && (payloadSize(bestEp) < int(size) || n < payloadSize(bestEp)):
+		case payloadSize(bestEp) < int(size) && n > payloadSize(bestEp):
+		default:
+			continue
+		}
+		best, bestEp = alt, ep
+	}
+	return best, bestEp
+}
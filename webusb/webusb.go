@@ -0,0 +1,163 @@
+// Package webusb decodes the WebUSB platform capability and reads URL
+// descriptors, such as the landing page a device advertises to browsers.
+package webusb
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/op0xA5/gousb"
+)
+
+// RequestGetURL is the wIndex of the GET_URL request, sent with the
+// vendor code of the capability as bRequest.
+const RequestGetURL = uint16(0x02)
+
+// DescriptorTypeURL is the type of the URL descriptor.
+const DescriptorTypeURL = uint8(0x03)
+
+// URL descriptor schemes
+const (
+	SchemeHTTP  = uint8(0x00)
+	SchemeHTTPS = uint8(0x01)
+	SchemeNone  = uint8(0xFF)
+)
+
+const (
+	capabilityLength    = 4
+	urlDescriptorHeader = 3
+	maxURLDescriptor    = 255
+)
+
+const requestDeviceIn = gousb.EndpointIn | gousb.RequestTypeVendor | gousb.RecipientDevice
+
+// PlatformUUID is the WebUSB platform capability UUID.
+var PlatformUUID = gousb.PlatformUUIDWebUSB
+
+// Capability is the WebUSB platform capability. LandingPage is the URL
+// descriptor index of the landing page, 0 if there is none.
+type Capability struct {
+	Version     uint16
+	VendorCode  uint8
+	LandingPage uint8
+}
+
+// ParseCapability parses the capability data that follows the UUID of the
+// WebUSB platform capability.
+func ParseCapability(data []byte) (*Capability, error) {
+	if len(data) < capabilityLength {
+		return nil, errors.New("webusb: short platform capability")
+	}
+	return &Capability{
+		Version:     binary.LittleEndian.Uint16(data),
+		VendorCode:  data[2],
+		LandingPage: data[3],
+	}, nil
+}
+
+// GetCapability reads the WebUSB capability from the BOS descriptor.
+func GetCapability(h *gousb.Handle) (*Capability, error) {
+	bos, err := h.BOSDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	p := bos.Platform(PlatformUUID)
+	if p == nil {
+		return nil, gousb.ErrNotFound
+	}
+	return ParseCapability(p.Data)
+}
+
+// ParseURLDescriptor decodes a URL descriptor into a URL with its scheme
+// prefix.
+func ParseURLDescriptor(b []byte) (string, error) {
+	if len(b) < urlDescriptorHeader || b[1] != DescriptorTypeURL {
+		return "", errors.New("webusb: bad URL descriptor")
+	}
+	length := int(b[0])
+	if length < urlDescriptorHeader || length > len(b) {
+		return "", errors.New("webusb: bad URL descriptor length")
+	}
+	url := string(b[urlDescriptorHeader:length])
+	switch b[2] {
+	case SchemeHTTP:
+		return "http://" + url, nil
+	case SchemeHTTPS:
+		return "https://" + url, nil
+	case SchemeNone:
+		return url, nil
+	}
+	return "", errors.New("webusb: unknown URL scheme")
+}
+
+// GetURL reads URL descriptor index with the vendor code of the
+// capability.
+func GetURL(h *gousb.Handle, vendorCode, index uint8) (string, error) {
+	buf := make([]byte, maxURLDescriptor)
+	n, err := h.ControlTransfer(requestDeviceIn, vendorCode, uint16(index), RequestGetURL, buf)
+	if err != nil {
+		return "", err
+	}
+	return ParseURLDescriptor(buf[:n])
+}
+
+// Info is what a device advertises through WebUSB.
+type Info struct {
+	Capability
+	LandingPageURL string
+}
+
+// Get reads the WebUSB capability and, if the device names one, its
+// landing page URL. Devices without WebUSB return gousb.ErrNotFound, or
+// the error of the BOS request if they have no BOS descriptor.
+func Get(h *gousb.Handle) (*Info, error) {
+	c, err := GetCapability(h)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{Capability: *c}
+	if c.LandingPage != 0 {
+		if info.LandingPageURL, err = GetURL(h, c.VendorCode, c.LandingPage); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// Device is a device that advertises WebUSB. The capability and landing
+// page are read once, when the device is opened.
+type Device struct {
+	h    *gousb.Handle
+	info Info
+}
+
+// Open reads the WebUSB capability and landing page of the device. It
+// fails like Get for devices without WebUSB.
+func Open(h *gousb.Handle) (*Device, error) {
+	info, err := Get(h)
+	if err != nil {
+		return nil, err
+	}
+	return &Device{h: h, info: *info}, nil
+}
+
+// Info returns what the device advertised when it was opened.
+func (d *Device) Info() Info {
+	return d.info
+}
+
+// VendorCode returns the bRequest of the device's WebUSB requests.
+func (d *Device) VendorCode() uint8 {
+	return d.info.VendorCode
+}
+
+// LandingPage returns the landing page URL, empty if the device names
+// none.
+func (d *Device) LandingPage() string {
+	return d.info.LandingPageURL
+}
+
+// URL reads URL descriptor index with the device's vendor code.
+func (d *Device) URL(index uint8) (string, error) {
+	return GetURL(d.h, d.info.VendorCode, index)
+}
@@ -0,0 +1,39 @@
+package webusb
+
+import "testing"
+
+func TestParseCapability(t *testing.T) {
+	c, err := ParseCapability([]byte{0x00, 0x01, 0x21, 0x01})
+	if err != nil || *c != (Capability{Version: 0x0100, VendorCode: 0x21, LandingPage: 1}) {
+		t.Errorf("got %+v, %v", c, err)
+	}
+	if _, err := ParseCapability([]byte{0x00, 0x01, 0x21}); err == nil {
+		t.Errorf("short capability accepted")
+	}
+}
+
+func TestParseURLDescriptor(t *testing.T) {
+	for in, want := range map[string]string{
+		"\x0e\x03\x01example.com":         "https://example.com",
+		"\x0e\x03\x00example.com":         "http://example.com",
+		"\x0e\x03\xff/index.html\x00\x00": "/index.html",
+		"\x07\x03\x01a.io....":            "https://a.io",
+		"\x03\x03\x01":                    "https://",
+	} {
+		if url, err := ParseURLDescriptor([]byte(in)); err != nil || url != want {
+			t.Errorf("%q: got %q, %v, want %q", in, url, err, want)
+		}
+	}
+
+	for in, want := range map[string]string{
+		"\x0e\x03":                "webusb: bad URL descriptor",
+		"\x0e\x0f\x01example.com": "webusb: bad URL descriptor",
+		"\x0f\x03\x01example.com": "webusb: bad URL descriptor length",
+		"\x02\x03\x01":            "webusb: bad URL descriptor length",
+		"\x0e\x03\x02example.com": "webusb: unknown URL scheme",
+	} {
+		if _, err := ParseURLDescriptor([]byte(in)); err == nil || err.Error() != want {
+			t.Errorf("%q: got error %v, want %q", in, err, want)
+		}
+	}
+}